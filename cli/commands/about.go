@@ -16,7 +16,6 @@ package commands
 
 import (
 	"fmt"
-	"os"
 	"sort"
 	"strings"
 
@@ -34,19 +33,17 @@ func newAboutCommand() *cobra.Command {
 			// Create a client
 			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Get detailed daemon info
 			about, err := client.About()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Print info using markdown/glamour for nicer formatting
-			renderAboutInfo(about)
+			renderAboutInfo(cmd, about)
 		},
 	}
 
@@ -54,7 +51,7 @@ func newAboutCommand() *cobra.Command {
 }
 
 // renderAboutInfo renders the about information as nicely formatted output
-func renderAboutInfo(about map[string]string) {
+func renderAboutInfo(cmd *cobra.Command, about map[string]string) {
 	// Sort keys for consistent output
 	keys := make([]string, 0, len(about))
 	for key := range about {
@@ -79,16 +76,16 @@ func renderAboutInfo(about map[string]string) {
 	)
 	if err != nil {
 		// Fallback to plain text if renderer fails
-		fmt.Println("\n--- BMC Daemon Information ---")
-		fmt.Println("|-----------------|----------------------------|")
-		fmt.Println("|       Key       |            Value           |")
-		fmt.Println("|-----------------|----------------------------|")
+		fmt.Fprintln(cmd.OutOrStdout(), "\n--- BMC Daemon Information ---")
+		fmt.Fprintln(cmd.OutOrStdout(), "|-----------------|----------------------------|")
+		fmt.Fprintln(cmd.OutOrStdout(), "|       Key       |            Value           |")
+		fmt.Fprintln(cmd.OutOrStdout(), "|-----------------|----------------------------|")
 
 		for _, key := range keys {
-			fmt.Printf("| %-15s | %-28s |\n", key, about[key])
+			fmt.Fprintf(cmd.OutOrStdout(), "| %-15s | %-28s |\n", key, about[key])
 		}
 
-		fmt.Println("|-----------------|----------------------------|")
+		fmt.Fprintln(cmd.OutOrStdout(), "|-----------------|----------------------------|")
 		return
 	}
 
@@ -96,9 +93,9 @@ func renderAboutInfo(about map[string]string) {
 	out, err := renderer.Render(md.String())
 	if err != nil {
 		// Fallback to plain text if rendering fails
-		fmt.Println(md.String())
+		fmt.Fprintln(cmd.OutOrStdout(), md.String())
 		return
 	}
 
-	fmt.Println(out)
+	fmt.Fprintln(cmd.OutOrStdout(), out)
 }
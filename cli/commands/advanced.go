@@ -15,10 +15,10 @@
 package commands
 
 import (
-	"errors"
+	"context"
 	"fmt"
 
-	"github.com/davidroman0O/tpi"
+	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
 )
 
@@ -28,56 +28,71 @@ func newAdvancedCommand() *cobra.Command {
 		Use:   "advanced",
 		Short: "Advanced node modes",
 		Long:  "Configure advanced node modes like normal or MSD (Mass Storage Device)",
-		RunE: func(cmd *cobra.Command, args []string) error {
+		Run: func(cmd *cobra.Command, args []string) {
 			// Create client
 			client, err := getClient(cmd)
 			if err != nil {
-				return err
+				exitWithError(cmd, err)
 			}
 
 			// Get mode
-			mode, err := cmd.Flags().GetString("mode")
-			if err != nil {
-				return err
-			}
+			mode, _ := cmd.Flags().GetString("mode")
 			if mode == "" {
-				return errors.New("mode is required")
+				exitWithUsage(cmd, "mode is required")
 			}
 
 			// Get node
-			node, err := cmd.Flags().GetInt("node")
-			if err != nil {
-				return err
-			}
+			node, _ := cmd.Flags().GetInt("node")
 			if node <= 0 || node > 4 {
-				return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+				exitWithUsage(cmd, "invalid node number: %d (must be 1-4)", node)
 			}
 
 			// Execute the appropriate command based on mode
 			switch tpi.ModeCmd(mode) {
 			case tpi.ModeNormal:
+				confirmOrExit(cmd, fmt.Sprintf("This will reset node %d.", node))
+
 				// Set to normal mode
 				if err := client.SetNodeNormalMode(node); err != nil {
-					return fmt.Errorf("failed to set normal mode: %w", err)
+					exitWithError(cmd, fmt.Errorf("failed to set normal mode: %w", err))
 				}
-				fmt.Printf("Node %d set to normal mode\n", node)
+				fmt.Fprintf(cmd.OutOrStdout(), "Node %d set to normal mode\n", node)
 			case tpi.ModeMsd:
-				// Set to mass storage device mode
-				if err := client.SetNodeMsdMode(node); err != nil {
-					return fmt.Errorf("failed to set MSD mode: %w", err)
+				if err := checkCompatibility(cmd, client, tpi.FirmwareOpMsd); err != nil {
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("Node %d set to MSD (Mass Storage Device) mode\n", node)
+				confirmOrExit(cmd, fmt.Sprintf("This will reboot node %d into mass storage mode.", node))
+
+				// Without --wait, set mass storage device mode and return
+				wait, _ := cmd.Flags().GetDuration("wait")
+				if wait <= 0 {
+					if err := client.SetNodeMsdMode(node); err != nil {
+						exitWithError(cmd, fmt.Errorf("failed to set MSD mode: %w", err))
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "Node %d set to MSD (Mass Storage Device) mode\n", node)
+					return
+				}
+
+				// Wait for the eMMC to show up on the BMC, so scripts get the device to write to
+				ctx, cancel := context.WithTimeout(context.Background(), wait)
+				defer cancel()
+				device, err := client.SetNodeMsdModeAndWait(ctx, node)
+				if err != nil {
+					exitWithError(cmd, err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Node %d set to MSD (Mass Storage Device) mode\n", node)
+				fmt.Fprintf(cmd.OutOrStdout(), "Node %d eMMC is available as %s\n", node, device)
 			default:
-				return fmt.Errorf("unsupported mode: %s (must be 'normal' or 'msd')", mode)
+				exitWithUsage(cmd, "unsupported mode: %s (must be 'normal' or 'msd')", mode)
 			}
-
-			return nil
 		},
 	}
 
 	// Add flags
 	cmd.Flags().StringP("mode", "m", "", "Specify mode [normal, msd]")
 	cmd.Flags().IntP("node", "n", 0, "Node number [1-4]")
+	cmd.Flags().Bool("force", false, "Run the mode change even on firmware where it is known to be broken")
+	cmd.Flags().Duration("wait", 0, "With msd, wait up to this long for the eMMC to appear on the BMC and print its device, e.g. 2m")
 	cmd.MarkFlagRequired("mode")
 	cmd.MarkFlagRequired("node")
 
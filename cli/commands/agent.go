@@ -17,10 +17,12 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/davidroman0O/tpi/client/agent"
@@ -45,11 +47,16 @@ func newAgentCommand() *cobra.Command {
 // newAgentServerCommand creates the agent server subcommand
 func newAgentServerCommand() *cobra.Command {
 	var port int
+	var bindAddress string
 	var secret string
 	var allowedIPs []string
+	var allowedCommands []string
+	var allowRaw bool
 	var tlsEnabled bool
 	var tlsCertFile string
 	var tlsKeyFile string
+	var selfNode int
+	var unixSocket string
 
 	cmd := &cobra.Command{
 		Use:   "server",
@@ -59,25 +66,40 @@ func newAgentServerCommand() *cobra.Command {
   tpi agent server --host=192.168.1.91 --user=root --password=turing
 
   # Run with a custom port and authentication
-  tpi agent server --host=192.168.1.91 --port=9977 --secret=mysecret`,
+  tpi agent server --host=192.168.1.91 --port=9977 --secret=mysecret
+
+  # Only listen on the management interface
+  tpi agent server --host=192.168.1.91 --bind=10.0.0.5 --secret=mysecret
+
+  # Running on node 1, refuse to power it off unless forced
+  tpi agent server --host=192.168.1.91 --self-node=1
+
+  # Only serve clients on the same host
+  tpi agent server --host=192.168.1.91 --unix-socket=/run/tpi-agent.sock`,
 		Run: func(cmd *cobra.Command, args []string) {
 			// Create a client
 			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Create agent config
 			agentConfig := agent.AgentConfig{
+				BindAddress:    bindAddress,
 				Port:           port,
 				AllowedClients: allowedIPs,
+				AllowRaw:       allowRaw,
 				Auth: agent.AgentAuthConfig{
 					Secret: secret,
 				},
 				TLSEnabled:  tlsEnabled,
 				TLSCertFile: tlsCertFile,
 				TLSKeyFile:  tlsKeyFile,
+				SelfNode:    selfNode,
+				UnixSocket:  unixSocket,
+			}
+			for _, command := range allowedCommands {
+				agentConfig.AllowedCommands = append(agentConfig.AllowedCommands, agent.CommandType(command))
 			}
 
 			// Set up context with signal handling for graceful shutdown
@@ -89,43 +111,46 @@ func newAgentServerCommand() *cobra.Command {
 			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 			go func() {
 				<-sigCh
-				fmt.Println("\nReceived shutdown signal, stopping agent server...")
+				fmt.Fprintln(cmd.OutOrStdout(), "\nReceived shutdown signal, stopping agent server...")
 				cancel()
 			}()
 
 			// Create the agent
 			agentServer, err := agent.NewAgent(agentConfig, client)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Print server info
 			host, _ := cmd.Flags().GetString("host")
-			fmt.Printf("Agent server started for Turing Pi at %s\n", host)
-			fmt.Printf("Listening on port: %d\n", port)
+			fmt.Fprintf(cmd.OutOrStdout(), "Agent server started for Turing Pi at %s\n", host)
+			fmt.Fprintf(cmd.OutOrStdout(), "Listening on: %s\n", agentServer.Addr())
 			if secret != "" {
-				fmt.Println("Authentication enabled")
+				fmt.Fprintln(cmd.OutOrStdout(), "Authentication enabled")
 			}
-			fmt.Println("Press Ctrl+C to stop the server")
+			fmt.Fprintln(cmd.OutOrStdout(), "Press Ctrl+C to stop the server")
 
 			// Start the agent server (this will block until the context is canceled)
 			if err := agentServer.Start(ctx); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
-			fmt.Println("Agent server stopped")
+			fmt.Fprintln(cmd.OutOrStdout(), "Agent server stopped")
 		},
 	}
 
 	// Add flags - without shorthand flags to avoid conflicts with global flags
 	cmd.Flags().IntVar(&port, "port", 9977, "Port to listen on")
+	cmd.Flags().StringVar(&bindAddress, "bind", "", "Address to listen on (empty for all interfaces)")
 	cmd.Flags().StringVar(&secret, "secret", "", "Secret for authentication")
 	cmd.Flags().StringSliceVar(&allowedIPs, "allowed-ips", nil, "List of allowed client IPs (empty for all)")
+	cmd.Flags().StringSliceVar(&allowedCommands, "allowed-commands", nil, "List of command types the agent executes (empty for all)")
+	cmd.Flags().BoolVar(&allowRaw, "allow-raw", false, "Allow the raw BMC passthrough, which reaches requests the agent doesn't wrap")
 	cmd.Flags().BoolVar(&tlsEnabled, "tls", false, "Enable TLS")
 	cmd.Flags().StringVar(&tlsCertFile, "cert", "", "TLS certificate file")
 	cmd.Flags().StringVar(&tlsKeyFile, "key", "", "TLS key file")
+	cmd.Flags().IntVar(&selfNode, "self-node", 0, "Node the agent runs on [1-4], powering it off then requires force")
+	cmd.Flags().StringVar(&unixSocket, "unix-socket", "", "Listen on a Unix socket at this path instead of TCP, for clients on the same host")
 
 	return cmd
 }
@@ -136,12 +161,13 @@ func newAgentClientCommand() *cobra.Command {
 	var agentPort int
 	var secret string
 	var command string
-	var node int
+	var nodeArg string
 	var tlsEnabled bool
 	var skipVerify bool
 	var localPath string
 	var remotePath string
 	var execCommand string
+	var unixSocket string
 
 	cmd := &cobra.Command{
 		Use:   "client",
@@ -163,12 +189,17 @@ func newAgentClientCommand() *cobra.Command {
   tpi agent client --agent-host=192.168.1.100 --secret=mysecret --command=list --remote-path=/tmp
   
   # Execute a command on the remote system
-  tpi agent client --agent-host=192.168.1.100 --secret=mysecret --command=execute --exec="ls -la /tmp"`,
+  tpi agent client --agent-host=192.168.1.100 --secret=mysecret --command=execute --exec="ls -la /tmp"
+
+  # Follow the UART of every node, prefixed with the node number
+  tpi agent client --agent-host=192.168.1.100 --secret=mysecret --command=uart-stream --node=all
+
+  # Connect to an agent on the same host over its Unix socket
+  tpi agent client --unix-socket=/run/tpi-agent.sock --command=power-status`,
 		Run: func(cmd *cobra.Command, args []string) {
 			// Check required flags
-			if agentHost == "" {
-				fmt.Fprintln(os.Stderr, "Error: agent host is required")
-				os.Exit(1)
+			if agentHost == "" && unixSocket == "" {
+				exitWithUsage(cmd, "agent host or unix socket is required")
 			}
 
 			// Create agent client options
@@ -176,10 +207,14 @@ func newAgentClientCommand() *cobra.Command {
 				agent.WithAgentHost(agentHost),
 				agent.WithAgentPort(agentPort),
 			}
+			if unixSocket != "" {
+				clientOptions = append(clientOptions, agent.WithAgentUnixSocket(unixSocket))
+			}
 
 			// Add authentication if provided
+			// Each CLI run is a new client, so reuse the token the agent confirmed last time
 			if secret != "" {
-				clientOptions = append(clientOptions, agent.WithAgentSecret(secret))
+				clientOptions = append(clientOptions, agent.WithAgentSecret(secret), agent.WithAgentPersistToken())
 			}
 
 			// Add TLS if enabled
@@ -190,8 +225,7 @@ func newAgentClientCommand() *cobra.Command {
 			// Create the agent client
 			client, err := agent.NewAgentClientFromOptions(clientOptions...)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Handle commands
@@ -199,124 +233,128 @@ func newAgentClientCommand() *cobra.Command {
 				// Display system info
 				info, err := client.Info()
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
 
-				fmt.Println("System Information:")
-				fmt.Println("|---------------|----------------------------|")
-				fmt.Println("|      key      |           value            |")
-				fmt.Println("|---------------|----------------------------|")
+				fmt.Fprintln(cmd.OutOrStdout(), "System Information:")
+				fmt.Fprintln(cmd.OutOrStdout(), "|---------------|----------------------------|")
+				fmt.Fprintln(cmd.OutOrStdout(), "|      key      |           value            |")
+				fmt.Fprintln(cmd.OutOrStdout(), "|---------------|----------------------------|")
 				for key, val := range info {
-					fmt.Printf("| %-14s | %-28s |\n", key, val)
+					fmt.Fprintf(cmd.OutOrStdout(), "| %-14s | %-28s |\n", key, val)
 				}
-				fmt.Println("|---------------|----------------------------|")
+				fmt.Fprintln(cmd.OutOrStdout(), "|---------------|----------------------------|")
 			} else if command == "power-status" {
 				// Get power status
 				status, err := client.PowerStatus()
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
 
-				fmt.Println("Power Status:")
+				fmt.Fprintln(cmd.OutOrStdout(), "Power Status:")
 				for node, isOn := range status {
 					state := "OFF"
 					if isOn {
 						state = "ON"
 					}
-					fmt.Printf("Node %d: %s\n", node, state)
+					fmt.Fprintf(cmd.OutOrStdout(), "Node %d: %s\n", node, state)
 				}
 			} else if command == "power-on" {
 				// Power on node
-				if node < 1 || node > 4 {
-					fmt.Fprintf(os.Stderr, "Error: node must be between 1 and 4\n")
-					os.Exit(1)
+				node, err := parseNodeArg(nodeArg)
+				if err != nil {
+					exitWithUsage(cmd, "node must be between 1 and 4")
 				}
 
 				if err := client.PowerOn(node); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("Node %d powered on\n", node)
+				fmt.Fprintf(cmd.OutOrStdout(), "Node %d powered on\n", node)
 			} else if command == "power-off" {
 				// Power off node
-				if node < 1 || node > 4 {
-					fmt.Fprintf(os.Stderr, "Error: node must be between 1 and 4\n")
-					os.Exit(1)
+				node, err := parseNodeArg(nodeArg)
+				if err != nil {
+					exitWithUsage(cmd, "node must be between 1 and 4")
 				}
 
 				if err := client.PowerOff(node); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("Node %d powered off\n", node)
+				fmt.Fprintf(cmd.OutOrStdout(), "Node %d powered off\n", node)
+			} else if command == "uart-stream" {
+				// Stream the UART of one or every node until interrupted
+				if nodeArg == "" {
+					exitWithUsage(cmd, "node is required for uart-stream, a node [1-4] or all")
+				}
+				nodes, err := parseNodeList(nodeArg)
+				if err != nil {
+					exitWithError(cmd, err)
+				}
+
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				color := false
+				if f, ok := cmd.OutOrStdout().(*os.File); ok {
+					color = isTerminal(f) && os.Getenv("NO_COLOR") == ""
+				}
+				streamUart(ctx, client, nodes, cmd.OutOrStdout(), cmd.ErrOrStderr(), color)
 			} else if command == "reboot" {
 				// Reboot BMC
 				if err := client.Reboot(); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Println("BMC is rebooting...")
+				fmt.Fprintln(cmd.OutOrStdout(), "BMC is rebooting...")
 			} else if command == "upload" {
 				// Upload file to remote system
 				if localPath == "" || remotePath == "" {
-					fmt.Fprintf(os.Stderr, "Error: local-path and remote-path are required for upload\n")
-					os.Exit(1)
+					exitWithUsage(cmd, "local-path and remote-path are required for upload")
 				}
 
 				// Check if local file exists
 				if _, err := os.Stat(localPath); os.IsNotExist(err) {
-					fmt.Fprintf(os.Stderr, "Error: local file %s does not exist\n", localPath)
-					os.Exit(1)
+					exitWithUsage(cmd, "local file %s does not exist", localPath)
 				}
 
-				fmt.Printf("Uploading %s to %s...\n", localPath, remotePath)
+				fmt.Fprintf(cmd.OutOrStdout(), "Uploading %s to %s...\n", localPath, remotePath)
 				if err := client.UploadFile(localPath, remotePath); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Println("Upload complete")
+				fmt.Fprintln(cmd.OutOrStdout(), "Upload complete")
 			} else if command == "download" {
 				// Download file from remote system
 				if localPath == "" || remotePath == "" {
-					fmt.Fprintf(os.Stderr, "Error: local-path and remote-path are required for download\n")
-					os.Exit(1)
+					exitWithUsage(cmd, "local-path and remote-path are required for download")
 				}
 
 				// Create local directory if it doesn't exist
 				localDir := filepath.Dir(localPath)
 				if localDir != "." {
 					if err := os.MkdirAll(localDir, 0755); err != nil {
-						fmt.Fprintf(os.Stderr, "Error creating local directory: %v\n", err)
-						os.Exit(1)
+						exitWithError(cmd, fmt.Errorf("failed to create local directory: %w", err))
 					}
 				}
 
-				fmt.Printf("Downloading %s to %s...\n", remotePath, localPath)
+				fmt.Fprintf(cmd.OutOrStdout(), "Downloading %s to %s...\n", remotePath, localPath)
 				if err := client.DownloadFile(remotePath, localPath); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Println("Download complete")
+				fmt.Fprintln(cmd.OutOrStdout(), "Download complete")
 			} else if command == "list" {
 				// List directory contents
 				if remotePath == "" {
-					fmt.Fprintf(os.Stderr, "Error: remote-path is required for listing\n")
-					os.Exit(1)
+					exitWithUsage(cmd, "remote-path is required for listing")
 				}
 
-				fmt.Printf("Listing contents of %s:\n", remotePath)
+				fmt.Fprintf(cmd.OutOrStdout(), "Listing contents of %s:\n", remotePath)
 				files, err := client.ListDirectory(remotePath)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
 
 				// Print directory contents in a table format
-				fmt.Println()
-				fmt.Printf("%-10s %-12s %-20s %s\n", "Type", "Size", "Modified", "Name")
-				fmt.Println(strings.Repeat("-", 80))
+				fmt.Fprintln(cmd.OutOrStdout())
+				fmt.Fprintf(cmd.OutOrStdout(), "%-10s %-12s %-20s %s\n", "Type", "Size", "Modified", "Name")
+				fmt.Fprintln(cmd.OutOrStdout(), strings.Repeat("-", 80))
 
 				for _, file := range files {
 					fileType := "file"
@@ -337,55 +375,60 @@ func newAgentClientCommand() *cobra.Command {
 					// Format time
 					timeStr := file.ModTime.Format("2006-01-02 15:04:05")
 
-					fmt.Printf("%-10s %-12s %-20s %s\n", fileType, sizeStr, timeStr, file.Name)
+					fmt.Fprintf(cmd.OutOrStdout(), "%-10s %-12s %-20s %s\n", fileType, sizeStr, timeStr, file.Name)
 				}
-				fmt.Println()
+				fmt.Fprintln(cmd.OutOrStdout())
 			} else if command == "execute" {
 				// Execute command on remote system
 				if execCommand == "" {
-					fmt.Fprintf(os.Stderr, "Error: exec parameter is required for execute command\n")
-					os.Exit(1)
+					exitWithUsage(cmd, "exec parameter is required for execute command")
 				}
 
-				fmt.Printf("Executing command: %s\n", execCommand)
-				output, err := client.ExecuteCommand(execCommand)
+				fmt.Fprintf(cmd.OutOrStdout(), "Executing command: %s\n", execCommand)
+				result, err := client.ExecuteCommand(execCommand)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
 
-				fmt.Println("Command output:")
-				fmt.Println(strings.Repeat("-", 80))
-				fmt.Println(output)
-				fmt.Println(strings.Repeat("-", 80))
+				fmt.Fprintln(cmd.OutOrStdout(), "Command output:")
+				fmt.Fprintln(cmd.OutOrStdout(), strings.Repeat("-", 80))
+				fmt.Fprint(cmd.OutOrStdout(), result.Stdout)
+				fmt.Fprint(cmd.ErrOrStderr(), result.Stderr)
+				fmt.Fprintln(cmd.OutOrStdout(), strings.Repeat("-", 80))
+
+				// Exit with the remote command's status so scripts can check it
+				if result.ExitCode != 0 {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Command exited with code %d\n", result.ExitCode)
+					exit(cmd, result.ExitCode)
+				}
 			} else if command == "interactive" {
 				// Interactive mode with multiple commands
-				fmt.Printf("Connected to agent at %s:%d\n", agentHost, agentPort)
-				fmt.Println("Interactive mode - press Ctrl+C to exit")
+				fmt.Fprintf(cmd.OutOrStdout(), "Connected to agent at %s:%d\n", agentHost, agentPort)
+				fmt.Fprintln(cmd.OutOrStdout(), "Interactive mode - press Ctrl+C to exit")
 
 				// First show the power status
 				status, err := client.PowerStatus()
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error getting power status: %v\n", err)
+					fmt.Fprintf(cmd.ErrOrStderr(), "Error getting power status: %v\n", err)
 				} else {
-					fmt.Println("\nCurrent Power Status:")
+					fmt.Fprintln(cmd.OutOrStdout(), "\nCurrent Power Status:")
 					for node, isOn := range status {
 						state := "OFF"
 						if isOn {
 							state = "ON"
 						}
-						fmt.Printf("Node %d: %s\n", node, state)
+						fmt.Fprintf(cmd.OutOrStdout(), "Node %d: %s\n", node, state)
 					}
 				}
 
 				// Then show system info
 				info, err := client.Info()
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error getting system info: %v\n", err)
+					fmt.Fprintf(cmd.ErrOrStderr(), "Error getting system info: %v\n", err)
 				} else {
-					fmt.Println("\nSystem Information:")
+					fmt.Fprintln(cmd.OutOrStdout(), "\nSystem Information:")
 					for key, val := range info {
-						fmt.Printf("%s: %s\n", key, val)
+						fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", key, val)
 					}
 				}
 
@@ -393,10 +436,9 @@ func newAgentClientCommand() *cobra.Command {
 				sigCh := make(chan os.Signal, 1)
 				signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 				<-sigCh
-				fmt.Println("\nDisconnected from agent")
+				fmt.Fprintln(cmd.OutOrStdout(), "\nDisconnected from agent")
 			} else {
-				fmt.Fprintf(os.Stderr, "Error: unknown command: %s\n", command)
-				os.Exit(1)
+				exitWithUsage(cmd, "unknown command: %s", command)
 			}
 		},
 	}
@@ -405,16 +447,77 @@ func newAgentClientCommand() *cobra.Command {
 	cmd.Flags().StringVar(&agentHost, "agent-host", "", "Agent server hostname or IP")
 	cmd.Flags().IntVar(&agentPort, "agent-port", 9977, "Agent server port")
 	cmd.Flags().StringVar(&secret, "secret", "", "Authentication secret")
-	cmd.Flags().StringVar(&command, "command", "", "Command to execute [info, power-status, power-on, power-off, reboot, upload, download, list, execute, interactive]")
-	cmd.Flags().IntVar(&node, "node", 0, "Node number for node-specific commands [1-4]")
+	cmd.Flags().StringVar(&command, "command", "", "Command to execute [info, power-status, power-on, power-off, reboot, uart-stream, upload, download, list, execute, interactive]")
+	cmd.Flags().StringVar(&nodeArg, "node", "", "Node number for node-specific commands [1-4], uart-stream also accepts a list or all")
 	cmd.Flags().BoolVar(&tlsEnabled, "tls", false, "Enable TLS")
 	cmd.Flags().BoolVar(&skipVerify, "skip-verify", true, "Skip TLS certificate verification")
 	cmd.Flags().StringVar(&localPath, "local-path", "", "Local file path for upload or download")
 	cmd.Flags().StringVar(&remotePath, "remote-path", "", "Remote file path for upload, download or list")
 	cmd.Flags().StringVar(&execCommand, "exec", "", "Command to execute on the remote system")
+	cmd.Flags().StringVar(&unixSocket, "unix-socket", "", "Connect to an agent on this host over its Unix socket")
 
 	// Mark required flags
-	cmd.MarkFlagRequired("agent-host")
+	cmd.MarkFlagsOneRequired("agent-host", "unix-socket")
 
 	return cmd
 }
+
+// uartStreamer opens a UART stream of a node, implemented by agent.AgentClient
+type uartStreamer interface {
+	StreamUart(ctx context.Context, node int) (<-chan agent.UartOutput, error)
+}
+
+// uartColors are the ANSI colors of the node prefixes, by node
+var uartColors = map[int]string{1: "\033[36m", 2: "\033[32m", 3: "\033[33m", 4: "\033[35m"}
+
+// streamUart streams the UART of nodes to w until ctx is done or every stream ended, one line
+// at a time prefixed with its node. A stream that fails or drops is reported to errW and
+// doesn't stop the others.
+func streamUart(ctx context.Context, streamer uartStreamer, nodes []int, w, errW io.Writer, color bool) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, node := range nodes {
+		prefix := fmt.Sprintf("[node %d] ", node)
+		if color {
+			prefix = uartColors[node] + prefix + "\033[0m"
+		}
+
+		wg.Add(1)
+		go func(node int) {
+			defer wg.Done()
+
+			outputs, err := streamer.StreamUart(ctx, node)
+			if err != nil {
+				mu.Lock()
+				fmt.Fprintf(errW, "Node %d: failed to stream UART: %v\n", node, err)
+				mu.Unlock()
+				return
+			}
+
+			// Output arrives in arbitrary pieces, only complete lines are printed
+			var partial string
+			for output := range outputs {
+				lines := strings.Split(partial+output.Data, "\n")
+				partial = lines[len(lines)-1]
+
+				mu.Lock()
+				for _, line := range lines[:len(lines)-1] {
+					fmt.Fprintf(w, "%s%s\n", prefix, strings.TrimSuffix(line, "\r"))
+				}
+				mu.Unlock()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if partial != "" {
+				fmt.Fprintf(w, "%s%s\n", prefix, partial)
+			}
+			if ctx.Err() == nil {
+				fmt.Fprintf(errW, "Node %d: UART stream closed\n", node)
+			}
+		}(node)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/davidroman0O/tpi/client/agent"
+)
+
+// fakeUartStreamer is a mock agent streaming fixed UART frames for every node, interleaved
+// through a shared channel, and refusing the nodes in fail
+type fakeUartStreamer struct {
+	frames map[int][]string
+	fail   map[int]bool
+	turns  chan int
+}
+
+func (f *fakeUartStreamer) StreamUart(ctx context.Context, node int) (<-chan agent.UartOutput, error) {
+	if f.fail[node] {
+		return nil, errors.New("connection refused")
+	}
+
+	outputs := make(chan agent.UartOutput)
+	go func() {
+		defer close(outputs)
+		for _, frame := range f.frames[node] {
+			// Wait for this node's turn so the frames of the nodes interleave
+			for turn := range f.turns {
+				if turn == node {
+					break
+				}
+				f.turns <- turn
+			}
+			outputs <- agent.UartOutput{Node: node, Data: frame}
+		}
+	}()
+	return outputs, nil
+}
+
+func TestStreamUartMultiplexesNodes(t *testing.T) {
+	streamer := &fakeUartStreamer{
+		frames: map[int][]string{
+			1: {"U-Boot 2023", ".01\nStarting kernel\n", "login: "},
+			3: {"Booting Linux\r\n", "systemd started\n"},
+		},
+		fail:  map[int]bool{2: true},
+		turns: make(chan int, 8),
+	}
+	for _, node := range []int{1, 3, 1, 3, 1} {
+		streamer.turns <- node
+	}
+
+	var out, errOut bytes.Buffer
+	streamUart(context.Background(), streamer, []int{1, 2, 3}, &out, &errOut, false)
+
+	// Every node's lines are complete and in order, whatever the interleaving
+	var node1, node3 []string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "[node 1] "):
+			node1 = append(node1, strings.TrimPrefix(line, "[node 1] "))
+		case strings.HasPrefix(line, "[node 3] "):
+			node3 = append(node3, strings.TrimPrefix(line, "[node 3] "))
+		default:
+			t.Errorf("Unexpected line %q", line)
+		}
+	}
+	if strings.Join(node1, "|") != "U-Boot 2023.01|Starting kernel|login: " {
+		t.Errorf("Unexpected node 1 lines %q", node1)
+	}
+	if strings.Join(node3, "|") != "Booting Linux|systemd started" {
+		t.Errorf("Unexpected node 3 lines %q", node3)
+	}
+
+	// The failed node is reported without stopping the others
+	if !strings.Contains(errOut.String(), "Node 2: failed to stream UART: connection refused") {
+		t.Errorf("Expected the failure of node 2, got %q", errOut.String())
+	}
+	if !strings.Contains(errOut.String(), "Node 1: UART stream closed") {
+		t.Errorf("Expected the end of node 1's stream, got %q", errOut.String())
+	}
+}
@@ -15,17 +15,10 @@
 package commands
 
 import (
-	"crypto/tls"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"os/exec"
-	"strings"
 
 	"github.com/charmbracelet/huh"
-	"github.com/davidroman0O/tpi"
+	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
 )
 
@@ -53,6 +46,9 @@ func newAuthLoginCommand() *cobra.Command {
 		Long:  "Explicitly authenticate with the BMC and cache the token for future use",
 		Example: `  # Login with specified credentials
   tpi auth login --host=192.168.1.91 --user=root --password=turing
+
+  # Login with the password read from a file, keeping it out of shell history
+  tpi auth login --host=192.168.1.91 --user=root --password-file=$HOME/.tpi-password
   
   # Login with just a host (will try default credentials)
   tpi auth login --host=192.168.1.91
@@ -63,7 +59,10 @@ func newAuthLoginCommand() *cobra.Command {
 			// Get flags
 			host, _ := cmd.Flags().GetString("host")
 			user, _ := cmd.Flags().GetString("user")
-			password, _ := cmd.Flags().GetString("password")
+			password, err := getPassword(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
 
 			// If host isn't specified, use interactive mode
 			if host == "" && password == "" && user == "" {
@@ -73,137 +72,27 @@ func newAuthLoginCommand() *cobra.Command {
 
 			// Ensure host is specified
 			if host == "" {
-				fmt.Fprintln(os.Stderr, "Error: host is required")
-				os.Exit(1)
+				exitWithUsage(cmd, "host is required")
 			}
 
-			// Try direct HTTP approach first - this is most reliable
-			if user != "" && password != "" {
-				fmt.Println("Attempting direct HTTP authentication...")
-
-				// Create JSON payload
-				payload := fmt.Sprintf(`{"username":"%s","password":"%s"}`, user, password)
-
-				// Create HTTP request
-				req, err := http.NewRequest("POST",
-					fmt.Sprintf("https://%s/api/bmc/authenticate", host),
-					strings.NewReader(payload))
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-				} else {
-					// Set headers
-					req.Header.Set("Content-Type", "application/json")
-
-					// Skip TLS verification
-					tr := &http.Transport{
-						TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-					}
-					client := &http.Client{Transport: tr}
-
-					// Send request
-					fmt.Println("Sending request...")
-					resp, err := client.Do(req)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "HTTP request failed: %v\n", err)
-					} else {
-						defer resp.Body.Close()
-
-						// Read response
-						body, err := io.ReadAll(resp.Body)
-						if err != nil {
-							fmt.Fprintf(os.Stderr, "Failed to read response: %v\n", err)
-						} else {
-							// Try to extract token
-							var response map[string]interface{}
-							if err := json.Unmarshal(body, &response); err != nil {
-								fmt.Fprintf(os.Stderr, "Failed to parse response: %v\n%s\n", err, string(body))
-							} else {
-								// Look for token in id field
-								if token, ok := response["id"].(string); ok {
-									// Cache token
-									if err := tpi.CacheToken(host, token); err != nil {
-										fmt.Fprintf(os.Stderr, "Failed to cache token: %v\n", err)
-									} else {
-										fmt.Printf("Successfully authenticated to %s and cached token\n", host)
-										return
-									}
-								} else {
-									fmt.Fprintf(os.Stderr, "Token not found in response: %s\n", string(body))
-								}
-							}
-						}
-					}
-				}
-
-				// If we're here, direct HTTP approach failed
-				fmt.Println("Direct HTTP approach failed, trying curl...")
+			// Same request as every other command, through the client, so the token is
+			// obtained with the configured TLS pinning, headers and API version
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
 			}
 
-			// Try using curl as a fallback
+			// With credentials given, always get a fresh token instead of reusing a cached one
 			if user != "" && password != "" {
-				// Build the curl command with more verbose output
-				curlCmd := exec.Command("curl", "-v", "-k", "-X", "POST",
-					"-H", "Content-Type: application/json",
-					"-d", fmt.Sprintf(`{"username":"%s","password":"%s"}`, user, password),
-					fmt.Sprintf("https://%s/api/bmc/authenticate", host))
-
-				// Print the exact command being executed (without password)
-				fmt.Println("Executing curl command:", strings.Replace(curlCmd.String(), password, "********", -1))
-
-				// Capture output
-				output, err := curlCmd.CombinedOutput()
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: curl command failed: %v\n%s\n", err, string(output))
-					os.Exit(1)
-				}
-
-				// Print raw output for debugging
-				fmt.Println("Raw curl output:", string(output))
-
-				// Try to parse response
-				var response map[string]interface{}
-				if err := json.Unmarshal(output, &response); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: failed to parse response: %v\n%s\n", err, string(output))
-					os.Exit(1)
-				}
-
-				// Get token from id field
-				tokenVal, ok := response["id"]
-				if !ok {
-					fmt.Fprintf(os.Stderr, "Error: token not found in response\n%s\n", string(output))
-					os.Exit(1)
-				}
-
-				token, ok := tokenVal.(string)
-				if !ok {
-					fmt.Fprintf(os.Stderr, "Error: token is not a string\n%s\n", string(output))
-					os.Exit(1)
-				}
-
-				// Cache the token
-				if err := tpi.CacheToken(host, token); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: failed to cache token: %v\n", err)
-					os.Exit(1)
-				}
-
-				fmt.Printf("Successfully authenticated to %s and cached token\n", host)
-				return
+				_, err = client.ForceAuthentication()
+			} else {
+				err = client.Login()
 			}
-
-			// Fall back to our client implementation if no username/password
-			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
-			// Log in
-			if err := client.Login(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
-			}
-
-			fmt.Printf("Successfully authenticated to %s and cached token\n", host)
+			fmt.Fprintf(cmd.OutOrStdout(), "Successfully authenticated to %s and cached token\n", host)
 		},
 	}
 
@@ -214,7 +103,7 @@ func newAuthLoginCommand() *cobra.Command {
 func runInteractiveLogin(cmd *cobra.Command) {
 	var host, username, password string
 
-	fmt.Println("🔐 Turing Pi Authentication")
+	fmt.Fprintln(cmd.OutOrStdout(), "🔐 Turing Pi Authentication")
 
 	// Create interactive form with huh
 	form := huh.NewForm(
@@ -245,8 +134,7 @@ func runInteractiveLogin(cmd *cobra.Command) {
 	// Run the form
 	err := form.Run()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		exitWithError(cmd, err)
 	}
 
 	// Set the values back to the command's flags
@@ -257,17 +145,15 @@ func runInteractiveLogin(cmd *cobra.Command) {
 	// Create a client
 	client, err := getClient(cmd)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		exitWithError(cmd, err)
 	}
 
 	// Log in
 	if err := client.Login(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		exitWithError(cmd, err)
 	}
 
-	fmt.Printf("✅ Successfully authenticated to %s and cached token\n", host)
+	fmt.Fprintf(cmd.OutOrStdout(), "✅ Successfully authenticated to %s and cached token\n", host)
 }
 
 // newAuthLogoutCommand creates the logout subcommand
@@ -287,17 +173,15 @@ func newAuthLogoutCommand() *cobra.Command {
 			if host == "" {
 				// Clear all tokens
 				if err := tpi.DeleteAllCachedTokens(); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Println("✅ Successfully logged out - all token caches cleared")
+				fmt.Fprintln(cmd.OutOrStdout(), "✅ Successfully logged out - all token caches cleared")
 			} else {
 				// Clear token for specific host
 				if err := tpi.DeleteCachedToken(host); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("✅ Successfully logged out from %s - token cache cleared\n", host)
+				fmt.Fprintf(cmd.OutOrStdout(), "✅ Successfully logged out from %s - token cache cleared\n", host)
 			}
 		},
 	}
@@ -310,42 +194,79 @@ func newAuthStatusCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Check authentication status",
-		Long:  "Check if there is a cached authentication token",
+		Long: `Check if there is a cached authentication token.
+
+With --verify, the BMC is asked whether it still accepts the token. A rejected
+token stays cached, run "tpi auth login" to replace it.`,
 		Example: `  # Check auth status for a specific host
   tpi auth status --host=192.168.1.91
   
+  # Check that the BMC still accepts the cached token
+  tpi auth status --host=192.168.1.91 --verify
+  
   # Check auth status for all hosts
   tpi auth status`,
 		Run: func(cmd *cobra.Command, args []string) {
 			host, _ := cmd.Flags().GetString("host")
+			verify, _ := cmd.Flags().GetBool("verify")
 
 			if host == "" {
 				// List all cached tokens
 				hosts, err := tpi.GetAllCachedTokens()
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
 
 				if len(hosts) == 0 {
-					fmt.Println("🔒 No cached authentication tokens found")
+					fmt.Fprintln(cmd.OutOrStdout(), "🔒 No cached authentication tokens found")
 				} else {
-					fmt.Println("🔓 Cached authentication tokens found for:")
+					fmt.Fprintln(cmd.OutOrStdout(), "🔓 Cached authentication tokens found for:")
 					for _, h := range hosts {
-						fmt.Printf("  • %s\n", h)
+						if !verify {
+							fmt.Fprintf(cmd.OutOrStdout(), "  • %s\n", h)
+							continue
+						}
+						fmt.Fprintf(cmd.OutOrStdout(), "  • %s (%s)\n", h, verifyToken(cmd, h))
 					}
 				}
 			} else {
 				// Check if token exists for specific host
 				_, err := tpi.GetCachedToken(host)
-				if err != nil {
-					fmt.Printf("🔒 Not authenticated to %s (no cached token)\n", host)
-				} else {
-					fmt.Printf("🔓 Authenticated to %s (token is cached)\n", host)
+				switch {
+				case err != nil:
+					fmt.Fprintf(cmd.OutOrStdout(), "🔒 Not authenticated to %s (no cached token)\n", host)
+				case !verify:
+					fmt.Fprintf(cmd.OutOrStdout(), "🔓 Authenticated to %s (token is cached)\n", host)
+				default:
+					status := verifyToken(cmd, host)
+					if status == "valid" {
+						fmt.Fprintf(cmd.OutOrStdout(), "🔓 Authenticated to %s (token is valid)\n", host)
+					} else {
+						fmt.Fprintf(cmd.OutOrStdout(), "🔒 Not authenticated to %s (token is %s)\n", host, status)
+					}
 				}
 			}
 		},
 	}
 
+	cmd.Flags().Bool("verify", false, "Ask the BMC whether it still accepts the cached token")
+
 	return cmd
 }
+
+// verifyToken asks the BMC at host whether it accepts the cached token, and describes the answer
+func verifyToken(cmd *cobra.Command, host string) string {
+	client, err := getClient(cmd, tpi.WithHost(host))
+	if err != nil {
+		return fmt.Sprintf("unverified: %v", err)
+	}
+	valid, err := client.ValidateToken()
+	switch {
+	case err != nil:
+		return fmt.Sprintf("unverified: %v", err)
+	case valid:
+		return "valid"
+	default:
+		return "rejected by the BMC"
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// authRequest is what the BMC sees of an authentication request
+type authRequest struct {
+	Method      string
+	Path        string
+	ContentType string
+	UserAgent   string
+	Body        string
+}
+
+func TestAuthLoginMatchesClient(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var mu sync.Mutex
+	var requests []authRequest
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, authRequest{
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			ContentType: r.Header.Get("Content-Type"),
+			UserAgent:   r.Header.Get("User-Agent"),
+			Body:        string(body),
+		})
+		mu.Unlock()
+		w.Write([]byte(`{"id":"mock-token"}`))
+	}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	// A password that would break JSON built by hand
+	password := `tu"ring\`
+
+	var out bytes.Buffer
+	root := NewRootCommand()
+	root.SetOut(&out)
+	root.SetErr(&out)
+	root.SetArgs([]string{"auth", "login", "--host", host, "--user", "root", "--password", password})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("auth login failed: %v\n%s", err, out.String())
+	}
+	if token, err := tpi.GetCachedToken(host); err != nil || token != "mock-token" {
+		t.Errorf("Expected the token to be cached, got %q, %v", token, err)
+	}
+
+	client, err := tpi.NewClient(tpi.WithHost(host), tpi.WithCredentials("root", password))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := client.ForceAuthentication(); err != nil {
+		t.Fatalf("ForceAuthentication failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("Expected one authentication request from each, got %v", requests)
+	}
+	if requests[0] != requests[1] {
+		t.Errorf("Expected tpi auth login to send the client's request %+v, got %+v", requests[1], requests[0])
+	}
+}
+
+func TestAuthStatusVerify(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"response":[{"result":[]}]}`))
+	}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	status := func() string {
+		var out bytes.Buffer
+		root := NewRootCommand()
+		root.SetOut(&out)
+		root.SetErr(&out)
+		root.SetArgs([]string{"auth", "status", "--host", host, "--verify"})
+		if err := root.Execute(); err != nil {
+			t.Fatalf("auth status failed: %v\n%s", err, out.String())
+		}
+		return out.String()
+	}
+
+	tpi.CacheToken(host, "good-token")
+	if out := status(); !strings.Contains(out, "token is valid") {
+		t.Errorf("Expected the token to be valid, got: %s", out)
+	}
+
+	tpi.CacheToken(host, "stale-token")
+	if out := status(); !strings.Contains(out, "rejected by the BMC") {
+		t.Errorf("Expected the token to be rejected, got: %s", out)
+	}
+	if token, _ := tpi.GetCachedToken(host); token != "stale-token" {
+		t.Errorf("Expected the rejected token to stay cached, got %q", token)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newBMCCommand creates the bmc command
+func newBMCCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bmc",
+		Short: "Configure the BMC itself",
+		Long: `Configure the hostname, clock and NTP server of the BMC, for first-boot provisioning.
+Each setting is only available if the firmware exposes it.`,
+	}
+
+	cmd.AddCommand(newBMCSetHostnameCommand())
+	cmd.AddCommand(newBMCSetTimeCommand())
+	cmd.AddCommand(newBMCSetNTPCommand())
+
+	return cmd
+}
+
+// newBMCSetHostnameCommand creates the bmc set-hostname command
+func newBMCSetHostnameCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set-hostname <name>",
+		Short: "Set the hostname of the BMC",
+		Example: `  # Name the BMC turing-1
+  tpi bmc set-hostname turing-1 --host=192.168.1.91`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			if err := client.SetBMCHostname(args[0]); err != nil {
+				exitWithUnsupported(cmd, err, "setting the BMC hostname")
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "BMC hostname set to %s\n", args[0])
+		},
+	}
+}
+
+// newBMCSetTimeCommand creates the bmc set-time command
+func newBMCSetTimeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set-time [time]",
+		Short: "Set the clock of the BMC",
+		Long: `Set the clock of the BMC to a time in RFC 3339 format, or to the time of this machine
+when no time is given.`,
+		Example: `  # Set the BMC clock to this machine's time
+  tpi bmc set-time --host=192.168.1.91
+
+  # Set the BMC clock to a given time
+  tpi bmc set-time 2024-05-01T12:00:00Z --host=192.168.1.91`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			value := ""
+			if len(args) == 1 {
+				value = args[0]
+			}
+			t, err := parseBMCTime(value, time.Now())
+			if err != nil {
+				exitWithUsage(cmd, "%v", err)
+			}
+
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			if err := client.SetBMCTime(t); err != nil {
+				exitWithUnsupported(cmd, err, "setting the BMC clock")
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "BMC time set to %s\n", t.Format(time.RFC3339))
+		},
+	}
+}
+
+// newBMCSetNTPCommand creates the bmc set-ntp command
+func newBMCSetNTPCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set-ntp <server>",
+		Short: "Set the NTP server of the BMC",
+		Example: `  # Synchronize the BMC clock with pool.ntp.org
+  tpi bmc set-ntp pool.ntp.org --host=192.168.1.91`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			if err := client.SetNTPServer(args[0]); err != nil {
+				exitWithUnsupported(cmd, err, "configuring an NTP server")
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "BMC NTP server set to %s\n", args[0])
+		},
+	}
+}
+
+// parseBMCTime parses a time in RFC 3339 format, returning now when value is empty
+func parseBMCTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return now, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time must be in RFC 3339 format, e.g. 2024-05-01T12:00:00Z, got %q", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBMCTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	parsed, err := parseBMCTime("", now)
+	if err != nil || !parsed.Equal(now) {
+		t.Errorf("Expected no time to mean now, got %v, %v", parsed, err)
+	}
+
+	parsed, err = parseBMCTime("2023-11-14T22:13:20Z", now)
+	if err != nil || parsed.Unix() != 1700000000 {
+		t.Errorf("Expected 1700000000, got %v, %v", parsed.Unix(), err)
+	}
+
+	if _, err := parseBMCTime("yesterday", now); err == nil {
+		t.Error("Expected an invalid time to fail")
+	}
+}
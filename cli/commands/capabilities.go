@@ -0,0 +1,89 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// newCapabilitiesCommand creates the capabilities command
+func newCapabilitiesCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capabilities",
+		Short: "List the operations supported by the BMC firmware",
+		Long:  "List the operations supported by the connected BMC, probing each one with a request that changes nothing.",
+		Example: `  # Show what the BMC supports
+  tpi capabilities --host=192.168.1.91`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Create a client
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			caps, err := client.Capabilities()
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Firmware version: %s\n", caps.FirmwareVersion)
+			fmt.Fprintf(cmd.OutOrStdout(), "API version:      %s\n\n", caps.Api)
+
+			rows := []struct {
+				name      string
+				supported bool
+			}{
+				{"USB flash mode", caps.UsbFlashMode},
+				{"USB routing to BMC", caps.UsbBmcRoute},
+				{"MSD mode", caps.MsdMode},
+				{"Flash upload", caps.FlashUpload},
+				{"Firmware upgrade", caps.FirmwareUpgrade},
+				{"Cooling", caps.Cooling},
+				{"Sensors", caps.Sensors},
+				{"Boot config", caps.BootConfig},
+				{"UART config", caps.UartConfig},
+			}
+			for _, row := range rows {
+				mark := "❌"
+				if row.supported {
+					mark = "✅"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", mark, row.name)
+			}
+		},
+	}
+
+	return cmd
+}
+
+// checkCompatibility warns about a firmware bug known to break op on the BMC, and
+// returns an error to stop the command unless --force is set. Firmware versions
+// that can't be probed are let through, the gate is only advisory.
+func checkCompatibility(cmd *cobra.Command, client *tpi.Client, op tpi.FirmwareOperation) error {
+	var incompatErr *tpi.IncompatibilityError
+	if err := client.CheckCompatibility(op); !errors.As(err, &incompatErr) {
+		return nil
+	}
+
+	warnf(cmd, "%v", incompatErr)
+	if force, _ := cmd.Flags().GetBool("force"); !force {
+		return fmt.Errorf("not running %s on firmware %s, use --force to run it anyway", op, incompatErr.FirmwareVersion)
+	}
+	return nil
+}
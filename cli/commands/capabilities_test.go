@@ -0,0 +1,70 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	version := "1.0.2"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		w.Write([]byte(`{"response":[{"result":{"api":"1.1","version":"` + version + `"}}]}`))
+	}))
+	defer server.Close()
+
+	check := func(args ...string) (string, error) {
+		client, err := tpi.NewClient(tpi.WithHost(server.Listener.Addr().String()), tpi.WithCredentials("root", "turing"),
+			tpi.WithIncompatibilities(tpi.Incompatibility{Operation: tpi.FirmwareOpUsbFlashMode, To: "1.1.0", Reason: "flash mode hangs"}))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		var errOut bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool("force", false, "")
+		cmd.SetErr(&errOut)
+		cmd.ParseFlags(args)
+		err = checkCompatibility(cmd, client, tpi.FirmwareOpUsbFlashMode)
+		return errOut.String(), err
+	}
+
+	warning, err := check()
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("Expected a known-bad firmware to stop the command, got: %v", err)
+	}
+	if !strings.Contains(warning, "Warning: usb-flash-mode is known to be broken on firmware 1.0.2") {
+		t.Errorf("Expected a warning, got %q", warning)
+	}
+
+	if warning, err := check("--force"); err != nil || warning == "" {
+		t.Errorf("Expected --force to proceed after the warning, got %v, %q", err, warning)
+	}
+
+	version = "2.0.5"
+	if warning, err := check(); err != nil || warning != "" {
+		t.Errorf("Expected a clean firmware to proceed silently, got %v, %q", err, warning)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// newCertCommand creates the cert command
+func newCertCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cert",
+		Short: "Inspect the BMC's TLS certificate",
+		Long:  "Inspect the TLS certificate served by the BMC.",
+	}
+
+	cmd.AddCommand(newCertShowCommand())
+
+	return cmd
+}
+
+// newCertShowCommand creates the cert show command
+func newCertShowCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print the BMC's certificate and its SHA256 fingerprint",
+		Long: `Connect to the BMC and print its TLS certificate, without authenticating.
+The fingerprint can be pinned with WithPinnedCertSHA256.`,
+		Example: `  # Show the certificate of the BMC
+  tpi cert show --host=192.168.1.91`,
+		Run: func(cmd *cobra.Command, args []string) {
+			host, _ := cmd.Flags().GetString("host")
+			if host == "" {
+				exitWithUsage(cmd, "host is required")
+			}
+			port, _ := cmd.Flags().GetInt("port")
+
+			cert, err := tpi.GetServerCertificate(host, port)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Fingerprint (SHA256): %s\n", tpi.CertificateFingerprint(cert))
+			fmt.Fprintf(cmd.OutOrStdout(), "Subject:              %s\n", cert.Subject)
+			fmt.Fprintf(cmd.OutOrStdout(), "Issuer:               %s\n", cert.Issuer)
+			fmt.Fprintf(cmd.OutOrStdout(), "Valid from:           %s\n", cert.NotBefore.Format(time.RFC3339))
+			fmt.Fprintf(cmd.OutOrStdout(), "Valid until:          %s\n", cert.NotAfter.Format(time.RFC3339))
+
+			now := time.Now()
+			if now.After(cert.NotAfter) {
+				warnf(cmd, "the certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+			} else if now.Before(cert.NotBefore) {
+				warnf(cmd, "the certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+			}
+			if tpi.IsSelfSigned(cert) {
+				warnf(cmd, "the certificate is self-signed, compare the fingerprint out of band before pinning it")
+			}
+		},
+	}
+
+	cmd.Flags().Int("port", 0, fmt.Sprintf("TLS port, defaults to the port in --host or %d", tpi.DefaultTLSPort))
+
+	return cmd
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of monitoring checks, following the Nagios plugin convention. Check mode
+// exits with these instead of the ExitCode constants.
+const (
+	CheckOK       = 0
+	CheckWarning  = 1 // A node is on that was expected off
+	CheckCritical = 2 // A node is off that was expected on
+	CheckUnknown  = 3 // The state couldn't be determined
+)
+
+// checkLabels are the status words that start the line of a check
+var checkLabels = map[int]string{
+	CheckOK:       "OK",
+	CheckWarning:  "WARNING",
+	CheckCritical: "CRITICAL",
+	CheckUnknown:  "UNKNOWN",
+}
+
+// parsePowerExpect parses the expected power state of a check: all-on, all-off, or the
+// list of nodes expected on (e.g. 1,3 or 1-2), the others being expected off
+func parsePowerExpect(expect string) (map[int]bool, error) {
+	expected := make(map[int]bool, nodeCount)
+	switch strings.ToLower(strings.TrimSpace(expect)) {
+	case "all-on":
+		for node := 1; node <= nodeCount; node++ {
+			expected[node] = true
+		}
+		return expected, nil
+	case "all-off":
+		for node := 1; node <= nodeCount; node++ {
+			expected[node] = false
+		}
+		return expected, nil
+	}
+
+	on, err := parseNodeList(expect)
+	if err != nil {
+		return nil, usageErrorf("invalid --expect %q: must be all-on, all-off or the nodes expected on, e.g. 1,3", expect)
+	}
+	for node := 1; node <= nodeCount; node++ {
+		expected[node] = false
+	}
+	for _, node := range on {
+		expected[node] = true
+	}
+	return expected, nil
+}
+
+// evaluatePowerCheck compares the power status to the expected state, returning the
+// check exit code and its single line summary. A node expected on that is off is
+// critical, a node expected off that is on is a warning, and a node the BMC didn't
+// report is unknown unless something worse was found.
+func evaluatePowerCheck(status map[int]bool, expected map[int]bool) (int, string) {
+	var critical, warning, unknown bool
+	var problems []string
+	on, total := 0, 0
+
+	for node := 1; node <= nodeCount; node++ {
+		want, ok := expected[node]
+		if !ok {
+			continue
+		}
+		total++
+
+		powered, reported := status[node]
+		switch {
+		case !reported:
+			unknown = true
+			problems = append(problems, fmt.Sprintf("node %d not reported", node))
+		case powered:
+			on++
+			if !want {
+				warning = true
+				problems = append(problems, fmt.Sprintf("node %d on, expected off", node))
+			}
+		case want:
+			critical = true
+			problems = append(problems, fmt.Sprintf("node %d off", node))
+		}
+	}
+
+	code := CheckOK
+	switch {
+	case critical:
+		code = CheckCritical
+	case warning:
+		code = CheckWarning
+	case unknown:
+		code = CheckUnknown
+	}
+
+	summary := fmt.Sprintf("%d/%d nodes on", on, total)
+	if len(problems) > 0 {
+		summary = strings.Join(problems, ", ")
+	}
+	return code, checkLabels[code] + " - " + summary
+}
+
+// exitCheck prints the line of a check and exits with its code, returning if it's OK
+func exitCheck(cmd *cobra.Command, code int, line string) {
+	fmt.Fprintln(cmd.OutOrStdout(), line)
+	if code != CheckOK {
+		exit(cmd, code)
+	}
+}
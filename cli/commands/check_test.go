@@ -0,0 +1,72 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"testing"
+)
+
+func TestEvaluatePowerCheck(t *testing.T) {
+	allOn, _ := parsePowerExpect("all-on")
+	someOn, _ := parsePowerExpect("1,3")
+
+	tests := []struct {
+		name     string
+		status   map[int]bool
+		expected map[int]bool
+		code     int
+		line     string
+	}{
+		{"all on as expected", map[int]bool{1: true, 2: true, 3: true, 4: true}, allOn, CheckOK, "OK - 4/4 nodes on"},
+		{"some on as expected", map[int]bool{1: true, 2: false, 3: true, 4: false}, someOn, CheckOK, "OK - 2/4 nodes on"},
+		{"unexpected node on", map[int]bool{1: true, 2: true, 3: true, 4: false}, someOn, CheckWarning, "WARNING - node 2 on, expected off"},
+		{"expected node off", map[int]bool{1: true, 2: false, 3: true, 4: true}, allOn, CheckCritical, "CRITICAL - node 2 off"},
+		{"critical wins over warning", map[int]bool{1: false, 2: true, 3: true, 4: false}, someOn, CheckCritical, "CRITICAL - node 1 off, node 2 on, expected off"},
+		{"node not reported", map[int]bool{1: true, 2: true, 3: true}, allOn, CheckUnknown, "UNKNOWN - node 4 not reported"},
+		{"single node", map[int]bool{1: true, 2: false}, map[int]bool{2: true}, CheckCritical, "CRITICAL - node 2 off"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, line := evaluatePowerCheck(tt.status, tt.expected)
+			if code != tt.code || line != tt.line {
+				t.Errorf("Expected %d %q, got %d %q", tt.code, tt.line, code, line)
+			}
+		})
+	}
+}
+
+func TestParsePowerExpect(t *testing.T) {
+	off, err := parsePowerExpect("all-off")
+	if err != nil || len(off) != nodeCount || off[1] || off[4] {
+		t.Errorf("Expected every node off, got %v, %v", off, err)
+	}
+
+	some, err := parsePowerExpect("2-3")
+	if err != nil || some[1] || !some[2] || !some[3] || some[4] {
+		t.Errorf("Expected nodes 2 and 3 on, got %v, %v", some, err)
+	}
+
+	if _, err := parsePowerExpect("most-on"); err == nil {
+		t.Error("Expected an error for an invalid expectation")
+	}
+}
+
+func TestPowerStatusCheckOK(t *testing.T) {
+	out, _, _ := runPowerCommand(t, "status", "--check", "--expect", "all-off")
+	if out != "OK - 0/4 nodes on\n" {
+		t.Errorf("Expected a single OK line, got %q", out)
+	}
+}
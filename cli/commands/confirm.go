@@ -0,0 +1,87 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// assumeYesEnv is the environment variable that skips confirmation prompts
+const assumeYesEnv = "TPI_ASSUME_YES"
+
+// confirm asks the user to confirm a destructive operation.
+// It returns true without prompting when --yes or TPI_ASSUME_YES is set, and
+// refuses with an error when stdin isn't a terminal rather than waiting for input.
+// Under --hosts every host would read stdin at once, so it refuses then too.
+func confirm(cmd *cobra.Command, prompt string) (bool, error) {
+	if assumeYes(cmd) {
+		return true, nil
+	}
+
+	in := cmd.InOrStdin()
+	if in == os.Stdin && hostRunOf(cmd) != nil {
+		return false, usageErrorf("confirmation required on every host; pass --yes or set %s=1", assumeYesEnv)
+	}
+	if in == os.Stdin && !isTerminal(os.Stdin) {
+		return false, usageErrorf("confirmation required but stdin is not a terminal; pass --yes or set %s=1", assumeYesEnv)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s Continue? [y/N] ", prompt)
+
+	response, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	response = strings.ToLower(strings.TrimSpace(response))
+	return response == "y" || response == "yes", nil
+}
+
+// confirmOrExit asks for confirmation and exits when it's refused or declined
+func confirmOrExit(cmd *cobra.Command, prompt string) {
+	ok, err := confirm(cmd, prompt)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+	if !ok {
+		fmt.Fprintln(cmd.OutOrStdout(), "Operation cancelled.")
+		exit(cmd, ExitCodeDeclined)
+	}
+}
+
+// assumeYes reports whether confirmation prompts should be skipped
+func assumeYes(cmd *cobra.Command) bool {
+	if yes, err := cmd.Flags().GetBool("yes"); err == nil && yes {
+		return true
+	}
+
+	switch strings.ToLower(os.Getenv(assumeYesEnv)) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
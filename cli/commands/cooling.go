@@ -0,0 +1,122 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"strconv"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// newCoolingCommand creates the cooling command
+func newCoolingCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cooling",
+		Short: "Show or set the speed of the fans",
+		Long:  "Show or set the speed of the cooling devices of the board.",
+	}
+
+	cmd.AddCommand(newCoolingStatusCommand())
+	cmd.AddCommand(newCoolingSetCommand())
+	cmd.AddCommand(newCoolingPresetCommand())
+
+	return cmd
+}
+
+// newCoolingStatusCommand creates the cooling status command
+func newCoolingStatusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Print the speed of every cooling device",
+		Example: `  # Show the fan speeds
+  tpi cooling status --host=192.168.1.91`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			devices, err := client.GetCoolingStatus()
+			if err != nil {
+				exitWithUnsupported(cmd, err, "reading the fans")
+			}
+
+			if len(devices) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No cooling devices reported by the BMC")
+				return
+			}
+			for _, device := range devices {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: %d/%d\n", device.Device, device.Speed, device.MaxSpeed)
+			}
+		},
+	}
+}
+
+// newCoolingSetCommand creates the cooling set command
+func newCoolingSetCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set <device> <speed>",
+		Short: "Set the speed of a cooling device",
+		Example: `  # Run fan0 at speed 5
+  tpi cooling set fan0 5 --host=192.168.1.91`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			speed, err := strconv.Atoi(args[1])
+			if err != nil {
+				exitWithUsage(cmd, "speed must be a number, got %q", args[1])
+			}
+
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			if err := client.SetCoolingSpeed(args[0], speed); err != nil {
+				exitWithUnsupported(cmd, err, "setting fan speeds")
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s set to speed %d\n", args[0], speed)
+		},
+	}
+}
+
+// newCoolingPresetCommand creates the cooling preset command
+func newCoolingPresetCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "preset <quiet|balanced|max>",
+		Short: "Set every cooling device to a preset speed",
+		Long: `Set every cooling device to a speed relative to its maximum: quiet runs at a quarter,
+balanced at half and max at full speed.`,
+		Example: `  # Run all fans at full speed
+  tpi cooling preset max --host=192.168.1.91`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"quiet", "balanced", "max"},
+		Run: func(cmd *cobra.Command, args []string) {
+			preset := tpi.CoolingPreset(args[0])
+
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			if err := client.SetCoolingPreset(preset); err != nil {
+				exitWithUnsupported(cmd, err, "cooling presets")
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Cooling set to %s\n", preset)
+		},
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// localBMCURLs are probed for the API of a BMC the CLI runs on, a variable so tests can
+// point it at a mock
+var localBMCURLs = []string{"https://127.0.0.1", "http://127.0.0.1"}
+
+// Local BMC probing: the timeout of an attempt, and of the whole detection, since it runs
+// before every command without --host. A timed out attempt is retried within the budget,
+// as the web server of a BMC that is still booting can be slow to answer.
+var (
+	localBMCProbeTimeout = 200 * time.Millisecond
+	localBMCProbeBudget  = 500 * time.Millisecond
+)
+
+// DetectHost sets --host to the local BMC's API when the CLI runs on a Turing Pi BMC and
+// no host was given, and returns it. Runs on --hosts or --hosts-file never detect.
+func DetectHost(cmd *cobra.Command) (string, bool) {
+	host, _ := cmd.Flags().GetString("host")
+	hosts, _ := cmd.Flags().GetStringSlice("hosts")
+	hostsFile, _ := cmd.Flags().GetString("hosts-file")
+	if host != "" || len(hosts) > 0 || hostsFile != "" {
+		return "", false
+	}
+
+	host, ok := DetectLocalBMC()
+	if !ok {
+		return "", false
+	}
+	if err := cmd.Flags().Set("host", host); err != nil {
+		return "", false
+	}
+	return host, true
+}
+
+// DetectLocalBMC reports whether the CLI runs on a Turing Pi BMC, whose API then answers on
+// the loopback address it returns. Any web server on the loopback isn't enough: the answer
+// of /api/bmc has to look like the BMC's. Setting TPI_NO_AUTODETECT disables the detection.
+func DetectLocalBMC() (string, bool) {
+	if value := os.Getenv("TPI_NO_AUTODETECT"); value != "" && value != "0" && value != "false" {
+		tpi.Debug("Local BMC detection disabled by TPI_NO_AUTODETECT")
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), localBMCProbeBudget)
+	defer cancel()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	for _, baseURL := range localBMCURLs {
+		for ctx.Err() == nil {
+			isBMC, err := probeLocalBMC(ctx, client, baseURL)
+			if err == nil {
+				if isBMC {
+					tpi.Debug("Detected a BMC API at %s", baseURL)
+					return strings.TrimPrefix(strings.TrimPrefix(baseURL, "https://"), "http://"), true
+				}
+				tpi.Debug("Ignoring %s for local BMC detection, it doesn't answer like a BMC", baseURL)
+				break
+			}
+
+			// Only a slow server is worth another attempt, a refused connection means none
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				break
+			}
+		}
+	}
+	return "", false
+}
+
+// probeLocalBMC asks the server at baseURL for /api/bmc and reports whether the answer is
+// one of a BMC: its JSON envelope, or a refusal to answer without a token from a server whose
+// login endpoint answers like the BMC's, see probeLocalLogin
+func probeLocalBMC(ctx context.Context, client *http.Client, baseURL string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, localBMCProbeTimeout)
+	defer cancel()
+
+	status, mediaType, body, err := probeLocal(ctx, client, http.MethodGet, baseURL+"/api/bmc?opt=get&type=other", "")
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		var envelope map[string]json.RawMessage
+		if json.Unmarshal(body, &envelope) != nil {
+			return false, nil
+		}
+		_, hasResponse := envelope["response"]
+		_, hasResult := envelope["result"]
+		return hasResponse || hasResult, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if mediaType == "text/html" {
+			return false, nil
+		}
+		return probeLocalLogin(ctx, client, baseURL)
+	default:
+		return false, nil
+	}
+}
+
+// probeLocalLogin reports whether the server at baseURL, which refused /api/bmc, has the
+// login endpoint of a BMC: it refuses empty credentials without a token, while only the BMC
+// API needs one. Any API refusing every request, a login of its own included, isn't a BMC.
+func probeLocalLogin(ctx context.Context, client *http.Client, baseURL string) (bool, error) {
+	status, mediaType, _, err := probeLocal(ctx, client, http.MethodPost, baseURL+"/api/bmc/authenticate", `{"username":"","password":""}`)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		if mediaType == "text/html" {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+
+	status, _, _, err = probeLocal(ctx, client, http.MethodGet, baseURL+"/", "")
+	if err != nil {
+		return false, err
+	}
+	return status != http.StatusUnauthorized && status != http.StatusForbidden, nil
+}
+
+// probeLocal sends a probe request and returns the status, media type and start of the body
+// of the answer
+func probeLocal(ctx context.Context, client *http.Client, method, url, body string) (int, string, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return resp.StatusCode, mediaType, data, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// probeLocalServer points the local BMC detection at a server running handler
+func probeLocalServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	urls := localBMCURLs
+	localBMCURLs = []string{server.URL}
+	t.Cleanup(func() { localBMCURLs = urls })
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestDetectLocalBMCIgnoresOtherServers(t *testing.T) {
+	t.Setenv("TPI_NO_AUTODETECT", "")
+	for name, handler := range map[string]http.HandlerFunc{
+		"page": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>It works!</html>"))
+		},
+		"not found": http.NotFound,
+		"login page": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("<html>Sign in</html>"))
+		},
+		"other JSON": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status":"ok"}`))
+		},
+		"JSON refusal": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/bmc" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+		},
+		"JSON refusal everywhere": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+		},
+	} {
+		probeLocalServer(t, handler)
+		if host, ok := DetectLocalBMC(); ok {
+			t.Errorf("%s: expected no BMC to be detected, got %s", name, host)
+		}
+	}
+}
+
+func TestDetectLocalBMC(t *testing.T) {
+	t.Setenv("TPI_NO_AUTODETECT", "")
+	addr := probeLocalServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/bmc" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"response":[{"result":[{"api":"1.1"}]}]}`))
+	})
+
+	if host, ok := DetectLocalBMC(); !ok || host != addr {
+		t.Errorf("Expected the BMC at %s to be detected, got %q, %v", addr, host, ok)
+	}
+
+	t.Setenv("TPI_NO_AUTODETECT", "1")
+	if _, ok := DetectLocalBMC(); ok {
+		t.Error("Expected TPI_NO_AUTODETECT to disable the detection")
+	}
+}
+
+func TestDetectLocalBMCWithAuthentication(t *testing.T) {
+	// The BMC API needs a token, its login refuses bad credentials, its web UI is public
+	t.Setenv("TPI_NO_AUTODETECT", "")
+	addr := probeLocalServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/api/bmc/authenticate":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden"))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>Turing Pi</html>"))
+		}
+	})
+
+	if host, ok := DetectLocalBMC(); !ok || host != addr {
+		t.Errorf("Expected the BMC at %s to be detected, got %q, %v", addr, host, ok)
+	}
+}
+
+func TestDetectLocalBMCBudget(t *testing.T) {
+	t.Setenv("TPI_NO_AUTODETECT", "")
+	release := make(chan struct{})
+	probeLocalServer(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer close(release)
+
+	// A server that never answers costs the budget, not every attempt of every URL
+	start := time.Now()
+	if _, ok := DetectLocalBMC(); ok {
+		t.Error("Expected no BMC to be detected")
+	}
+	if elapsed := time.Since(start); elapsed > localBMCProbeBudget+200*time.Millisecond {
+		t.Errorf("Expected the detection to stop after %s, took %s", localBMCProbeBudget, elapsed)
+	}
+}
+
+func TestDetectHostSkippedWithHosts(t *testing.T) {
+	t.Setenv("TPI_NO_AUTODETECT", "")
+	var probes atomic.Int32
+	addr := probeLocalServer(t, func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		w.Write([]byte(`{"response":[{"result":[{"api":"1.1"}]}]}`))
+	})
+
+	for _, args := range [][]string{{"--hosts=10.0.0.1"}, {"--hosts-file=hosts.txt"}, {"--host=10.0.0.1"}} {
+		cmd := NewRootCommand()
+		cmd.ParseFlags(args)
+		if host, ok := DetectHost(cmd); ok {
+			t.Errorf("%v: expected no detection, got %s", args, host)
+		}
+	}
+	if probes.Load() != 0 {
+		t.Errorf("Expected no probe, got %d", probes.Load())
+	}
+
+	cmd := NewRootCommand()
+	cmd.ParseFlags(nil)
+	if host, ok := DetectHost(cmd); !ok || host != addr {
+		t.Errorf("Expected the BMC at %s to be detected, got %q, %v", addr, host, ok)
+	}
+	if host, _ := cmd.Flags().GetString("host"); host != addr {
+		t.Errorf("Expected --host to be set to %s, got %q", addr, host)
+	}
+}
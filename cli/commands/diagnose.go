@@ -0,0 +1,81 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// newDiagnoseCommand creates the diagnose command
+func newDiagnoseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diagnose",
+		Short: "Collect a diagnostic bundle for support tickets",
+		Long: `Collect the info, about, power, USB, cooling and capabilities of the board into a zip file,
+with credentials and tokens redacted, to attach to a support ticket.`,
+		Example: `  # Write the bundle to bundle.zip
+  tpi diagnose --out=bundle.zip --host=192.168.1.91
+
+  # Also capture the UART output buffered for every node
+  tpi diagnose --out=bundle.zip --uart --host=192.168.1.91`,
+		Run: func(cmd *cobra.Command, args []string) {
+			out, _ := cmd.Flags().GetString("out")
+			if out == "" {
+				exitWithUsage(cmd, "--out is required")
+			}
+			uart, _ := cmd.Flags().GetBool("uart")
+
+			// Create a client
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			bundle, err := client.DiagnosticBundleWithOptions(cmd.Context(), &tpi.DiagnosticOptions{Uart: uart})
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			file, err := os.Create(out)
+			if err != nil {
+				exitWithError(cmd, fmt.Errorf("failed to create bundle: %w", err))
+			}
+			if err := bundle.WriteZip(file); err != nil {
+				file.Close()
+				exitWithError(cmd, err)
+			}
+			if err := file.Close(); err != nil {
+				exitWithError(cmd, fmt.Errorf("failed to write bundle: %w", err))
+			}
+
+			// Sections that failed are part of the diagnosis, not a failure of the command
+			for _, section := range bundle.Sections {
+				if section.Err != "" {
+					warnf(cmd, "%s not collected: %s", section.Name, section.Err)
+				}
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Diagnostic bundle written to %s\n", out)
+		},
+	}
+
+	cmd.Flags().String("out", "tpi-diagnostics.zip", "Path of the zip file to write")
+	cmd.Flags().Bool("uart", false, "Also capture the UART output buffered for every node, which consumes it")
+
+	return cmd
+}
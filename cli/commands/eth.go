@@ -16,7 +16,6 @@ package commands
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -31,38 +30,47 @@ func newEthCommand() *cobra.Command {
 			// Get command
 			cmdStr, _ := cmd.Flags().GetString("cmd")
 			if cmdStr == "" {
-				fmt.Fprintln(os.Stderr, "Error: command is required")
-				os.Exit(1)
+				exitWithUsage(cmd, "command is required")
 			}
 
 			// Make sure the command is valid
 			if cmdStr != "reset" {
-				fmt.Fprintf(os.Stderr, "Error: invalid command: %s (must be reset)\n", cmdStr)
-				os.Exit(1)
+				exitWithUsage(cmd, "invalid command: %s (must be reset)", cmdStr)
 			}
 
 			// Create a client
 			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Execute the command based on the command string
 			switch cmdStr {
 			case "reset":
-				fmt.Println("Resetting Ethernet switch...")
-				if err := client.EthReset(); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+				confirmOrExit(cmd, "This will reset the Ethernet switch and drop all network connections.")
+
+				wait, _ := cmd.Flags().GetBool("wait")
+				if !wait {
+					fmt.Fprintln(cmd.OutOrStdout(), "Resetting Ethernet switch...")
+					if err := client.EthReset(); err != nil {
+						exitWithError(cmd, err)
+					}
+					fmt.Fprintln(cmd.OutOrStdout(), "ok")
+					return
+				}
+
+				fmt.Fprintln(cmd.OutOrStdout(), "Resetting Ethernet switch and waiting for the BMC to come back...")
+				if err := client.EthResetAndWait(cmd.Context()); err != nil {
+					exitWithError(cmd, err)
 				}
-				fmt.Println("ok")
+				fmt.Fprintln(cmd.OutOrStdout(), "BMC is reachable again")
 			}
 		},
 	}
 
 	// Add flags
 	cmd.Flags().StringP("cmd", "c", "", "Specify command [reset]")
+	cmd.Flags().Bool("wait", false, "After a reset, wait until the BMC is reachable again")
 	cmd.MarkFlagRequired("cmd")
 
 	return cmd
@@ -0,0 +1,117 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the CLI. They are stable, scripts can rely on them.
+const (
+	ExitCodeOK       = 0
+	ExitCodeError    = 1   // Any other failure
+	ExitCodeUsage    = 2   // Invalid arguments or flags
+	ExitCodeAuth     = 3   // The BMC rejected the credentials, or none were available
+	ExitCodeNetwork  = 4   // The BMC couldn't be reached
+	ExitCodeBMC      = 5   // The BMC answered with an error
+	ExitCodeDeclined = 6   // The confirmation prompt was declined, nothing was done
+	ExitCodeTimeout  = 124 // The BMC didn't answer in time, or the command exceeded --timeout
+)
+
+// UsageError is an error in the arguments or flags of a command
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// usageErrorf formats a UsageError
+func usageErrorf(format string, args ...interface{}) error {
+	return &UsageError{Err: fmt.Errorf(format, args...)}
+}
+
+// ExitCode returns the exit code for err, see the ExitCode constants
+func ExitCode(err error) int {
+	var usageErr *UsageError
+	var timeoutErr *tpi.TimeoutError
+	var bmcErr *tpi.BMCError
+	var htmlErr *tpi.HTMLResponseError
+	var netErr net.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+
+	switch {
+	case err == nil:
+		return ExitCodeOK
+	case errors.As(err, &usageErr):
+		return ExitCodeUsage
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ExitCodeTimeout
+	case errors.Is(err, tpi.ErrUnauthorized), errors.Is(err, tpi.ErrNoCredentials):
+		return ExitCodeAuth
+	case errors.As(err, &bmcErr), errors.Is(err, tpi.ErrUnsupported):
+		return ExitCodeBMC
+	case errors.As(err, &opErr), errors.As(err, &dnsErr), errors.As(err, &htmlErr):
+		return ExitCodeNetwork
+	default:
+		return ExitCodeError
+	}
+}
+
+// exit ends the command with code. A command run for one host of --hosts only ends its
+// own run, the other hosts carry on.
+func exit(cmd *cobra.Command, code int) {
+	if run := hostRunOf(cmd); run != nil {
+		run.finish(code)
+		runtime.Goexit()
+	}
+	os.Exit(code)
+}
+
+// exitWithError prints err to the command's error output and exits with its exit code
+func exitWithError(cmd *cobra.Command, err error) {
+	fmt.Fprintf(cmd.ErrOrStderr(), "Error: %v\n", err)
+	exit(cmd, ExitCode(err))
+}
+
+// exitWithUnsupported exits like exitWithError, and when err is ErrUnsupported explains
+// which feature the BMC firmware lacks, e.g. "UART configuration"
+func exitWithUnsupported(cmd *cobra.Command, err error, feature string) {
+	if errors.Is(err, tpi.ErrUnsupported) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Error: this BMC firmware doesn't support %s\n", feature)
+		exit(cmd, ExitCode(err))
+	}
+	exitWithError(cmd, err)
+}
+
+// exitWithUsage prints a usage error and exits with ExitCodeUsage
+func exitWithUsage(cmd *cobra.Command, format string, args ...interface{}) {
+	exitWithError(cmd, usageErrorf(format, args...))
+}
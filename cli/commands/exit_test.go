@@ -0,0 +1,116 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no error", nil, ExitCodeOK},
+		{"generic error", errors.New("boom"), ExitCodeError},
+		{"usage error", usageErrorf("node number must be between 1 and 4, got %d", 5), ExitCodeUsage},
+		{"timeout", &tpi.TimeoutError{Timeout: 10 * time.Second, Err: errors.New("deadline")}, ExitCodeTimeout},
+		{"wrapped timeout", fmt.Errorf("failed to get info: %w", &tpi.TimeoutError{Err: errors.New("deadline")}), ExitCodeTimeout},
+		{"context deadline", context.DeadlineExceeded, ExitCodeTimeout},
+		{"unauthorized", tpi.ErrUnauthorized, ExitCodeAuth},
+		{"wrapped unauthorized", fmt.Errorf("failed to authenticate: %w", tpi.ErrUnauthorized), ExitCodeAuth},
+		{"bmc 401", &tpi.BMCError{StatusCode: 401, Message: "unauthorized"}, ExitCodeAuth},
+		{"no credentials", tpi.ErrNoCredentials, ExitCodeAuth},
+		{"bmc error", &tpi.BMCError{StatusCode: 500, Message: "internal error"}, ExitCodeBMC},
+		{"bmc error in a 200", fmt.Errorf("failed to set USB mode: %w", &tpi.BMCError{StatusCode: 200, Message: "invalid node"}), ExitCodeBMC},
+		{"unsupported", fmt.Errorf("uart config: %w", tpi.ErrUnsupported), ExitCodeBMC},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ExitCodeNetwork},
+		{"unknown host", fmt.Errorf("request failed: %w", &net.DNSError{Err: "no such host", Name: "bmc.invalid"}), ExitCodeNetwork},
+		{"html page", &tpi.HTMLResponseError{StatusCode: 502, Status: "502 Bad Gateway"}, ExitCodeNetwork},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitWithUnsupported(t *testing.T) {
+	tests := []struct {
+		err     error
+		code    int
+		message string
+	}{
+		{fmt.Errorf("uart config: %w", tpi.ErrUnsupported), ExitCodeBMC, "Error: this BMC firmware doesn't support UART configuration\n"},
+		{errors.New("boom"), ExitCodeError, "Error: boom\n"},
+	}
+
+	for _, tt := range tests {
+		newRoot := func() *cobra.Command {
+			root := NewRootCommand()
+			root.AddCommand(&cobra.Command{
+				Use: "uart-config",
+				Run: func(cmd *cobra.Command, args []string) {
+					exitWithUnsupported(cmd, tt.err, "UART configuration")
+				},
+			})
+			return root
+		}
+
+		var stdout, stderr bytes.Buffer
+		if code := runOnHost(context.Background(), newRoot, []string{"uart-config"}, &stdout, &stderr); code != tt.code {
+			t.Errorf("%v: expected exit code %d, got %d", tt.err, tt.code, code)
+		}
+		if stderr.String() != tt.message {
+			t.Errorf("%v: expected %q, got %q", tt.err, tt.message, stderr.String())
+		}
+	}
+}
+
+func TestConfirmDeclined(t *testing.T) {
+	newRoot := func() *cobra.Command {
+		root := NewRootCommand()
+		root.AddCommand(&cobra.Command{
+			Use: "wipe",
+			Run: func(cmd *cobra.Command, args []string) {
+				cmd.SetIn(strings.NewReader("n\n"))
+				confirmOrExit(cmd, "This wipes everything.")
+			},
+		})
+		return root
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runOnHost(context.Background(), newRoot, []string{"wipe"}, &stdout, &stderr); code != ExitCodeDeclined {
+		t.Errorf("Expected exit code %d, got %d", ExitCodeDeclined, code)
+	}
+	if !strings.Contains(stdout.String(), "Operation cancelled.") {
+		t.Errorf("Expected the cancellation to be printed, got %q", stdout.String())
+	}
+}
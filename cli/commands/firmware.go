@@ -15,10 +15,12 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
+	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
 )
 
@@ -28,48 +30,133 @@ func newFirmwareCommand() *cobra.Command {
 		Use:   "firmware",
 		Short: "Upgrade the firmware of the BMC",
 		Long:  "Upgrade the firmware of the BMC.",
-		Run: func(cmd *cobra.Command, args []string) {
-			// Get required flags
-			file, _ := cmd.Flags().GetString("file")
-			if file == "" {
-				fmt.Fprintln(os.Stderr, "Error: firmware file is required")
-				os.Exit(1)
-			}
+		Example: `  # Upgrade the firmware
+  tpi firmware --file tp2-firmware.swu --host=192.168.1.91
+  
+  # Show the A/B firmware slots
+  tpi firmware slots --host=192.168.1.91
+  
+  # Upgrade the standby slot only
+  tpi firmware upgrade --file tp2-firmware.swu --slot=standby --host=192.168.1.91`,
+		Run: runFirmwareUpgrade,
+	}
 
-			// Check if file exists
-			if _, err := os.Stat(file); os.IsNotExist(err) {
-				fmt.Fprintf(os.Stderr, "Error: firmware file does not exist: %s\n", file)
-				os.Exit(1)
-			}
+	// Add flags
+	addFirmwareUpgradeFlags(cmd)
+	cmd.MarkFlagRequired("file")
 
-			// Get optional SHA256 checksum
-			sha256, _ := cmd.Flags().GetString("sha256")
+	cmd.AddCommand(newFirmwareUpgradeCommand())
+	cmd.AddCommand(newFirmwareSlotsCommand())
 
+	return cmd
+}
+
+// newFirmwareUpgradeCommand creates the firmware upgrade command
+func newFirmwareUpgradeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade the firmware of the BMC",
+		Long:  "Upgrade the firmware of the BMC, optionally targeting an A/B firmware slot.",
+		Run:   runFirmwareUpgrade,
+	}
+
+	// Add flags
+	addFirmwareUpgradeFlags(cmd)
+	cmd.Flags().String("slot", "", "Firmware slot to write [active, standby or a slot name]")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+// newFirmwareSlotsCommand creates the firmware slots command
+func newFirmwareSlotsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "slots",
+		Short: "Show the A/B firmware slots of the BMC",
+		Long:  "Show the active and standby firmware slots of the BMC.",
+		Run: func(cmd *cobra.Command, args []string) {
 			// Create a client
 			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
-			// Get file name for display
-			fileName := filepath.Base(file)
-			fmt.Printf("Upgrading firmware with %s...\n", fileName)
-
-			// Upload firmware
-			if err := client.UpgradeFirmware(file, sha256); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+			slots, err := client.FirmwareSlots()
+			if err != nil {
+				exitWithUnsupported(cmd, err, "A/B firmware slots")
 			}
 
-			fmt.Println("Firmware upgrade completed successfully")
+			fmt.Fprintf(cmd.OutOrStdout(), "Active slot:  %s\n", slots.Active)
+			if slots.Standby != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "Standby slot: %s\n", slots.Standby)
+			}
 		},
 	}
 
-	// Add flags
+	return cmd
+}
+
+// addFirmwareUpgradeFlags adds the flags shared by firmware and firmware upgrade
+func addFirmwareUpgradeFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP("file", "f", "", "Firmware file path")
 	cmd.Flags().String("sha256", "", "SHA256 checksum for verification")
-	cmd.MarkFlagRequired("file")
+	cmd.Flags().Bool("force", false, "Upload the file even if it doesn't look like BMC firmware")
+}
 
-	return cmd
+// runFirmwareUpgrade upgrades the firmware from the command flags
+func runFirmwareUpgrade(cmd *cobra.Command, args []string) {
+	// Get required flags
+	file, _ := cmd.Flags().GetString("file")
+	if file == "" {
+		exitWithUsage(cmd, "firmware file is required")
+	}
+
+	// Check if file exists
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		exitWithUsage(cmd, "firmware file does not exist: %s", file)
+	}
+
+	// Get optional SHA256 checksum and slot
+	sha256, _ := cmd.Flags().GetString("sha256")
+	slot, _ := cmd.Flags().GetString("slot")
+	force, _ := cmd.Flags().GetBool("force")
+
+	// Check the file before asking for confirmation rather than after
+	if !force {
+		if err := tpi.ValidateFirmwareFile(file, nil); err != nil {
+			if errors.Is(err, tpi.ErrInvalidFirmware) {
+				err = fmt.Errorf("%s: %w; writing a file that isn't BMC firmware can brick the board, use --force if you are sure", file, err)
+			}
+			exitWithError(cmd, err)
+		}
+	}
+
+	// Create a client
+	client, err := getClient(cmd)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	// Get file name for display
+	fileName := filepath.Base(file)
+	if slot != "" {
+		confirmOrExit(cmd, fmt.Sprintf("This will write %s to the %s firmware slot of the BMC.", fileName, slot))
+		fmt.Fprintf(cmd.OutOrStdout(), "Upgrading firmware slot %s with %s...\n", slot, fileName)
+	} else {
+		confirmOrExit(cmd, fmt.Sprintf("This will upgrade the BMC firmware with %s and reboot the BMC.", fileName))
+		fmt.Fprintf(cmd.OutOrStdout(), "Upgrading firmware with %s...\n", fileName)
+	}
+
+	// Upload firmware
+	options := &tpi.FirmwareOptions{
+		FilePath: file,
+		SHA256:   sha256,
+		Slot:     slot,
+		Force:    force,
+	}
+	if err := client.UpgradeFirmwareWithOptions(options); err != nil {
+		exitWithUnsupported(cmd, err, "A/B firmware slots")
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout(), "Firmware upgrade completed successfully")
 }
@@ -15,9 +15,11 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
@@ -34,59 +36,111 @@ func newFlashCommand() *cobra.Command {
 			local, _ := cmd.Flags().GetBool("local")
 			imagePath, _ := cmd.Flags().GetString("image-path")
 			if imagePath == "" {
-				fmt.Fprintln(os.Stderr, "Error: image path is required")
-				os.Exit(1)
+				exitWithUsage(cmd, "image path is required")
 			}
 
 			node, _ := cmd.Flags().GetInt("node")
 			if node < 1 || node > 4 {
-				fmt.Fprintf(os.Stderr, "Error: node number must be between 1 and 4, got %d\n", node)
-				os.Exit(1)
+				exitWithUsage(cmd, "node number must be between 1 and 4, got %d", node)
 			}
 
 			sha256, _ := cmd.Flags().GetString("sha256")
 			skipCrc, _ := cmd.Flags().GetBool("skip-crc")
+			skipZero, _ := cmd.Flags().GetBool("skip-zero-blocks")
+			ensureFlashMode, _ := cmd.Flags().GetBool("ensure-flash-mode")
+			autoPower, _ := cmd.Flags().GetBool("auto-power")
+			progressFlag, _ := cmd.Flags().GetString("progress")
+
+			// In JSON mode, stdout only carries progress lines, everything else goes to stderr
+			var progressFormat tpi.ProgressFormat
+			status := cmd.OutOrStdout()
+			switch progressFlag {
+			case "human":
+			case "json":
+				progressFormat = tpi.ProgressJSON
+				status = cmd.ErrOrStderr()
+			default:
+				exitWithUsage(cmd, "invalid progress format %q (must be human or json)", progressFlag)
+			}
 
 			// Create a client
 			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
+			}
+
+			operations := []tpi.FirmwareOperation{tpi.FirmwareOpFlash}
+			if ensureFlashMode {
+				operations = append(operations, tpi.FirmwareOpUsbFlashMode)
+			}
+			for _, op := range operations {
+				if err := checkCompatibility(cmd, client, op); err != nil {
+					exitWithError(cmd, err)
+				}
 			}
 
+			confirmOrExit(cmd, fmt.Sprintf("This will overwrite the storage of node %d.", node))
+
 			// If local flag is set, use local flash
 			if local {
-				fmt.Printf("Flashing node %d from local file %s...\n", node, imagePath)
-				if err := client.FlashNodeLocal(node, imagePath); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+				if skipZero {
+					notef(cmd, "--skip-zero-blocks has no effect on local images, nothing is uploaded")
+				}
+				if autoPower {
+					notef(cmd, "--auto-power has no effect on local images")
+				}
+				fmt.Fprintf(status, "Flashing node %d from local file %s...\n", node, imagePath)
+				err := client.FlashNodeLocalWithOptions(node, imagePath, &tpi.LocalFlashOptions{
+					ProgressFormat: progressFormat,
+					ProgressWriter: cmd.OutOrStdout(),
+				})
+				if err != nil {
+					exitWithError(cmd, err)
 				}
 				return
 			}
 
 			// Otherwise, check if image file exists
 			if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-				fmt.Fprintf(os.Stderr, "Error: image file does not exist: %s\n", imagePath)
-				os.Exit(1)
+				exitWithUsage(cmd, "image file does not exist: %s", imagePath)
+			}
+
+			// Without --sha256, the client verifies against the checksum published next to the image
+			if sha256 == "" {
+				sidecar, err := tpi.ReadSidecarChecksum(imagePath)
+				if err != nil {
+					exitWithError(cmd, err)
+				}
+				if sidecar != "" {
+					fmt.Fprintf(status, "Verifying against checksum from %s\n", tpi.SidecarChecksumPath(imagePath))
+				}
 			}
 
 			// Get file name for display
 			fileName := filepath.Base(imagePath)
-			fmt.Printf("Flashing node %d with %s...\n", node, fileName)
+			fmt.Fprintf(status, "Flashing node %d with %s...\n", node, fileName)
 
 			// Flash the node
 			options := &tpi.FlashOptions{
-				ImagePath: imagePath,
-				SHA256:    sha256,
-				SkipCRC:   skipCrc,
+				ImagePath:       imagePath,
+				SHA256:          sha256,
+				SkipCRC:         skipCrc,
+				SkipZeroBlocks:  skipZero,
+				EnsureFlashMode: ensureFlashMode,
+				AutoPower:       autoPower,
+				ProgressFormat:  progressFormat,
+				ProgressWriter:  cmd.OutOrStdout(),
 			}
 
 			if err := client.FlashNode(node, options); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				var offErr *tpi.NodePoweredOffError
+				if errors.As(err, &offErr) {
+					err = fmt.Errorf("%w (pass --auto-power to power it on first)", err)
+				}
+				exitWithError(cmd, err)
 			}
 
-			fmt.Println("Flash operation completed successfully")
+			fmt.Fprintln(status, "Flash operation completed successfully")
 		},
 	}
 
@@ -94,10 +148,98 @@ func newFlashCommand() *cobra.Command {
 	cmd.Flags().BoolP("local", "l", false, "Update a node with an image accessible from the local filesystem")
 	cmd.Flags().StringP("image-path", "i", "", "Update a node with the given image")
 	cmd.Flags().IntP("node", "n", 0, "Node number [1-4]")
-	cmd.Flags().String("sha256", "", "SHA256 checksum for verification")
+	cmd.Flags().String("sha256", "", "SHA256 checksum for verification (defaults to <image>.sha256 if present)")
 	cmd.Flags().Bool("skip-crc", false, "Opt out of the CRC integrity check")
+	cmd.Flags().Bool("skip-zero-blocks", false, "Report how much of the image is zero blocks; the BMC can't skip them, so the full image is still uploaded")
+	cmd.Flags().Bool("ensure-flash-mode", false, "Put the node in USB flash mode before flashing and restore the USB mode afterwards")
+	cmd.Flags().Bool("auto-power", false, "Power the node on before flashing if it is off, instead of failing")
+	cmd.Flags().Bool("force", false, "Flash even on firmware where flashing is known to be broken")
+	cmd.Flags().String("progress", "human", "Progress format: human for a progress bar, json for one JSON object per line")
 	cmd.MarkFlagRequired("image-path")
 	cmd.MarkFlagRequired("node")
 
+	cmd.AddCommand(newFlashStatusCommand())
+	cmd.AddCommand(newFlashCancelCommand())
+
 	return cmd
 }
+
+// newFlashStatusCommand creates the flash status command
+func newFlashStatusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the flash transfers in progress on the BMC",
+		Long: `Show the flash transfers in progress on the BMC. A transfer left behind by an
+interrupted flash blocks new flashes until it is cancelled with "tpi flash cancel".`,
+		Example: `  # Check for a stuck flash
+  tpi flash status --host=192.168.1.91`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			transfers, err := client.ActiveTransfers()
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			if len(transfers) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No flash transfer in progress")
+				return
+			}
+			for _, transfer := range transfers {
+				fmt.Fprintln(cmd.OutOrStdout(), formatTransfer(transfer))
+			}
+		},
+	}
+}
+
+// newFlashCancelCommand creates the flash cancel command
+func newFlashCancelCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cancel <handle>",
+		Short: "Cancel a flash transfer",
+		Long:  "Cancel a flash transfer in progress, e.g. one left behind by an interrupted flash, without rebooting the BMC.",
+		Example: `  # Cancel the transfer reported by "tpi flash status"
+  tpi flash cancel 42 --host=192.168.1.91`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			handle, err := strconv.Atoi(args[0])
+			if err != nil || handle < 0 {
+				exitWithUsage(cmd, "invalid transfer handle: %s", args[0])
+			}
+
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			confirmOrExit(cmd, fmt.Sprintf("This will abort flash transfer %d, leaving the node with a partially written image.", handle))
+
+			if err := client.CancelTransfer(handle); err != nil {
+				exitWithUnsupported(cmd, err, "cancelling transfers, reboot the BMC to clear it")
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Cancelled flash transfer %d\n", handle)
+		},
+	}
+}
+
+// formatTransfer describes a transfer on one line
+func formatTransfer(transfer tpi.TransferInfo) string {
+	line := fmt.Sprintf("Transfer %d", transfer.ID)
+	if transfer.Node != 0 {
+		line += fmt.Sprintf(", node %d", transfer.Node)
+	}
+	if transfer.Size > 0 {
+		line += fmt.Sprintf(": %d / %d bytes (%.1f%%)", transfer.BytesWritten, transfer.Size,
+			float64(transfer.BytesWritten)/float64(transfer.Size)*100)
+	} else {
+		line += fmt.Sprintf(": %d bytes written", transfer.BytesWritten)
+	}
+	if transfer.Cancelled {
+		line += ", cancelling"
+	}
+	return line
+}
@@ -0,0 +1,283 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// RunOnHosts runs the command on every host given with --hosts or --hosts-file, concurrently.
+// Each host gets its own run of the command, in-process on a root command made by newRoot
+// with --host set, so authentication and failures are independent; output lines are prefixed
+// with the host. It reports false when no hosts were given, and otherwise the exit code to
+// use: 0 when every host succeeded.
+func RunOnHosts(cmd *cobra.Command, args []string, newRoot func() *cobra.Command) (int, bool) {
+	// A run for one host never dispatches again
+	if hostRunOf(cmd) != nil {
+		return 0, false
+	}
+
+	hosts, err := getHosts(cmd)
+	if err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Error: %v\n", err)
+		return 1, true
+	}
+	if len(hosts) == 0 {
+		return 0, false
+	}
+
+	if stdin, _ := cmd.Flags().GetBool("password-stdin"); stdin {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Error: --password-stdin can't be shared between hosts, use --password-file")
+		return 1, true
+	}
+	if cmd.Flags().Changed("host") {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Error: --host and --hosts are mutually exclusive")
+		return 1, true
+	}
+
+	return runOnHosts(cmd.Context(), newRoot, hostArgs(cmd, args), hosts, cmd.OutOrStdout(), cmd.ErrOrStderr()), true
+}
+
+// hostArgs rebuilds the command line of cmd from the flags and arguments cobra parsed,
+// without the host selection flags
+func hostArgs(cmd *cobra.Command, args []string) []string {
+	rebuilt := strings.Fields(cmd.CommandPath())[1:]
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		switch flag.Name {
+		case "host", "hosts", "hosts-file":
+			return
+		}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			for _, value := range slice.GetSlice() {
+				rebuilt = append(rebuilt, "--"+flag.Name+"="+value)
+			}
+			return
+		}
+		rebuilt = append(rebuilt, "--"+flag.Name+"="+flag.Value.String())
+	})
+
+	// Arguments given after -- stay after it, they may look like flags
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		rebuilt = append(rebuilt, args[:dash]...)
+		rebuilt = append(rebuilt, "--")
+		args = args[dash:]
+	}
+	return append(rebuilt, args...)
+}
+
+// hostRunKey is the context key of the hostRun of a command run for one host of --hosts
+type hostRunKey struct{}
+
+// hostRun receives the exit code of a command run for one host of --hosts
+type hostRun struct {
+	once sync.Once
+	code chan int
+}
+
+// finish reports the exit code of the run, only the first one counts
+func (r *hostRun) finish(code int) {
+	r.once.Do(func() { r.code <- code })
+}
+
+// hostRunOf returns the hostRun of cmd, nil unless it runs for one host of --hosts
+func hostRunOf(cmd *cobra.Command) *hostRun {
+	ctx := cmd.Context()
+	if ctx == nil {
+		return nil
+	}
+	run, _ := ctx.Value(hostRunKey{}).(*hostRun)
+	return run
+}
+
+// getHosts returns the hosts of --hosts and --hosts-file, without duplicates
+func getHosts(cmd *cobra.Command) ([]string, error) {
+	hosts, _ := cmd.Flags().GetStringSlice("hosts")
+	hostsFile, _ := cmd.Flags().GetString("hosts-file")
+
+	if hostsFile != "" {
+		data, err := os.ReadFile(hostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hosts file: %w", err)
+		}
+		hosts = append(hosts, parseHostsFile(data)...)
+	}
+
+	seen := make(map[string]bool)
+	unique := hosts[:0:0]
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		unique = append(unique, host)
+	}
+	return unique, nil
+}
+
+// parseHostsFile parses one host per line, skipping blank lines and # comments
+func parseHostsFile(data []byte) []string {
+	var hosts []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			hosts = append(hosts, line)
+		}
+	}
+	return hosts
+}
+
+// runOnHosts runs a root command made by newRoot with args and --host for every host
+// concurrently, copying their output with a host prefix, and returns the aggregated exit code
+func runOnHosts(ctx context.Context, newRoot func() *cobra.Command, args, hosts []string, stdout, stderr io.Writer) int {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var mu sync.Mutex
+	codes := make(map[string]int, len(hosts))
+	var wg sync.WaitGroup
+
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+
+			prefix := fmt.Sprintf("[%s] ", host)
+			out := &prefixWriter{w: stdout, prefix: prefix, mu: &mu}
+			errOut := &prefixWriter{w: stderr, prefix: prefix, mu: &mu}
+
+			code := runOnHost(ctx, newRoot, append([]string{"--host=" + host}, args...), out, errOut)
+			out.Flush()
+			errOut.Flush()
+
+			mu.Lock()
+			codes[host] = code
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	return aggregateExitCodes(hosts, codes, stderr)
+}
+
+// runOnHost runs a root command made by newRoot with args and returns its exit code. The
+// command runs on a goroutine of its own, which exit ends instead of the process. Its
+// context is cancelled once it ends, which stops its --timeout.
+func runOnHost(ctx context.Context, newRoot func() *cobra.Command, args []string, stdout, stderr io.Writer) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	run := &hostRun{code: make(chan int, 1)}
+
+	root := newRoot()
+	root.SetArgs(args)
+	root.SetOut(stdout)
+	root.SetErr(stderr)
+
+	go func() {
+		// A command that returns without calling exit succeeded
+		defer run.finish(ExitCodeOK)
+		if err := root.ExecuteContext(context.WithValue(ctx, hostRunKey{}, run)); err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			run.finish(ExitCodeUsage)
+		}
+	}()
+
+	return <-run.code
+}
+
+// aggregateExitCodes summarizes the failed hosts. The exit code is 0 when every host
+// succeeded, the hosts' exit code when they all failed the same way, and 1 otherwise.
+func aggregateExitCodes(hosts []string, codes map[string]int, stderr io.Writer) int {
+	var failed []string
+	failedCodes := make(map[int]bool)
+	for _, host := range hosts {
+		if code := codes[host]; code != 0 {
+			failed = append(failed, fmt.Sprintf("%s (exit %d)", host, code))
+			failedCodes[code] = true
+		}
+	}
+	if len(failed) == 0 {
+		return 0
+	}
+
+	sort.Strings(failed)
+	fmt.Fprintf(stderr, "Error: %d of %d hosts failed: %s\n", len(failed), len(hosts), strings.Join(failed, ", "))
+
+	if len(failedCodes) == 1 {
+		for code := range failedCodes {
+			return code
+		}
+	}
+	return 1
+}
+
+// prefixWriter writes complete lines to w with a prefix, under a lock shared by the
+// writers of every host so lines of different hosts don't interleave
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+// Write implements io.Writer
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes a trailing line without newline
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+// writeLine writes a single line with the prefix, with the lock held
+func (p *prefixWriter) writeLine(line []byte) {
+	fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+}
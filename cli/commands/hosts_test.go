@@ -0,0 +1,295 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newPowerBMC starts a mock BMC reporting the given power status, or failing when status is empty
+func newPowerBMC(t *testing.T, status string) string {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			if status == "" {
+				http.Error(w, "BMC failure", http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":[` + status + `]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func TestRunOnHosts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	first := newPowerBMC(t, `{"node1":1,"node2":0,"node3":0,"node4":0}`)
+	second := newPowerBMC(t, `{"node1":0,"node2":0,"node3":0,"node4":1}`)
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"power", "status", "--user=root", "--password=turing"}
+	code := runOnHosts(context.Background(), NewRootCommand, args, []string{first, second}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d, stderr:\n%s", code, stderr.String())
+	}
+
+	// Every line carries the host it comes from
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	onLines := map[string]string{}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "["+first+"] "):
+			if strings.Contains(line, "ON") && !strings.Contains(line, "OFF") {
+				onLines[first] = line
+			}
+		case strings.HasPrefix(line, "["+second+"] "):
+			if strings.Contains(line, "ON") && !strings.Contains(line, "OFF") {
+				onLines[second] = line
+			}
+		default:
+			t.Errorf("Expected every line to be prefixed with its host, got %q", line)
+		}
+	}
+	if !strings.Contains(onLines[first], "Node 1") || !strings.Contains(onLines[second], "Node 4") {
+		t.Errorf("Expected node 1 on for the first host and node 4 for the second, got:\n%s", stdout.String())
+	}
+}
+
+func TestRunOnHostsAggregatesFailures(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	healthy := newPowerBMC(t, `{"node1":1,"node2":1,"node3":1,"node4":1}`)
+	broken := newPowerBMC(t, "")
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"power", "status", "--user=root", "--password=turing"}
+	code := runOnHosts(context.Background(), NewRootCommand, args, []string{healthy, broken}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "1 of 2 hosts failed: "+broken) {
+		t.Errorf("Expected the failed host to be reported, got:\n%s", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "["+healthy+"] ") {
+		t.Errorf("Expected the healthy host's output, got:\n%s", stdout.String())
+	}
+}
+
+// parseCommandLine parses args as cobra does before running a command, and returns the
+// command with its positional arguments
+func parseCommandLine(t *testing.T, args []string) (*cobra.Command, []string) {
+	t.Helper()
+	cmd, rest, err := NewRootCommand().Find(args)
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if err := cmd.ParseFlags(rest); err != nil {
+		t.Fatalf("ParseFlags failed: %v", err)
+	}
+	return cmd, cmd.Flags().Args()
+}
+
+func TestHostArgs(t *testing.T) {
+	cmd, args := parseCommandLine(t, []string{
+		"--hosts", "a,b", "flash", "--hosts-file=hosts.txt", "--image-path", "-Hx", "--node", "2", "--yes",
+		"--header", "X-A: 1", "--header", "X-B: 2",
+	})
+
+	// A flag value that looks like -H isn't mistaken for the host flag
+	expected := []string{"flash", "--header=X-A: 1", "--header=X-B: 2", "--image-path=-Hx", "--node=2", "--yes=true"}
+	if got := hostArgs(cmd, args); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected args %v, got %v", expected, got)
+	}
+	if cmd.Flags().Changed("host") {
+		t.Error("Expected no single host")
+	}
+
+	for _, given := range [][]string{{"--host", "h"}, {"--host=h"}, {"-H", "h"}, {"-Hh"}} {
+		if cmd, _ := parseCommandLine(t, append(given, "power", "status")); !cmd.Flags().Changed("host") {
+			t.Errorf("Expected %v to be detected as a single host", given)
+		}
+	}
+
+	// Arguments after -- stay after it
+	cmd, args = parseCommandLine(t, []string{"power", "on", "--", "1"})
+	if expected := []string{"power", "on", "--", "1"}; !reflect.DeepEqual(hostArgs(cmd, args), expected) {
+		t.Errorf("Expected args %v, got %v", expected, hostArgs(cmd, args))
+	}
+}
+
+func TestRunOnHostExit(t *testing.T) {
+	// exit ends the run of its host with the code, not the process
+	newRoot := func() *cobra.Command {
+		root := NewRootCommand()
+		root.AddCommand(&cobra.Command{
+			Use: "fail",
+			Run: func(cmd *cobra.Command, args []string) {
+				exitWithUsage(cmd, "always fails")
+			},
+		})
+		return root
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runOnHost(context.Background(), newRoot, []string{"fail"}, &stdout, &stderr); code != ExitCodeUsage {
+		t.Errorf("Expected exit code %d, got %d", ExitCodeUsage, code)
+	}
+	if !strings.Contains(stderr.String(), "always fails") {
+		t.Errorf("Expected the error to be printed, got %q", stderr.String())
+	}
+
+	if code := runOnHost(context.Background(), newRoot, []string{"fail", "--bogus"}, &stdout, &stderr); code != ExitCodeUsage {
+		t.Errorf("Expected exit code %d for an unknown flag, got %d", ExitCodeUsage, code)
+	}
+}
+
+func TestRunOnHostNeedsYes(t *testing.T) {
+	// Hosts can't share a prompt, confirmations are refused instead of read from stdin
+	newRoot := func() *cobra.Command {
+		root := NewRootCommand()
+		root.AddCommand(&cobra.Command{
+			Use: "wipe",
+			Run: func(cmd *cobra.Command, args []string) {
+				confirmOrExit(cmd, "This wipes everything.")
+			},
+		})
+		return root
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runOnHost(context.Background(), newRoot, []string{"wipe"}, &stdout, &stderr); code != ExitCodeUsage {
+		t.Errorf("Expected exit code %d, got %d", ExitCodeUsage, code)
+	}
+	if !strings.Contains(stderr.String(), "--yes") {
+		t.Errorf("Expected the error to mention --yes, got %q", stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := runOnHost(context.Background(), newRoot, []string{"wipe", "--yes"}, &stdout, &stderr); code != ExitCodeOK {
+		t.Errorf("Expected exit code %d with --yes, got %d: %s", ExitCodeOK, code, stderr.String())
+	}
+}
+
+func TestRunOnHostTimeout(t *testing.T) {
+	// The timeout cancels the context of the host's command and ends its run
+	cancelled := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	newRoot := func() *cobra.Command {
+		root := NewRootCommand()
+		root.AddCommand(&cobra.Command{
+			Use: "hang",
+			PreRun: func(cmd *cobra.Command, args []string) {
+				StartCommandTimeout(cmd)
+			},
+			Run: func(cmd *cobra.Command, args []string) {
+				<-cmd.Context().Done()
+				close(cancelled)
+				<-release
+			},
+		})
+		return root
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runOnHost(context.Background(), newRoot, []string{"hang", "--timeout=50ms"}, &stdout, &stderr); code != ExitCodeTimeout {
+		t.Errorf("Expected exit code %d, got %d", ExitCodeTimeout, code)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the command's context to be cancelled")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to write from the timeout goroutine while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRunOnHostTimeoutStopsWithCommand(t *testing.T) {
+	// A host done before the timeout doesn't report it when the timeout passes
+	newRoot := func() *cobra.Command {
+		root := NewRootCommand()
+		root.AddCommand(&cobra.Command{
+			Use: "quick",
+			PreRun: func(cmd *cobra.Command, args []string) {
+				StartCommandTimeout(cmd)
+			},
+			Run: func(cmd *cobra.Command, args []string) {},
+		})
+		return root
+	}
+
+	var stdout, stderr syncBuffer
+	if code := runOnHost(context.Background(), newRoot, []string{"quick", "--timeout=20ms"}, &stdout, &stderr); code != ExitCodeOK {
+		t.Fatalf("Expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if strings.Contains(stderr.String(), "timed out") {
+		t.Errorf("Expected no timeout after the command ended, got:\n%s", stderr.String())
+	}
+}
+
+func TestParseHostsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	content := "# lab boards\n192.168.1.91\n\n192.168.1.92  # rack 2\n192.168.1.91\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write hosts file: %v", err)
+	}
+
+	cmd := NewRootCommand()
+	cmd.ParseFlags([]string{"--hosts=10.0.0.1", "--hosts-file=" + path})
+
+	hosts, err := getHosts(cmd)
+	if err != nil {
+		t.Fatalf("getHosts failed: %v", err)
+	}
+	if expected := []string{"10.0.0.1", "192.168.1.91", "192.168.1.92"}; !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected hosts %v, got %v", expected, hosts)
+	}
+}
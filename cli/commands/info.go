@@ -15,53 +15,219 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
-	"os"
 	"sort"
+	"time"
 
+	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
 )
 
 // newInfoCommand creates the info command
 func newInfoCommand() *cobra.Command {
+	var showAbout bool
+
 	cmd := &cobra.Command{
 		Use:   "info",
 		Short: "Print turing-pi info",
 		Long:  "Print turing-pi info.",
+		Example: `  # Print the board info
+  tpi info --host=192.168.1.91
+  
+  # Print the BMC daemon details as JSON
+  tpi info --about --json --host=192.168.1.91`,
 		Run: func(cmd *cobra.Command, args []string) {
 			// Create a client
 			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
-			// Get board info
-			info, err := client.Info()
+			// Get board info, or the daemon details
+			var info map[string]string
+			var board *tpi.BoardInfo
+			if showAbout {
+				info, err = client.About()
+			} else {
+				board, err = client.BoardInfo()
+				if err == nil {
+					info = board.Fields
+				}
+			}
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
-			// Print info in a table
-			fmt.Println("|---------------|----------------------------|")
-			fmt.Println("|      key      |           value            |")
-			fmt.Println("|---------------|----------------------------|")
-
-			// Sort keys for consistent output
-			keys := make([]string, 0, len(info))
-			for key := range info {
-				keys = append(keys, key)
+			if jsonOutput(cmd) {
+				var out string
+				if board != nil {
+					out, err = renderBoardInfoJSON(board)
+				} else {
+					out, err = renderInfoJSON(info)
+				}
+				if err != nil {
+					exitWithError(cmd, err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), out)
+				return
 			}
-			sort.Strings(keys)
 
-			for _, key := range keys {
-				fmt.Printf("| %-14s | %-28s |\n", key, info[key])
+			if board != nil {
+				fmt.Fprintln(cmd.OutOrStdout(), renderBoardInfoTable(board))
+				return
 			}
-
-			fmt.Println("|---------------|----------------------------|")
+			fmt.Fprintln(cmd.OutOrStdout(), renderInfoTable(info))
 		},
 	}
 
+	// Add flags
+	cmd.Flags().BoolVar(&showAbout, "about", false, "Show the BMC daemon details instead of the board info")
+
 	return cmd
 }
+
+// infoRow is a row of an info table
+type infoRow struct {
+	key   string
+	value string
+}
+
+// boardInfoFields are the fields of BoardInfo, in the order and with the labels they're shown with
+var boardInfoFields = []struct {
+	key   string
+	label string
+	value func(*tpi.BoardInfo) string
+}{
+	{"api", "API version", func(b *tpi.BoardInfo) string { return b.Api }},
+	{"version", "Firmware version", func(b *tpi.BoardInfo) string { return b.Version }},
+	{"build_version", "Build version", func(b *tpi.BoardInfo) string { return b.BuildVersion }},
+	{"buildtime", "Build time", func(b *tpi.BoardInfo) string { return b.BuildTime }},
+	{"ip", "IP address", func(b *tpi.BoardInfo) string { return b.IP }},
+	{"mac", "MAC address", func(b *tpi.BoardInfo) string { return b.MAC }},
+	{"uptime", "Uptime", func(b *tpi.BoardInfo) string {
+		// An uptime that didn't parse is shown as reported
+		if b.Uptime == 0 {
+			return b.Fields["uptime"]
+		}
+		return b.Uptime.Round(time.Second).String()
+	}},
+}
+
+// renderBoardInfoTable renders the known fields of the board info, then the others as reported
+func renderBoardInfoTable(board *tpi.BoardInfo) string {
+	var rows []infoRow
+	known := make(map[string]bool)
+	for _, field := range boardInfoFields {
+		known[field.key] = true
+		if _, ok := board.Fields[field.key]; ok {
+			rows = append(rows, infoRow{field.label, field.value(board)})
+		}
+	}
+
+	for _, key := range sortedKeys(board.Fields) {
+		if !known[key] {
+			rows = append(rows, infoRow{key, board.Fields[key]})
+		}
+	}
+
+	return renderInfoRows(rows)
+}
+
+// renderInfoTable renders the info as a styled key/value table
+func renderInfoTable(info map[string]string) string {
+	// Sort keys for consistent output
+	var rows []infoRow
+	for _, key := range sortedKeys(info) {
+		rows = append(rows, infoRow{key, info[key]})
+	}
+
+	return renderInfoRows(rows)
+}
+
+// renderInfoRows renders rows as a styled key/value table
+func renderInfoRows(rows []infoRow) string {
+	// Some firmware reports little or nothing
+	if len(rows) == 0 {
+		return "No information reported by the BMC"
+	}
+
+	width := 0
+	for _, row := range rows {
+		if len(row.key) > width {
+			width = len(row.key)
+		}
+	}
+
+	table := headerStyle.Render(fmt.Sprintf("%-*s", width, "KEY")) + headerStyle.Render("VALUE")
+	for _, row := range rows {
+		value := row.value
+		if value == "" {
+			value = "-"
+		}
+		table += "\n" + nodeStyle.Render(fmt.Sprintf("%-*s", width, row.key)) + nodeStyle.Render(value)
+	}
+
+	return tableStyle.Render(table)
+}
+
+// sortedKeys returns the keys of info in order
+func sortedKeys(info map[string]string) []string {
+	keys := make([]string, 0, len(info))
+	for key := range info {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// boardInfoJSON is the JSON form of BoardInfo, the known fields parsed and every field
+// as reported
+type boardInfoJSON struct {
+	Api           string            `json:"api,omitempty"`
+	Version       string            `json:"version,omitempty"`
+	BuildVersion  string            `json:"build_version,omitempty"`
+	BuildTime     string            `json:"build_time,omitempty"`
+	IP            string            `json:"ip,omitempty"`
+	MAC           string            `json:"mac,omitempty"`
+	UptimeSeconds float64           `json:"uptime_seconds,omitempty"`
+	Fields        map[string]string `json:"fields"`
+}
+
+// renderBoardInfoJSON renders the board info as indented JSON
+func renderBoardInfoJSON(board *tpi.BoardInfo) (string, error) {
+	fields := board.Fields
+	if fields == nil {
+		fields = map[string]string{}
+	}
+
+	out, err := json.MarshalIndent(boardInfoJSON{
+		Api:           board.Api,
+		Version:       board.Version,
+		BuildVersion:  board.BuildVersion,
+		BuildTime:     board.BuildTime,
+		IP:            board.IP,
+		MAC:           board.MAC,
+		UptimeSeconds: board.Uptime.Seconds(),
+		Fields:        fields,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode info: %w", err)
+	}
+
+	return string(out), nil
+}
+
+// renderInfoJSON renders the info as indented JSON, with an empty object for no info
+func renderInfoJSON(info map[string]string) (string, error) {
+	if info == nil {
+		info = map[string]string{}
+	}
+
+	out, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode info: %w", err)
+	}
+
+	return string(out), nil
+}
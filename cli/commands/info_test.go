@@ -0,0 +1,154 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+func TestRenderInfoPopulated(t *testing.T) {
+	info := map[string]string{
+		"api":     "1.1",
+		"version": "2.0.5",
+		"ip":      "",
+	}
+
+	table := renderInfoTable(info)
+	for _, want := range []string{"KEY", "VALUE", "api", "1.1", "version", "2.0.5", "ip"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("Expected table to contain %q, got:\n%s", want, table)
+		}
+	}
+	if strings.Index(table, "api") > strings.Index(table, "version") {
+		t.Errorf("Expected keys to be sorted, got:\n%s", table)
+	}
+
+	out, err := renderInfoJSON(info)
+	if err != nil {
+		t.Fatalf("renderInfoJSON failed: %v", err)
+	}
+	var decoded map[string]string
+	if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", out, err)
+	}
+	if len(decoded) != 3 || decoded["version"] != "2.0.5" {
+		t.Errorf("Unexpected JSON: %s", out)
+	}
+}
+
+func TestRenderBoardInfo(t *testing.T) {
+	board := tpi.ParseBoardInfo(map[string]string{
+		"version": "2.0.5",
+		"uptime":  "3725",
+		"api":     "1.1",
+		"serial":  "TP2-1234",
+	})
+
+	table := renderBoardInfoTable(board)
+	for _, want := range []string{"API version", "Firmware version", "2.0.5", "Uptime", "1h2m5s", "serial", "TP2-1234"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("Expected table to contain %q, got:\n%s", want, table)
+		}
+	}
+	if strings.Index(table, "Uptime") > strings.Index(table, "serial") {
+		t.Errorf("Expected the known fields before the others, got:\n%s", table)
+	}
+	if strings.Contains(table, "Build time") {
+		t.Errorf("Expected fields the BMC didn't report to be left out, got:\n%s", table)
+	}
+
+	// An uptime that didn't parse is shown as reported
+	table = renderBoardInfoTable(tpi.ParseBoardInfo(map[string]string{"uptime": "soon"}))
+	if !strings.Contains(table, "soon") {
+		t.Errorf("Expected the raw uptime, got:\n%s", table)
+	}
+
+	if table := renderBoardInfoTable(tpi.ParseBoardInfo(nil)); table != "No information reported by the BMC" {
+		t.Errorf("Unexpected table for empty info: %q", table)
+	}
+}
+
+func TestRenderBoardInfoJSON(t *testing.T) {
+	out, err := renderBoardInfoJSON(tpi.ParseBoardInfo(map[string]string{
+		"version":   "2.0.5",
+		"buildtime": "2024-01-01",
+		"uptime":    "3725.5",
+		"serial":    "TP2-1234",
+	}))
+	if err != nil {
+		t.Fatalf("renderBoardInfoJSON failed: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", out, err)
+	}
+	if decoded["version"] != "2.0.5" || decoded["build_time"] != "2024-01-01" {
+		t.Errorf("Expected the parsed fields, got: %s", out)
+	}
+	if decoded["uptime_seconds"] != 3725.5 {
+		t.Errorf("Expected the parsed uptime, got: %s", out)
+	}
+	if _, ok := decoded["ip"]; ok {
+		t.Errorf("Expected fields the BMC didn't report to be left out, got: %s", out)
+	}
+	fields, _ := decoded["fields"].(map[string]interface{})
+	if fields["serial"] != "TP2-1234" || fields["uptime"] != "3725.5" {
+		t.Errorf("Expected every field as reported, got: %s", out)
+	}
+
+	out, err = renderBoardInfoJSON(tpi.ParseBoardInfo(nil))
+	if err != nil {
+		t.Fatalf("renderBoardInfoJSON failed: %v", err)
+	}
+	if out != "{\n  \"fields\": {}\n}" {
+		t.Errorf("Unexpected JSON for empty info: %q", out)
+	}
+}
+
+func TestRenderInfoEmpty(t *testing.T) {
+	for _, info := range []map[string]string{nil, {}} {
+		if table := renderInfoTable(info); table != "No information reported by the BMC" {
+			t.Errorf("Unexpected table for empty info: %q", table)
+		}
+
+		out, err := renderInfoJSON(info)
+		if err != nil {
+			t.Fatalf("renderInfoJSON failed: %v", err)
+		}
+		if out != "{}" {
+			t.Errorf("Expected {} for empty info, got %q", out)
+		}
+	}
+}
+
+func TestJSONFlagIsShared(t *testing.T) {
+	// A local --json would hide the global one, and with it JSON notices
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		if cmd.HasParent() && cmd.LocalNonPersistentFlags().Lookup("json") != nil {
+			t.Errorf("%s defines its own --json flag", cmd.CommandPath())
+		}
+		for _, sub := range cmd.Commands() {
+			walk(sub)
+		}
+	}
+	walk(NewRootCommand())
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// newLogsCommand creates the logs command
+func newLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Print the BMC log",
+		Long: `Print the log buffer of the BMC, to find out why an operation failed on the BMC side.
+With --follow, keep printing new entries as they are logged until interrupted.
+Not every firmware exposes its log.`,
+		Example: `  # Show the last hour of the BMC log
+  tpi logs --since=1h --host=192.168.1.91
+
+  # Follow warnings and errors as they are logged
+  tpi logs --level=warn --follow`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			sinceFlag, _ := cmd.Flags().GetString("since")
+			level, _ := cmd.Flags().GetString("level")
+			follow, _ := cmd.Flags().GetBool("follow")
+
+			opts := tpi.LogOptions{Level: level}
+			if sinceFlag != "" {
+				since, err := parseSince(sinceFlag, time.Now())
+				if err != nil {
+					exitWithError(cmd, err)
+				}
+				opts.Since = since
+			}
+
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			printEntry := func(entry tpi.LogEntry) {
+				fmt.Fprintln(cmd.OutOrStdout(), entry)
+			}
+
+			if !follow {
+				entries, err := client.Logs(opts)
+				if err != nil {
+					exitWithUnsupported(cmd, err, "reading the BMC log")
+				}
+				for _, entry := range entries {
+					printEntry(entry)
+				}
+				return
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+
+			if err := client.FollowLogs(ctx, opts, printEntry); err != nil && !errors.Is(err, context.Canceled) {
+				exitWithUnsupported(cmd, err, "reading the BMC log")
+			}
+		},
+	}
+
+	cmd.Flags().String("since", "", "Only show entries newer than a duration ago (e.g. 1h) or a RFC3339 time")
+	cmd.Flags().String("level", "", "Only show entries at least this severe (trace, debug, info, warn or error)")
+	cmd.Flags().BoolP("follow", "f", false, "Keep printing new entries until interrupted")
+
+	return cmd
+}
+
+// parseSince parses --since, either a duration before now or a RFC3339 time
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, usageErrorf("--since must not be negative: %s", value)
+		}
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, usageErrorf("invalid --since %q (use a duration like 1h or a RFC3339 time)", value)
+}
@@ -0,0 +1,266 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// hostStatus is the last known state of a BMC watched by tpi monitor
+type hostStatus struct {
+	Host string `json:"host"`
+	// Reachable is false when the last poll couldn't reach the BMC
+	Reachable bool `json:"reachable"`
+	// Stale is set when the last poll failed, Power and Usb then hold the last known values
+	Stale bool               `json:"stale"`
+	Power map[int]bool       `json:"power,omitempty"`
+	Usb   *tpi.UsbStatusInfo `json:"usb,omitempty"`
+	Error string             `json:"error,omitempty"`
+	// LastSeen is when the BMC was last polled successfully, zero if it never was
+	LastSeen time.Time `json:"last_seen"`
+	LastPoll time.Time `json:"last_poll"`
+}
+
+// monitor polls a set of BMCs and serves their status over HTTP
+type monitor struct {
+	hosts   []string
+	clients map[string]*tpi.Client
+
+	mu     sync.Mutex
+	status map[string]*hostStatus
+}
+
+// newMonitor creates a monitor for the given clients, keyed by host
+func newMonitor(hosts []string, clients map[string]*tpi.Client) *monitor {
+	m := &monitor{
+		hosts:   hosts,
+		clients: clients,
+		status:  make(map[string]*hostStatus, len(hosts)),
+	}
+	for _, host := range hosts {
+		m.status[host] = &hostStatus{Host: host}
+	}
+	return m
+}
+
+// run polls every host right away, then every interval until ctx is done
+func (m *monitor) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.poll(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll polls every host concurrently, each bounded by timeout
+func (m *monitor) poll(ctx context.Context, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, host := range m.hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			pollCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			m.pollHost(pollCtx, host)
+		}(host)
+	}
+	wg.Wait()
+}
+
+// pollHost updates the status of a host. A failed poll keeps the last known values, marked stale.
+func (m *monitor) pollHost(ctx context.Context, host string) {
+	client := m.clients[host]
+
+	var snapshot *tpi.ClusterSnapshot
+	err := client.Ping(ctx)
+	if err == nil {
+		snapshot = client.Snapshot(ctx)
+		err = snapshot.Power.Err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.status[host]
+	status.LastPoll = time.Now()
+	status.Reachable = snapshot != nil
+	if err != nil {
+		status.Stale = true
+		status.Error = err.Error()
+		return
+	}
+
+	status.Stale = false
+	status.Error = ""
+	status.LastSeen = status.LastPoll
+	status.Power = snapshot.Power.Status
+	if snapshot.Usb.Err == nil {
+		status.Usb = snapshot.Usb.Status
+	}
+}
+
+// statuses returns a copy of the status of every host, in the order they were given
+func (m *monitor) statuses() []hostStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]hostStatus, 0, len(m.hosts))
+	for _, host := range m.hosts {
+		statuses = append(statuses, *m.status[host])
+	}
+	return statuses
+}
+
+// monitorPage is the status page, refreshed by the browser every poll interval
+var monitorPage = template.Must(template.New("monitor").Funcs(template.FuncMap{
+	"nodes": func() []int { return []int{1, 2, 3, 4} },
+	"since": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Second).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Turing Pi monitor</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+.up { color: #2e7d32; } .stale { color: #ef6c00; } .down { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>Turing Pi monitor</h1>
+<table>
+<tr><th>Host</th><th>Status</th>{{range nodes}}<th>Node {{.}}</th>{{end}}<th>USB</th><th>Last seen</th><th>Error</th></tr>
+{{range .Hosts}}<tr>
+<td>{{.Host}}</td>
+<td>{{if .LastPoll.IsZero}}pending{{else if not .Stale}}<span class="up">up</span>{{else if .Reachable}}<span class="stale">stale</span>{{else}}<span class="down">unreachable</span>{{end}}</td>
+{{$power := .Power}}{{range nodes}}<td>{{if $power}}{{if index $power .}}on{{else}}off{{end}}{{else}}-{{end}}</td>{{end}}
+<td>{{with .Usb}}{{.Node}} {{.Mode}} ({{.Route}}){{else}}-{{end}}</td>
+<td>{{since .LastSeen}}</td>
+<td>{{.Error}}</td>
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// handler serves the status page at / and the status as JSON at /status.json
+func (m *monitor) handler(refresh time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.statuses())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		monitorPage.Execute(w, struct {
+			Refresh int
+			Hosts   []hostStatus
+		}{max(int(refresh.Seconds()), 1), m.statuses()})
+	})
+	return mux
+}
+
+// newMonitorCommand creates the monitor command
+func newMonitorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "monitor",
+		Short: "Poll BMCs and serve their status on a web page",
+		Long: `Poll the power and USB state of every BMC given with --hosts or --hosts-file (or --host)
+and serve it on a status page, with the same data as JSON at /status.json.
+BMCs that stop answering keep their last known state, marked stale, and are polled again.`,
+		Example: `  # Watch three boards, refreshing every 30 seconds
+  tpi monitor --hosts=192.168.1.91,192.168.1.92,192.168.1.93
+
+  # Watch the boards of a hosts file and serve the page to the network
+  tpi monitor --hosts-file=boards.txt --interval=1m --listen=:8090`,
+		Run: func(cmd *cobra.Command, args []string) {
+			interval, _ := cmd.Flags().GetDuration("interval")
+			listen, _ := cmd.Flags().GetString("listen")
+			if interval <= 0 {
+				exitWithUsage(cmd, "--interval must be positive")
+			}
+
+			hosts, err := getHosts(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+			if host, _ := cmd.Flags().GetString("host"); len(hosts) == 0 && host != "" {
+				hosts = []string{host}
+			}
+			if len(hosts) == 0 {
+				exitWithUsage(cmd, "no hosts to monitor, use --hosts, --hosts-file or --host")
+			}
+
+			clients := make(map[string]*tpi.Client, len(hosts))
+			for _, host := range hosts {
+				client, err := getClient(cmd, tpi.WithHost(host))
+				if err != nil {
+					exitWithError(cmd, fmt.Errorf("%s: %w", host, err))
+				}
+				clients[host] = client
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+
+			m := newMonitor(hosts, clients)
+			go m.run(ctx, interval)
+
+			server := &http.Server{Addr: listen, Handler: m.handler(interval)}
+			go func() {
+				<-ctx.Done()
+				server.Close()
+			}()
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Monitoring %d BMCs every %s, status page on http://%s/\n", len(hosts), interval, listen)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				exitWithError(cmd, err)
+			}
+		},
+	}
+
+	cmd.Flags().Duration("interval", 30*time.Second, "Time between polls of each BMC")
+	cmd.Flags().String("listen", "127.0.0.1:8090", "Address to serve the status page on")
+
+	return cmd
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// newMonitorBMC starts a mock BMC with node 1 powered on, answering 503 while down is set
+func newMonitorBMC(t *testing.T, down *atomic.Bool) *tpi.Client {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Query().Get("type") {
+		case "power":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":0}]}]}`))
+		case "usb":
+			w.Write([]byte(`{"result":[{"node":"Node1","mode":"Host","route":"AlpineUsb"}]}`))
+		default:
+			w.Write([]byte(`{"id":"mock-token","response":[{"result":[{"api":"1.1"}]}]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := tpi.NewClient(
+		tpi.WithHost(server.Listener.Addr().String()),
+		tpi.WithCredentials("root", "turing"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client
+}
+
+func TestMonitorMarksUnreachableHostsStale(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var down atomic.Bool
+	m := newMonitor([]string{"board1"}, map[string]*tpi.Client{"board1": newMonitorBMC(t, &down)})
+
+	m.poll(context.Background(), 5*time.Second)
+	status := m.statuses()[0]
+	if !status.Reachable || status.Stale || status.Error != "" {
+		t.Fatalf("Expected a healthy host, got %+v", status)
+	}
+	if !status.Power[1] || status.Power[2] {
+		t.Errorf("Expected only node 1 powered on, got %v", status.Power)
+	}
+	if status.Usb == nil || status.Usb.Mode != "Host" {
+		t.Errorf("Expected the USB status, got %+v", status.Usb)
+	}
+	lastSeen := status.LastSeen
+
+	// The last known state is kept when the BMC stops answering
+	down.Store(true)
+	m.poll(context.Background(), 5*time.Second)
+	status = m.statuses()[0]
+	if status.Reachable || !status.Stale || status.Error == "" {
+		t.Fatalf("Expected an unreachable, stale host, got %+v", status)
+	}
+	if !status.Power[1] || !status.LastSeen.Equal(lastSeen) {
+		t.Errorf("Expected the last known state to be kept, got %+v", status)
+	}
+
+	// And refreshed once it's back
+	down.Store(false)
+	m.poll(context.Background(), 5*time.Second)
+	if status = m.statuses()[0]; !status.Reachable || status.Stale {
+		t.Errorf("Expected the host to recover, got %+v", status)
+	}
+}
+
+func TestMonitorHandler(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var down atomic.Bool
+	m := newMonitor([]string{"board1"}, map[string]*tpi.Client{"board1": newMonitorBMC(t, &down)})
+	m.poll(context.Background(), 5*time.Second)
+	handler := m.handler(30 * time.Second)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status.json", nil))
+	var statuses []hostStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	if len(statuses) != 1 || statuses[0].Host != "board1" || !statuses[0].Power[1] {
+		t.Errorf("Unexpected statuses: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	page := rec.Body.String()
+	for _, want := range []string{"board1", `class="up"`, `content="30"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected the page to contain %q, got:\n%s", want, page)
+		}
+	}
+}
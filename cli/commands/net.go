@@ -0,0 +1,116 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// newNetCommand creates the net command
+func newNetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "net",
+		Short: "Show the network addresses of the nodes",
+		Long:  "Show the network addresses of the nodes as the BMC sees them.",
+	}
+
+	cmd.AddCommand(newNetNodesCommand())
+
+	return cmd
+}
+
+// newNetNodesCommand creates the net nodes command
+func newNetNodesCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "nodes",
+		Short: "Print the MAC and IP address of every node",
+		Example: `  # Show the node addresses
+  tpi net nodes --host=192.168.1.91
+
+  # Look up the address of node 2 after it booted
+  tpi net nodes --json --host=192.168.1.91 | jq -r '."2".ip'`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			client, err := getClient(cmd)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			networks, err := client.NodeNetworkInfo()
+			if err != nil {
+				exitWithUnsupported(cmd, err, "reporting the node addresses")
+			}
+
+			if jsonOutput(cmd) {
+				out, err := renderNodeNetworksJSON(networks)
+				if err != nil {
+					exitWithError(cmd, err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), out)
+				return
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), renderNodeNetworksTable(networks))
+		},
+	}
+
+	return cmd
+}
+
+// renderNodeNetworksTable renders the node addresses as a styled table, in node order
+func renderNodeNetworksTable(networks map[int]tpi.NodeNetwork) string {
+	if len(networks) == 0 {
+		return "No node addresses reported by the BMC"
+	}
+
+	table := headerStyle.Render("NODE  ") + headerStyle.Render(fmt.Sprintf("%-17s", "MAC")) + headerStyle.Render("IP")
+	for node := 1; node <= nodeCount; node++ {
+		network, ok := networks[node]
+		if !ok {
+			continue
+		}
+		mac, ip := network.MAC, network.IP
+		if mac == "" {
+			mac = "-"
+		}
+		if ip == "" {
+			ip = "-"
+		}
+		table += "\n" + nodeStyle.Render(fmt.Sprintf("Node %d", node)) + nodeStyle.Render(fmt.Sprintf("%-17s", mac)) + nodeStyle.Render(ip)
+	}
+
+	return tableStyle.Render(table)
+}
+
+// renderNodeNetworksJSON renders the node addresses as indented JSON keyed by node number
+func renderNodeNetworksJSON(networks map[int]tpi.NodeNetwork) (string, error) {
+	keyed := make(map[string]tpi.NodeNetwork, len(networks))
+	for node, network := range networks {
+		keyed[strconv.Itoa(node)] = network
+	}
+
+	out, err := json.MarshalIndent(keyed, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode node addresses: %w", err)
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+func TestRenderNodeNetworks(t *testing.T) {
+	networks := map[int]tpi.NodeNetwork{
+		3: {MAC: "02:00:00:00:00:03", IP: "192.168.1.103"},
+		1: {MAC: "02:00:00:00:00:01"},
+	}
+
+	table := renderNodeNetworksTable(networks)
+	for _, want := range []string{"NODE", "MAC", "IP", "Node 1", "02:00:00:00:00:01", "Node 3", "192.168.1.103"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("Expected table to contain %q, got:\n%s", want, table)
+		}
+	}
+	if strings.Index(table, "Node 1") > strings.Index(table, "Node 3") {
+		t.Errorf("Expected nodes in order, got:\n%s", table)
+	}
+
+	out, err := renderNodeNetworksJSON(networks)
+	if err != nil {
+		t.Fatalf("renderNodeNetworksJSON failed: %v", err)
+	}
+	var decoded map[string]tpi.NodeNetwork
+	if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", out, err)
+	}
+	if len(decoded) != 2 || decoded["3"].IP != "192.168.1.103" || decoded["1"].MAC != "02:00:00:00:00:01" {
+		t.Errorf("Unexpected JSON: %s", out)
+	}
+
+	if table := renderNodeNetworksTable(nil); table != "No node addresses reported by the BMC" {
+		t.Errorf("Unexpected table without addresses: %q", table)
+	}
+}
@@ -15,11 +15,15 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
 )
 
@@ -51,20 +55,49 @@ var (
 // newPowerCommand creates the power command
 func newPowerCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "power [command] [node]",
+		Use:   "power [command] [node|all|file]",
 		Short: "Power on/off or reset specific nodes",
 		Long:  "Power on/off or reset specific nodes.",
 		Example: `  # Power on node 1
   tpi power on 1 --host=192.168.1.91
   
   # Power off all nodes
-  tpi power off --host=192.168.1.91
+  tpi power off all --host=192.168.1.91
   
   # Check power status of all nodes
-  tpi power status --host=192.168.1.91`,
+  tpi power status --host=192.168.1.91
+
+  # Use as a monitoring plugin, nodes 1 and 2 should be on and the others off
+  tpi power status --check --expect=1,2 --host=192.168.1.91
+
+  # Save the power state before maintenance, then restore it
+  tpi power save state.json --host=192.168.1.91
+  tpi power restore state.json --host=192.168.1.91
+
+  # Show why node 2 last powered on or reset
+  tpi power history 2 --host=192.168.1.91`,
 		Args: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("requires a command (on, off, reset, status)")
+				return fmt.Errorf("requires a command (on, off, reset, status, save, restore, history)")
+			}
+
+			// history takes a single node
+			if args[0] == "history" {
+				if len(args) != 2 {
+					return fmt.Errorf("history requires a node, e.g. tpi power history 2")
+				}
+				if _, err := parseNodeArg(args[1]); err != nil {
+					return err
+				}
+				return nil
+			}
+
+			// save and restore take a file instead of a node
+			if args[0] == "save" || args[0] == "restore" {
+				if len(args) != 2 {
+					return fmt.Errorf("%s requires a state file, e.g. tpi power %s state.json", args[0], args[0])
+				}
+				return nil
 			}
 
 			validCommands := map[string]bool{
@@ -75,76 +108,133 @@ func newPowerCommand() *cobra.Command {
 			}
 
 			if !validCommands[args[0]] {
-				return fmt.Errorf("invalid command: %s (must be on, off, reset, or status)", args[0])
+				return fmt.Errorf("invalid command: %s (must be on, off, reset, status, save, restore or history)", args[0])
 			}
 
-			// If a node is specified, validate it
+			// If a node is specified, validate it, selectors such as on or except:2 resolve later
 			if len(args) > 1 {
-				nodeNum, err := strconv.Atoi(args[1])
-				if err != nil {
-					return fmt.Errorf("node must be a number: %v", err)
-				}
-
-				if nodeNum < 1 || nodeNum > 4 {
-					return fmt.Errorf("node number must be between 1 and 4, got %d", nodeNum)
+				if _, _, err := parseNodeSelection(args[1]); err != nil && tpi.ValidateNodeSelector(args[1]) != nil {
+					return err
 				}
 			}
 
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			// Create a client
-			client, err := getClient(cmd)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
-			}
-
 			// Get command flags
 			cmdFlag, _ := cmd.Flags().GetString("cmd")
-			nodeFlag, _ := cmd.Flags().GetInt("node")
+			nodeFlag, _ := cmd.Flags().GetString("node")
+			all, _ := cmd.Flags().GetBool("all")
+			strict, _ := cmd.Flags().GetBool("strict")
+			check, _ := cmd.Flags().GetBool("check")
+			expect, _ := cmd.Flags().GetString("expect")
+
+			switch args[0] {
+			case "save":
+				runPowerSave(cmd, args[1])
+				return
+			case "restore":
+				runPowerRestore(cmd, args[1])
+				return
+			case "history":
+				node, _ := parseNodeArg(args[1]) // Already validated in Args
+				runPowerHistory(cmd, node)
+				return
+			}
 
-			// Get the command (args[0]) and node number (args[1], if present)
+			// Get the command (args[0]) and node number or all (args[1], if present)
 			command := args[0]
+			nodeArg := nodeFlag
+			if len(args) > 1 {
+				nodeArg = args[1]
+			}
+
 			var nodeNum int
+			if nodeArg != "" {
+				var allNodes bool
+				var err error
+				nodeNum, allNodes, err = parseNodeSelection(nodeArg)
+				if err != nil && tpi.ValidateNodeSelector(nodeArg) == nil {
+					// Several nodes, possibly depending on the power status
+					if check {
+						exitWithUsage(cmd, "--check takes a single node or all, got %s", nodeArg)
+					}
+					runPowerSelector(cmd, command, nodeArg)
+					return
+				}
+				if err != nil {
+					exitWithError(cmd, err)
+				}
+				if allNodes && all {
+					exitWithUsage(cmd, "all can't be combined with --all")
+				}
+				all = all || allNodes
+			}
 
-			if len(args) > 1 {
-				nodeNum, _ = strconv.Atoi(args[1]) // Already validated in Args
-			} else if nodeFlag > 0 {
-				// Use node from flag if provided
-				nodeNum = nodeFlag
-			} else if command != "status" {
-				// For on/off/reset without a specified node, use all nodes
-				if command == "on" {
-					err = client.PowerOnAll()
+			if command == "status" && check {
+				runPowerCheck(cmd, nodeNum, expect)
+				return
+			}
+
+			if err := checkNodeSelection(command, nodeNum, all, strict); err != nil {
+				exitWithError(cmd, err)
+			}
+			if implicitAllNodes(command, nodeNum, all) {
+				deprecatedf(cmd, "tpi power %s without a node applies to all nodes. Pass all (tpi power %s all) to keep doing so, a future version will require it.", command, command)
+			}
+
+			// Create a client
+			var options []tpi.Option
+			if strict {
+				options = append(options, tpi.WithStrictNodeSelection())
+			}
+			client, err := getClient(cmd, options...)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			if nodeNum == 0 && command != "status" {
+				// Without a node, on/off apply to all nodes, unless --strict asks for --all
+				switch command {
+				case "on":
+					if all {
+						err = client.PowerOnAll()
+					} else {
+						err = client.Power(tpi.PowerOn, 0)
+					}
 					if err == nil {
-						fmt.Println("✅ All nodes powered on\n")
+						fmt.Fprint(cmd.OutOrStdout(), "✅ All nodes powered on\n\n")
+					}
+				case "off":
+					confirmOrExit(cmd, "This will power off all nodes.")
 
-						// Show current power status
-						fmt.Println("Current power status:")
-						status, _ := client.PowerStatus()
-						printStyledPowerStatus(status, 0)
+					if all {
+						err = client.PowerOffAll()
+					} else {
+						err = client.Power(tpi.PowerOff, 0)
 					}
-				} else if command == "off" {
-					err = client.PowerOffAll()
 					if err == nil {
-						fmt.Println("✅ All nodes powered off\n")
+						fmt.Fprint(cmd.OutOrStdout(), "✅ All nodes powered off\n\n")
+					}
+				case "reset":
+					confirmOrExit(cmd, "This will reset all nodes.")
 
-						// Show current power status
-						fmt.Println("Current power status:")
-						status, _ := client.PowerStatus()
-						printStyledPowerStatus(status, 0)
+					for node := 1; node <= nodeCount && err == nil; node++ {
+						err = client.PowerReset(node)
+					}
+					if err == nil {
+						fmt.Fprint(cmd.OutOrStdout(), "✅ All nodes reset\n\n")
 					}
-				} else if command == "reset" {
-					fmt.Fprintf(os.Stderr, "Error: reset command requires a node number\n")
-					os.Exit(1)
 				}
 
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
 
+				// Show current power status
+				fmt.Fprintln(cmd.OutOrStdout(), "Current power status:")
+				status, _ := client.PowerStatus()
+				printStyledPowerStatus(cmd, status, 0)
 				return
 			}
 
@@ -153,82 +243,309 @@ func newPowerCommand() *cobra.Command {
 			case "status":
 				// Check if the --cmd flag was also used
 				if cmdFlag != "" && cmdFlag != "status" {
-					fmt.Printf("⚠️  Warning: Ignoring --cmd=%s flag in favor of 'status' argument\n", cmdFlag)
+					warnf(cmd, "ignoring --cmd=%s in favor of the 'status' argument", cmdFlag)
 				}
 
 				// Get power status
 				status, err := client.PowerStatus()
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
 
 				// Print the status with nice styling
-				printStyledPowerStatus(status, nodeNum)
+				printStyledPowerStatus(cmd, status, nodeNum)
 
 			case "on":
 				// Check if the --cmd flag was also used
 				if cmdFlag != "" && cmdFlag != "on" {
-					fmt.Printf("⚠️  Warning: Ignoring --cmd=%s flag in favor of 'on' argument\n", cmdFlag)
+					warnf(cmd, "ignoring --cmd=%s in favor of the 'on' argument", cmdFlag)
 				}
 
 				if err := client.PowerOn(nodeNum); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("✅ Node %d powered on\n", nodeNum)
+				fmt.Fprintf(cmd.OutOrStdout(), "✅ Node %d powered on\n", nodeNum)
 
 				// Show the current power status
-				fmt.Println("\nCurrent power status:")
+				fmt.Fprintln(cmd.OutOrStdout(), "\nCurrent power status:")
 				status, _ := client.PowerStatus()
-				printStyledPowerStatus(status, 0)
+				printStyledPowerStatus(cmd, status, 0)
 
 			case "off":
 				// Check if the --cmd flag was also used
 				if cmdFlag != "" && cmdFlag != "off" {
-					fmt.Printf("⚠️  Warning: Ignoring --cmd=%s flag in favor of 'off' argument\n", cmdFlag)
+					warnf(cmd, "ignoring --cmd=%s in favor of the 'off' argument", cmdFlag)
 				}
 
+				confirmOrExit(cmd, fmt.Sprintf("This will power off node %d.", nodeNum))
+
 				if err := client.PowerOff(nodeNum); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("✅ Node %d powered off\n", nodeNum)
+				fmt.Fprintf(cmd.OutOrStdout(), "✅ Node %d powered off\n", nodeNum)
 
 				// Show the current power status
-				fmt.Println("\nCurrent power status:")
+				fmt.Fprintln(cmd.OutOrStdout(), "\nCurrent power status:")
 				status, _ := client.PowerStatus()
-				printStyledPowerStatus(status, 0)
+				printStyledPowerStatus(cmd, status, 0)
 
 			case "reset":
 				// Check if the --cmd flag was also used
 				if cmdFlag != "" && cmdFlag != "reset" {
-					fmt.Printf("⚠️  Warning: Ignoring --cmd=%s flag in favor of 'reset' argument\n", cmdFlag)
+					warnf(cmd, "ignoring --cmd=%s in favor of the 'reset' argument", cmdFlag)
 				}
 
+				confirmOrExit(cmd, fmt.Sprintf("This will reset node %d.", nodeNum))
+
 				if err := client.PowerReset(nodeNum); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("✅ Node %d reset\n", nodeNum)
+				fmt.Fprintf(cmd.OutOrStdout(), "✅ Node %d reset\n", nodeNum)
 
 				// Show the current power status
-				fmt.Println("\nCurrent power status:")
+				fmt.Fprintln(cmd.OutOrStdout(), "\nCurrent power status:")
 				status, _ := client.PowerStatus()
-				printStyledPowerStatus(status, 0)
+				printStyledPowerStatus(cmd, status, 0)
 			}
 		},
 	}
 
 	// Add flags
 	cmd.Flags().StringP("cmd", "c", "", "Specify command [on, off, reset, status]")
-	cmd.Flags().IntP("node", "n", 0, "Node number [1-4]. Not specifying a node selects all nodes")
+	cmd.Flags().StringP("node", "n", "", "Node number [1-4], all, or a selector such as 1,3, on, off or except:2. Not specifying a node selects all nodes for on/off (deprecated), unless --strict is set")
+	cmd.Flags().Bool("all", false, "Apply the command to all nodes, same as the all node")
+	cmd.Flags().Bool("strict", false, "Require a node or all instead of falling back to all nodes")
+	cmd.Flags().Bool("check", false, "With status, print a Nagios style check line and exit 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN)")
+	cmd.Flags().String("expect", "all-on", "Power state expected by --check: all-on, all-off or the nodes expected on, e.g. 1,3")
 
 	return cmd
 }
 
+// runPowerSelector runs command on the nodes selected by a selector such as 1,3, on or
+// except:2, resolved against the current power status
+func runPowerSelector(cmd *cobra.Command, command, selector string) {
+	client, err := getClient(cmd)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	nodes, err := resolveNodes(client, selector)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	if command == "status" {
+		status, err := client.PowerStatus()
+		if err != nil {
+			exitWithError(cmd, err)
+		}
+		selected := make(map[int]bool, len(nodes))
+		for _, node := range nodes {
+			selected[node] = status[node]
+		}
+		printStyledPowerStatus(cmd, selected, 0)
+		return
+	}
+
+	if len(nodes) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "No nodes match %s, nothing to do\n", selector)
+		return
+	}
+
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = strconv.Itoa(node)
+	}
+	switch command {
+	case "off":
+		confirmOrExit(cmd, fmt.Sprintf("This will power off nodes %s.", strings.Join(names, ", ")))
+	case "reset":
+		confirmOrExit(cmd, fmt.Sprintf("This will reset nodes %s.", strings.Join(names, ", ")))
+	}
+
+	for _, node := range nodes {
+		switch command {
+		case "on":
+			err = client.PowerOn(node)
+		case "off":
+			err = client.PowerOff(node)
+		case "reset":
+			err = client.PowerReset(node)
+		}
+		if err != nil {
+			exitWithError(cmd, fmt.Errorf("node %d: %w", node, err))
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "✅ Node %d %s\n", node, powerDone[command])
+	}
+
+	// Show the current power status
+	fmt.Fprintln(cmd.OutOrStdout(), "\nCurrent power status:")
+	status, _ := client.PowerStatus()
+	printStyledPowerStatus(cmd, status, 0)
+}
+
+// powerDone describes the outcome of a power command, by command
+var powerDone = map[string]string{"on": "powered on", "off": "powered off", "reset": "reset"}
+
+// runPowerCheck checks the power status against expect for monitoring, only for node if
+// given, and exits with the check's code
+func runPowerCheck(cmd *cobra.Command, node int, expect string) {
+	expected, err := parsePowerExpect(expect)
+	if err != nil {
+		exitCheck(cmd, CheckUnknown, "UNKNOWN - "+err.Error())
+	}
+	if node > 0 {
+		expected = map[int]bool{node: expected[node]}
+	}
+
+	client, err := getClient(cmd)
+	if err != nil {
+		exitCheck(cmd, CheckUnknown, "UNKNOWN - "+err.Error())
+	}
+	status, err := client.PowerStatus()
+	if err != nil {
+		exitCheck(cmd, CheckUnknown, "UNKNOWN - "+err.Error())
+	}
+
+	code, line := evaluatePowerCheck(status, expected)
+	exitCheck(cmd, code, line)
+}
+
+// runPowerSave writes the power state of every node to path as JSON
+func runPowerSave(cmd *cobra.Command, path string) {
+	client, err := getClient(cmd)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	state, err := client.CapturePowerState()
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		exitWithError(cmd, fmt.Errorf("failed to encode power state: %w", err))
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		exitWithError(cmd, fmt.Errorf("failed to write %s: %w", path, err))
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "✅ Power state saved to %s\n\n", path)
+	printStyledPowerStatus(cmd, state, 0)
+}
+
+// runPowerHistory prints the power events recorded for node, as JSON with --json
+func runPowerHistory(cmd *cobra.Command, node int) {
+	client, err := getClient(cmd)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	events, err := client.NodePowerHistory(node)
+	if err != nil {
+		exitWithUnsupported(cmd, err, "power history")
+	}
+
+	if jsonOutput(cmd) {
+		type jsonEvent struct {
+			Time   *time.Time `json:"time,omitempty"`
+			Event  string     `json:"event"`
+			Reason string     `json:"reason,omitempty"`
+		}
+		out := make([]jsonEvent, 0, len(events))
+		for _, event := range events {
+			entry := jsonEvent{Event: event.Event, Reason: string(event.Reason)}
+			if !event.Time.IsZero() {
+				entry.Time = &event.Time
+			}
+			out = append(out, entry)
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			exitWithError(cmd, fmt.Errorf("failed to encode power history: %w", err))
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(data))
+		return
+	}
+
+	if len(events) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "No power events recorded for node %d\n", node)
+		return
+	}
+	for _, event := range events {
+		when := "unknown time"
+		if !event.Time.IsZero() {
+			when = event.Time.Format(time.RFC3339)
+		}
+		reason := "unknown reason"
+		if event.Reason != "" {
+			reason = strings.ReplaceAll(string(event.Reason), "_", " ")
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%-25s %-6s %s\n", when, event.Event, reason)
+	}
+}
+
+// runPowerRestore applies the power state saved by runPowerSave, changing only the
+// nodes that differ
+func runPowerRestore(cmd *cobra.Command, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitWithError(cmd, fmt.Errorf("failed to read %s: %w", path, err))
+	}
+	var state map[int]bool
+	if err := json.Unmarshal(data, &state); err != nil {
+		exitWithUsage(cmd, "invalid power state file %s: %v", path, err)
+	}
+
+	client, err := getClient(cmd)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	confirmOrExit(cmd, fmt.Sprintf("This will restore the power state saved in %s, powering nodes on or off.", path))
+
+	if err := client.RestorePowerState(state); err != nil {
+		exitWithError(cmd, err)
+	}
+	fmt.Fprint(cmd.OutOrStdout(), "✅ Power state restored\n\n")
+
+	// Show the current power status
+	fmt.Fprintln(cmd.OutOrStdout(), "Current power status:")
+	status, _ := client.PowerStatus()
+	printStyledPowerStatus(cmd, status, 0)
+}
+
+// implicitAllNodes reports whether a power command falls back to all nodes without
+// being asked to, which is deprecated
+func implicitAllNodes(command string, node int, all bool) bool {
+	return (command == "on" || command == "off") && node == 0 && !all
+}
+
+// checkNodeSelection validates the nodes targeted by a power command. Without a node, on and off
+// fall back to all nodes for compatibility; strict mode requires --all for that, and reset
+// always does.
+func checkNodeSelection(command string, node int, all, strict bool) error {
+	if command == "status" {
+		return nil
+	}
+	if all && node > 0 {
+		return usageErrorf("all can't be combined with a node number")
+	}
+	if node > 0 || all {
+		return nil
+	}
+	if command == "reset" {
+		return usageErrorf("reset command requires a node number or all")
+	}
+	if strict {
+		return usageErrorf("%s command requires a node number or all in strict mode", command)
+	}
+	return nil
+}
+
 // printStyledPowerStatus prints the status with nice lipgloss styling
-func printStyledPowerStatus(status map[int]bool, specificNode int) {
+func printStyledPowerStatus(cmd *cobra.Command, status map[int]bool, specificNode int) {
 	// Header
 	header := headerStyle.Render("NODE") + headerStyle.Render("STATUS")
 
@@ -240,8 +557,7 @@ func printStyledPowerStatus(status map[int]bool, specificNode int) {
 		if powerOn, ok := status[specificNode]; ok {
 			rows = append(rows, renderNodeRow(specificNode, powerOn))
 		} else {
-			fmt.Fprintf(os.Stderr, "Error: node %d not found\n", specificNode)
-			os.Exit(1)
+			exitWithUsage(cmd, "node %d not found", specificNode)
 		}
 	} else {
 		// Otherwise show all nodes in order
@@ -259,7 +575,7 @@ func printStyledPowerStatus(status map[int]bool, specificNode int) {
 	}
 
 	// Print the table with border
-	fmt.Println(tableStyle.Render(table))
+	fmt.Fprintln(cmd.OutOrStdout(), tableStyle.Render(table))
 }
 
 // renderNodeRow renders a single row in the power status table
@@ -277,10 +593,10 @@ func renderNodeRow(node int, powerOn bool) string {
 }
 
 // printNodeStatus prints the status of a node (DEPRECATED - using the styled version now)
-func printNodeStatus(node int, powerOn bool) {
+func printNodeStatus(cmd *cobra.Command, node int, powerOn bool) {
 	status := "OFF"
 	if powerOn {
 		status = "ON"
 	}
-	fmt.Printf("Node %d: %s\n", node, status)
+	fmt.Fprintf(cmd.OutOrStdout(), "Node %d: %s\n", node, status)
 }
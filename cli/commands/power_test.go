@@ -0,0 +1,289 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCheckNodeSelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		node    int
+		all     bool
+		strict  bool
+		wantErr bool
+	}{
+		{"lenient on falls back to all nodes", "on", 0, false, false, false},
+		{"lenient off falls back to all nodes", "off", 0, false, false, false},
+		{"lenient reset requires a node", "reset", 0, false, false, true},
+		{"strict off requires a node", "off", 0, false, true, true},
+		{"strict on requires a node", "on", 0, false, true, true},
+		{"strict off with --all", "off", 0, true, true, false},
+		{"strict reset with --all", "reset", 0, true, true, false},
+		{"strict off with a node", "off", 2, false, true, false},
+		{"strict status without a node", "status", 0, false, true, false},
+		{"--all with a node", "on", 3, true, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNodeSelection(tt.command, tt.node, tt.all, tt.strict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPowerStatusWritesToCommandOutput(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		default:
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":1,"node4":0}]}]}`))
+		}
+	}))
+	defer server.Close()
+
+	var out, errOut bytes.Buffer
+	root := NewRootCommand()
+	root.SetOut(&out)
+	root.SetErr(&errOut)
+	root.SetArgs([]string{"power", "status", "--host", server.Listener.Addr().String(), "--user", "root", "--password", "turing"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("power status failed: %v", err)
+	}
+
+	got := out.String()
+	for _, want := range []string{"NODE", "STATUS", "Node 1", "Node 4", "ON", "OFF"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected the rendered table to contain %q, got:\n%s", want, got)
+		}
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("Expected nothing on the error output, got %q", errOut.String())
+	}
+}
+
+func TestParseNodeSelection(t *testing.T) {
+	tests := []struct {
+		arg     string
+		node    int
+		all     bool
+		wantErr bool
+	}{
+		{"1", 1, false, false},
+		{"4", 4, false, false},
+		{"all", 0, true, false},
+		{"ALL", 0, true, false},
+		{"0", 0, false, true},
+		{"5", 0, false, true},
+		{"every", 0, false, true},
+		{"2,4", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		node, all, err := parseNodeSelection(tt.arg)
+		if (err != nil) != tt.wantErr || node != tt.node || all != tt.all {
+			t.Errorf("parseNodeSelection(%q) = %d, %v, %v; expected %d, %v, error %v", tt.arg, node, all, err, tt.node, tt.all, tt.wantErr)
+		}
+	}
+
+	nodes, err := parseNodeList("all")
+	if err != nil || len(nodes) != nodeCount || nodes[0] != 1 || nodes[nodeCount-1] != nodeCount {
+		t.Errorf("Expected all to list nodes 1 to %d, got %v, %v", nodeCount, nodes, err)
+	}
+}
+
+// runPowerCommand runs tpi power with args against a mock BMC, returning the output,
+// the error output and the power queries the BMC received
+func runPowerCommand(t *testing.T, args ...string) (string, string, []url.Values) {
+	t.Helper()
+	return runPowerCommandWithStatus(t, `{"node1":0,"node2":0,"node3":0,"node4":0}`, args...)
+}
+
+// runPowerCommandWithStatus is runPowerCommand against a mock BMC reporting status, a JSON
+// object such as {"node1":1,"node2":0,...}
+func runPowerCommandWithStatus(t *testing.T, status string, args ...string) (string, string, []url.Values) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	var mu sync.Mutex
+	var sets []url.Values
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Query().Get("opt") == "set":
+			mu.Lock()
+			sets = append(sets, r.URL.Query())
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			w.Write([]byte(`{"response":[{"result":[` + status + `]}]}`))
+		}
+	}))
+	defer server.Close()
+
+	var out, errOut bytes.Buffer
+	root := NewRootCommand()
+	root.SetOut(&out)
+	root.SetErr(&errOut)
+	root.SetArgs(append([]string{"power"}, append(args, "--yes", "--host", server.Listener.Addr().String(), "--user", "root", "--password", "turing")...))
+	if err := root.Execute(); err != nil {
+		t.Fatalf("tpi power %v failed: %v", args, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return out.String(), errOut.String(), sets
+}
+
+func TestPowerAllKeyword(t *testing.T) {
+	out, errOut, sets := runPowerCommand(t, "off", "all")
+	if !strings.Contains(out, "All nodes powered off") {
+		t.Errorf("Expected all nodes to be powered off, got:\n%s", out)
+	}
+	if strings.Contains(errOut, "Deprecated") {
+		t.Errorf("Expected no deprecation notice with all, got %q", errOut)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("Expected one power request, got %v", sets)
+	}
+	for node := 1; node <= nodeCount; node++ {
+		if got := sets[0].Get("node" + strconv.Itoa(node)); got != "0" {
+			t.Errorf("Expected node%d=0, got query %v", node, sets[0])
+		}
+	}
+
+	// The node flag takes all too
+	if out, _, _ := runPowerCommand(t, "on", "--node", "all"); !strings.Contains(out, "All nodes powered on") {
+		t.Errorf("Expected all nodes to be powered on, got:\n%s", out)
+	}
+}
+
+func TestPowerExplicitNode(t *testing.T) {
+	out, errOut, sets := runPowerCommand(t, "off", "2")
+	if !strings.Contains(out, "Node 2 powered off") {
+		t.Errorf("Expected node 2 to be powered off, got:\n%s", out)
+	}
+	if strings.Contains(errOut, "Deprecated") {
+		t.Errorf("Expected no deprecation notice with a node, got %q", errOut)
+	}
+	if len(sets) != 1 || sets[0].Get("node2") != "0" || sets[0].Has("node1") {
+		t.Errorf("Expected only node 2 to be powered off, got %v", sets)
+	}
+}
+
+func TestPowerImplicitAllDeprecated(t *testing.T) {
+	out, errOut, _ := runPowerCommand(t, "off")
+	if !strings.Contains(out, "All nodes powered off") {
+		t.Errorf("Expected all nodes to still be powered off, got:\n%s", out)
+	}
+	if !strings.Contains(errOut, "Deprecated") || !strings.Contains(errOut, "tpi power off all") {
+		t.Errorf("Expected a deprecation notice pointing to all, got %q", errOut)
+	}
+
+	// Status isn't mutating, so it isn't deprecated
+	if _, errOut, _ := runPowerCommand(t, "status"); errOut != "" {
+		t.Errorf("Expected no notice for status, got %q", errOut)
+	}
+}
+
+func TestPowerSelectors(t *testing.T) {
+	// Nodes 1 and 3 are on
+	status := `{"node1":1,"node2":0,"node3":1,"node4":0}`
+
+	tests := []struct {
+		args     []string
+		expected []string // type and node of every set request
+	}{
+		{[]string{"reset", "on"}, []string{"reset 0", "reset 2"}},
+		{[]string{"on", "off"}, []string{"power node2=1", "power node4=1"}},
+		{[]string{"off", "except:2"}, []string{"power node1=0", "power node3=0", "power node4=0"}},
+		{[]string{"reset", "--node", "2,4"}, []string{"reset 1", "reset 3"}},
+	}
+
+	for _, test := range tests {
+		_, _, sets := runPowerCommandWithStatus(t, status, test.args...)
+
+		var got []string
+		for _, query := range sets {
+			if query.Get("type") == "reset" {
+				got = append(got, "reset "+query.Get("node"))
+				continue
+			}
+			for node := 1; node <= nodeCount; node++ {
+				key := "node" + strconv.Itoa(node)
+				if value := query.Get(key); value != "" {
+					got = append(got, "power "+key+"="+value)
+				}
+			}
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("tpi power %v: expected requests %v, got %v", test.args, test.expected, got)
+		}
+	}
+}
+
+func TestPowerSelectorWithoutMatch(t *testing.T) {
+	out, _, sets := runPowerCommand(t, "reset", "on")
+	if len(sets) != 0 {
+		t.Errorf("Expected no requests with every node off, got %v", sets)
+	}
+	if !strings.Contains(out, "No nodes match on") {
+		t.Errorf("Expected to be told nothing matched, got:\n%s", out)
+	}
+}
+
+func TestWarningsQuietAndJSON(t *testing.T) {
+	_, errOut, _ := runPowerCommand(t, "on", "2", "--cmd", "off")
+	if !strings.Contains(errOut, "Warning: ignoring --cmd=off in favor of the 'on' argument") {
+		t.Errorf("Expected a warning on stderr, got %q", errOut)
+	}
+
+	if _, errOut, _ := runPowerCommand(t, "on", "2", "--cmd", "off", "--quiet"); errOut != "" {
+		t.Errorf("Expected no warnings with --quiet, got %q", errOut)
+	}
+
+	_, errOut, _ = runPowerCommand(t, "on", "2", "--cmd", "off", "--json")
+	var warning notice
+	if err := json.Unmarshal([]byte(errOut), &warning); err != nil {
+		t.Fatalf("Expected a JSON warning, got %q: %v", errOut, err)
+	}
+	if warning.Level != "warning" || !strings.Contains(warning.Message, "ignoring --cmd=off") {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+
+	// Deprecations go through the same path
+	_, errOut, _ = runPowerCommand(t, "off", "--json")
+	if err := json.Unmarshal([]byte(errOut), &warning); err != nil || warning.Level != "deprecation" {
+		t.Errorf("Expected a JSON deprecation notice, got %q", errOut)
+	}
+}
@@ -16,98 +16,106 @@ package commands
 
 import (
 	"fmt"
-	"os"
+	"strconv"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// defaultRebootWaitTimeout bounds reboot --wait when the global --timeout isn't set
+const defaultRebootWaitTimeout = 2 * time.Minute
+
+// rebootTimeout is the --timeout of reboot: a duration, like the global flag it shadows, or
+// a number of seconds as reboot took before the global flag existed, which is deprecated
+type rebootTimeout struct {
+	value   time.Duration
+	seconds bool
+}
+
+// String implements pflag.Value
+func (t *rebootTimeout) String() string {
+	return t.value.String()
+}
+
+// Set implements pflag.Value
+func (t *rebootTimeout) Set(value string) error {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		t.value, t.seconds = time.Duration(seconds)*time.Second, true
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	t.value, t.seconds = duration, false
+	return nil
+}
+
+// Type implements pflag.Value, it's a duration so the flag reads like the global --timeout
+func (t *rebootTimeout) Type() string {
+	return "duration"
+}
+
 // newRebootCommand creates the reboot command
 func newRebootCommand() *cobra.Command {
 	var waitForBoot bool
-	var waitTimeout int
-	var skipConfirmation bool
-	var showDebug bool
+	var timeout rebootTimeout
 
 	cmd := &cobra.Command{
 		Use:   "reboot",
 		Short: "Reboot the BMC chip",
-		Long:  "Reboot the BMC chip. Nodes will lose power until booted!",
+		Long:  "Reboot the BMC chip. Nodes will lose power until booted!\n\nWith --wait, --timeout bounds the wait for the BMC to come back (2m by default). A --timeout in seconds, as in -t 60, is deprecated.",
 		Run: func(cmd *cobra.Command, args []string) {
+			if timeout.seconds {
+				deprecatedf(cmd, "--timeout in seconds will stop working in a future version, pass a duration such as --timeout=%s", timeout.value)
+			}
+
 			// Create a client
 			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Get confirmation unless skipped
-			if !skipConfirmation {
-				fmt.Println("WARNING: Rebooting the BMC will cause all nodes to lose power until the BMC boots up again.")
-				fmt.Print("Are you sure you want to continue? [y/N] ")
-
-				var response string
-				fmt.Scanln(&response)
-
-				if response != "y" && response != "Y" {
-					fmt.Println("Reboot cancelled.")
-					return
-				}
-			}
+			confirmOrExit(cmd, "WARNING: Rebooting the BMC will cause all nodes to lose power until the BMC boots up again.")
 
-			// If wait is requested, use RebootAndWait
+			// If wait is requested, use RebootAndWaitContext
 			if waitForBoot {
-				fmt.Println("BMC is rebooting...")
-				fmt.Printf("Waiting for BMC to come back online (timeout: %d seconds)\n", waitTimeout)
-
-				// Store original stdout if we need to hide debug output
-				var originalStdout *os.File
-				var null *os.File
-
-				if !showDebug {
-					// Temporarily redirect debug output to /dev/null
-					originalStdout = os.Stdout
-					var err error
-					null, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
-					if err == nil {
-						os.Stdout = null
-						defer func() {
-							os.Stdout = originalStdout
-							null.Close()
-						}()
-					}
+				// --timeout bounds the wait, the default one applies without it
+				waitTimeout := timeout.value
+				if waitTimeout <= 0 {
+					waitTimeout = defaultRebootWaitTimeout
 				}
 
-				// Call the reboot method
-				err := client.RebootAndWait(waitTimeout)
+				fmt.Fprintln(cmd.OutOrStdout(), "BMC is rebooting...")
+				fmt.Fprintf(cmd.OutOrStdout(), "Waiting for BMC to come back online (timeout: %s)\n", waitTimeout)
 
-				// If we redirected debug output, restore stdout before printing progress
-				if !showDebug && originalStdout != nil {
-					os.Stdout = originalStdout
-				}
+				// Call the reboot method
+				result, err := client.RebootAndWaitContext(cmd.Context(), waitTimeout)
 
 				// Print final result
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					if result != nil && result.Attempts > 0 {
+						fmt.Fprintf(cmd.ErrOrStderr(), "Gave up after %s (%d checks)\n", result.Elapsed.Round(time.Second), result.Attempts)
+					}
+					exitWithError(cmd, err)
 				}
 
-				fmt.Println("BMC is back online!")
+				fmt.Fprintf(cmd.OutOrStdout(), "BMC is back online after %s (%d checks)\n", result.Elapsed.Round(time.Second), result.Attempts)
 			} else {
 				// Just reboot without waiting
 				if err := client.Reboot(); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Println("BMC is rebooting...")
+				fmt.Fprintln(cmd.OutOrStdout(), "BMC is rebooting...")
 			}
 		},
 	}
 
 	// Add flags
 	cmd.Flags().BoolVarP(&waitForBoot, "wait", "w", false, "Wait for the BMC to come back online after reboot")
-	cmd.Flags().IntVarP(&waitTimeout, "timeout", "t", 120, "Timeout in seconds when waiting for BMC to come back online")
-	cmd.Flags().BoolVarP(&skipConfirmation, "yes", "y", false, "Skip confirmation prompt")
-	cmd.Flags().BoolVarP(&showDebug, "debug", "d", false, "Show debug output during wait")
+	cmd.Flags().VarP(&timeout, "timeout", "t", "Abort the whole command after this duration (e.g. 30s, 5m), with --wait bound the wait (2m by default); 0 disables it")
+	cmd.Flags().MarkShorthandDeprecated("timeout", "use --timeout")
 
 	return cmd
 }
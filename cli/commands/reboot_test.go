@@ -0,0 +1,69 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRebootTimeoutFlag(t *testing.T) {
+	tests := []struct {
+		args    []string
+		timeout time.Duration
+		seconds bool
+	}{
+		{[]string{"reboot", "-w", "-t", "60"}, time.Minute, true},
+		{[]string{"reboot", "--wait", "--timeout", "90"}, 90 * time.Second, true},
+		{[]string{"reboot", "--timeout=2m"}, 2 * time.Minute, false},
+		{[]string{"--timeout=30s", "reboot"}, 30 * time.Second, false},
+	}
+
+	for _, tt := range tests {
+		cmd, _ := parseCommandLine(t, tt.args)
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil || timeout != tt.timeout {
+			t.Errorf("%v: expected a timeout of %s, got %s (%v)", tt.args, tt.timeout, timeout, err)
+		}
+		if seconds := cmd.Flags().Lookup("timeout").Value.(*rebootTimeout).seconds; seconds != tt.seconds {
+			t.Errorf("%v: expected seconds to be %v", tt.args, tt.seconds)
+		}
+	}
+
+	cmd, _, _ := NewRootCommand().Find([]string{"reboot"})
+	if err := cmd.ParseFlags([]string{"--timeout=soon"}); err == nil {
+		t.Error("Expected an error for an invalid timeout")
+	}
+}
+
+func TestStartCommandTimeoutReboot(t *testing.T) {
+	// Only reboot --wait bounds the command by --timeout itself
+	for _, tt := range []struct {
+		args     []string
+		deadline bool
+	}{
+		{[]string{"reboot", "--timeout=1h"}, true},
+		{[]string{"reboot", "--wait", "--timeout=1h"}, false},
+		{[]string{"power", "status", "--timeout=1h"}, true},
+	} {
+		cmd, _ := parseCommandLine(t, tt.args)
+		cmd.SetContext(context.Background())
+		StartCommandTimeout(cmd)
+		if _, ok := cmd.Context().Deadline(); ok != tt.deadline {
+			t.Errorf("%v: expected a deadline to be %v", tt.args, tt.deadline)
+		}
+	}
+}
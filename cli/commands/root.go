@@ -15,6 +15,12 @@
 package commands
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
 	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
 )
@@ -32,43 +38,105 @@ modules simultaneously. For more information, visit https://turingpi.com/`,
 
 	// Add persistent flags
 	rootCmd.PersistentFlags().StringP("host", "H", "", "BMC hostname or IP address")
+	rootCmd.PersistentFlags().StringSlice("hosts", nil, "Run the command on several BMCs concurrently, comma-separated")
+	rootCmd.PersistentFlags().String("hosts-file", "", "Run the command on the BMCs listed in a file, one per line")
 	rootCmd.PersistentFlags().StringP("user", "u", "", "BMC username")
 	rootCmd.PersistentFlags().StringP("password", "p", "", "BMC password")
+	rootCmd.PersistentFlags().String("password-file", "", "Read the BMC password from a file")
+	rootCmd.PersistentFlags().Bool("password-stdin", false, "Read the BMC password from stdin")
 	rootCmd.PersistentFlags().StringP("api-version", "a", string(tpi.ApiVersionV1_1), "Force which version of the BMC API to use")
+	rootCmd.PersistentFlags().BoolP("yes", "y", false, "Skip confirmation prompts for destructive operations (or set TPI_ASSUME_YES=1)")
+	rootCmd.PersistentFlags().Bool("no-auth", false, "Don't authenticate unless the BMC asks for it, for BMCs without authentication")
+	rootCmd.PersistentFlags().StringArray("header", nil, "Add a header to every BMC request, \"Name: value\" (repeatable)")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Don't print warnings and notes, only results and errors")
+	rootCmd.PersistentFlags().Bool("json", false, "Print results as JSON, and warnings and notes as JSON objects, one per line")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Abort the whole command after this duration (e.g. 30s, 5m); 0 disables it")
 
 	// Add commands
 	rootCmd.AddCommand(newPowerCommand())
 	rootCmd.AddCommand(newUsbCommand())
 	rootCmd.AddCommand(newInfoCommand())
 	rootCmd.AddCommand(newAboutCommand())
+	rootCmd.AddCommand(newCapabilitiesCommand())
+	rootCmd.AddCommand(newDiagnoseCommand())
 	rootCmd.AddCommand(newRebootCommand())
 	rootCmd.AddCommand(newFirmwareCommand())
 	rootCmd.AddCommand(newFlashCommand())
 	rootCmd.AddCommand(newEthCommand())
+	rootCmd.AddCommand(newNetCommand())
 	rootCmd.AddCommand(newUartCommand())
 	rootCmd.AddCommand(newAdvancedCommand())
 	rootCmd.AddCommand(newAuthCommand())
+	rootCmd.AddCommand(newCertCommand())
+	rootCmd.AddCommand(newCoolingCommand())
+	rootCmd.AddCommand(newBMCCommand())
+	rootCmd.AddCommand(newLogsCommand())
 	rootCmd.AddCommand(newAgentCommand())
+	rootCmd.AddCommand(newMonitorCommand())
+	rootCmd.AddCommand(newResetStateCommand())
 
 	return rootCmd
 }
 
-// getClient creates a client from command flags
-func getClient(cmd *cobra.Command) (*tpi.Client, error) {
+// StartCommandTimeout enforces the root --timeout flag on the command about to run.
+// The command's context is cancelled when the timeout expires, and since not every
+// operation honours the context yet, the process exits with ExitCodeTimeout. A command
+// run for one host of --hosts only ends its own run, on its cancelled context.
+func StartCommandTimeout(cmd *cobra.Command) {
+	// reboot --wait bounds its wait by --timeout itself, to report how long it waited
+	if wait, _ := cmd.Flags().GetBool("wait"); cmd.Name() == "reboot" && wait {
+		return
+	}
+
+	// A command may shadow the global --timeout with its own, as reboot does
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil || timeout <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	cmd.SetContext(ctx)
+
+	go func() {
+		<-ctx.Done()
+		cancel()
+		// A command that ended, such as the run of a host of --hosts, cancels the context
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+
+		fmt.Fprintf(cmd.ErrOrStderr(), "Error: operation timed out after %s\n", timeout)
+		if run := hostRunOf(cmd); run != nil {
+			run.finish(ExitCodeTimeout)
+			return
+		}
+		os.Exit(ExitCodeTimeout)
+	}()
+}
+
+// getClient creates a client from command flags, extra options are applied last
+func getClient(cmd *cobra.Command, extra ...tpi.Option) (*tpi.Client, error) {
 	// Get flags
 	host, _ := cmd.Flags().GetString("host")
 	user, _ := cmd.Flags().GetString("user")
-	password, _ := cmd.Flags().GetString("password")
 	apiVersionStr, _ := cmd.Flags().GetString("api-version")
 
+	password, err := getPassword(cmd)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create options
 	options := []tpi.Option{
 		tpi.WithHost(host),
 	}
 
 	// Add API version if specified
-	apiVersion := tpi.ApiVersion(apiVersionStr)
-	if apiVersion != "" {
+	if apiVersionStr != "" {
+		apiVersion, err := tpi.ParseApiVersion(apiVersionStr)
+		if err != nil {
+			return nil, usageErrorf("invalid --api-version: %w", err)
+		}
 		options = append(options, tpi.WithApiVersion(apiVersion))
 	}
 
@@ -77,6 +145,49 @@ func getClient(cmd *cobra.Command) (*tpi.Client, error) {
 		options = append(options, tpi.WithCredentials(user, password))
 	}
 
+	// Skip authentication on open BMCs
+	if noAuth, _ := cmd.Flags().GetBool("no-auth"); noAuth {
+		options = append(options, tpi.WithNoAuth())
+	}
+
+	// Add custom headers, e.g. for an auth gateway in front of the BMC
+	headers, _ := cmd.Flags().GetStringArray("header")
+	for _, header := range headers {
+		key, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, usageErrorf("invalid --header %q (must be \"Name: value\")", header)
+		}
+		options = append(options, tpi.WithHeader(key, strings.TrimSpace(value)))
+	}
+
 	// Create client
-	return tpi.NewClient(options...)
+	return tpi.NewClient(append(options, extra...)...)
+}
+
+// getPassword returns the BMC password from --password, --password-file or --password-stdin
+func getPassword(cmd *cobra.Command) (string, error) {
+	password, _ := cmd.Flags().GetString("password")
+	passwordFile, _ := cmd.Flags().GetString("password-file")
+	passwordStdin, _ := cmd.Flags().GetBool("password-stdin")
+
+	// Only one password source may be used at a time
+	sources := 0
+	for _, set := range []bool{password != "", passwordFile != "", passwordStdin} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", usageErrorf("--password, --password-file and --password-stdin are mutually exclusive")
+	}
+
+	if passwordFile != "" {
+		return tpi.ReadPasswordFile(passwordFile)
+	}
+
+	if passwordStdin {
+		return tpi.ReadPassword(cmd.InOrStdin())
+	}
+
+	return password, nil
 }
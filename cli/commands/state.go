@@ -0,0 +1,71 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+
+	tpi "github.com/davidroman0O/tpi/client"
+	"github.com/spf13/cobra"
+)
+
+// newResetStateCommand creates the reset-state command
+func newResetStateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reset-state",
+		Short: "Clear all cached tokens, agent tokens and images",
+		Long: `Remove everything tpi caches locally: BMC tokens, agent tokens and cached images.
+Unlike "auth logout", which only clears BMC tokens, this starts over from a clean state.`,
+		Example: `  # Preview what would be removed
+  tpi reset-state --dry-run
+
+  # Clear all cached state
+  tpi reset-state`,
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+			paths, err := tpi.CachedState()
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+
+			if len(paths) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No cached state found")
+				return
+			}
+
+			if dryRun {
+				fmt.Fprintln(cmd.OutOrStdout(), "Would remove:")
+			} else {
+				fmt.Fprintln(cmd.OutOrStdout(), "Removing:")
+			}
+			for _, path := range paths {
+				fmt.Fprintf(cmd.OutOrStdout(), "  • %s\n", path)
+			}
+			if dryRun {
+				return
+			}
+
+			if err := tpi.DeleteAllCachedState(); err != nil {
+				exitWithError(cmd, err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "✅ Cached state cleared")
+		},
+	}
+
+	cmd.Flags().Bool("dry-run", false, "Print what would be removed without removing it")
+
+	return cmd
+}
@@ -15,9 +15,15 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
 )
 
@@ -31,19 +37,43 @@ func newUartCommand() *cobra.Command {
   tpi uart get 1 --host=192.168.1.91
   
   # Send a command to node 2 over UART
-  tpi uart set 2 --cmd "ls -la" --host=192.168.1.91`,
+  tpi uart set 2 --cmd "ls -la" --host=192.168.1.91
+  
+  # Run a command on node 2 and print its output
+  tpi uart exec 2 "uname -a" --host=192.168.1.91
+  
+  # Send every line of a script to node 2 over UART
+  tpi uart send 2 --host=192.168.1.91 < script.txt
+  
+  # Show the UART configuration of node 3
+  tpi uart config 3 --host=192.168.1.91
+  
+  # Change the baud rate of node 3
+  tpi uart config 3 --baud 9600 --host=192.168.1.91
+  
+  # Save the UART buffers of all nodes to ./logs, one file per node
+  tpi uart collect --nodes=1-4 --out=./logs/ --host=192.168.1.91`,
 		Args: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("requires an action (get, set)")
+				return fmt.Errorf("requires an action (get, set, send, exec, config, collect)")
 			}
 
 			validActions := map[string]bool{
-				"get": true,
-				"set": true,
+				"get":     true,
+				"set":     true,
+				"send":    true,
+				"exec":    true,
+				"config":  true,
+				"collect": true,
 			}
 
 			if !validActions[args[0]] {
-				return fmt.Errorf("invalid action: %s (must be get or set)", args[0])
+				return fmt.Errorf("invalid action: %s (must be get, set, send, exec, config or collect)", args[0])
+			}
+
+			// The collect action takes its nodes from --nodes
+			if args[0] == "collect" {
+				return nil
 			}
 
 			if len(args) < 2 {
@@ -60,21 +90,30 @@ func newUartCommand() *cobra.Command {
 				}
 			}
 
+			// For exec action, the command follows the node
+			if args[0] == "exec" && len(args) < 3 {
+				return fmt.Errorf("exec action requires a command, e.g. tpi uart exec 2 \"uname -a\"")
+			}
+
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
+			if args[0] == "collect" {
+				runUartCollect(cmd)
+				return
+			}
+
 			// Get node
 			nodeNum, err := parseNodeArg(args[1])
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Create client
-			client, err := getClient(cmd)
+			delay, _ := cmd.Flags().GetDuration("line-delay")
+			client, err := getClient(cmd, tpi.WithUartLineDelay(delay))
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Handle action
@@ -83,44 +122,176 @@ func newUartCommand() *cobra.Command {
 				// Get UART output
 				output, err := client.GetUartOutput(nodeNum)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
-				fmt.Print(output)
+				fmt.Fprint(cmd.OutOrStdout(), output)
 			} else if action == "set" {
 				// Send UART command
 				cmdStr, _ := cmd.Flags().GetString("cmd")
 				if cmdStr == "" {
-					fmt.Fprintln(os.Stderr, "Error: command is required for set action")
-					os.Exit(1)
+					exitWithUsage(cmd, "command is required for set action")
 				}
 
 				if err := client.SendUartCommand(nodeNum, cmdStr); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Command sent to node %d\n", nodeNum)
+			} else if action == "send" {
+				// Send every line of stdin
+				if err := client.SendUartStream(nodeNum, cmd.InOrStdin()); err != nil {
+					exitWithError(cmd, err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Input sent to node %d\n", nodeNum)
+			} else if action == "exec" {
+				// Send the command and print what the node answered
+				wait, _ := cmd.Flags().GetDuration("wait")
+				output, err := client.SendUartAndRead(nodeNum, strings.Join(args[2:], " "), wait)
+				if err != nil {
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("Command sent to node %d\n", nodeNum)
+				fmt.Fprint(cmd.OutOrStdout(), output)
+			} else if action == "config" {
+				runUartConfig(cmd, client, nodeNum)
 			}
 		},
 	}
 
 	// Add flags
 	cmd.Flags().StringP("cmd", "c", "", "Command to send over UART")
+	cmd.Flags().Duration("line-delay", tpi.DefaultUartLineDelay, "Delay between lines (send action)")
+	cmd.Flags().Duration("wait", time.Second, "Time to wait for the command's output (exec action)")
+	cmd.Flags().Int("baud", 0, "Baud rate to set (config action)")
+	cmd.Flags().Int("data-bits", 0, "Data bits to set, 5-8 (config action)")
+	cmd.Flags().String("parity", "", "Parity to set: none, even or odd (config action)")
+	cmd.Flags().String("nodes", "1-4", "Nodes to collect, e.g. 1-4, 1,3, all, on or except:2 (collect action)")
+	cmd.Flags().String("out", ".", "Directory to write node<N>.log files to (collect action)")
 
 	return cmd
 }
 
+// runUartConfig shows the UART configuration of a node, or changes it when any config flag is set
+func runUartConfig(cmd *cobra.Command, client *tpi.Client, nodeNum int) {
+	current, err := client.GetUartConfig(nodeNum)
+	if err != nil {
+		exitWithUnsupported(cmd, err, "UART configuration")
+	}
+
+	if !cmd.Flags().Changed("baud") && !cmd.Flags().Changed("data-bits") && !cmd.Flags().Changed("parity") {
+		fmt.Fprintf(cmd.OutOrStdout(), "Node %d UART: %d baud, %d data bits, parity %s\n", nodeNum, current.BaudRate, current.DataBits, current.Parity)
+		return
+	}
+
+	// Only override the settings that were given
+	config := *current
+	if cmd.Flags().Changed("baud") {
+		config.BaudRate, _ = cmd.Flags().GetInt("baud")
+	}
+	if cmd.Flags().Changed("data-bits") {
+		config.DataBits, _ = cmd.Flags().GetInt("data-bits")
+	}
+	if cmd.Flags().Changed("parity") {
+		parity, _ := cmd.Flags().GetString("parity")
+		config.Parity = tpi.UartParity(parity)
+	}
+
+	if err := client.SetUartConfig(nodeNum, config); err != nil {
+		exitWithUnsupported(cmd, err, "UART configuration")
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Node %d UART set to %d baud, %d data bits, parity %s\n", nodeNum, config.BaudRate, config.DataBits, config.Parity)
+}
+
+// runUartCollect saves the UART buffers of the nodes in --nodes to one file per node in --out
+func runUartCollect(cmd *cobra.Command) {
+	nodesFlag, _ := cmd.Flags().GetString("nodes")
+	outDir, _ := cmd.Flags().GetString("out")
+
+	if err := tpi.ValidateNodeSelector(nodesFlag); err != nil {
+		exitWithError(cmd, &UsageError{Err: err})
+	}
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		exitWithError(cmd, fmt.Errorf("failed to create output directory: %w", err))
+	}
+
+	client, err := getClient(cmd)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	nodes, err := resolveNodes(client, nodesFlag)
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+	if len(nodes) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "No nodes match %s, nothing to collect\n", nodesFlag)
+		return
+	}
+
+	// Keep the buffers that were fetched even if some nodes failed
+	outputs, collectErr := client.CollectUart(nodes)
+
+	for _, node := range nodes {
+		output, ok := outputs[node]
+		if !ok {
+			continue
+		}
+
+		path := filepath.Join(outDir, fmt.Sprintf("node%d.log", node))
+		if err := os.WriteFile(path, []byte(output), 0o644); err != nil {
+			exitWithError(cmd, fmt.Errorf("failed to write %s: %w", path, err))
+		}
+
+		if output == "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "Node %d: empty UART buffer, wrote %s\n", node, path)
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "Node %d: wrote %d bytes to %s\n", node, len(output), path)
+		}
+	}
+
+	if collectErr != nil {
+		exitWithError(cmd, collectErr)
+	}
+}
+
 // parseNodeArg parses and validates the node argument
 func parseNodeArg(arg string) (int, error) {
-	var nodeNum int
-	_, err := fmt.Sscanf(arg, "%d", &nodeNum)
+	// Sscanf would take the 2 of 2,4 as a node
+	nodeNum, err := strconv.Atoi(strings.TrimSpace(arg))
 	if err != nil {
-		return 0, fmt.Errorf("node must be a number: %v", err)
+		return 0, usageErrorf("node must be a number, got %q", arg)
 	}
 
-	if nodeNum < 1 || nodeNum > 4 {
-		return 0, fmt.Errorf("node number must be between 1 and 4, got %d", nodeNum)
+	if nodeNum < 1 || nodeNum > nodeCount {
+		return 0, usageErrorf("node number must be between 1 and %d, got %d", nodeCount, nodeNum)
 	}
 
 	return nodeNum, nil
 }
+
+// nodeCount is the number of nodes of the board, all selects nodes 1 to nodeCount
+const nodeCount = 4
+
+// parseNodeSelection parses a node argument that may also be all, for every node
+func parseNodeSelection(arg string) (node int, all bool, err error) {
+	if strings.EqualFold(strings.TrimSpace(arg), "all") {
+		return 0, true, nil
+	}
+	node, err = parseNodeArg(arg)
+	return node, false, err
+}
+
+// parseNodeList parses a node selector that doesn't need the power status, such as "1-4",
+// "1,3", "1-2,4", all or except:2, keeping the order given
+func parseNodeList(arg string) ([]int, error) {
+	return resolveNodes(nil, arg)
+}
+
+// resolveNodes resolves a node selector, see tpi.ResolveNodeSelector. The on and off
+// selectors read the power status through client. Malformed selectors are usage errors.
+func resolveNodes(client *tpi.Client, arg string) ([]int, error) {
+	nodes, err := tpi.ResolveNodeSelector(client, arg)
+	if errors.Is(err, tpi.ErrInvalidNodeSelector) {
+		return nil, &UsageError{Err: err}
+	}
+	return nodes, err
+}
@@ -16,9 +16,9 @@ package commands
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 
+	tpi "github.com/davidroman0O/tpi/client"
 	"github.com/spf13/cobra"
 )
 
@@ -80,8 +80,7 @@ func newUsbCommand() *cobra.Command {
 			// Create a client
 			client, err := getClient(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
+				exitWithError(cmd, err)
 			}
 
 			// Get the mode and node number
@@ -100,13 +99,12 @@ func newUsbCommand() *cobra.Command {
 				// Get USB status
 				status, err := client.UsbGetStatus()
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+					exitWithError(cmd, err)
 				}
 
 				// Print status
-				fmt.Println("    USB Host    -->    USB Device    ")
-				fmt.Println("---------------    ---------------")
+				fmt.Fprintln(cmd.OutOrStdout(), "    USB Host    -->    USB Device    ")
+				fmt.Fprintln(cmd.OutOrStdout(), "---------------    ---------------")
 
 				var host, device string
 				if status.Mode == "host" {
@@ -117,28 +115,22 @@ func newUsbCommand() *cobra.Command {
 					device = status.Node
 				}
 
-				fmt.Printf("    %-12s -->    %-12s\n", host, device)
+				fmt.Fprintf(cmd.OutOrStdout(), "    %-12s -->    %-12s\n", host, device)
 
 			case "device":
-				if err := client.UsbSetDevice(nodeNum, bmcFlag); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
-				}
-				fmt.Printf("Node %d configured as USB device\n", nodeNum)
+				takeUsbBus(cmd, client, nodeNum, tpi.UsbDevice, bmcFlag)
+				fmt.Fprintf(cmd.OutOrStdout(), "Node %d configured as USB device\n", nodeNum)
 
 			case "host":
-				if err := client.UsbSetHost(nodeNum, bmcFlag); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
-				}
-				fmt.Printf("Node %d configured as USB host\n", nodeNum)
+				takeUsbBus(cmd, client, nodeNum, tpi.UsbHost, bmcFlag)
+				fmt.Fprintf(cmd.OutOrStdout(), "Node %d configured as USB host\n", nodeNum)
 
 			case "flash":
-				if err := client.UsbSetFlash(nodeNum, bmcFlag); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
+				if err := checkCompatibility(cmd, client, tpi.FirmwareOpUsbFlashMode); err != nil {
+					exitWithError(cmd, err)
 				}
-				fmt.Printf("Node %d configured in USB flash mode\n", nodeNum)
+				takeUsbBus(cmd, client, nodeNum, tpi.UsbFlash, bmcFlag)
+				fmt.Fprintf(cmd.OutOrStdout(), "Node %d configured in USB flash mode\n", nodeNum)
 			}
 		},
 	}
@@ -147,6 +139,36 @@ func newUsbCommand() *cobra.Command {
 	cmd.Flags().StringP("mode", "m", "", "Specify mode [device, host, flash, status]")
 	cmd.Flags().IntP("node", "n", 0, "Node number [1-4]")
 	cmd.Flags().BoolP("bmc", "b", false, "Instead of USB-A, route the USB-bus to the BMC chip")
+	cmd.Flags().Bool("force", false, "Enter USB flash mode even on firmware where it is known to be broken")
 
 	return cmd
 }
+
+// takeUsbBus routes the USB bus to node in the given role, telling the user which node lost it:
+// the bus can only be routed to one node at a time. The routing is only read for that, so the
+// bus is routed even if it can't be read.
+func takeUsbBus(cmd *cobra.Command, client *tpi.Client, node int, role tpi.UsbRole, bmc bool) {
+	holder := 0
+	if status, err := client.UsbGetStatus(); err != nil {
+		warnf(cmd, "couldn't read the USB routing, routing it anyway: %v", err)
+	} else {
+		holder = status.BusHolder()
+	}
+
+	var err error
+	switch role {
+	case tpi.UsbHost:
+		err = client.UsbSetHost(node, bmc)
+	case tpi.UsbDevice:
+		err = client.UsbSetDevice(node, bmc)
+	default:
+		err = client.UsbSetFlash(node, bmc)
+	}
+	if err != nil {
+		exitWithError(cmd, err)
+	}
+
+	if holder != 0 && holder != node {
+		notef(cmd, "the USB bus was held by node %d, it is now routed to node %d", holder, node)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// notice is a warning printed as JSON with --json
+type notice struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// warnf prints a warning to the command's error output, see printNotice
+func warnf(cmd *cobra.Command, format string, args ...interface{}) {
+	printNotice(cmd, "warning", fmt.Sprintf(format, args...))
+}
+
+// notef prints a note about how the command ran to the command's error output, see printNotice
+func notef(cmd *cobra.Command, format string, args ...interface{}) {
+	printNotice(cmd, "note", fmt.Sprintf(format, args...))
+}
+
+// deprecatedf prints a deprecation notice to the command's error output, see printNotice
+func deprecatedf(cmd *cobra.Command, format string, args ...interface{}) {
+	printNotice(cmd, "deprecation", fmt.Sprintf(format, args...))
+}
+
+// noticePrefixes are the prefixes of notices printed for humans, by level
+var noticePrefixes = map[string]string{
+	"warning":     "Warning",
+	"note":        "Note",
+	"deprecation": "Deprecated",
+}
+
+// jsonOutput reports whether the global --json is set, commands then print their results
+// as JSON and notices as JSON objects
+func jsonOutput(cmd *cobra.Command) bool {
+	asJSON, _ := cmd.Flags().GetBool("json")
+	return asJSON
+}
+
+// printNotice prints message to the command's error output, prefixed by its level for
+// humans or as a JSON object on one line with --json. Nothing is printed with --quiet,
+// errors are never notices.
+func printNotice(cmd *cobra.Command, level, message string) {
+	if quiet, _ := cmd.Flags().GetBool("quiet"); quiet {
+		return
+	}
+	if jsonOutput(cmd) {
+		json.NewEncoder(cmd.ErrOrStderr()).Encode(notice{Level: level, Message: message})
+		return
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "%s: %s\n", noticePrefixes[level], message)
+}
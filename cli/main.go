@@ -16,10 +16,8 @@ package main
 
 import (
 	"fmt"
-	"net"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/davidroman0O/tpi/cli/commands"
 	"github.com/spf13/cobra"
@@ -55,6 +53,44 @@ func main() {
 	}
 
 	// Create a root command
+	rootCmd := newRootCommand()
+
+	// Handle generating shell completions
+	if len(os.Args) > 1 && os.Args[1] == "-gen" && len(os.Args) > 2 {
+		shell := os.Args[2]
+		var err error
+		switch strings.ToLower(shell) {
+		case "bash":
+			err = rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			err = rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			err = rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown shell type: %s\n", shell)
+			os.Exit(commands.ExitCodeUsage)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating completion: %s\n", err)
+			os.Exit(commands.ExitCodeError)
+		}
+		os.Exit(0)
+	}
+
+	// Execute the command - Cobra will handle all the argument parsing.
+	// Commands exit on their own failures, so errors here are unknown
+	// commands, bad flags or arguments and a missing host.
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(commands.ExitCodeUsage)
+	}
+}
+
+// newRootCommand creates the root command of the CLI, with the version command and the
+// checks run before every command
+func newRootCommand() *cobra.Command {
 	rootCmd := commands.NewRootCommand()
 
 	// Add version command
@@ -62,9 +98,9 @@ func main() {
 		Use:   "version",
 		Short: "Print the version information",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("TPI CLI %s\n", version)
-			fmt.Printf("Commit: %s\n", commit)
-			fmt.Printf("Built: %s\n", date)
+			fmt.Fprintf(cmd.OutOrStdout(), "TPI CLI %s\n", version)
+			fmt.Fprintf(cmd.OutOrStdout(), "Commit: %s\n", commit)
+			fmt.Fprintf(cmd.OutOrStdout(), "Built: %s\n", date)
 		},
 	})
 
@@ -75,13 +111,33 @@ func main() {
 			return nil
 		}
 
+		// Running on a Turing Pi BMC, use its API when no host is given
+		if cmd.Name() != "version" && cmd.Name() != "reset-state" {
+			if host, ok := commands.DetectHost(cmd); ok && debug == "true" {
+				fmt.Printf("Detected running on a Turing Pi BMC, using %s as host\n", host)
+			}
+		}
+
+		// monitor watches every host itself
+		if cmd.Name() == "monitor" {
+			return nil
+		}
+
+		// With --hosts, run the command once per host and exit with the aggregated status
+		if code, ok := commands.RunOnHosts(cmd, args, newRootCommand); ok {
+			os.Exit(code)
+		}
+
+		// Bound the whole command by --timeout
+		commands.StartCommandTimeout(cmd)
+
 		// Skip validation for auth commands
 		if cmd.Name() == "auth" || cmd.Parent() != nil && cmd.Parent().Name() == "auth" {
 			return nil
 		}
 
-		// Skip validation for version command
-		if cmd.Name() == "version" {
+		// Skip validation for commands that don't talk to the BMC
+		if cmd.Name() == "version" || cmd.Name() == "reset-state" {
 			return nil
 		}
 
@@ -90,76 +146,11 @@ func main() {
 
 		// For all other commands, validate the host
 		if host == "" {
-			return fmt.Errorf("No host specified. Please provide the Turing Pi hostname with --host.\nExample: tpi --host=192.168.1.91 power status\n\nIf running on a Turing Pi, the host should be detected automatically.")
+			return fmt.Errorf("No host specified. Please provide the Turing Pi hostname with --host.\nExample: tpi --host=192.168.1.91 power status\n\nOn the BMC of a Turing Pi, the local API is detected automatically unless TPI_NO_AUTODETECT is set.")
 		}
 
 		return nil
 	}
 
-	// Handle generating shell completions
-	if len(os.Args) > 1 && os.Args[1] == "-gen" && len(os.Args) > 2 {
-		shell := os.Args[2]
-		var err error
-		switch strings.ToLower(shell) {
-		case "bash":
-			err = rootCmd.GenBashCompletion(os.Stdout)
-		case "zsh":
-			err = rootCmd.GenZshCompletion(os.Stdout)
-		case "fish":
-			err = rootCmd.GenFishCompletion(os.Stdout, true)
-		case "powershell":
-			err = rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
-		default:
-			fmt.Fprintf(os.Stderr, "Unknown shell type: %s\n", shell)
-			os.Exit(1)
-		}
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error generating completion: %s\n", err)
-			os.Exit(1)
-		}
-		os.Exit(0)
-	}
-
-	// Set up host auto-detection
-	cobra.OnInitialize(func() {
-		if isLocalTuringPi() {
-			// Running on a Turing Pi, set the host to localhost if not already set
-			hostFlag := rootCmd.PersistentFlags().Lookup("host")
-			if hostFlag != nil && hostFlag.Value.String() == "" {
-				rootCmd.PersistentFlags().Set("host", "127.0.0.1")
-				if debug == "true" {
-					fmt.Println("Detected running on a Turing Pi, using 127.0.0.1 as host")
-				}
-			}
-		}
-	})
-
-	// Execute the command - Cobra will handle all the argument parsing
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
-	}
-}
-
-// isLocalTuringPi tries to detect if we're running on a Turing Pi
-func isLocalTuringPi() bool {
-	// Try different local addresses that might indicate we're on a Turing Pi
-	addresses := []string{"127.0.0.1:80", "127.0.0.1:443"}
-
-	for _, address := range addresses {
-		conn, err := net.DialTimeout("tcp", address, 100*time.Millisecond)
-		if err == nil {
-			conn.Close()
-			return true
-		}
-	}
-
-	// Additional checks - try to identify Turing Pi specific hardware or files
-	// This is a simple placeholder - we could add more sophisticated detection here
-	_, err := os.Stat("/sys/class/gpio/export")
-	if err == nil {
-		return true
-	}
-
-	return false
+	return rootCmd
 }
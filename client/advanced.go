@@ -15,13 +15,34 @@
 package tpi
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"path"
 	"strings"
 	"time"
 )
 
+// msdPollInterval is the wait between block device listings in WaitForMsdDevice, a
+// variable so tests can shorten it
+var msdPollInterval = 2 * time.Second
+
+// executeOnBMC runs a command on the BMC over SSH, a variable so tests can replace it
+var executeOnBMC = func(c *Client, command string) (string, error) {
+	return c.ExecuteCommand(command)
+}
+
+// listUSBDisksCommand lists the disks of the BMC with their transport, falling back to
+// the sysfs links, whose targets go through the USB bus, where lsblk is missing
+const listUSBDisksCommand = "lsblk -dnpo NAME,TRAN 2>/dev/null || ls -l /sys/block"
+
+// listMsdDisksCommand lists the USB disks of the BMC with the USB device each one is on and
+// that device's number, which the kernel assigns anew every time the device enumerates
+const listMsdDisksCommand = `for d in /sys/block/*; do p=$(readlink -f "$d/device") || continue; ` +
+	`case "$p" in */usb*) u=$p; while [ "$u" != / ] && [ ! -e "$u/devnum" ]; do u=$(dirname "$u"); done; ` +
+	`echo "/dev/${d##*/} ${u##*/} $(cat "$u/devnum" 2>/dev/null)";; esac; done`
+
 // SetNodeNormalMode sets the specified node to normal mode (clears any advanced mode)
 // and resets the node
 func (c *Client) SetNodeNormalMode(node int) error {
@@ -38,7 +59,7 @@ func (c *Client) SetNodeNormalMode(node int) error {
 	// Add query parameters
 	req.AddQueryParam("opt", "set")
 	req.AddQueryParam("type", "clear_usb_boot")
-	req.AddQueryParam("node", strconv.Itoa(node-1)) // BMC uses 0-based indexing
+	req.AddQueryParam("node", toBMCNodeIndex(node))
 
 	// Send the request
 	resp, err := req.Send()
@@ -53,9 +74,18 @@ func (c *Client) SetNodeNormalMode(node int) error {
 	}
 
 	// Then, reset the node to apply changes
-	return c.PowerReset(node)
+	if err := c.PowerReset(node); err != nil {
+		return err
+	}
+
+	c.emit(EventNodeMode, node, string(ModeNormal))
+	return nil
 }
 
+// msdTimeouts are the timeouts of the attempts of SetNodeMsdMode, which can take long
+// as the node reboots
+var msdTimeouts = []time.Duration{60 * time.Second, 120 * time.Second}
+
 // SetNodeMsdMode puts the specified node into Mass Storage Device mode
 // This reboots supported compute modules and exposes its eMMC storage as a mass storage device
 func (c *Client) SetNodeMsdMode(node int) error {
@@ -63,98 +93,187 @@ func (c *Client) SetNodeMsdMode(node int) error {
 		return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
 	}
 
-	// Create a request with a longer timeout specifically for MSD mode
-	// which takes longer to complete
+	// A timed out or rejected request is retried once, with a longer timeout and a fresh token
+	retry := newBackoff(c.retryPolicy(DefaultMsdRetryPolicy))
+	for {
+		retried := retry.Retries() > 0
+		err := c.sendMsdMode(node, msdTimeouts[retry.Retries()], retried)
+		if err == nil {
+			break
+		}
+		if retried || !(isTimeoutError(err) || errors.Is(err, ErrUnauthorized)) {
+			return err
+		}
+
+		if isTimeoutError(err) {
+			Debug("MSD mode operation taking longer than expected, retrying with a longer timeout")
+		}
+		time.Sleep(retry.Next())
+	}
+
+	Debug("Node %d set to MSD mode, it may take up to a minute to reboot", node)
+	c.emit(EventNodeMode, node, string(ModeMsd))
+	return nil
+}
+
+// sendMsdMode sends the request putting node in MSD mode. With reauthenticate, the cached
+// token is dropped and a new one is requested first.
+func (c *Client) sendMsdMode(node int, timeout time.Duration, reauthenticate bool) error {
 	req, err := c.newRequest()
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
-
-	// Increase the timeout for this operation, as it can take longer
-	req.Timeout = 60 * time.Second
+	req.Timeout = timeout
 
 	// Add query parameters
 	req.AddQueryParam("opt", "set")
 	req.AddQueryParam("type", "node_to_msd")
-	req.AddQueryParam("node", strconv.Itoa(node-1)) // BMC uses 0-based indexing
+	req.AddQueryParam("node", toBMCNodeIndex(node))
 
-	// Send the request with auto-retry on auth failures
-	var resp *http.Response
+	if reauthenticate {
+		DeleteCachedToken(c.Host)
+		if _, err := req.ForceAuthentication(); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
 
-	// First try with any cached token
-	resp, err = req.Send()
+	resp, err := req.Send()
 	if err != nil {
-		// If send failed, try force authentication and retry once
-		if isTimeoutError(err) {
-			fmt.Printf("MSD mode operation taking longer than expected. Retrying with longer timeout...\n")
-
-			// Create a new request with even longer timeout
-			req, err = c.newRequest()
-			if err != nil {
-				return fmt.Errorf("failed to create request: %w", err)
-			}
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
 
-			// Try with a much longer timeout
-			req.Timeout = 120 * time.Second
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("failed to set MSD mode: %w", ErrUnauthorized)
+	}
 
-			req.AddQueryParam("opt", "set")
-			req.AddQueryParam("type", "node_to_msd")
-			req.AddQueryParam("node", strconv.Itoa(node-1))
+	// Check for errors in the response
+	if err := checkResponseError(resp); err != nil {
+		return fmt.Errorf("failed to set MSD mode: %w", err)
+	}
+	return nil
+}
 
-			// Force authentication to ensure we have a valid token
-			if _, authErr := req.ForceAuthentication(); authErr != nil {
-				return fmt.Errorf("authentication failed: %w", authErr)
-			}
+// USBDisks lists the USB block devices of the BMC over SSH (see ExecuteCommand), such as
+// the eMMC of nodes in MSD mode
+func (c *Client) USBDisks() ([]string, error) {
+	output, err := executeOnBMC(c, listUSBDisksCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list block devices: %w", err)
+	}
+	return parseUSBDisks(output), nil
+}
 
-			// Retry the request
-			resp, err = req.Send()
-			if err != nil {
-				return fmt.Errorf("failed to send request: %w", err)
-			}
-		} else {
-			return fmt.Errorf("failed to send request: %w", err)
-		}
+// SetNodeMsdModeAndWait puts the node in MSD mode and waits until its eMMC shows up as a
+// USB block device on the BMC, returning its path, such as /dev/sda. The disks already
+// there before the mode change are never taken for the node's, unless they enumerate again.
+func (c *Client) SetNodeMsdModeAndWait(ctx context.Context, node int) (string, error) {
+	if node < 1 || node > 4 {
+		return "", fmt.Errorf("invalid node number: %d (must be 1-4)", node)
 	}
-	defer resp.Body.Close()
 
-	// If we get unauthorized, try to force authentication and retry
-	if resp.StatusCode == http.StatusUnauthorized {
-		// Delete the cached token which is causing the 401
-		DeleteCachedToken(c.Host)
+	before, err := c.msdDisks()
+	if err != nil {
+		return "", err
+	}
+	if err := c.SetNodeMsdMode(node); err != nil {
+		return "", err
+	}
+	return c.waitForMsdDisk(ctx, node, before)
+}
 
-		// Force re-authentication
-		req, err = c.newRequest()
-		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
-		}
+// WaitForMsdDevice waits until the eMMC of node, put in MSD mode with SetNodeMsdMode, shows up
+// as a USB block device on the BMC, and returns its path. Call it right after the mode change:
+// the node's disk is the one that enumerates from then on, which a disk that was already there
+// doesn't, even if the node's comes back under its name. It lists the disks until ctx is done.
+func (c *Client) WaitForMsdDevice(ctx context.Context, node int) (string, error) {
+	if node < 1 || node > 4 {
+		return "", fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+	}
 
-		// Maintain the longer timeout
-		req.Timeout = 60 * time.Second
+	before, err := c.msdDisks()
+	if err != nil {
+		return "", err
+	}
+	return c.waitForMsdDisk(ctx, node, before)
+}
 
-		req.AddQueryParam("opt", "set")
-		req.AddQueryParam("type", "node_to_msd")
-		req.AddQueryParam("node", strconv.Itoa(node-1))
+// waitForMsdDisk lists the USB disks until one that isn't in before enumerates, and returns it
+func (c *Client) waitForMsdDisk(ctx context.Context, node int, before []msdDisk) (string, error) {
+	known := make(map[msdDisk]bool, len(before))
+	for _, disk := range before {
+		known[disk.enumeration()] = true
+	}
 
-		// Force authentication before sending
-		if _, authErr := req.ForceAuthentication(); authErr != nil {
-			return fmt.Errorf("authentication failed: %w", authErr)
+	var lastErr error
+	for {
+		disks, err := c.msdDisks()
+		lastErr = err
+		for _, disk := range disks {
+			if !known[disk.enumeration()] {
+				return disk.device, nil
+			}
 		}
 
-		// Retry the request with the new token
-		resp, err = req.Send()
-		if err != nil {
-			return fmt.Errorf("failed to send request after re-authentication: %w", err)
+		select {
+		case <-ctx.Done():
+			if lastErr == nil {
+				lastErr = ctx.Err()
+			}
+			return "", fmt.Errorf("no MSD device appeared for node %d: %w", node, lastErr)
+		case <-time.After(msdPollInterval):
 		}
-		defer resp.Body.Close()
 	}
+}
 
-	// Check for errors in the response
-	if err := checkResponseError(resp); err != nil {
-		return fmt.Errorf("failed to set MSD mode: %w", err)
+// msdDisk is a USB disk of the BMC, on the USB device at port with the number devnum
+type msdDisk struct {
+	device string
+	port   string
+	devnum string
+}
+
+// enumeration identifies the enumeration of the USB device the disk is on, whatever the name
+// the disk got
+func (d msdDisk) enumeration() msdDisk {
+	return msdDisk{port: d.port, devnum: d.devnum}
+}
+
+// msdDisks lists the USB disks of the BMC over SSH, with the USB device they are on
+func (c *Client) msdDisks() ([]msdDisk, error) {
+	output, err := executeOnBMC(c, listMsdDisksCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list block devices: %w", err)
 	}
+	return parseMsdDisks(output), nil
+}
 
-	fmt.Println("Setting node to MSD mode. This may take up to a minute...")
-	return nil
+// parseMsdDisks parses the lines of listMsdDisksCommand: a device path, the USB port and the
+// device number
+func parseMsdDisks(output string) []msdDisk {
+	var disks []msdDisk
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 {
+			disks = append(disks, msdDisk{device: fields[0], port: fields[1], devnum: fields[2]})
+		}
+	}
+	return disks
+}
+
+// parseUSBDisks returns the USB disks in the output of listUSBDisksCommand: lsblk lines
+// of a device path and its transport, or ls -l lines of /sys/block links
+func parseUSBDisks(output string) []string {
+	var devices []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[1] == "usb":
+			devices = append(devices, fields[0])
+		case len(fields) >= 3 && fields[len(fields)-2] == "->" && strings.Contains(fields[len(fields)-1], "/usb"):
+			devices = append(devices, "/dev/"+path.Base(fields[len(fields)-1]))
+		}
+	}
+	return devices
 }
 
 // Helper function to determine if an error is a timeout error
@@ -163,6 +282,8 @@ func isTimeoutError(err error) bool {
 		return false
 	}
 
-	return strings.Contains(err.Error(), "timeout") ||
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr) ||
+		strings.Contains(err.Error(), "timeout") ||
 		strings.Contains(err.Error(), "deadline exceeded")
 }
@@ -0,0 +1,168 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeBMCExec replaces the SSH commands run on the BMC with outputs, one per call, the
+// last one repeating, and returns the number of calls
+func fakeBMCExec(t *testing.T, outputs ...string) *int {
+	t.Helper()
+
+	interval := msdPollInterval
+	msdPollInterval = time.Millisecond
+	original := executeOnBMC
+	calls := 0
+	executeOnBMC = func(c *Client, command string) (string, error) {
+		if command != listMsdDisksCommand {
+			return "", errors.New("unexpected command " + command)
+		}
+		output := outputs[min(calls, len(outputs)-1)]
+		calls++
+		return output, nil
+	}
+	t.Cleanup(func() {
+		executeOnBMC = original
+		msdPollInterval = interval
+	})
+
+	return &calls
+}
+
+func TestWaitForMsdDevice(t *testing.T) {
+	calls := fakeBMCExec(t,
+		"",
+		"",
+		"/dev/sda 1-1 5\n",
+	)
+	client, err := NewClient(WithHost("127.0.0.1:1"), WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	device, err := client.WaitForMsdDevice(ctx, 1)
+	if err != nil {
+		t.Fatalf("WaitForMsdDevice failed: %v", err)
+	}
+	if device != "/dev/sda" {
+		t.Errorf("Expected /dev/sda, got %q", device)
+	}
+	if *calls != 3 {
+		t.Errorf("Expected 3 listings, got %d", *calls)
+	}
+}
+
+func TestSetNodeMsdModeAndWaitIgnoresExistingDisk(t *testing.T) {
+	// A USB disk is plugged into the BMC before the node is switched, alone on the
+	// first listings after the switch too
+	calls := fakeBMCExec(t,
+		"/dev/sda 2-1 3\n",
+		"/dev/sda 2-1 3\n",
+		"/dev/sda 2-1 3\n",
+		"/dev/sda 2-1 3\n/dev/sdb 1-1 6\n",
+	)
+	var msdRequests atomic.Int32
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		if r.URL.Query().Get("type") == "node_to_msd" {
+			msdRequests.Add(1)
+		}
+		w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	device, err := client.SetNodeMsdModeAndWait(ctx, 2)
+	if err != nil {
+		t.Fatalf("SetNodeMsdModeAndWait failed: %v", err)
+	}
+	if device != "/dev/sdb" {
+		t.Errorf("Expected the new disk /dev/sdb, got %q", device)
+	}
+	if msdRequests.Load() != 1 {
+		t.Errorf("Expected 1 MSD mode request, got %d", msdRequests.Load())
+	}
+	if *calls != 4 {
+		t.Errorf("Expected 4 listings, got %d", *calls)
+	}
+}
+
+func TestWaitForMsdDeviceSameName(t *testing.T) {
+	// The node was already in MSD mode, its eMMC leaves while it reboots and comes back
+	// under the same name, on a new enumeration of the USB device
+	fakeBMCExec(t,
+		"/dev/sda 1-1 4\n",
+		"/dev/sda 1-1 4\n",
+		"",
+		"/dev/sda 1-1 5\n",
+	)
+	client, _ := NewClient(WithHost("127.0.0.1:1"), WithCredentials("root", "turing"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	device, err := client.WaitForMsdDevice(ctx, 2)
+	if err != nil || device != "/dev/sda" {
+		t.Errorf("Expected /dev/sda, got %q, %v", device, err)
+	}
+}
+
+func TestWaitForMsdDeviceTimeout(t *testing.T) {
+	fakeBMCExec(t, "/dev/sda 1-1 4\n")
+	client, _ := NewClient(WithHost("127.0.0.1:1"), WithCredentials("root", "turing"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.WaitForMsdDevice(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if _, err := client.WaitForMsdDevice(ctx, 5); err == nil {
+		t.Error("Expected an invalid node to be refused")
+	}
+}
+
+func TestParseMsdDisks(t *testing.T) {
+	output := "/dev/sda 1-1 5\n/dev/sdb 2-1\n\n"
+	expected := []msdDisk{{device: "/dev/sda", port: "1-1", devnum: "5"}}
+	if disks := parseMsdDisks(output); !reflect.DeepEqual(disks, expected) {
+		t.Errorf("Unexpected disks: %v", disks)
+	}
+}
+
+func TestParseUSBDisks(t *testing.T) {
+	lsblk := "/dev/mmcblk0 mmc\n/dev/sda   usb\n/dev/sdb sata\n/dev/sdc\n"
+	if devices := parseUSBDisks(lsblk); !reflect.DeepEqual(devices, []string{"/dev/sda"}) {
+		t.Errorf("Unexpected devices from lsblk: %v", devices)
+	}
+
+	sysfs := `lrwxrwxrwx 1 root root 0 Jan  1 00:00 mmcblk0 -> ../devices/platform/soc/4020000.mmc/mmc_host/mmc0/mmc0:0001/block/mmcblk0
+lrwxrwxrwx 1 root root 0 Jan  1 00:00 sda -> ../devices/platform/soc/4101000.usb/usb1/1-1/1-1:1.0/host0/target0:0:0/0:0:0:0/block/sda
+`
+	if devices := parseUSBDisks(sysfs); !reflect.DeepEqual(devices, []string{"/dev/sda"}) {
+		t.Errorf("Unexpected devices from sysfs: %v", devices)
+	}
+}
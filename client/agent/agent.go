@@ -19,11 +19,13 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -40,6 +42,14 @@ type Agent struct {
 	router    *http.ServeMux
 	authCache map[string]time.Time
 	mu        sync.RWMutex
+	events    *eventHub
+
+	// runCommand executes CmdExecuteCommand, over SSH on the BMC by default
+	runCommand func(command string, options ...tpi.SSHOption) (*tpi.CommandResult, error)
+
+	// uploadFile and openRemoteFile serve the transfer endpoints, over SFTP on the BMC by default
+	uploadFile     func(r io.Reader, size int64, mode os.FileMode, remotePath string, options ...tpi.SSHOption) error
+	openRemoteFile func(remotePath string, options ...tpi.SSHOption) (io.ReadCloser, os.FileInfo, error)
 }
 
 // NewAgent creates a new TPI agent server
@@ -47,83 +57,177 @@ func NewAgent(config AgentConfig, client *tpi.Client) (*Agent, error) {
 	if config.Port == 0 {
 		config.Port = DefaultAgentPort
 	}
+	if config.SelfNode < 0 || config.SelfNode > 4 {
+		return nil, fmt.Errorf("invalid self node: %d (must be 1-4, or 0 if the agent doesn't run on a node)", config.SelfNode)
+	}
 
 	router := http.NewServeMux()
 
 	agent := &Agent{
-		config:    config,
-		client:    client,
-		router:    router,
-		authCache: make(map[string]time.Time),
+		config:         config,
+		client:         client,
+		router:         router,
+		authCache:      make(map[string]time.Time),
+		events:         newEventHub(),
+		runCommand:     client.RunCommand,
+		uploadFile:     client.UploadReader,
+		openRemoteFile: client.OpenRemoteFile,
 	}
 
 	// Register command handler
-	router.HandleFunc("/", agent.handleCommand)
-
-	// Create HTTP server
+	router.HandleFunc("/api/agent", agent.handleCommand)
+	router.HandleFunc("/api/agent/spec", agent.handleSpec)
+	router.HandleFunc("/api/agent/events", agent.handleEvents)
+	router.HandleFunc("/api/agent/uart", agent.handleUart)
+	router.HandleFunc("/api/agent/upload", agent.handleUpload)
+	router.HandleFunc("/api/agent/download", agent.handleDownload)
+
+	// Create HTTP server, timeouts guard against clients that send their request slowly
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", config.Port),
-		Handler: router,
+		Addr:           net.JoinHostPort(config.BindAddress, strconv.Itoa(config.Port)),
+		Handler:        router,
+		ReadTimeout:    serverTimeout(config.ReadTimeout, DefaultReadTimeout),
+		WriteTimeout:   serverTimeout(config.WriteTimeout, DefaultWriteTimeout),
+		IdleTimeout:    serverTimeout(config.IdleTimeout, DefaultIdleTimeout),
+		MaxHeaderBytes: config.MaxHeaderBytes,
 	}
+	if server.MaxHeaderBytes <= 0 {
+		server.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+
+	// Event streams would otherwise hold the shutdown until its timeout
+	server.RegisterOnShutdown(agent.events.close)
 
 	agent.server = server
 
 	return agent, nil
 }
 
+// serverTimeout returns the timeout to use for a configured value: the default if zero, none if negative
+func serverTimeout(configured, defaultValue time.Duration) time.Duration {
+	switch {
+	case configured == 0:
+		return defaultValue
+	case configured < 0:
+		return 0
+	default:
+		return configured
+	}
+}
+
+// longRunningCommands can take longer than the server's WriteTimeout to respond
+var longRunningCommands = map[CommandType]bool{
+	CmdRebootAndWait:   true,
+	CmdFlashNode:       true,
+	CmdFlashNodeLocal:  true,
+	CmdUpgradeFirmware: true,
+	CmdExecuteCommand:  true,
+}
+
+// Addr returns the address the agent listens on, host:port or the Unix socket path
+func (a *Agent) Addr() string {
+	if a.config.UnixSocket != "" {
+		return a.config.UnixSocket
+	}
+	return a.server.Addr
+}
+
 // Start starts the agent server
 func (a *Agent) Start(ctx context.Context) error {
-	var listener net.Listener
-	var err error
+	listener, err := a.listen()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("TPI Agent started on %s", listener.Addr())
+
+	// Handle graceful shutdown
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
+	// Start serving
+	if err := a.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server error: %w", err)
+	}
+
+	return nil
+}
+
+// listen creates the listener on the configured bind address and port, or Unix socket,
+// with TLS if enabled
+func (a *Agent) listen() (net.Listener, error) {
+	var tlsConfig *tls.Config
 
 	// Set up TLS if enabled
 	if a.config.TLSEnabled {
 		if a.config.TLSCertFile == "" || a.config.TLSKeyFile == "" {
-			return fmt.Errorf("TLS enabled but certificate or key file not provided")
+			return nil, fmt.Errorf("TLS enabled but certificate or key file not provided")
 		}
 
 		// Create TLS configuration
 		cert, err := tls.LoadX509KeyPair(a.config.TLSCertFile, a.config.TLSKeyFile)
 		if err != nil {
-			return fmt.Errorf("failed to load TLS certificates: %w", err)
+			return nil, fmt.Errorf("failed to load TLS certificates: %w", err)
 		}
 
-		tlsConfig := &tls.Config{
+		// Offering h2 enables HTTP/2 for clients that support it
+		tlsConfig = &tls.Config{
 			Certificates: []tls.Certificate{cert},
 			MinVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"h2", "http/1.1"},
 		}
+	}
 
-		// Create TLS listener
-		listener, err = tls.Listen("tcp", a.server.Addr, tlsConfig)
-		if err != nil {
-			return fmt.Errorf("failed to create TLS listener: %w", err)
-		}
+	var listener net.Listener
+	var err error
+	if a.config.UnixSocket != "" {
+		listener, err = listenUnix(a.config.UnixSocket)
 	} else {
-		// Create non-TLS listener
 		listener, err = net.Listen("tcp", a.server.Addr)
-		if err != nil {
-			return fmt.Errorf("failed to create listener: %w", err)
-		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create listener: %w", err)
 	}
 
-	log.Printf("TPI Agent started on %s", a.server.Addr)
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 
-	// Handle graceful shutdown
-	go func() {
-		<-ctx.Done()
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := a.server.Shutdown(shutdownCtx); err != nil {
-			log.Printf("Error shutting down server: %v", err)
+	return listener, nil
+}
+
+// listenUnix listens on a Unix socket at path, replacing a socket left behind by an
+// agent that didn't shut down cleanly, and restricts it to its owner
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
 		}
-	}()
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
 
-	// Start serving
-	if err := a.server.Serve(listener); err != nil && err != http.ErrServerClosed {
-		return fmt.Errorf("server error: %w", err)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
 	}
 
-	return nil
+	return listener, nil
 }
 
 // handleCommand handles incoming command requests
@@ -135,19 +239,9 @@ func (a *Agent) handleCommand(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check IP allowlist if configured
-	if len(a.config.AllowedClients) > 0 {
-		clientIP := strings.Split(r.RemoteAddr, ":")[0]
-		allowed := false
-		for _, allowedIP := range a.config.AllowedClients {
-			if clientIP == allowedIP {
-				allowed = true
-				break
-			}
-		}
-		if !allowed {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
+	if !a.isClientAllowed(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
 	}
 
 	// Decode the command
@@ -166,6 +260,17 @@ func (a *Agent) handleCommand(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Refuse commands the operator didn't allow
+	if !a.isCommandAllowed(cmd.Type) {
+		sendErrorResponse(w, fmt.Sprintf("Command not allowed: %s", cmd.Type), http.StatusForbidden)
+		return
+	}
+
+	// Lift the write deadline for commands that may run longer than WriteTimeout
+	if longRunningCommands[cmd.Type] {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+
 	// Execute the command
 	result, err := a.executeCommand(cmd)
 	if err != nil {
@@ -179,11 +284,55 @@ func (a *Agent) handleCommand(w http.ResponseWriter, r *http.Request) {
 		Result:  result,
 	}
 
+	// Confirm the token, so the client can authenticate with it alone from now on
+	if a.config.Auth.Secret != "" && cmd.Auth.Token != "" {
+		response.Token = cmd.Auth.Token
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
+// isClientAllowed checks the request's remote address against the IP allowlist, if configured
+func (a *Agent) isClientAllowed(r *http.Request) bool {
+	// Unix socket clients have no IP, the socket's permissions restrict them instead
+	if len(a.config.AllowedClients) == 0 || a.config.UnixSocket != "" {
+		return true
+	}
+
+	clientIP := strings.Split(r.RemoteAddr, ":")[0]
+	for _, allowedIP := range a.config.AllowedClients {
+		if clientIP == allowedIP {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCommandAllowed checks the command against the command allowlist, if configured.
+// CmdAuthenticate is always allowed, CmdRaw only with AllowRaw.
+func (a *Agent) isCommandAllowed(cmdType CommandType) bool {
+	if cmdType == CmdAuthenticate {
+		return true
+	}
+	if cmdType == CmdRaw && !a.config.AllowRaw {
+		return false
+	}
+	if len(a.config.AllowedCommands) == 0 {
+		return true
+	}
+
+	for _, allowed := range a.config.AllowedCommands {
+		if cmdType == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
 // authenticateRequest verifies the authentication of an incoming request
 func (a *Agent) authenticateRequest(auth AgentAuthConfig) bool {
 	// Check if token-based authentication is used
@@ -0,0 +1,227 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// freePort returns a TCP port that is free on the loopback interface
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestAgentBindAddress(t *testing.T) {
+	port := freePort(t)
+	want := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	agent, err := NewAgent(AgentConfig{BindAddress: "127.0.0.1", Port: port}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	if agent.Addr() != want {
+		t.Errorf("Expected address %s, got %s", want, agent.Addr())
+	}
+
+	listener, err := agent.listen()
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	if got := listener.Addr().String(); got != want {
+		t.Errorf("Expected listener on %s, got %s", want, got)
+	}
+}
+
+func TestAgentBindAllInterfaces(t *testing.T) {
+	agent, err := NewAgent(AgentConfig{Port: 9000}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	if agent.Addr() != ":9000" {
+		t.Errorf("Expected address :9000, got %s", agent.Addr())
+	}
+
+	agent, err = NewAgent(AgentConfig{BindAddress: "::1", Port: 9000}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	if agent.Addr() != "[::1]:9000" {
+		t.Errorf("Expected address [::1]:9000, got %s", agent.Addr())
+	}
+}
+
+// startAgent runs the agent until the test ends
+func startAgent(t *testing.T, agent *Agent) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := agent.Start(ctx); err != nil {
+			t.Errorf("Agent failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Wait for the listener
+	network := "tcp"
+	if agent.config.UnixSocket != "" {
+		network = "unix"
+	}
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial(network, agent.Addr()); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Agent didn't start listening on %s", agent.Addr())
+}
+
+func TestAgentTimesOutSlowHeaders(t *testing.T) {
+	agent, err := NewAgent(AgentConfig{BindAddress: "127.0.0.1", Port: freePort(t), ReadTimeout: 100 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	startAgent(t, agent)
+
+	conn, err := net.Dial("tcp", agent.Addr())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Send part of the headers and never finish them
+	if _, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: agent\r\n")); err != nil {
+		t.Fatalf("Failed to write headers: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("Expected the agent to close the connection of a slow client")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the connection to be closed after about 100ms, took %s", elapsed)
+	}
+}
+
+func TestAgentLongRunningCommandOutlivesWriteTimeout(t *testing.T) {
+	agent, err := NewAgent(AgentConfig{BindAddress: "127.0.0.1", Port: freePort(t), WriteTimeout: 50 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	agent.runCommand = func(command string, options ...tpi.SSHOption) (*tpi.CommandResult, error) {
+		time.Sleep(200 * time.Millisecond)
+		return &tpi.CommandResult{Stdout: "done"}, nil
+	}
+	startAgent(t, agent)
+
+	host, portStr, _ := net.SplitHostPort(agent.Addr())
+	port, _ := strconv.Atoi(portStr)
+	client, err := NewAgentClient(AgentClientConfig{Host: host, Port: port})
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	result, err := client.ExecuteCommand("sleep")
+	if err != nil {
+		t.Fatalf("Expected the command to outlive the write timeout, got: %v", err)
+	}
+	if result.Stdout != "done" {
+		t.Errorf("Expected output done, got %q", result.Stdout)
+	}
+}
+
+func TestAgentUnixSocket(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+
+	// A socket left behind by an agent that didn't shut down cleanly is replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	agent, err := NewAgent(AgentConfig{UnixSocket: socket, AllowedClients: []string{"10.0.0.1"}}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	agent.runCommand = runLocally
+	if agent.Addr() != socket {
+		t.Errorf("Expected address %s, got %s", socket, agent.Addr())
+	}
+	startAgent(t, agent)
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("Socket missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected socket permissions 0600, got %o", perm)
+	}
+
+	client, err := NewAgentClientFromOptions(WithAgentUnixSocket(socket))
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+	result, err := client.ExecuteCommand("echo over the socket")
+	if err != nil {
+		t.Fatalf("Command over the socket failed: %v", err)
+	}
+	if result.Stdout != "over the socket\n" {
+		t.Errorf("Unexpected output %q", result.Stdout)
+	}
+
+	// A live socket isn't taken over
+	second, err := NewAgent(AgentConfig{UnixSocket: socket}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	if listener, err := second.listen(); err == nil {
+		listener.Close()
+		t.Error("Expected listening on a socket in use to fail")
+	}
+}
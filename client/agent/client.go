@@ -16,14 +16,20 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	tpi "github.com/davidroman0O/tpi/client"
@@ -34,11 +40,18 @@ type AgentClient struct {
 	config     AgentClientConfig
 	httpClient *http.Client
 	auth       AgentAuthConfig
+
+	// tokenConfirmed is set once the agent accepted the token, requests then omit the secret
+	tokenConfirmed bool
+	mu             sync.Mutex
 }
 
 // NewAgentClient creates a new agent client with the given configuration
 func NewAgentClient(config AgentClientConfig) (*AgentClient, error) {
-	// Validate configuration
+	// Validate configuration, the host only names an agent on a Unix socket
+	if config.Host == "" && config.UnixSocket != "" {
+		config.Host = "localhost"
+	}
 	if config.Host == "" {
 		return nil, fmt.Errorf("host is required")
 	}
@@ -59,6 +72,13 @@ func NewAgentClient(config AgentClientConfig) (*AgentClient, error) {
 			InsecureSkipVerify: config.SkipVerify,
 		}
 	}
+	if config.UnixSocket != "" {
+		socket := config.UnixSocket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
 
 	// Create HTTP client
 	httpClient := &http.Client{
@@ -66,20 +86,32 @@ func NewAgentClient(config AgentClientConfig) (*AgentClient, error) {
 		Transport: transport,
 	}
 
-	// Generate a token if not provided but secret is
+	// Generate a token if not provided but secret is, or reuse the one the agent
+	// confirmed before if the token is persisted
 	auth := config.Auth
+	confirmed := false
 	if auth.Secret != "" && auth.Token == "" {
-		token, err := generateRandomToken()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate token: %w", err)
+		if config.PersistToken {
+			if token, err := loadAgentToken(config.Host, config.Port); err == nil {
+				auth.Token = token
+				confirmed = true
+			}
+		}
+
+		if auth.Token == "" {
+			token, err := generateRandomToken()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate token: %w", err)
+			}
+			auth.Token = token
 		}
-		auth.Token = token
 	}
 
 	return &AgentClient{
-		config:     config,
-		httpClient: httpClient,
-		auth:       auth,
+		config:         config,
+		httpClient:     httpClient,
+		auth:           auth,
+		tokenConfirmed: confirmed,
 	}, nil
 }
 
@@ -129,6 +161,20 @@ func WithAgentTimeout(timeout time.Duration) AgentOption {
 	}
 }
 
+// WithAgentUnixSocket connects to an agent listening on a Unix domain socket at path
+func WithAgentUnixSocket(path string) AgentOption {
+	return func(cfg *AgentClientConfig) {
+		cfg.UnixSocket = path
+	}
+}
+
+// WithAgentPersistToken caches the generated token on disk and reuses it across client restarts
+func WithAgentPersistToken() AgentOption {
+	return func(cfg *AgentClientConfig) {
+		cfg.PersistToken = true
+	}
+}
+
 // NewAgentClientFromOptions creates a new agent client with the provided options
 func NewAgentClientFromOptions(opts ...AgentOption) (*AgentClient, error) {
 	// Create default configuration
@@ -157,17 +203,76 @@ func generateRandomToken() (string, error) {
 
 // sendCommand sends a command to the agent and returns the response
 func (c *AgentClient) sendCommand(cmdType CommandType, args map[string]any) (interface{}, error) {
+	return c.sendCommandWithSSH(cmdType, args, nil)
+}
+
+// sendCommandWithSSH sends a command to the agent with the SSH args, if any, and returns the response
+func (c *AgentClient) sendCommandWithSSH(cmdType CommandType, args map[string]any, ssh *SSHArgs) (interface{}, error) {
+	// Once the agent confirmed the token, the secret doesn't need to be sent anymore
+	c.mu.Lock()
+	auth := c.auth
+	if c.tokenConfirmed {
+		auth.Secret = ""
+	}
+	c.mu.Unlock()
+
+	response, status, err := c.send(cmdType, args, ssh, auth)
+	if status == http.StatusUnauthorized && auth.Secret == "" && c.auth.Secret != "" {
+		// The agent doesn't know the token anymore, e.g. after a restart or when it
+		// expired, so authenticate with the secret again
+		c.mu.Lock()
+		c.tokenConfirmed = false
+		c.mu.Unlock()
+		response, _, err = c.send(cmdType, args, ssh, c.auth)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Token != "" && response.Token == c.auth.Token {
+		c.confirmToken()
+	}
+
+	// Check if the command was successful
+	if !response.Success {
+		return nil, fmt.Errorf("command failed: %s", response.Error)
+	}
+
+	return response.Result, nil
+}
+
+// confirmToken records that the agent accepted the token, persisting it if configured
+func (c *AgentClient) confirmToken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tokenConfirmed {
+		return
+	}
+	c.tokenConfirmed = true
+
+	if c.config.PersistToken {
+		if err := saveAgentToken(c.config.Host, c.config.Port, c.auth.Token); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to cache agent token: %v\n", err)
+		}
+	}
+}
+
+// send sends a single command to the agent with the given authentication,
+// returning the decoded response and the HTTP status
+func (c *AgentClient) send(cmdType CommandType, args map[string]any, ssh *SSHArgs, auth AgentAuthConfig) (*Response, int, error) {
 	// Create the command
 	cmd := Command{
 		Type: cmdType,
 		Args: args,
-		Auth: c.auth,
+		Auth: auth,
+		SSH:  ssh,
 	}
 
 	// Marshal the command to JSON
 	cmdJSON, err := json.Marshal(cmd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal command: %w", err)
+		return nil, 0, fmt.Errorf("failed to marshal command: %w", err)
 	}
 
 	// Create request URL
@@ -180,41 +285,59 @@ func (c *AgentClient) sendCommand(cmdType CommandType, args map[string]any) (int
 	// Create the HTTP request
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(cmdJSON))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "TPI-Agent-Client")
 
-	// Send the request
-	resp, err := c.httpClient.Do(req)
+	// Send the request, waiting out rate limiting as the agent asks
+	resp, err := tpi.DoWithRetryAfter(c.httpClient, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	// Check if the response code is not 200
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, resp.StatusCode, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Parse the response
 	var response Response
 	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	// Check if the command was successful
-	if !response.Success {
-		return nil, fmt.Errorf("command failed: %s", response.Error)
+	return &response, resp.StatusCode, nil
+}
+
+// agentTokenPath returns the path the token for an agent is cached at
+func agentTokenPath(host string, port int) string {
+	return filepath.Join(tpi.CacheDir(), "agent_token_"+tpi.SanitizeHost(net.JoinHostPort(host, strconv.Itoa(port))))
+}
+
+// loadAgentToken returns the cached token for an agent
+func loadAgentToken(host string, port int) (string, error) {
+	data, err := os.ReadFile(agentTokenPath(host, port))
+	if err != nil {
+		return "", err
 	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("empty agent token")
+	}
+	return token, nil
+}
 
-	return response.Result, nil
+// saveAgentToken caches the token for an agent
+func saveAgentToken(host string, port int, token string) error {
+	return os.WriteFile(agentTokenPath(host, port), []byte(token), 0600)
 }
 
 // Info gets the basic information about the Turing Pi
@@ -317,6 +440,16 @@ func (c *AgentClient) PowerOff(node int) error {
 	return err
 }
 
+// ForcePowerOff turns off the specified node, even if the agent runs on it
+func (c *AgentClient) ForcePowerOff(node int) error {
+	args := map[string]any{
+		"node":  node,
+		"force": true,
+	}
+	_, err := c.sendCommand(CmdPowerOff, args)
+	return err
+}
+
 // PowerReset resets the specified node
 func (c *AgentClient) PowerReset(node int) error {
 	args := map[string]any{
@@ -338,6 +471,12 @@ func (c *AgentClient) PowerOffAll() error {
 	return err
 }
 
+// ForcePowerOffAll turns off all nodes, including the one the agent runs on
+func (c *AgentClient) ForcePowerOffAll() error {
+	_, err := c.sendCommand(CmdPowerOffAll, map[string]any{"force": true})
+	return err
+}
+
 // UsbGetStatus gets the USB mode of each node
 func (c *AgentClient) UsbGetStatus() (map[int]bool, error) {
 	result, err := c.sendCommand(CmdUsbGetStatus, nil)
@@ -466,65 +605,6 @@ func (c *AgentClient) UpgradeFirmware(filePath string, providedSha256 string) er
 	return err
 }
 
-// UploadFile uploads a local file to the remote system through the agent
-func (c *AgentClient) UploadFile(localPath, remotePath string) error {
-	// Open the local file to get its contents
-	file, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open local file: %w", err)
-	}
-	defer file.Close()
-
-	// Read file content
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return fmt.Errorf("failed to read file content: %w", err)
-	}
-
-	// Encode file content as base64 to send in the request
-	args := map[string]any{
-		"local_path":  localPath,
-		"remote_path": remotePath,
-		"content":     content,
-	}
-
-	_, err = c.sendCommand(CmdUploadFile, args)
-	return err
-}
-
-// DownloadFile downloads a file from the remote system through the agent
-func (c *AgentClient) DownloadFile(remotePath, localPath string) error {
-	args := map[string]any{
-		"remote_path": remotePath,
-	}
-
-	result, err := c.sendCommand(CmdDownloadFile, args)
-	if err != nil {
-		return err
-	}
-
-	// Extract file content from the response
-	var fileContent []byte
-	if content, ok := result.([]byte); ok {
-		fileContent = content
-	} else if contentMap, ok := result.(map[string]interface{}); ok {
-		if content, ok := contentMap["content"].([]byte); ok {
-			fileContent = content
-		} else {
-			return fmt.Errorf("invalid response format: content not found or not a byte array")
-		}
-	} else {
-		return fmt.Errorf("invalid response format")
-	}
-
-	// Write the content to the local file
-	if err := os.WriteFile(localPath, fileContent, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
-
-	return nil
-}
-
 // ListDirectory lists the contents of a remote directory through the agent
 func (c *AgentClient) ListDirectory(remotePath string) ([]FileInfo, error) {
 	args := map[string]any{
@@ -574,20 +654,59 @@ func (c *AgentClient) ListDirectory(remotePath string) ([]FileInfo, error) {
 	return files, nil
 }
 
-// ExecuteCommand executes a command on the remote system through the agent
-func (c *AgentClient) ExecuteCommand(command string) (string, error) {
+// ExecuteCommand executes a command on the remote system through the agent.
+// A command that exits nonzero is reported through the result's ExitCode, not as an error.
+func (c *AgentClient) ExecuteCommand(command string) (*tpi.CommandResult, error) {
+	return c.ExecuteCommandWithSSH(command, nil)
+}
+
+// ExecuteCommandWithSSH executes a command through the agent over the SSH connection ssh
+// configures, e.g. on a node with a key of the agent
+func (c *AgentClient) ExecuteCommandWithSSH(command string, ssh *SSHArgs) (*tpi.CommandResult, error) {
 	args := map[string]any{
 		"command": command,
 	}
 
-	result, err := c.sendCommand(CmdExecuteCommand, args)
+	result, err := c.sendCommandWithSSH(CmdExecuteCommand, args, ssh)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	// The result was decoded as a generic object, convert it back to the struct
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode command result: %w", err)
 	}
 
-	if output, ok := result.(string); ok {
-		return output, nil
+	var commandResult tpi.CommandResult
+	if err := json.Unmarshal(data, &commandResult); err != nil {
+		return nil, fmt.Errorf("failed to parse command result: %w", err)
 	}
 
-	return fmt.Sprintf("%v", result), nil
+	return &commandResult, nil
+}
+
+// Raw sends a request the agent doesn't wrap to the BMC and returns the BMC's JSON response.
+// The agent refuses it unless its config sets AllowRaw.
+func (c *AgentClient) Raw(opt, typ string, params map[string]string) (json.RawMessage, error) {
+	args := map[string]any{
+		"opt":  opt,
+		"type": typ,
+	}
+	if len(params) > 0 {
+		args["params"] = params
+	}
+
+	result, err := c.sendCommand(CmdRaw, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The result was decoded as a generic value, encode it back to JSON
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode raw result: %w", err)
+	}
+
+	return json.RawMessage(data), nil
 }
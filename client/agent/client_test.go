@@ -0,0 +1,169 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// newTestAgent starts an agent requiring secret, recording the authentication of every command
+func newTestAgent(t *testing.T, secret string) (*Agent, *[]AgentAuthConfig, string, int) {
+	t.Helper()
+
+	agent := &Agent{
+		config:    AgentConfig{Auth: AgentAuthConfig{Secret: secret}},
+		authCache: make(map[string]time.Time),
+		runCommand: func(command string, options ...tpi.SSHOption) (*tpi.CommandResult, error) {
+			return &tpi.CommandResult{Stdout: command}, nil
+		},
+	}
+
+	var (
+		mu    sync.Mutex
+		auths []AgentAuthConfig
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var cmd Command
+		json.Unmarshal(body, &cmd)
+		mu.Lock()
+		auths = append(auths, cmd.Auth)
+		mu.Unlock()
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		agent.handleCommand(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return agent, &auths, host, port
+}
+
+func TestAgentTokenReusedAcrossClients(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	_, auths, host, port := newTestAgent(t, "shared-secret")
+
+	newClient := func() *AgentClient {
+		client, err := NewAgentClientFromOptions(
+			WithAgentHost(host),
+			WithAgentPort(port),
+			WithAgentSecret("shared-secret"),
+			WithAgentPersistToken(),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent client: %v", err)
+		}
+		return client
+	}
+
+	first := newClient()
+	for i := 0; i < 2; i++ {
+		if _, err := first.ExecuteCommand("true"); err != nil {
+			t.Fatalf("Command %d failed: %v", i+1, err)
+		}
+	}
+
+	second := newClient()
+	if second.auth.Token != first.auth.Token {
+		t.Fatalf("Expected the second client to reuse token %s, got %s", first.auth.Token, second.auth.Token)
+	}
+	if _, err := second.ExecuteCommand("true"); err != nil {
+		t.Fatalf("Command with the reused token failed: %v", err)
+	}
+
+	// Only the first command needs the secret, the rest use the confirmed token
+	if len(*auths) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(*auths))
+	}
+	for i, auth := range *auths {
+		if auth.Token != first.auth.Token {
+			t.Errorf("Request %d: expected token %s, got %s", i+1, first.auth.Token, auth.Token)
+		}
+		if withSecret := auth.Secret != ""; withSecret != (i == 0) {
+			t.Errorf("Request %d: secret sent = %v", i+1, withSecret)
+		}
+	}
+}
+
+func TestAgentTokenFallsBackToSecret(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	agent, auths, host, port := newTestAgent(t, "shared-secret")
+
+	client, err := NewAgentClientFromOptions(WithAgentHost(host), WithAgentPort(port), WithAgentSecret("shared-secret"))
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+	if _, err := client.ExecuteCommand("true"); err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	// The agent restarts and forgets the token
+	agent.mu.Lock()
+	agent.authCache = make(map[string]time.Time)
+	agent.mu.Unlock()
+
+	if _, err := client.ExecuteCommand("true"); err != nil {
+		t.Fatalf("Expected the client to authenticate with the secret again, got: %v", err)
+	}
+
+	// secret, rejected token, secret again
+	if len(*auths) != 3 || (*auths)[1].Secret != "" || (*auths)[2].Secret == "" {
+		t.Errorf("Unexpected authentication sequence: %+v", *auths)
+	}
+}
+
+func TestAgentClientRetriesRateLimited(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(Response{Success: true, Result: map[string]string{"version": "2.0.5"}})
+	}))
+	defer server.Close()
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	client, err := NewAgentClient(AgentClientConfig{Host: host, Port: port})
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	info, err := client.Info()
+	if err != nil {
+		t.Fatalf("Expected the command to succeed after the rate limit, got: %v", err)
+	}
+	if info["version"] != "2.0.5" {
+		t.Errorf("Unexpected info: %v", info)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected 2 requests, got %d", got)
+	}
+}
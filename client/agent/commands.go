@@ -16,6 +16,7 @@ package agent
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 
 	tpi "github.com/davidroman0O/tpi/client"
@@ -26,8 +27,15 @@ func (a *Agent) executeCommand(cmd Command) (interface{}, error) {
 	var result interface{}
 	var err error
 
+	if cmd.SSH != nil && !sshCommands[cmd.Type] {
+		return nil, fmt.Errorf("command %s doesn't use SSH, it takes no SSH args", cmd.Type)
+	}
+
 	// Execute the command based on its type
 	switch cmd.Type {
+	// Authentication handshake, the request was authenticated before reaching here
+	case CmdAuthenticate:
+
 	// Basic commands
 	case CmdInfo:
 		result, err = a.client.Info()
@@ -36,90 +44,113 @@ func (a *Agent) executeCommand(cmd Command) (interface{}, error) {
 	case CmdReboot:
 		err = a.client.Reboot()
 	case CmdRebootAndWait:
-		timeout, _ := getIntArg(cmd.Args, "timeout", 60)
-		err = a.client.RebootAndWait(timeout)
+		var timeout int
+		if timeout, err = argInt(cmd.Args, "timeout", 60); err == nil {
+			err = a.client.RebootAndWait(timeout)
+		}
 
 	// Power commands
 	case CmdPowerStatus:
 		result, err = a.client.PowerStatus()
 	case CmdPowerOn:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		err = validateNodeNumber(node)
-		if err == nil {
-			err = a.client.PowerOn(node)
+		var opts PowerOptions
+		if opts, err = parsePowerOptions(cmd.Args); err == nil {
+			err = a.client.PowerOn(opts.Node)
 		}
 	case CmdPowerOff:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		err = validateNodeNumber(node)
+		var opts PowerOptions
+		opts, err = parsePowerOptions(cmd.Args)
+		if err == nil {
+			err = a.checkSelfNode(opts.Node, opts.Force)
+		}
 		if err == nil {
-			err = a.client.PowerOff(node)
+			err = a.client.PowerOff(opts.Node)
 		}
 	case CmdPowerReset:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		err = validateNodeNumber(node)
+		var opts PowerOptions
+		opts, err = parsePowerOptions(cmd.Args)
+		if err == nil {
+			err = a.checkSelfNode(opts.Node, opts.Force)
+		}
 		if err == nil {
-			err = a.client.PowerReset(node)
+			err = a.client.PowerReset(opts.Node)
 		}
 	case CmdPowerOnAll:
 		err = a.client.PowerOnAll()
 	case CmdPowerOffAll:
-		err = a.client.PowerOffAll()
+		var force bool
+		force, err = argBool(cmd.Args, "force", false)
+		if err == nil {
+			err = a.checkSelfNode(a.config.SelfNode, force)
+		}
+		if err == nil {
+			err = a.client.PowerOffAll()
+		}
 
 	// Advanced mode commands
 	case CmdSetNodeNormalMode:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		err = validateNodeNumber(node)
+		var opts PowerOptions
+		opts, err = parsePowerOptions(cmd.Args)
+		if err == nil {
+			err = a.checkSelfNode(opts.Node, opts.Force)
+		}
 		if err == nil {
-			err = a.client.SetNodeNormalMode(node)
+			err = a.client.SetNodeNormalMode(opts.Node)
 		}
 	case CmdSetNodeMsdMode:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		err = validateNodeNumber(node)
+		var opts PowerOptions
+		opts, err = parsePowerOptions(cmd.Args)
 		if err == nil {
-			err = a.client.SetNodeMsdMode(node)
+			err = a.checkSelfNode(opts.Node, opts.Force)
+		}
+		if err == nil {
+			err = a.client.SetNodeMsdMode(opts.Node)
 		}
 
 	// USB commands
 	case CmdUsbGetStatus:
 		result, err = a.client.UsbGetStatus()
 	case CmdUsbSetHost:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		bmc, _ := getBoolArg(cmd.Args, "bmc", false)
-		err = validateNodeNumber(node)
-		if err == nil {
-			err = a.client.UsbSetHost(node, bmc)
+		var opts UsbOptions
+		if opts, err = parseUsbOptions(cmd.Args); err == nil {
+			err = a.client.UsbSetHost(opts.Node, opts.BMC)
 		}
 	case CmdUsbSetDevice:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		bmc, _ := getBoolArg(cmd.Args, "bmc", false)
-		err = validateNodeNumber(node)
-		if err == nil {
-			err = a.client.UsbSetDevice(node, bmc)
+		var opts UsbOptions
+		if opts, err = parseUsbOptions(cmd.Args); err == nil {
+			err = a.client.UsbSetDevice(opts.Node, opts.BMC)
 		}
 	case CmdUsbSetFlash:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		bmc, _ := getBoolArg(cmd.Args, "bmc", false)
-		err = validateNodeNumber(node)
+		var opts UsbOptions
+		opts, err = parseUsbOptions(cmd.Args)
+		if err == nil {
+			// Flash mode resets the node into USB boot
+			err = a.checkSelfNode(opts.Node, opts.Force)
+		}
 		if err == nil {
-			err = a.client.UsbSetFlash(node, bmc)
+			err = a.client.UsbSetFlash(opts.Node, opts.BMC)
 		}
 
 	// UART commands
 	case CmdGetUartOutput:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		err = validateNodeNumber(node)
-		if err == nil {
+		var node int
+		if node, err = argNode(cmd.Args); err == nil {
 			result, err = a.client.GetUartOutput(node)
 		}
 	case CmdSendUartCommand:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		command, _ := getStringArg(cmd.Args, "command", "")
-		err = validateNodeNumber(node)
-		if err == nil && command != "" {
-			err = a.client.SendUartCommand(node, command)
-		} else if command == "" {
+		var node int
+		var command string
+		if node, err = argNode(cmd.Args); err != nil {
+			break
+		}
+		if command, err = argString(cmd.Args, "command", ""); err != nil {
+			break
+		}
+		if command == "" {
 			err = fmt.Errorf("command is required for SendUartCommand")
+			break
 		}
+		err = a.client.SendUartCommand(node, command)
 
 	// Ethernet commands
 	case CmdEthReset:
@@ -127,48 +158,100 @@ func (a *Agent) executeCommand(cmd Command) (interface{}, error) {
 
 	// Flash commands
 	case CmdFlashNode:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		err = validateNodeNumber(node)
-		if err == nil {
-			imagePath, _ := getStringArg(cmd.Args, "image_path", "")
-			if imagePath == "" {
-				err = fmt.Errorf("image_path is required for FlashNode")
-				break
-			}
-
-			// Create flash options
-			options := &tpi.FlashOptions{
-				ImagePath: imagePath,
-			}
-
-			// Get optional parameters
-			options.SHA256, _ = getStringArg(cmd.Args, "sha256", "")
-			options.SkipCRC, _ = getBoolArg(cmd.Args, "skip_crc", false)
-
-			err = a.client.FlashNode(node, options)
+		var opts FlashOptions
+		if opts, err = parseFlashOptions(cmd.Args); err != nil {
+			break
+		}
+		if opts.ImagePath == "" {
+			err = fmt.Errorf("image_path is required for FlashNode")
+			break
+		}
+		if err = a.checkSelfNode(opts.Node, opts.Force); err != nil {
+			break
 		}
+		err = a.client.FlashNode(opts.Node, &tpi.FlashOptions{
+			ImagePath: opts.ImagePath,
+			SHA256:    opts.SHA256,
+			SkipCRC:   opts.SkipCRC,
+		})
 	case CmdFlashNodeLocal:
-		node, _ := getIntArg(cmd.Args, "node", 0)
-		imagePath, _ := getStringArg(cmd.Args, "image_path", "")
-		err = validateNodeNumber(node)
-		if err == nil {
-			if imagePath == "" {
-				err = fmt.Errorf("image_path is required for FlashNodeLocal")
-				break
-			}
-			err = a.client.FlashNodeLocal(node, imagePath)
+		var opts FlashOptions
+		if opts, err = parseFlashOptions(cmd.Args); err != nil {
+			break
+		}
+		if opts.ImagePath == "" {
+			err = fmt.Errorf("image_path is required for FlashNodeLocal")
+			break
+		}
+		if err = a.checkSelfNode(opts.Node, opts.Force); err != nil {
+			break
 		}
+		err = a.client.FlashNodeLocal(opts.Node, opts.ImagePath)
 
 	// Firmware commands
 	case CmdUpgradeFirmware:
-		filePath, _ := getStringArg(cmd.Args, "file_path", "")
-		sha256, _ := getStringArg(cmd.Args, "sha256", "")
+		var filePath, sha256 string
+		if filePath, err = argString(cmd.Args, "file_path", ""); err != nil {
+			break
+		}
+		if sha256, err = argString(cmd.Args, "sha256", ""); err != nil {
+			break
+		}
 		if filePath == "" {
 			err = fmt.Errorf("file_path is required for UpgradeFirmware")
 			break
 		}
 		err = a.client.UpgradeFirmware(filePath, sha256)
 
+	// Remote execution commands
+	case CmdExecuteCommand:
+		var command string
+		if command, err = argString(cmd.Args, "command", ""); err != nil {
+			break
+		}
+		if command == "" {
+			err = fmt.Errorf("command is required for ExecuteCommand")
+			break
+		}
+		options, sshErr := a.sshOptions(cmd.SSH)
+		if sshErr != nil {
+			err = sshErr
+			break
+		}
+		// A nonzero exit code is part of the result, not a failure of the agent
+		result, err = a.runCommand(command, options...)
+
+	// Passthrough commands
+	case CmdRaw:
+		var opt, typ string
+		var params map[string]string
+		var force bool
+		if opt, err = argString(cmd.Args, "opt", ""); err != nil {
+			break
+		}
+		if typ, err = argString(cmd.Args, "type", ""); err != nil {
+			break
+		}
+		if opt == "" || typ == "" {
+			err = fmt.Errorf("opt and type are required for Raw")
+			break
+		}
+		if params, err = rawParams(cmd.Args); err != nil {
+			break
+		}
+		if force, err = argBool(cmd.Args, "force", false); err != nil {
+			break
+		}
+		for _, node := range rawChangedNodes(opt, typ, params) {
+			if err = a.checkSelfNode(node, force); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+		result, err = a.client.Raw(opt, typ, params)
+
 	default:
 		err = fmt.Errorf("unknown command: %s", cmd.Type)
 	}
@@ -176,53 +259,201 @@ func (a *Agent) executeCommand(cmd Command) (interface{}, error) {
 	return result, err
 }
 
-// Helper functions for argument extraction
-
-func getIntArg(args map[string]any, key string, defaultValue int) (int, bool) {
-	if val, ok := args[key]; ok {
-		switch v := val.(type) {
-		case float64:
-			return int(v), true
-		case int:
-			return v, true
-		case string:
-			if i, err := strconv.Atoi(v); err == nil {
-				return i, true
+// checkSelfNode refuses to power off, reset, switch the mode of, put in USB flash mode or
+// flash the node the agent runs on, unless forced
+func (a *Agent) checkSelfNode(node int, force bool) error {
+	if force || a.config.SelfNode == 0 || node != a.config.SelfNode {
+		return nil
+	}
+	return fmt.Errorf("refusing to change node %d: the agent runs on it and would be cut off, set force to do it anyway", node)
+}
+
+// rawNodeTypes are the BMC request types that reset, switch the mode of or flash the
+// node given by their 0-based node param
+var rawNodeTypes = map[string]bool{
+	"reset":          true,
+	"node_to_msd":    true,
+	"clear_usb_boot": true,
+	"flash":          true,
+}
+
+// rawChangedNodes returns the nodes [1-4] a Raw request powers off, resets, switches
+// the mode of, puts in USB flash mode or flashes
+func rawChangedNodes(opt, typ string, params map[string]string) []int {
+	if opt != "set" {
+		return nil
+	}
+
+	var nodes []int
+	switch {
+	case typ == "power":
+		// Power params are named node1 to node4, 0 turns the node off
+		for node := 1; node <= 4; node++ {
+			if params["node"+strconv.Itoa(node)] == "0" {
+				nodes = append(nodes, node)
 			}
 		}
+	case rawNodeTypes[typ]:
+		if index, err := strconv.Atoi(params["node"]); err == nil {
+			nodes = append(nodes, index+1)
+		}
+	case typ == "usb":
+		// The low bits of the mode select host, device or flash mode, which resets
+		// the node into USB boot
+		mode, modeErr := strconv.Atoi(params["mode"])
+		index, nodeErr := strconv.Atoi(params["node"])
+		if modeErr == nil && nodeErr == nil && mode&3 == 2 {
+			nodes = append(nodes, index+1)
+		}
 	}
-	return defaultValue, false
+	return nodes
 }
 
-func getStringArg(args map[string]any, key string, defaultValue string) (string, bool) {
-	if val, ok := args[key]; ok {
-		if str, ok := val.(string); ok {
-			return str, true
+// Typed argument parsing
+//
+// Command.Args arrives as decoded JSON, or from callers filling the map by hand, so
+// every accessor checks the type it gets and returns an error naming the arg instead
+// of asserting. A missing or null arg yields the default.
+
+// parsePowerOptions reads the args of the single node power and mode commands
+func parsePowerOptions(args map[string]any) (PowerOptions, error) {
+	var opts PowerOptions
+	var err error
+	if opts.Node, err = argNode(args); err != nil {
+		return PowerOptions{}, err
+	}
+	if opts.Force, err = argBool(args, "force", false); err != nil {
+		return PowerOptions{}, err
+	}
+	return opts, nil
+}
+
+// parseUsbOptions reads the args of the USB routing commands
+func parseUsbOptions(args map[string]any) (UsbOptions, error) {
+	var opts UsbOptions
+	var err error
+	if opts.Node, err = argNode(args); err != nil {
+		return UsbOptions{}, err
+	}
+	if opts.BMC, err = argBool(args, "bmc", false); err != nil {
+		return UsbOptions{}, err
+	}
+	if opts.Force, err = argBool(args, "force", false); err != nil {
+		return UsbOptions{}, err
+	}
+	return opts, nil
+}
+
+// parseFlashOptions reads the args of the flash commands, callers check ImagePath
+func parseFlashOptions(args map[string]any) (FlashOptions, error) {
+	var opts FlashOptions
+	var err error
+	if opts.Node, err = argNode(args); err != nil {
+		return FlashOptions{}, err
+	}
+	if opts.ImagePath, err = argString(args, "image_path", ""); err != nil {
+		return FlashOptions{}, err
+	}
+	if opts.SHA256, err = argString(args, "sha256", ""); err != nil {
+		return FlashOptions{}, err
+	}
+	if opts.SkipCRC, err = argBool(args, "skip_crc", false); err != nil {
+		return FlashOptions{}, err
+	}
+	if opts.Force, err = argBool(args, "force", false); err != nil {
+		return FlashOptions{}, err
+	}
+	return opts, nil
+}
+
+// argNode reads the required node arg and validates it
+func argNode(args map[string]any) (int, error) {
+	node, err := argInt(args, "node", 0)
+	if err != nil {
+		return 0, err
+	}
+	return node, validateNodeNumber(node)
+}
+
+// argInt reads an integer arg, whole JSON numbers and numeric strings are accepted
+func argInt(args map[string]any, key string, defaultValue int) (int, error) {
+	val, ok := args[key]
+	if !ok || val == nil {
+		return defaultValue, nil
+	}
+	switch v := val.(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+			return int(v), nil
+		}
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i, nil
 		}
-		// Try to convert to string
-		return fmt.Sprintf("%v", val), true
 	}
-	return defaultValue, false
+	return 0, argTypeError(key, "an integer", val)
 }
 
-func getBoolArg(args map[string]any, key string, defaultValue bool) (bool, bool) {
-	if val, ok := args[key]; ok {
-		switch v := val.(type) {
-		case bool:
-			return v, true
-		case string:
-			if v == "true" {
-				return true, true
-			} else if v == "false" {
-				return false, true
-			}
-		case float64:
-			return v != 0, true
-		case int:
-			return v != 0, true
+// argString reads a string arg
+func argString(args map[string]any, key string, defaultValue string) (string, error) {
+	val, ok := args[key]
+	if !ok || val == nil {
+		return defaultValue, nil
+	}
+	if str, ok := val.(string); ok {
+		return str, nil
+	}
+	return "", argTypeError(key, "a string", val)
+}
+
+// argBool reads a boolean arg, "true"/"false" strings and numbers are accepted
+func argBool(args map[string]any, key string, defaultValue bool) (bool, error) {
+	val, ok := args[key]
+	if !ok || val == nil {
+		return defaultValue, nil
+	}
+	switch v := val.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
 		}
+	case float64:
+		return v != 0, nil
+	case int:
+		return v != 0, nil
 	}
-	return defaultValue, false
+	return false, argTypeError(key, "a boolean", val)
+}
+
+// rawParams reads the params of a Raw command, scalar values are sent as their string form
+func rawParams(args map[string]any) (map[string]string, error) {
+	params := make(map[string]string)
+	val, ok := args["params"]
+	if !ok || val == nil {
+		return params, nil
+	}
+	raw, ok := val.(map[string]any)
+	if !ok {
+		return nil, argTypeError("params", "an object", val)
+	}
+	for key, v := range raw {
+		switch v.(type) {
+		case string, float64, int, bool:
+			params[key] = fmt.Sprintf("%v", v)
+		default:
+			return nil, argTypeError("params."+key, "a string, number or boolean", v)
+		}
+	}
+	return params, nil
+}
+
+// argTypeError reports an arg holding a value of the wrong type
+func argTypeError(key, want string, val any) error {
+	return fmt.Errorf("invalid %s: %v is %T, must be %s", key, val, val, want)
 }
 
 // validateNodeNumber validates that the node number is between 1 and 4
@@ -0,0 +1,195 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// runLocally executes the command with the local shell instead of over SSH
+func runLocally(command string, options ...tpi.SSHOption) (*tpi.CommandResult, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	result := &tpi.CommandResult{}
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, nil
+}
+
+func TestExecuteCommandRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	agent := &Agent{config: AgentConfig{}, runCommand: runLocally}
+	server := httptest.NewServer(http.HandlerFunc(agent.handleCommand))
+	defer server.Close()
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	client, err := NewAgentClient(AgentClientConfig{Host: host, Port: port})
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	result, err := client.ExecuteCommand("echo out; echo err >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Expected a nonzero exit to be reported in the result, got error: %v", err)
+	}
+
+	if result.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", result.ExitCode)
+	}
+	if result.Stdout != "out\n" {
+		t.Errorf("Expected stdout %q, got %q", "out\n", result.Stdout)
+	}
+	if result.Stderr != "err\n" {
+		t.Errorf("Expected stderr %q, got %q", "err\n", result.Stderr)
+	}
+
+	if _, err := client.ExecuteCommand(""); err == nil {
+		t.Error("Expected an error for an empty command")
+	}
+}
+
+func TestPowerOffRefusesSelfNode(t *testing.T) {
+	client, queries := newRawAgent(t, AgentConfig{AllowRaw: true, SelfNode: 2})
+
+	refused := map[string]func() error{
+		"power off":     func() error { return client.PowerOff(2) },
+		"power off all": func() error { return client.PowerOffAll() },
+		"power reset":   func() error { return client.PowerReset(2) },
+		"msd mode": func() error {
+			_, err := client.sendCommand(CmdSetNodeMsdMode, map[string]any{"node": 2})
+			return err
+		},
+		"normal mode": func() error {
+			_, err := client.sendCommand(CmdSetNodeNormalMode, map[string]any{"node": 2})
+			return err
+		},
+		"flash":       func() error { return client.FlashNode(2, &tpi.FlashOptions{ImagePath: "/tmp/image.img"}) },
+		"flash local": func() error { return client.FlashNodeLocal(2, "/mnt/sdcard/image.img") },
+		"raw power off": func() error {
+			_, err := client.Raw("set", "power", map[string]string{"node1": "1", "node2": "0"})
+			return err
+		},
+		"raw reset": func() error {
+			_, err := client.Raw("set", "reset", map[string]string{"node": "1"})
+			return err
+		},
+		"usb flash": func() error { return client.UsbSetFlash(2, true) },
+		"raw usb flash": func() error {
+			_, err := client.Raw("set", "usb", map[string]string{"node": "1", "mode": "6"})
+			return err
+		},
+	}
+	for name, call := range refused {
+		if err := call(); err == nil || !strings.Contains(err.Error(), "refusing to change node 2") {
+			t.Errorf("%s: expected changing the agent's node to be refused, got: %v", name, err)
+		}
+	}
+	if got := queries(); len(got) != 0 {
+		t.Fatalf("Expected nothing sent to the BMC, got %v", got)
+	}
+
+	// Other nodes, reads and forced requests go through
+	if err := client.PowerOff(3); err != nil {
+		t.Errorf("Expected powering off another node to work, got: %v", err)
+	}
+	if err := client.PowerReset(1); err != nil {
+		t.Errorf("Expected resetting another node to work, got: %v", err)
+	}
+	if _, err := client.Raw("set", "power", map[string]string{"node2": "1"}); err != nil {
+		t.Errorf("Expected powering on the agent's node to work, got: %v", err)
+	}
+	if err := client.ForcePowerOff(2); err != nil {
+		t.Errorf("Expected a forced power off to work, got: %v", err)
+	}
+	if err := client.ForcePowerOffAll(); err != nil {
+		t.Errorf("Expected a forced power off of all nodes to work, got: %v", err)
+	}
+	if _, err := client.sendCommand(CmdPowerReset, map[string]any{"node": 2, "force": true}); err != nil {
+		t.Errorf("Expected a forced reset to work, got: %v", err)
+	}
+	if _, err := client.Raw("set", "usb", map[string]string{"node": "1", "mode": "4"}); err != nil {
+		t.Errorf("Expected host mode on the agent's node to work, got: %v", err)
+	}
+	if _, err := client.sendCommand(CmdUsbSetFlash, map[string]any{"node": 2, "force": true}); err != nil {
+		t.Errorf("Expected a forced flash mode to work, got: %v", err)
+	}
+	if got := queries(); len(got) != 8 {
+		t.Errorf("Expected 8 BMC requests, got %d: %v", len(got), got)
+	}
+}
+
+func TestExecuteCommandRejectsWrongTypedArgs(t *testing.T) {
+	client, queries := newRawAgent(t, AgentConfig{AllowRaw: true})
+
+	cases := []struct {
+		cmd  CommandType
+		args map[string]any
+		want string
+	}{
+		{CmdPowerOn, map[string]any{"node": "two"}, "invalid node"},
+		{CmdPowerOn, map[string]any{"node": true}, "invalid node"},
+		{CmdPowerReset, map[string]any{"node": 1.5}, "invalid node"},
+		{CmdPowerOff, map[string]any{"node": 1, "force": "yes"}, "invalid force"},
+		{CmdUsbSetHost, map[string]any{"node": 1, "bmc": "maybe"}, "invalid bmc"},
+		{CmdFlashNode, map[string]any{"node": 1, "image_path": 42}, "invalid image_path"},
+		{CmdSendUartCommand, map[string]any{"node": 1, "command": []string{"ls"}}, "invalid command"},
+		{CmdRebootAndWait, map[string]any{"timeout": map[string]any{}}, "invalid timeout"},
+		{CmdRaw, map[string]any{"opt": "get", "type": "info", "params": "node=1"}, "invalid params"},
+		{CmdRaw, map[string]any{"opt": "get", "type": "info", "params": map[string]any{"node": []int{1}}}, "invalid params.node"},
+	}
+	for _, tc := range cases {
+		_, err := client.sendCommand(tc.cmd, tc.args)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s %v: expected an error containing %q, got: %v", tc.cmd, tc.args, tc.want, err)
+		}
+	}
+	if got := queries(); len(got) != 0 {
+		t.Fatalf("Expected nothing sent to the BMC, got %v", got)
+	}
+
+	// The lenient string forms keep working
+	if _, err := client.sendCommand(CmdUsbSetHost, map[string]any{"node": "3", "bmc": "true"}); err != nil {
+		t.Errorf("Expected string node and bmc to be accepted, got: %v", err)
+	}
+	if got := queries(); len(got) != 1 || got[0].Get("node") != "2" {
+		t.Errorf("Expected one USB request for node index 2, got %v", got)
+	}
+}
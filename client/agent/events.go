@@ -0,0 +1,431 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEventInterval is how often the agent polls the BMC for events, used when
+// AgentConfig.EventInterval is zero
+const DefaultEventInterval = 5 * time.Second
+
+// eventBuffer is the number of events a subscriber may lag behind before events are dropped
+const eventBuffer = 64
+
+// EventType defines the kind of change an event reports
+type EventType string
+
+const (
+	EventPower   EventType = "power"
+	EventCooling EventType = "cooling"
+)
+
+// Event is a change detected by the agent, pushed on GET /api/agent/events
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Power events
+	Node int  `json:"node,omitempty"`
+	On   bool `json:"on"`
+
+	// Cooling events
+	Device string `json:"device,omitempty"`
+	Speed  int    `json:"speed,omitempty"`
+}
+
+// eventHub polls the BMC while someone is subscribed and fans the changes out
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	cancel      context.CancelFunc
+
+	// Last known state, replayed to new subscribers
+	power   map[int]bool
+	cooling map[string]int
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan Event]struct{})}
+}
+
+// subscribe registers a subscriber, starting the poller for the first one. The channel
+// starts with the last known state and is closed by unsubscribe or when the hub closes.
+func (a *Agent) subscribe() (chan Event, func()) {
+	h := a.events
+	ch := make(chan Event, eventBuffer)
+
+	h.mu.Lock()
+	for _, event := range h.stateEvents() {
+		ch <- event
+	}
+	h.subscribers[ch] = struct{}{}
+	if h.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.cancel = cancel
+		go a.pollEvents(ctx)
+	}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; !ok {
+			return
+		}
+		delete(h.subscribers, ch)
+		close(ch)
+		if len(h.subscribers) == 0 && h.cancel != nil {
+			h.cancel()
+			h.cancel = nil
+		}
+	}
+}
+
+// close ends every subscription and stops the poller
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+	if h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+}
+
+// stateEvents returns the last known state as events, the caller holds the lock
+func (h *eventHub) stateEvents() []Event {
+	now := time.Now()
+	var events []Event
+
+	nodes := make([]int, 0, len(h.power))
+	for node := range h.power {
+		nodes = append(nodes, node)
+	}
+	sort.Ints(nodes)
+	for _, node := range nodes {
+		events = append(events, Event{Type: EventPower, Time: now, Node: node, On: h.power[node]})
+	}
+
+	devices := make([]string, 0, len(h.cooling))
+	for device := range h.cooling {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	for _, device := range devices {
+		events = append(events, Event{Type: EventCooling, Time: now, Device: device, Speed: h.cooling[device]})
+	}
+
+	return events
+}
+
+// publish sends an event to every subscriber, dropping it for those that lag behind
+func (h *eventHub) publish(event Event) {
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// pollEvents polls the BMC until ctx is done, publishing what changed
+func (a *Agent) pollEvents(ctx context.Context) {
+	interval := a.config.EventInterval
+	if interval <= 0 {
+		interval = DefaultEventInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		a.pollOnce()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce fetches the power status, and the cooling status if enabled, and publishes the changes
+func (a *Agent) pollOnce() {
+	now := time.Now()
+	h := a.events
+
+	power, err := a.client.PowerStatus()
+	if err != nil {
+		log.Printf("Event poller: failed to get power status: %v", err)
+	} else {
+		h.mu.Lock()
+		if h.power == nil {
+			h.power = make(map[int]bool)
+		}
+		for node := 1; node <= 4; node++ {
+			on, ok := power[node]
+			if !ok {
+				continue
+			}
+			if previous, known := h.power[node]; !known || previous != on {
+				h.power[node] = on
+				h.publish(Event{Type: EventPower, Time: now, Node: node, On: on})
+			}
+		}
+		h.mu.Unlock()
+	}
+
+	if !a.config.EventCooling {
+		return
+	}
+
+	devices, err := a.client.GetCoolingStatus()
+	if err != nil {
+		log.Printf("Event poller: failed to get cooling status: %v", err)
+		return
+	}
+	h.mu.Lock()
+	if h.cooling == nil {
+		h.cooling = make(map[string]int)
+	}
+	for _, device := range devices {
+		if previous, known := h.cooling[device.Device]; !known || previous != device.Speed {
+			h.cooling[device.Device] = device.Speed
+			h.publish(Event{Type: EventCooling, Time: now, Device: device.Device, Speed: device.Speed})
+		}
+	}
+	h.mu.Unlock()
+}
+
+// handleEvents streams events as Server-Sent Events. Browsers' EventSource can't send a
+// body, so the client authenticates with a token the agent already confirmed, in the
+// token query parameter.
+func (a *Agent) handleEvents(w http.ResponseWriter, r *http.Request) {
+	// Events report the power status, so they follow its allowlist entry
+	controller, ok := a.startStream(w, r, CmdPowerStatus)
+	if !ok {
+		return
+	}
+
+	events, unsubscribe := a.subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			controller.Flush()
+		}
+	}
+}
+
+// startStream checks that r may open a Server-Sent Events stream whose data follows the
+// allowlist entry of command, and starts the stream. It answers the error and returns false
+// otherwise.
+func (a *Agent) startStream(w http.ResponseWriter, r *http.Request, command CommandType) (*http.ResponseController, bool) {
+	if !a.authorizeEndpoint(w, r, http.MethodGet, command, r.URL.Query().Get("token")) {
+		return nil, false
+	}
+
+	// The stream stays open, the write timeout doesn't apply
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	return controller, true
+}
+
+// authorizeEndpoint checks that r, sent to an endpoint outside of the command protocol,
+// uses method, authenticates with a token the agent confirmed and that command, which
+// the endpoint follows, is allowed. It answers the request when it doesn't.
+func (a *Agent) authorizeEndpoint(w http.ResponseWriter, r *http.Request, method string, command CommandType, token string) bool {
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if !a.isClientAllowed(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	if a.config.Auth.Secret != "" {
+		if token == "" || !a.authenticateRequest(AgentAuthConfig{Token: token}) {
+			sendErrorResponse(w, "Authentication failed", http.StatusUnauthorized)
+			return false
+		}
+	}
+
+	if !a.isCommandAllowed(command) {
+		sendErrorResponse(w, fmt.Sprintf("Command not allowed: %s", command), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// Subscribe streams the events of the agent until ctx is done or the agent closes the
+// stream, then closes the channel. The current state comes first, then the changes.
+func (c *AgentClient) Subscribe(ctx context.Context) (<-chan Event, error) {
+	resp, err := c.openStream(ctx, "/api/agent/events", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// openStream opens the Server-Sent Events stream at path, with query, authenticating first
+// if needed. The response body must be closed by the caller.
+func (c *AgentClient) openStream(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	return c.sendEndpoint(func(token string) (*http.Request, error) {
+		if token != "" {
+			query.Set("token", token)
+		}
+		streamURL := c.endpointURL(path)
+		if len(query) > 0 {
+			streamURL += "?" + query.Encode()
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		return req, nil
+	})
+}
+
+// sendEndpoint sends the request newRequest builds with the token to an endpoint outside of
+// the command protocol, authenticating first if needed. When the agent doesn't know the
+// token anymore, e.g. after a restart or when it expired, it authenticates with the secret
+// again and sends a new request once. The response body must be closed by the caller.
+func (c *AgentClient) sendEndpoint(newRequest func(token string) (*http.Request, error)) (*http.Response, error) {
+	// Streams and large files outlive the client timeout, so they only end with their context
+	httpClient := &http.Client{Transport: c.httpClient.Transport}
+
+	for retried := false; ; retried = true {
+		token, err := c.confirmTokenFirst()
+		if err != nil {
+			return nil, err
+		}
+		req, err := newRequest(token)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "TPI-Agent-Client")
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+
+		c.mu.Lock()
+		secret := c.auth.Secret
+		c.mu.Unlock()
+		if resp.StatusCode == http.StatusUnauthorized && secret != "" && !retried {
+			resp.Body.Close()
+			c.mu.Lock()
+			c.tokenConfirmed = false
+			c.mu.Unlock()
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			var response Response
+			if json.NewDecoder(resp.Body).Decode(&response) == nil && response.Error != "" {
+				return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, response.Error)
+			}
+			return nil, fmt.Errorf("request failed with status %d", resp.StatusCode)
+		}
+		return resp, nil
+	}
+}
+
+// confirmTokenFirst makes sure the agent confirmed the token, which the endpoints outside
+// of the command protocol only accept once confirmed by a command, and returns the token
+func (c *AgentClient) confirmTokenFirst() (string, error) {
+	c.mu.Lock()
+	auth, confirmed := c.auth, c.tokenConfirmed
+	c.mu.Unlock()
+	if auth.Secret != "" && !confirmed {
+		if _, err := c.sendCommand(CmdAuthenticate, nil); err != nil {
+			return "", fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+	return auth.Token, nil
+}
+
+// endpointURL returns the URL of the agent endpoint at path
+func (c *AgentClient) endpointURL(path string) string {
+	protocol := "http"
+	if c.config.TLSEnabled {
+		protocol = "https"
+	}
+	return fmt.Sprintf("%s://%s:%d%s", protocol, c.config.Host, c.config.Port, path)
+}
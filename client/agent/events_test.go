@@ -0,0 +1,200 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+func TestSubscribePowerChange(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// Node 2 is off until the test turns it on
+	var node2 atomic.Int32
+	bmc := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			fmt.Fprintf(w, `{"response":[{"result":[{"node1":1,"node2":%d,"node3":0,"node4":0}]}]}`, node2.Load())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer bmc.Close()
+
+	client, err := tpi.NewClient(tpi.WithHost(bmc.Listener.Addr().String()), tpi.WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	agent, err := NewAgent(AgentConfig{
+		Auth:          AgentAuthConfig{Secret: "s3cret"},
+		EventInterval: 10 * time.Millisecond,
+	}, client)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	server := httptest.NewServer(agent.router)
+	defer server.Close()
+	defer agent.events.close()
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	// Without the confirmed token, the stream is refused
+	resp, err := http.Get(server.URL + "/api/agent/events?token=unknown")
+	if err != nil {
+		t.Fatalf("GET events failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with an unknown token, got %d", resp.StatusCode)
+	}
+
+	agentClient, err := NewAgentClient(AgentClientConfig{Host: host, Port: port, Auth: AgentAuthConfig{Secret: "s3cret"}})
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, err := agentClient.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// The initial state comes first
+	initial := make(map[int]bool)
+	for len(initial) < 4 {
+		select {
+		case event := <-events:
+			if event.Type == EventPower {
+				initial[event.Node] = event.On
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for the initial state, got %v", initial)
+		}
+	}
+	if !initial[1] || initial[2] {
+		t.Fatalf("Unexpected initial state: %v", initial)
+	}
+
+	node2.Store(1)
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("Event stream closed before the power change")
+			}
+			if event.Type == EventPower && event.Node == 2 {
+				if !event.On {
+					t.Errorf("Expected node 2 on, got %+v", event)
+				}
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for the power change")
+		}
+	}
+}
+
+func TestSubscribeAfterAgentRestart(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	bmc := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":0}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer bmc.Close()
+
+	client, err := tpi.NewClient(tpi.WithHost(bmc.Listener.Addr().String()), tpi.WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Restarting swaps the agent behind the same address, which forgets every token
+	var current atomic.Pointer[Agent]
+	restart := func() {
+		agent, err := NewAgent(AgentConfig{Auth: AgentAuthConfig{Secret: "s3cret"}, EventInterval: 10 * time.Millisecond}, client)
+		if err != nil {
+			t.Fatalf("NewAgent failed: %v", err)
+		}
+		t.Cleanup(agent.events.close)
+		current.Store(agent)
+	}
+	restart()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current.Load().router.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	config := AgentClientConfig{Host: host, Port: port, Auth: AgentAuthConfig{Secret: "s3cret"}, PersistToken: true}
+
+	subscribe := func(agentClient *AgentClient) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		events, err := agentClient.Subscribe(ctx)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		select {
+		case <-events:
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for the initial state")
+		}
+	}
+
+	agentClient, err := NewAgentClient(config)
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+	subscribe(agentClient)
+
+	// The confirmed token is refused after a restart, the client authenticates again
+	restart()
+	subscribe(agentClient)
+
+	// A restarted client loads the persisted token as confirmed
+	restart()
+	restarted, err := NewAgentClient(config)
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+	if !restarted.tokenConfirmed {
+		t.Fatal("Expected the persisted token to be loaded as confirmed")
+	}
+	subscribe(restarted)
+}
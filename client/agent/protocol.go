@@ -21,10 +21,21 @@ import (
 // Default port for the agent server
 const DefaultAgentPort = 9977
 
+// Default HTTP server limits of the agent, used when the AgentConfig fields are zero
+const (
+	DefaultReadTimeout    = 30 * time.Second
+	DefaultWriteTimeout   = 60 * time.Second
+	DefaultIdleTimeout    = 120 * time.Second
+	DefaultMaxHeaderBytes = 64 << 10
+)
+
 // CommandType defines the type of command being sent
 type CommandType string
 
 const (
+	// Authentication handshake, confirms the token without reaching the BMC
+	CmdAuthenticate CommandType = "authenticate"
+
 	// Basic commands
 	CmdInfo          CommandType = "info"
 	CmdAbout         CommandType = "about"
@@ -68,6 +79,9 @@ const (
 	CmdDownloadFile   CommandType = "download-file"
 	CmdListDirectory  CommandType = "list-directory"
 	CmdExecuteCommand CommandType = "execute-command"
+
+	// Passthrough commands
+	CmdRaw CommandType = "raw"
 )
 
 // Command represents a command sent from a client to the agent
@@ -75,6 +89,21 @@ type Command struct {
 	Type CommandType     `json:"type"`
 	Args map[string]any  `json:"args,omitempty"`
 	Auth AgentAuthConfig `json:"auth,omitempty"`
+
+	// SSH configures the SSH connection of the commands that use one, see SSHArgs
+	SSH *SSHArgs `json:"ssh,omitempty"`
+}
+
+// SSHArgs configures the SSH connection the agent opens for a command or transfer, the
+// agent's defaults apply to the fields left zero. The agent refuses them on commands that
+// don't use SSH.
+type SSHArgs struct {
+	User string `json:"user,omitempty"`
+	Port int    `json:"port,omitempty"`
+	// Node connects to a compute node [1-4] instead of the BMC
+	Node int `json:"node,omitempty"`
+	// KeyRef names a private key file in the agent's SSHKeyDir, a plain file name
+	KeyRef string `json:"key_ref,omitempty"`
 }
 
 // Response represents the response sent from the agent to the client
@@ -82,16 +111,56 @@ type Response struct {
 	Success bool        `json:"success"`
 	Result  interface{} `json:"result,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Token   string      `json:"token,omitempty"` // Confirms the token the agent accepts from now on
 }
 
 // AgentConfig holds the configuration for the agent
 type AgentConfig struct {
+	BindAddress    string          `json:"bind_address,omitempty"` // Interface to listen on, all interfaces if empty
 	Port           int             `json:"port"`
 	AllowedClients []string        `json:"allowed_clients,omitempty"`
 	Auth           AgentAuthConfig `json:"auth,omitempty"`
 	TLSEnabled     bool            `json:"tls_enabled"`
 	TLSCertFile    string          `json:"tls_cert_file,omitempty"`
 	TLSKeyFile     string          `json:"tls_key_file,omitempty"`
+
+	// AllowedCommands restricts the commands the agent executes, all commands are allowed
+	// if empty. CmdRaw also needs AllowRaw.
+	AllowedCommands []CommandType `json:"allowed_commands,omitempty"`
+
+	// AllowRaw enables CmdRaw, the raw BMC passthrough. It reaches every BMC request,
+	// including those the agent doesn't wrap, so it is refused unless set.
+	AllowRaw bool `json:"allow_raw,omitempty"`
+
+	// HTTP server limits, the defaults apply when zero and a negative timeout disables it.
+	// Long running commands (flash, firmware upgrade, ...) are exempt from WriteTimeout.
+	ReadTimeout    time.Duration `json:"read_timeout,omitempty"`
+	WriteTimeout   time.Duration `json:"write_timeout,omitempty"`
+	IdleTimeout    time.Duration `json:"idle_timeout,omitempty"`
+	MaxHeaderBytes int           `json:"max_header_bytes,omitempty"`
+
+	// EventInterval is how often the BMC is polled while a client is subscribed to
+	// /api/agent/events, DefaultEventInterval if zero. EventCooling adds fan speed changes.
+	EventInterval time.Duration `json:"event_interval,omitempty"`
+	EventCooling  bool          `json:"event_cooling,omitempty"`
+
+	// UartInterval is how often the UART of a node is read while a client streams it
+	// from /api/agent/uart, DefaultUartInterval if zero
+	UartInterval time.Duration `json:"uart_interval,omitempty"`
+
+	// SelfNode is the node [1-4] the agent runs on, 0 if it runs elsewhere. Powering it
+	// off, resetting it, switching its mode or flashing it would kill the agent, so the
+	// commands doing so, CmdRaw included, refuse to unless the command sets the force arg.
+	SelfNode int `json:"self_node,omitempty"`
+
+	// UnixSocket makes the agent listen on a Unix domain socket at this path instead of
+	// TCP, for clients on the same host. A stale socket file is removed first and the
+	// socket is only accessible to its owner; AllowedClients doesn't apply to it.
+	UnixSocket string `json:"unix_socket,omitempty"`
+
+	// SSHKeyDir holds the private keys clients may name in SSHArgs.KeyRef, key references
+	// are refused if empty
+	SSHKeyDir string `json:"ssh_key_dir,omitempty"`
 }
 
 // AgentAuthConfig holds authentication configuration
@@ -109,13 +178,36 @@ type AgentClientConfig struct {
 	TLSEnabled bool            `json:"tls_enabled"`
 	SkipVerify bool            `json:"skip_verify"`
 	Timeout    time.Duration   `json:"timeout,omitempty"`
+
+	// PersistToken caches the generated token on disk, keyed by agent host and port,
+	// and reuses it across client restarts
+	PersistToken bool `json:"persist_token,omitempty"`
+
+	// UnixSocket connects to an agent listening on a Unix domain socket at this path,
+	// Host and Port then only name the agent
+	UnixSocket string `json:"unix_socket,omitempty"`
+}
+
+// PowerOptions contains the args of the single node power and mode commands
+type PowerOptions struct {
+	Node  int  `json:"node"`
+	Force bool `json:"force,omitempty"`
+}
+
+// UsbOptions contains the args of the USB routing commands, Force only applies to flash mode
+type UsbOptions struct {
+	Node  int  `json:"node"`
+	BMC   bool `json:"bmc,omitempty"`
+	Force bool `json:"force,omitempty"`
 }
 
-// FlashOptions contains options for flashing a node (used with CmdFlashNode)
+// FlashOptions contains options for flashing a node (used with CmdFlashNode and CmdFlashNodeLocal)
 type FlashOptions struct {
+	Node      int    `json:"node"`
 	ImagePath string `json:"image_path"`
 	SHA256    string `json:"sha256,omitempty"`
 	SkipCRC   bool   `json:"skip_crc,omitempty"`
+	Force     bool   `json:"force,omitempty"`
 }
 
 // FileInfo represents information about a file on the remote system
@@ -0,0 +1,138 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// newRawAgent starts an agent in front of a mock BMC, recording the BMC queries
+func newRawAgent(t *testing.T, config AgentConfig) (*AgentClient, func() []url.Values) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	var mu sync.Mutex
+	var queries []url.Values
+	bmc := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			mu.Lock()
+			queries = append(queries, r.URL.Query())
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":[{"speed":"42"}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(bmc.Close)
+
+	client, err := tpi.NewClient(tpi.WithHost(bmc.Listener.Addr().String()), tpi.WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	agent := &Agent{config: config, client: client}
+	server := httptest.NewServer(http.HandlerFunc(agent.handleCommand))
+	t.Cleanup(server.Close)
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	agentClient, err := NewAgentClient(AgentClientConfig{Host: host, Port: port})
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	return agentClient, func() []url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return queries
+	}
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	client, queries := newRawAgent(t, AgentConfig{AllowRaw: true})
+
+	raw, err := client.Raw("get", "cooling", map[string]string{"device": "fan0"})
+	if err != nil {
+		t.Fatalf("Raw failed: %v", err)
+	}
+
+	var decoded struct {
+		Response []struct {
+			Result []map[string]string `json:"result"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("Failed to decode raw result %s: %v", raw, err)
+	}
+	if len(decoded.Response) != 1 || len(decoded.Response[0].Result) != 1 || decoded.Response[0].Result[0]["speed"] != "42" {
+		t.Errorf("Unexpected raw result: %s", raw)
+	}
+
+	got := queries()
+	if len(got) != 1 {
+		t.Fatalf("Expected one BMC request, got %d", len(got))
+	}
+	for key, want := range map[string]string{"opt": "get", "type": "cooling", "device": "fan0"} {
+		if got[0].Get(key) != want {
+			t.Errorf("Expected %s=%s on the BMC, got query %v", key, want, got[0])
+		}
+	}
+
+	if _, err := client.Raw("get", "", nil); err == nil {
+		t.Error("Expected an error without a type")
+	}
+}
+
+func TestRawDisabledByDefault(t *testing.T) {
+	client, queries := newRawAgent(t, AgentConfig{})
+
+	_, err := client.Raw("get", "cooling", nil)
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected the raw command to be refused, got: %v", err)
+	}
+	if got := queries(); len(got) != 0 {
+		t.Errorf("Expected nothing sent to the BMC, got %v", got)
+	}
+}
+
+func TestRawDisabledByAllowlist(t *testing.T) {
+	client, queries := newRawAgent(t, AgentConfig{AllowRaw: true, AllowedCommands: []CommandType{CmdInfo}})
+
+	_, err := client.Raw("get", "cooling", nil)
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected the raw command to be refused, got: %v", err)
+	}
+	if got := queries(); len(got) != 0 {
+		t.Errorf("Expected nothing sent to the BMC, got %v", got)
+	}
+
+	// Allowed commands still go through
+	if _, err := client.Info(); err != nil {
+		t.Errorf("Expected info to be allowed, got: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProtocolVersion is the version of the agent protocol described by DescribeProtocol
+const ProtocolVersion = "1"
+
+// Argument types used in the protocol description
+const (
+	ArgTypeInt    = "int"
+	ArgTypeString = "string"
+	ArgTypeBool   = "bool"
+	ArgTypeObject = "object"
+)
+
+// ProtocolSpec is a machine-readable description of the agent protocol
+type ProtocolSpec struct {
+	Version  string        `json:"version"`
+	Endpoint string        `json:"endpoint"`
+	Method   string        `json:"method"`
+	Request  string        `json:"request"`
+	Response ResponseSpec  `json:"response"`
+	Commands []CommandSpec `json:"commands"`
+	Events   EventsSpec    `json:"events"`
+	Uart     EventsSpec    `json:"uart"`
+	Upload   EventsSpec    `json:"upload"`
+	Download EventsSpec    `json:"download"`
+}
+
+// EventsSpec describes an endpoint of the agent outside of the command envelope, a
+// Server-Sent Events stream or a file transfer
+type EventsSpec struct {
+	Endpoint string `json:"endpoint"`
+	Method   string `json:"method"`
+	Auth     string `json:"auth"`
+	Data     string `json:"data"`
+}
+
+// ResponseSpec describes the envelope returned for every command
+type ResponseSpec struct {
+	Success string `json:"success"`
+	Result  string `json:"result"`
+	Error   string `json:"error"`
+}
+
+// CommandSpec describes a single command accepted by the agent
+type CommandSpec struct {
+	Type        CommandType `json:"type"`
+	Description string      `json:"description"`
+	Args        []ArgSpec   `json:"args,omitempty"`
+	Result      string      `json:"result,omitempty"`
+}
+
+// ArgSpec describes an argument of a command
+type ArgSpec struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Default     any    `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// nodeArg is the node argument shared by node-specific commands
+var nodeArg = ArgSpec{Name: "node", Type: ArgTypeInt, Required: true, Description: "Node number [1-4]"}
+
+// bmcArg is the optional BMC routing argument of the USB commands
+var bmcArg = ArgSpec{Name: "bmc", Type: ArgTypeBool, Default: false, Description: "Route the USB bus to the BMC chip instead of USB-A"}
+
+// forceArg is the optional argument of the commands refusing to act on the agent's own node
+var forceArg = ArgSpec{Name: "force", Type: ArgTypeBool, Default: false, Description: "Act on the node the agent runs on too"}
+
+// DescribeProtocol returns the description of every command the agent executes
+func DescribeProtocol() ProtocolSpec {
+	return ProtocolSpec{
+		Version:  ProtocolVersion,
+		Endpoint: "/api/agent",
+		Method:   http.MethodPost,
+		Request:  `JSON object {"type": <command type>, "args": {<name>: <value>}, "auth": {"secret": <string>, "token": <string>}, "ssh": {"user": <string>, "port": <int>, "node": <int>, "key_ref": <string>}}, ssh only for the commands opening an SSH connection, key_ref naming a key file of the agent's SSH key directory`,
+		Response: ResponseSpec{
+			Success: "bool, whether the command succeeded",
+			Result:  "command specific result, omitted when the command returns nothing",
+			Error:   "string, error message when success is false",
+		},
+		Commands: []CommandSpec{
+			// Authentication handshake
+			{Type: CmdAuthenticate, Description: "Confirm the token without reaching the BMC, always allowed"},
+
+			// Basic commands
+			{Type: CmdInfo, Description: "Get basic information about the Turing Pi", Result: "object of string values"},
+			{Type: CmdAbout, Description: "Get detailed information about the BMC daemon", Result: "object of string values"},
+			{Type: CmdReboot, Description: "Reboot the BMC"},
+			{Type: CmdRebootAndWait, Description: "Reboot the BMC and wait for it to come back online", Args: []ArgSpec{
+				{Name: "timeout", Type: ArgTypeInt, Default: 60, Description: "Timeout in seconds"},
+			}},
+
+			// Power commands
+			{Type: CmdPowerStatus, Description: "Get the power status of all nodes", Result: `object mapping node number ("1"-"4") to bool`},
+			{Type: CmdPowerOn, Description: "Power on a node", Args: []ArgSpec{nodeArg}},
+			{Type: CmdPowerOff, Description: "Power off a node", Args: []ArgSpec{nodeArg, forceArg}},
+			{Type: CmdPowerReset, Description: "Reset a node", Args: []ArgSpec{nodeArg, forceArg}},
+			{Type: CmdPowerOnAll, Description: "Power on all nodes"},
+			{Type: CmdPowerOffAll, Description: "Power off all nodes", Args: []ArgSpec{forceArg}},
+
+			// Advanced mode commands
+			{Type: CmdSetNodeNormalMode, Description: "Clear any advanced mode of a node and reset it", Args: []ArgSpec{nodeArg, forceArg}},
+			{Type: CmdSetNodeMsdMode, Description: "Expose the eMMC of a node as a mass storage device", Args: []ArgSpec{nodeArg, forceArg}},
+
+			// USB commands
+			{Type: CmdUsbGetStatus, Description: "Get the current USB configuration", Result: `object {"Node": string, "Mode": string, "Route": string}`},
+			{Type: CmdUsbSetHost, Description: "Configure a node as USB host", Args: []ArgSpec{nodeArg, bmcArg}},
+			{Type: CmdUsbSetDevice, Description: "Configure a node as USB device", Args: []ArgSpec{nodeArg, bmcArg}},
+			{Type: CmdUsbSetFlash, Description: "Configure a node in USB flash mode", Args: []ArgSpec{nodeArg, bmcArg, forceArg}},
+
+			// UART commands
+			{Type: CmdGetUartOutput, Description: "Get the UART output of a node", Args: []ArgSpec{nodeArg}, Result: "string"},
+			{Type: CmdSendUartCommand, Description: "Send a command to a node over UART", Args: []ArgSpec{
+				nodeArg,
+				{Name: "command", Type: ArgTypeString, Required: true, Description: "Command to send"},
+			}},
+
+			// Ethernet commands
+			{Type: CmdEthReset, Description: "Reset the on-board Ethernet switch"},
+
+			// Flash commands
+			{Type: CmdFlashNode, Description: "Flash a node with an OS image stored on the agent host", Args: []ArgSpec{
+				nodeArg,
+				{Name: "image_path", Type: ArgTypeString, Required: true, Description: "Path of the image on the agent host"},
+				{Name: "sha256", Type: ArgTypeString, Description: "SHA256 checksum for verification"},
+				{Name: "skip_crc", Type: ArgTypeBool, Default: false, Description: "Opt out of the CRC integrity check"},
+				forceArg,
+			}},
+			{Type: CmdFlashNodeLocal, Description: "Flash a node with an image accessible from the BMC", Args: []ArgSpec{
+				nodeArg,
+				{Name: "image_path", Type: ArgTypeString, Required: true, Description: "Path of the image on the BMC"},
+				forceArg,
+			}},
+
+			// Firmware commands
+			{Type: CmdUpgradeFirmware, Description: "Upgrade the BMC firmware with a file stored on the agent host", Args: []ArgSpec{
+				{Name: "file_path", Type: ArgTypeString, Required: true, Description: "Path of the firmware on the agent host"},
+				{Name: "sha256", Type: ArgTypeString, Description: "SHA256 checksum for verification"},
+			}},
+
+			// Remote execution commands
+			{Type: CmdExecuteCommand, Description: "Execute a shell command on the BMC over SSH", Args: []ArgSpec{
+				{Name: "command", Type: ArgTypeString, Required: true, Description: "Command to execute"},
+			}, Result: `object {"stdout": string, "stderr": string, "exit_code": int}, a nonzero exit code is not an error`},
+
+			// Passthrough commands
+			{Type: CmdRaw, Description: "Send a request the agent doesn't wrap to the BMC, refused unless the agent sets allow_raw", Args: []ArgSpec{
+				{Name: "opt", Type: ArgTypeString, Required: true, Description: "BMC opt parameter, get or set"},
+				{Name: "type", Type: ArgTypeString, Required: true, Description: "BMC type parameter"},
+				{Name: "params", Type: ArgTypeObject, Description: "Additional query parameters, with string values"},
+				forceArg,
+			}, Result: "JSON response of the BMC, as is"},
+		},
+		Events: EventsSpec{
+			Endpoint: "/api/agent/events",
+			Method:   http.MethodGet,
+			Auth:     "token query parameter, a token the agent confirmed on a command; refused when power_status isn't allowed",
+			Data:     `text/event-stream, the current state then every change: {"type": "power", "time": <RFC 3339>, "node": int, "on": bool} or {"type": "cooling", "time": <RFC 3339>, "device": string, "speed": int}`,
+		},
+		Uart: EventsSpec{
+			Endpoint: "/api/agent/uart?node=<1-4>",
+			Method:   http.MethodGet,
+			Auth:     "token query parameter, a token the agent confirmed on a command; refused when get_uart_output isn't allowed",
+			Data:     `text/event-stream, the UART output of the node as it arrives: {"node": int, "time": <RFC 3339>, "data": string}`,
+		},
+		Upload: EventsSpec{
+			Endpoint: "/api/agent/upload",
+			Method:   http.MethodPost,
+			Auth:     "Authorization: Bearer <token> header, a token the agent confirmed on a command; refused when upload-file isn't allowed",
+			Data:     "raw file content as the body, written over SFTP to the path of the X-Agent-Remote-Path header with the octal permissions of the optional X-Agent-File-Mode header and the SSH args of the optional X-Agent-SSH JSON header; answers the response envelope",
+		},
+		Download: EventsSpec{
+			Endpoint: "/api/agent/download",
+			Method:   http.MethodGet,
+			Auth:     "Authorization: Bearer <token> header, a token the agent confirmed on a command; refused when download-file isn't allowed",
+			Data:     "raw content of the file at the path of the X-Agent-Remote-Path header, read over SFTP with the SSH args of the optional X-Agent-SSH JSON header, with Content-Length and X-Agent-File-Mode headers; the response envelope on error",
+		},
+	}
+}
+
+// handleSpec serves the protocol description, it doesn't require authentication
+func (a *Agent) handleSpec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !a.isClientAllowed(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(DescribeProtocol())
+}
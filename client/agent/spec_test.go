@@ -0,0 +1,199 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// handledCommands returns the command constants listed in the case clauses of executeCommand
+func handledCommands(t *testing.T) []string {
+	return commandCases(t, func(*ast.CaseClause) bool { return true })
+}
+
+// guardedCommands returns the command constants of the case clauses of executeCommand
+// calling checkSelfNode
+func guardedCommands(t *testing.T) []string {
+	return commandCases(t, func(clause *ast.CaseClause) bool {
+		guarded := false
+		ast.Inspect(clause, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == "checkSelfNode" {
+				guarded = true
+			}
+			return !guarded
+		})
+		return guarded
+	})
+}
+
+// commandCases returns the command constants listed in the case clauses of executeCommand
+// matching keep
+func commandCases(t *testing.T, keep func(*ast.CaseClause) bool) []string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "commands.go", nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse commands.go: %v", err)
+	}
+
+	var names []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "executeCommand" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			clause, ok := n.(*ast.CaseClause)
+			if !ok || !keep(clause) {
+				return true
+			}
+			for _, expr := range clause.List {
+				if ident, ok := expr.(*ast.Ident); ok {
+					names = append(names, ident.Name)
+				}
+			}
+			return true
+		})
+	}
+
+	if len(names) == 0 {
+		t.Fatalf("No matching command cases found in executeCommand")
+	}
+	return names
+}
+
+// commandConstants maps the name of every CommandType constant to its value
+func commandConstants(t *testing.T) map[string]CommandType {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "protocol.go", nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse protocol.go: %v", err)
+	}
+
+	consts := make(map[string]CommandType)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			for i, name := range value.Names {
+				if i >= len(value.Values) {
+					continue
+				}
+				lit, ok := value.Values[i].(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				consts[name.Name] = CommandType(lit.Value[1 : len(lit.Value)-1])
+			}
+		}
+	}
+	return consts
+}
+
+func TestSpecCoversHandledCommands(t *testing.T) {
+	described := make(map[CommandType]bool)
+	for _, cmd := range DescribeProtocol().Commands {
+		if described[cmd.Type] {
+			t.Errorf("Command %s is described more than once", cmd.Type)
+		}
+		described[cmd.Type] = true
+	}
+
+	consts := commandConstants(t)
+	for _, name := range handledCommands(t) {
+		cmdType, ok := consts[name]
+		if !ok {
+			t.Errorf("Case %s in executeCommand is not a CommandType constant", name)
+			continue
+		}
+		if !described[cmdType] {
+			t.Errorf("Command %s is handled by executeCommand but missing from DescribeProtocol", cmdType)
+		}
+	}
+}
+
+func TestSpecDeclaresForce(t *testing.T) {
+	args := make(map[CommandType][]ArgSpec)
+	for _, cmd := range DescribeProtocol().Commands {
+		args[cmd.Type] = cmd.Args
+	}
+
+	consts := commandConstants(t)
+	for _, name := range guardedCommands(t) {
+		cmdType := consts[name]
+		declared := false
+		for _, arg := range args[cmdType] {
+			if arg.Name == forceArg.Name {
+				declared = true
+			}
+		}
+		if !declared {
+			t.Errorf("Command %s refuses the agent's own node but doesn't declare %s", cmdType, forceArg.Name)
+		}
+	}
+}
+
+func TestSpecCommandRoute(t *testing.T) {
+	agent, err := NewAgent(AgentConfig{}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+
+	body := `{"type": "unknown"}`
+	rec := httptest.NewRecorder()
+	agent.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, DescribeProtocol().Endpoint, strings.NewReader(body)))
+	if rec.Code == http.StatusNotFound {
+		t.Errorf("Expected the spec endpoint %s to reach the command handler", DescribeProtocol().Endpoint)
+	}
+
+	rec = httptest.NewRecorder()
+	agent.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 outside the spec endpoint, got %d", rec.Code)
+	}
+}
+
+func TestSpecEndpoint(t *testing.T) {
+	agent := &Agent{config: AgentConfig{}}
+
+	rec := httptest.NewRecorder()
+	agent.handleSpec(rec, httptest.NewRequest(http.MethodGet, "/api/agent/spec", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var spec ProtocolSpec
+	if err := json.NewDecoder(rec.Body).Decode(&spec); err != nil {
+		t.Fatalf("Failed to decode spec: %v", err)
+	}
+	if len(spec.Commands) != len(DescribeProtocol().Commands) {
+		t.Errorf("Expected %d commands, got %d", len(DescribeProtocol().Commands), len(spec.Commands))
+	}
+
+	rec = httptest.NewRecorder()
+	agent.handleSpec(rec, httptest.NewRequest(http.MethodPost, "/api/agent/spec", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", rec.Code)
+	}
+}
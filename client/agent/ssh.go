@@ -0,0 +1,91 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// sshCommands are the commands that open an SSH connection, the only ones taking SSHArgs
+var sshCommands = map[CommandType]bool{
+	CmdExecuteCommand: true,
+	CmdUploadFile:     true,
+	CmdDownloadFile:   true,
+	CmdListDirectory:  true,
+}
+
+// sshOptions validates args and returns the SSH options they stand for, none if args is nil
+func (a *Agent) sshOptions(args *SSHArgs) ([]tpi.SSHOption, error) {
+	if args == nil {
+		return nil, nil
+	}
+
+	var options []tpi.SSHOption
+	if args.User != "" {
+		if strings.IndexFunc(args.User, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+			return nil, fmt.Errorf("invalid SSH user: %q", args.User)
+		}
+		options = append(options, tpi.WithSSHUser(args.User))
+	}
+	if args.Port != 0 {
+		if args.Port < 1 || args.Port > 65535 {
+			return nil, fmt.Errorf("invalid SSH port: %d", args.Port)
+		}
+		options = append(options, tpi.WithSSHPort(args.Port))
+	}
+	if args.Node != 0 {
+		if err := validateNodeNumber(args.Node); err != nil {
+			return nil, err
+		}
+		options = append(options, tpi.WithSSHTargetNode(args.Node))
+	}
+	if args.KeyRef != "" {
+		path, err := a.resolveKeyRef(args.KeyRef)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tpi.WithSSHPrivateKeyFile(path))
+	}
+
+	return options, nil
+}
+
+// resolveKeyRef returns the path of the key named ref in the SSHKeyDir. The reference must
+// be a plain file name, so it can't name a file outside of the directory, and the key a
+// regular file, not a link to one elsewhere.
+func (a *Agent) resolveKeyRef(ref string) (string, error) {
+	if a.config.SSHKeyDir == "" {
+		return "", fmt.Errorf("SSH key references are disabled, the agent has no SSH key directory")
+	}
+	if ref != filepath.Base(ref) || strings.ContainsAny(ref, `/\`) || strings.HasPrefix(ref, ".") {
+		return "", fmt.Errorf("invalid SSH key reference %q: must be a file name in the SSH key directory", ref)
+	}
+
+	path := filepath.Join(a.config.SSHKeyDir, ref)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("unknown SSH key reference %q", ref)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("invalid SSH key reference %q: not a regular file", ref)
+	}
+	return path, nil
+}
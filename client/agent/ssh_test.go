@@ -0,0 +1,102 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+func TestExecuteCommandWithSSHArgs(t *testing.T) {
+	agent, _, host, port := newTestAgent(t, "")
+	agent.config.SSHKeyDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(agent.config.SSHKeyDir, "id_node"), []byte("key"), 0600); err != nil {
+		t.Fatalf("Failed to write the key: %v", err)
+	}
+
+	var received tpi.SSHConfig
+	agent.runCommand = func(command string, options ...tpi.SSHOption) (*tpi.CommandResult, error) {
+		received = tpi.SSHConfig{}
+		for _, option := range options {
+			option(&received)
+		}
+		return &tpi.CommandResult{Stdout: command}, nil
+	}
+
+	client, err := NewAgentClientFromOptions(WithAgentHost(host), WithAgentPort(port))
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	result, err := client.ExecuteCommandWithSSH("uname -a", &SSHArgs{User: "ubuntu", Port: 2222, Node: 3, KeyRef: "id_node"})
+	if err != nil {
+		t.Fatalf("ExecuteCommandWithSSH failed: %v", err)
+	}
+	if result.Stdout != "uname -a" {
+		t.Errorf("Unexpected output %q", result.Stdout)
+	}
+	keyFile := filepath.Join(agent.config.SSHKeyDir, "id_node")
+	if received.User != "ubuntu" || received.Port != 2222 || received.Node != 3 || received.PrivateKeyFile != keyFile {
+		t.Errorf("Expected user ubuntu, port 2222, node 3 and key %s, got %+v", keyFile, received)
+	}
+
+	// Without SSH args, the agent's defaults apply
+	if _, err := client.ExecuteCommand("true"); err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if received.User != "" || received.PrivateKeyFile != "" {
+		t.Errorf("Expected no SSH options without SSH args, got %+v", received)
+	}
+}
+
+func TestSSHArgsRejected(t *testing.T) {
+	dir := t.TempDir()
+	keyDir := filepath.Join(dir, "keys")
+	os.Mkdir(keyDir, 0700)
+	os.WriteFile(filepath.Join(dir, "outside"), []byte("key"), 0600)
+	os.Symlink(filepath.Join(dir, "outside"), filepath.Join(keyDir, "link"))
+
+	agent := &Agent{config: AgentConfig{SSHKeyDir: keyDir}}
+	for _, args := range []SSHArgs{
+		{KeyRef: "../outside"},
+		{KeyRef: filepath.Join(dir, "outside")},
+		{KeyRef: "sub/key"},
+		{KeyRef: ".hidden"},
+		{KeyRef: "link"},
+		{KeyRef: "missing"},
+		{User: "root\nforwarded"},
+		{Port: 70000},
+		{Node: 5},
+	} {
+		if _, err := agent.sshOptions(&args); err == nil {
+			t.Errorf("Expected %+v to be rejected", args)
+		}
+	}
+
+	// Without a key directory, no reference is accepted
+	agent.config.SSHKeyDir = ""
+	if _, err := agent.sshOptions(&SSHArgs{KeyRef: "id_node"}); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("Expected key references to be disabled, got: %v", err)
+	}
+
+	// Commands that don't use SSH refuse SSH args
+	if _, err := agent.executeCommand(Command{Type: CmdPowerStatus, SSH: &SSHArgs{User: "root"}}); err == nil {
+		t.Error("Expected SSH args on a power command to be rejected")
+	}
+}
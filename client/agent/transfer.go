@@ -0,0 +1,258 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// Headers of the transfer endpoints, which carry the file itself as the body
+const (
+	// HeaderRemotePath is the path of the file on the remote system
+	HeaderRemotePath = "X-Agent-Remote-Path"
+	// HeaderFileMode is the permissions of the file, in octal
+	HeaderFileMode = "X-Agent-File-Mode"
+	// HeaderSSH is the SSHArgs of the transfer, as JSON
+	HeaderSSH = "X-Agent-SSH"
+)
+
+// handleUpload streams the body of a POST /api/agent/upload to the remote system over SFTP,
+// without buffering it. It follows the allowlist entry of upload-file.
+func (a *Agent) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if !a.authorizeEndpoint(w, r, http.MethodPost, CmdUploadFile, bearerToken(r)) {
+		return
+	}
+
+	remotePath := r.Header.Get(HeaderRemotePath)
+	if remotePath == "" {
+		sendErrorResponse(w, HeaderRemotePath+" header is required", http.StatusBadRequest)
+		return
+	}
+	options, err := a.transferSSHOptions(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode := os.FileMode(0644)
+	if header := r.Header.Get(HeaderFileMode); header != "" {
+		parsed, err := strconv.ParseUint(header, 8, 32)
+		if err != nil {
+			sendErrorResponse(w, fmt.Sprintf("Invalid %s header: %q", HeaderFileMode, header), http.StatusBadRequest)
+			return
+		}
+		mode = os.FileMode(parsed).Perm()
+	}
+
+	// The transfer of a large file outlasts the server timeouts
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+
+	if err := a.uploadFile(r.Body, r.ContentLength, mode, remotePath, options...); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Success: true})
+}
+
+// handleDownload streams the file of the remote system named by the X-Agent-Remote-Path
+// header of a GET /api/agent/download as the body. It follows the allowlist entry of
+// download-file.
+func (a *Agent) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if !a.authorizeEndpoint(w, r, http.MethodGet, CmdDownloadFile, bearerToken(r)) {
+		return
+	}
+
+	remotePath := r.Header.Get(HeaderRemotePath)
+	if remotePath == "" {
+		sendErrorResponse(w, HeaderRemotePath+" header is required", http.StatusBadRequest)
+		return
+	}
+	options, err := a.transferSSHOptions(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, info, err := a.openRemoteFile(remotePath, options...)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// The length lets the client notice a transfer cut short
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set(HeaderFileMode, strconv.FormatUint(uint64(info.Mode().Perm()), 8))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, file)
+}
+
+// transferSSHOptions returns the SSH options of the SSHArgs in the X-Agent-SSH header of r
+func (a *Agent) transferSSHOptions(r *http.Request) ([]tpi.SSHOption, error) {
+	header := r.Header.Get(HeaderSSH)
+	if header == "" {
+		return nil, nil
+	}
+	var args SSHArgs
+	if err := json.Unmarshal([]byte(header), &args); err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", HeaderSSH, err)
+	}
+	return a.sshOptions(&args)
+}
+
+// bearerToken returns the token of the Authorization header of r
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// UploadFile uploads a local file to the remote system through the agent. The file is
+// streamed on POST /api/agent/upload, so its size doesn't matter.
+func (c *AgentClient) UploadFile(localPath, remotePath string) error {
+	return c.UploadFileWithSSH(localPath, remotePath, nil)
+}
+
+// UploadFileWithSSH uploads a local file through the agent over the SSH connection ssh configures
+func (c *AgentClient) UploadFileWithSSH(localPath, remotePath string, ssh *SSHArgs) error {
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+	if stat.IsDir() {
+		return fmt.Errorf("cannot upload a directory, only files are supported")
+	}
+
+	// The file is opened again for every request, the request closes it
+	newRequest := func() (*http.Request, error) {
+		body, err := os.Open(localPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open local file: %w", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, c.endpointURL("/api/agent/upload"), body)
+		if err != nil {
+			body.Close()
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.ContentLength = stat.Size()
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(HeaderRemotePath, remotePath)
+		req.Header.Set(HeaderFileMode, strconv.FormatUint(uint64(stat.Mode().Perm()), 8))
+		return req, nil
+	}
+
+	resp, err := c.sendTransfer(newRequest, ssh)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DownloadFile downloads a file from the remote system through the agent. The file is
+// streamed from GET /api/agent/download, so its size doesn't matter.
+func (c *AgentClient) DownloadFile(remotePath, localPath string) error {
+	return c.DownloadFileWithSSH(remotePath, localPath, nil)
+}
+
+// DownloadFileWithSSH downloads a file through the agent over the SSH connection ssh configures
+func (c *AgentClient) DownloadFileWithSSH(remotePath, localPath string, ssh *SSHArgs) error {
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, c.endpointURL("/api/agent/download"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set(HeaderRemotePath, remotePath)
+		return req, nil
+	}
+
+	resp, err := c.sendTransfer(newRequest, ssh)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	mode := os.FileMode(0644)
+	if parsed, err := strconv.ParseUint(resp.Header.Get(HeaderFileMode), 8, 32); err == nil {
+		mode = os.FileMode(parsed).Perm()
+	}
+
+	// Create the local directory if it doesn't exist
+	localDir := filepath.Dir(localPath)
+	if localDir != "." && localDir != "/" {
+		if err := os.MkdirAll(localDir, 0755); err != nil {
+			return fmt.Errorf("failed to create local directory: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	// A body shorter than its Content-Length fails the copy
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
+
+// sendTransfer sends the request newRequest builds to a transfer endpoint with the SSH args,
+// if any, authenticated with the token in the Authorization header. newRequest may be called
+// twice, so it must give every request its own body.
+func (c *AgentClient) sendTransfer(newRequest func() (*http.Request, error), ssh *SSHArgs) (*http.Response, error) {
+	var sshHeader string
+	if ssh != nil {
+		data, err := json.Marshal(ssh)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode SSH args: %w", err)
+		}
+		sshHeader = string(data)
+	}
+
+	return c.sendEndpoint(func(token string) (*http.Request, error) {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if sshHeader != "" {
+			req.Header.Set(HeaderSSH, sshHeader)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req, nil
+	})
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+// newTransferAgent starts an agent requiring secret whose transfers read and write the local disk
+func newTransferAgent(t *testing.T, secret string) (*Agent, string, int) {
+	t.Helper()
+
+	agent, err := NewAgent(AgentConfig{Auth: AgentAuthConfig{Secret: secret}}, nil)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	agent.uploadFile = func(r io.Reader, size int64, mode os.FileMode, remotePath string, options ...tpi.SSHOption) error {
+		file, err := os.OpenFile(remotePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(file, r)
+		return err
+	}
+	agent.openRemoteFile = func(remotePath string, options ...tpi.SSHOption) (io.ReadCloser, os.FileInfo, error) {
+		file, err := os.Open(remotePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return file, info, nil
+	}
+
+	server := httptest.NewServer(agent.router)
+	t.Cleanup(server.Close)
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return agent, host, port
+}
+
+func TestAgentStreamsLargeFiles(t *testing.T) {
+	agent, host, port := newTransferAgent(t, "shared-secret")
+	client, err := NewAgentClientFromOptions(WithAgentHost(host), WithAgentPort(port), WithAgentSecret("shared-secret"))
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	// A command confirmed the token already
+	agent.authCache[client.auth.Token] = time.Now()
+	client.tokenConfirmed = true
+
+	dir := t.TempDir()
+	content := make([]byte, 48<<20)
+	rand.Read(content)
+	localPath := filepath.Join(dir, "large.img")
+	if err := os.WriteFile(localPath, content, 0640); err != nil {
+		t.Fatalf("Failed to write the file: %v", err)
+	}
+
+	remotePath := filepath.Join(dir, "remote.img")
+	if err := client.UploadFile(localPath, remotePath); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	uploaded, err := os.ReadFile(remotePath)
+	if err != nil || !bytes.Equal(uploaded, content) {
+		t.Fatalf("Uploaded file differs from the original (%d of %d bytes): %v", len(uploaded), len(content), err)
+	}
+
+	downloadPath := filepath.Join(dir, "downloads", "large.img")
+	if err := client.DownloadFile(remotePath, downloadPath); err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	downloaded, err := os.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, content) {
+		t.Fatalf("Downloaded file differs from the original (%d of %d bytes): %v", len(downloaded), len(content), err)
+	}
+	if info, _ := os.Stat(downloadPath); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the permissions to be kept, got %o", info.Mode().Perm())
+	}
+
+	if err := client.DownloadFile(filepath.Join(dir, "missing.img"), downloadPath); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Expected the agent to report the missing file, got: %v", err)
+	}
+}
+
+func TestAgentTransferAuthenticatesAgain(t *testing.T) {
+	agent, host, port := newTransferAgent(t, "shared-secret")
+	client, err := NewAgentClientFromOptions(WithAgentHost(host), WithAgentPort(port), WithAgentSecret("shared-secret"))
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	// The client believes the token confirmed, the agent doesn't know it
+	client.tokenConfirmed = true
+
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(localPath, []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed to write the file: %v", err)
+	}
+
+	// The refused upload is sent again with the whole file
+	remotePath := filepath.Join(dir, "remote.txt")
+	if err := client.UploadFile(localPath, remotePath); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if uploaded, err := os.ReadFile(remotePath); err != nil || string(uploaded) != "content" {
+		t.Fatalf("Expected the file to be uploaded, got %q: %v", uploaded, err)
+	}
+
+	// Same after the token expired
+	delete(agent.authCache, client.auth.Token)
+	if err := client.DownloadFile(remotePath, filepath.Join(dir, "downloaded.txt")); err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+}
+
+func TestAgentTransferRequiresToken(t *testing.T) {
+	agent, host, port := newTransferAgent(t, "shared-secret")
+	agent.config.AllowedCommands = []CommandType{CmdUploadFile}
+	baseURL := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
+	agent.authCache["known-token"] = time.Now()
+
+	send := func(method, path, token string) int {
+		req, _ := http.NewRequest(method, baseURL+path, strings.NewReader("data"))
+		req.Header.Set(HeaderRemotePath, filepath.Join(t.TempDir(), "file"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := send(http.MethodPost, "/api/agent/upload", ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected an upload without token to be refused, got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/agent/upload", "unknown-token"); status != http.StatusUnauthorized {
+		t.Errorf("Expected an upload with an unknown token to be refused, got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/agent/upload", "known-token"); status != http.StatusOK {
+		t.Errorf("Expected an upload with a confirmed token to succeed, got %d", status)
+	}
+	if status := send(http.MethodGet, "/api/agent/download", "known-token"); status != http.StatusForbidden {
+		t.Errorf("Expected a download left out of the allowed commands to be refused, got %d", status)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultUartInterval is how often the agent reads the UART of a streamed node, used when
+// AgentConfig.UartInterval is zero
+const DefaultUartInterval = time.Second
+
+// UartOutput is output of a node's UART, pushed on GET /api/agent/uart
+type UartOutput struct {
+	Node int       `json:"node"`
+	Time time.Time `json:"time"`
+	Data string    `json:"data"`
+}
+
+// handleUart streams the UART output of the node in the node query parameter as Server-Sent
+// Events, authenticated like the events stream
+func (a *Agent) handleUart(w http.ResponseWriter, r *http.Request) {
+	node, err := strconv.Atoi(r.URL.Query().Get("node"))
+	if err != nil || validateNodeNumber(node) != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid node: %q", r.URL.Query().Get("node")), http.StatusBadRequest)
+		return
+	}
+
+	// The stream reads the UART, so it follows the allowlist entry of get_uart_output
+	controller, ok := a.startStream(w, r, CmdGetUartOutput)
+	if !ok {
+		return
+	}
+
+	interval := a.config.UartInterval
+	if interval <= 0 {
+		interval = DefaultUartInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var reads uartReads
+	for {
+		output, err := a.client.GetUartOutput(node)
+		if err != nil {
+			log.Printf("UART stream: failed to read node %d: %v", node, err)
+		} else if data := reads.delta(output); data != "" {
+			event, err := json.Marshal(UartOutput{Node: node, Time: time.Now(), Data: data})
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: uart\ndata: %s\n\n", event); err != nil {
+				return
+			}
+			controller.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// uartReads follows the successive UART reads of a node. Depending on the firmware, a read
+// returns either the whole ring buffer, or only what was written since the last read.
+type uartReads struct {
+	previous string
+	// draining is set once a read came back empty after output, which a whole buffer never
+	// does: every read is then new output
+	draining bool
+}
+
+// delta returns the output of a UART read that is new since the previous one. A whole buffer
+// read overlaps the previous one: the longest end of the previous read that starts this one
+// was already seen, even once the buffer wrapped and dropped its start.
+func (u *uartReads) delta(output string) string {
+	before := u.previous
+	u.previous = output
+	if output == "" && before != "" {
+		u.draining = true
+	}
+	if u.draining {
+		return output
+	}
+
+	for i := 0; i < len(before); i++ {
+		if strings.HasPrefix(output, before[i:]) {
+			return output[len(before)-i:]
+		}
+	}
+	return output
+}
+
+// StreamUart streams the UART output of node until ctx is done or the agent closes the
+// stream, then closes the channel
+func (c *AgentClient) StreamUart(ctx context.Context, node int) (<-chan UartOutput, error) {
+	if err := validateNodeNumber(node); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.openStream(ctx, "/api/agent/uart", url.Values{"node": {strconv.Itoa(node)}})
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make(chan UartOutput)
+	go func() {
+		defer close(outputs)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var output UartOutput
+			if err := json.Unmarshal([]byte(data), &output); err != nil {
+				continue
+			}
+			select {
+			case outputs <- output:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return outputs, nil
+}
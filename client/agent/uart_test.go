@@ -0,0 +1,129 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tpi "github.com/davidroman0O/tpi/client"
+)
+
+func TestUartReadsDelta(t *testing.T) {
+	tests := []struct {
+		name  string
+		reads []string
+		want  []string
+	}{
+		{"growing buffer", []string{"a\n", "a\nb\n", "a\nb\n", "a\nb\nc\n"}, []string{"a\n", "b\n", "", "c\n"}},
+		// The ring buffer holds 6 bytes and drops its start as it wraps
+		{"wrapped buffer", []string{"a\nb\nc\n", "b\nc\nd\n", "d\ne\nf\n"}, []string{"a\nb\nc\n", "d\n", "e\nf\n"}},
+		// Reads drain the buffer, a chunk repeating the previous one is still new
+		{"drained buffer", []string{"ok\n", "", "ok\n", "ok\nnext\n"}, []string{"ok\n", "", "ok\n", "ok\nnext\n"}},
+	}
+
+	for _, tt := range tests {
+		var reads uartReads
+		for i, output := range tt.reads {
+			if got := reads.delta(output); got != tt.want[i] {
+				t.Errorf("%s: read %d: delta(%q) = %q, want %q", tt.name, i, output, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestStreamUart(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// The UART buffer of node 3 grows by a line on every read
+	var mu sync.Mutex
+	reads := 0
+	bmc := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			// Authenticating on the agent doesn't reach the BMC
+			if r.URL.Query().Get("type") != "uart" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			if r.URL.Query().Get("node") != "2" {
+				http.Error(w, "unexpected node", http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			reads++
+			buffer := strings.Repeat("line\\n", reads)
+			mu.Unlock()
+			fmt.Fprintf(w, `{"response":["%s"]}`, buffer)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer bmc.Close()
+
+	client, err := tpi.NewClient(tpi.WithHost(bmc.Listener.Addr().String()), tpi.WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	agent, err := NewAgent(AgentConfig{
+		Auth:         AgentAuthConfig{Secret: "s3cret"},
+		UartInterval: 10 * time.Millisecond,
+		// The stream needs no other command, not even to authenticate
+		AllowedCommands: []CommandType{CmdGetUartOutput},
+	}, client)
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	server := httptest.NewServer(agent.router)
+	defer server.Close()
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	agentClient, err := NewAgentClient(AgentClientConfig{Host: host, Port: port, Auth: AgentAuthConfig{Secret: "s3cret"}})
+	if err != nil {
+		t.Fatalf("Failed to create agent client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	outputs, err := agentClient.StreamUart(ctx, 3)
+	if err != nil {
+		t.Fatalf("StreamUart failed: %v", err)
+	}
+
+	// Only the new output of every read is streamed
+	for i := 0; i < 3; i++ {
+		select {
+		case output := <-outputs:
+			if output.Node != 3 || output.Data != "line\n" {
+				t.Fatalf("Unexpected output %d: %+v", i, output)
+			}
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for UART output")
+		}
+	}
+}
@@ -16,7 +16,7 @@ package tpi
 
 import (
 	"bytes"
-	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -40,6 +40,30 @@ func (a *Auth) HasCredentials() bool {
 	return a.Username != "" && a.Password != ""
 }
 
+// ReadPassword reads a password from r, trimming a single trailing newline
+func ReadPassword(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+
+	password := strings.TrimSuffix(string(data), "\n")
+	password = strings.TrimSuffix(password, "\r")
+
+	return password, nil
+}
+
+// ReadPasswordFile reads a password from the file at path, trimming a single trailing newline
+func ReadPasswordFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open password file: %w", err)
+	}
+	defer file.Close()
+
+	return ReadPassword(file)
+}
+
 // ForceAuthentication forces authentication and token caching
 func (c *Client) ForceAuthentication() (string, error) {
 	// Delete any existing token for this host
@@ -63,16 +87,39 @@ func (c *Client) ForceAuthentication() (string, error) {
 
 // requestToken requests a new authentication token
 func (c *Client) requestToken() (string, error) {
-	// Use the credentials from the client
+	_, token, err := c.authenticate()
+	return token, err
+}
+
+// AuthenticateRaw authenticates with the BMC and returns the whole parsed response,
+// for callers that want more than the token. The token is cached as with Login.
+func (c *Client) AuthenticateRaw() (map[string]interface{}, error) {
+	response, _, err := c.authenticate()
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// authenticate sends the credentials to the BMC and returns the parsed response and
+// the token in its id field
+func (c *Client) authenticate() (map[string]interface{}, string, error) {
+	// Use the credentials from the client, or ask the provider
 	username := c.auth.Username
 	password := c.auth.Password
+	if !c.auth.HasCredentials() && c.credentialProvider != nil {
+		var err error
+		username, password, err = c.credentialProvider.Credentials(c.Host)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get credentials: %w", err)
+		}
+	}
 
 	// Debug information
 	Debug("Auth attempt with user: %s to URL: %s", username, c.Host)
 
 	// Construct authentication URL
-	baseURL := fmt.Sprintf("%s://%s", c.ApiVersion.GetScheme(), c.Host)
-	authURL := fmt.Sprintf("%s/api/bmc/authenticate", baseURL)
+	authURL := c.ApiVersion.BaseURL(c.Host) + c.paths().Auth()
 
 	Debug("Auth URL: %s", authURL)
 
@@ -84,7 +131,7 @@ func (c *Client) requestToken() (string, error) {
 
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal auth request: %w", err)
+		return nil, "", fmt.Errorf("failed to marshal auth request: %w", err)
 	}
 
 	Debug("Auth request body: %s", string(jsonBody))
@@ -92,33 +139,26 @@ func (c *Client) requestToken() (string, error) {
 	// Create a POST request with JSON body
 	req, err := http.NewRequest(http.MethodPost, authURL, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create auth request: %w", err)
+		return nil, "", fmt.Errorf("failed to create auth request: %w", err)
 	}
 
 	// Set Content-Type to application/json - this is critical
+	addExtraHeaders(req.Header, c.headers)
 	req.Header.Set("Content-Type", "application/json")
 
 	// Set User-Agent header
-	osInfo := runtime.GOOS
-	osVersion := runtime.Version()
-	userAgent := fmt.Sprintf("TPI (%s;%s)", osInfo, osVersion)
-	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("User-Agent", c.userAgentHeader())
 
 	// Create a client that ignores SSL certificate errors
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, // Skip certificate verification
-		},
-	}
 	client := &http.Client{
-		Transport: tr,
+		Transport: newBMCTransport(c.pinnedCert),
 		Timeout:   3 * time.Second,
 	}
 
 	// Send the request
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send auth request: %w", err)
+		return nil, "", fmt.Errorf("failed to send auth request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -127,17 +167,20 @@ func (c *Client) requestToken() (string, error) {
 		body, _ := io.ReadAll(resp.Body)
 		Debug("Auth failed with status: %d, body: %s", resp.StatusCode, string(body))
 
-		if resp.StatusCode == http.StatusForbidden {
-			return "", fmt.Errorf("authentication failed: invalid credentials")
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+			return nil, "", authFailure(resp, time.Now())
+		}
+		if err := checkHTMLResponse(resp, body); err != nil {
+			return nil, "", fmt.Errorf("authentication failed: %w", err)
 		}
 
-		return "", fmt.Errorf("authentication failed: %s", string(body))
+		return nil, "", fmt.Errorf("authentication failed: %s", string(body))
 	}
 
 	// Parse response
 	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("failed to parse auth response: %w", err)
+	if err := decodeJSONResponse(resp, &response); err != nil {
+		return nil, "", fmt.Errorf("failed to parse auth response: %w", err)
 	}
 
 	Debug("Auth response: %+v", response)
@@ -145,12 +188,12 @@ func (c *Client) requestToken() (string, error) {
 	// Look for token in the "id" field
 	tokenVal, ok := response["id"]
 	if !ok {
-		return "", fmt.Errorf("invalid auth response: missing id field")
+		return nil, "", fmt.Errorf("invalid auth response: missing id field")
 	}
 
 	token, ok := tokenVal.(string)
 	if !ok {
-		return "", fmt.Errorf("invalid auth response: id is not a string")
+		return nil, "", fmt.Errorf("invalid auth response: id is not a string")
 	}
 
 	Debug("Successfully got auth token: %s", token)
@@ -160,7 +203,30 @@ func (c *Client) requestToken() (string, error) {
 		fmt.Fprintf(os.Stderr, "Warning: failed to cache token for host %s: %v\n", c.Host, err)
 	}
 
-	return token, nil
+	return response, token, nil
+}
+
+// DecodeTokenExpiry reads the exp claim of a JWT token, without verifying its signature.
+// It returns false if the token isn't a JWT or has no expiry.
+func DecodeTokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp *float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(*claims.Exp), 0), true
 }
 
 // Login authenticates with the BMC and caches the token for future use
@@ -197,99 +263,136 @@ func (c *Client) Login() error {
 	return nil
 }
 
-// getCacheFilePath returns the path to the cache file for a specific host
-func getCacheFilePath(host string) string {
-	var cacheDir string
+// validateTokenTimeout bounds the probe of ValidateToken
+var validateTokenTimeout = 3 * time.Second
+
+// ValidateToken reports whether the BMC accepts the cached token of the host, probing it
+// with a cheap authenticated request. Unlike the other requests, a rejected token is neither
+// deleted nor replaced by authenticating again. Without a cached token it returns false.
+func (c *Client) ValidateToken() (bool, error) {
+	token, err := GetCachedToken(c.Host)
+	if err != nil {
+		if c.auth.Token == "" {
+			return false, nil
+		}
+		token = c.auth.Token
+	}
 
-	// Get cache directory based on OS
+	probeURL := c.ApiVersion.BaseURL(c.Host) + c.paths().Base + "?opt=get&type=other"
+	req, err := http.NewRequest(http.MethodGet, probeURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	addExtraHeaders(req.Header, c.headers)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", c.userAgentHeader())
+
+	// Send it without the authenticating transport, which would act on a 401
+	client := &http.Client{
+		Transport: newBMCTransport(c.pinnedCert),
+		Timeout:   validateTokenTimeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, nil
+	default:
+		if err := checkHTMLResponse(resp, body); err != nil {
+			return false, err
+		}
+		return false, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+}
+
+// defaultCacheDir returns the OS specific cache directory of tpi, or "" if it can't be determined
+func defaultCacheDir() string {
 	switch runtime.GOOS {
 	case "windows":
-		cacheDir = filepath.Join(os.Getenv("LOCALAPPDATA"), "tpi")
+		return filepath.Join(os.Getenv("LOCALAPPDATA"), "tpi")
 	case "darwin":
 		homeDir, err := os.UserHomeDir()
 		if err == nil {
-			cacheDir = filepath.Join(homeDir, "Library", "Caches", "tpi")
+			return filepath.Join(homeDir, "Library", "Caches", "tpi")
 		}
 	default: // Linux and others
 		homeDir, err := os.UserHomeDir()
 		if err == nil {
-			cacheDir = filepath.Join(homeDir, ".cache", "tpi")
+			return filepath.Join(homeDir, ".cache", "tpi")
 		}
 	}
+	return ""
+}
+
+// CacheDir returns the directory tokens are cached in, creating it if needed.
+// It falls back to the current directory if the cache directory can't be used.
+func CacheDir() string {
+	cacheDir := defaultCacheDir()
 
 	// Fallback to current directory if we couldn't determine the cache directory
 	if cacheDir == "" {
-		cacheDir = "."
+		return "."
 	}
 
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll(cacheDir, 0700); err != nil {
 		// If we can't create the directory, just use current directory
-		cacheDir = "."
+		return "."
 	}
 
+	return cacheDir
+}
+
+// getCacheFilePath returns the path to the cache file for a specific host
+func getCacheFilePath(host string) string {
+	cacheDir := CacheDir()
+
 	// If no host is specified, use the default token path (for backward compatibility)
 	if host == "" {
 		return filepath.Join(cacheDir, "tpi_token")
 	}
 
-	// Create a sanitized version of the host for the filename
+	return filepath.Join(cacheDir, fmt.Sprintf("tpi_token_%s", SanitizeHost(host)))
+}
+
+// SanitizeHost turns a host into a string that can be used in a file name
+func SanitizeHost(host string) string {
 	safeHost := strings.ReplaceAll(host, ":", "_")
 	safeHost = strings.ReplaceAll(safeHost, "/", "_")
 	safeHost = strings.ReplaceAll(safeHost, ".", "_")
-
-	return filepath.Join(cacheDir, fmt.Sprintf("tpi_token_%s", safeHost))
+	return safeHost
 }
 
 // CacheToken caches the token for a specific host
 func CacheToken(host, token string) error {
-	path := getCacheFilePath(host)
-	err := os.WriteFile(path, []byte(token), 0600)
+	err := tokenCache.Save(getCacheFilePath(host), token)
 	if err != nil {
 		return fmt.Errorf("failed to write token: %w", err)
 	}
 	return nil
 }
 
-// GetCachedToken returns the cached token for a specific host
+// GetCachedToken returns the cached token for a specific host.
+// Tokens are kept in memory for a short while, so repeated calls don't read the token file each time.
 func GetCachedToken(host string) (string, error) {
-	path := getCacheFilePath(host)
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-	return string(data), nil
+	return tokenCache.Load(getCacheFilePath(host))
 }
 
 // DeleteCachedToken deletes the cached token for a specific host
 func DeleteCachedToken(host string) error {
-	path := getCacheFilePath(host)
-	err := os.Remove(path)
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	return nil
+	return tokenCache.Delete(getCacheFilePath(host))
 }
 
 // GetAllCachedTokens returns a list of all hosts with cached tokens
 func GetAllCachedTokens() ([]string, error) {
-	var cacheDir string
-
-	// Get cache directory based on OS
-	switch runtime.GOOS {
-	case "windows":
-		cacheDir = filepath.Join(os.Getenv("LOCALAPPDATA"), "tpi")
-	case "darwin":
-		homeDir, err := os.UserHomeDir()
-		if err == nil {
-			cacheDir = filepath.Join(homeDir, "Library", "Caches", "tpi")
-		}
-	default: // Linux and others
-		homeDir, err := os.UserHomeDir()
-		if err == nil {
-			cacheDir = filepath.Join(homeDir, ".cache", "tpi")
-		}
-	}
+	cacheDir := defaultCacheDir()
 
 	// If we couldn't determine the cache directory or it doesn't exist, return empty list
 	if cacheDir == "" {
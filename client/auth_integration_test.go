@@ -42,6 +42,16 @@ func loadAuthTestConfig(t *testing.T) *AuthTestConfig {
 		t.Fatalf("Failed to parse config.json: %v", err)
 	}
 
+	// Fail fast on a typo rather than talking to the BMC with an invalid version
+	if config.ApiVersion == "" {
+		config.ApiVersion = string(ApiVersionV1_1)
+	}
+	version, err := ParseApiVersion(config.ApiVersion)
+	if err != nil {
+		t.Fatalf("Invalid api_version in config.json: %v", err)
+	}
+	config.ApiVersion = string(version)
+
 	return &config
 }
 
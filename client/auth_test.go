@@ -17,6 +17,7 @@ package tpi
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -273,3 +274,47 @@ func TestCacheTokenIntegration(t *testing.T) {
 		t.Errorf("Legacy token fallback failed: expected %s, got %s", legacyToken, retrievedToken)
 	}
 }
+
+func TestWithPasswordFromFile(t *testing.T) {
+	tempDir := createTempDir(t)
+	defer cleanupTempDir(t, tempDir)
+
+	// Write a password file with a trailing newline, as editors and echo do
+	path := filepath.Join(tempDir, "password")
+	if err := os.WriteFile(path, []byte("s3cret pass\n"), 0600); err != nil {
+		t.Fatalf("Failed to write password file: %v", err)
+	}
+
+	client, err := NewClient(
+		WithHost("192.168.1.1"),
+		WithCredentials("root", ""),
+		WithPasswordFromFile(path),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if client.auth.Password != "s3cret pass" {
+		t.Errorf("Expected password to be %q, got %q", "s3cret pass", client.auth.Password)
+	}
+
+	// A missing password file should fail client creation
+	_, err = NewClient(
+		WithHost("192.168.1.1"),
+		WithPasswordFromFile(filepath.Join(tempDir, "missing")),
+	)
+	if err == nil {
+		t.Error("Expected error for a missing password file, got nil")
+	}
+}
+
+func TestReadPasswordTrimsNewline(t *testing.T) {
+	password, err := ReadPassword(strings.NewReader("turing\r\n"))
+	if err != nil {
+		t.Fatalf("Failed to read password: %v", err)
+	}
+
+	if password != "turing" {
+		t.Errorf("Expected password to be %q, got %q", "turing", password)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import "fmt"
+
+// Verb is an operation supported by Do
+type Verb string
+
+const (
+	// Reads, their Result.Value type is given for each
+	VerbPowerStatus Verb = "power_status" // map[int]bool
+	VerbUsbStatus   Verb = "usb_status"   // *UsbStatusInfo
+	VerbInfo        Verb = "info"         // map[string]string
+	VerbAbout       Verb = "about"        // map[string]string
+	VerbUartRead    Verb = "uart_read"    // string
+
+	// Writes, their Result.Value is nil
+	VerbPowerOn    Verb = "power_on"
+	VerbPowerOff   Verb = "power_off"
+	VerbPowerReset Verb = "power_reset"
+	VerbUsbHost    Verb = "usb_host"
+	VerbUsbDevice  Verb = "usb_device"
+	VerbUsbFlash   Verb = "usb_flash"
+	VerbUartSend   Verb = "uart_send"
+	VerbNormalMode Verb = "normal_mode"
+	VerbMsdMode    Verb = "msd_mode"
+)
+
+// Operation is one step of a batch executed by Do
+type Operation struct {
+	Verb Verb
+	// Node targeted by node operations, 1-4
+	Node int
+	// Bmc routes USB to the BMC for the USB verbs
+	Bmc bool
+	// Command is the line sent by VerbUartSend
+	Command string
+}
+
+// Result is the outcome of an Operation
+type Result struct {
+	Op Operation
+	// Value holds the data returned by reads, see the Verb constants for its type
+	Value interface{}
+	// Err is set when the operation failed, later operations still run
+	Err error
+}
+
+// Do executes operations in order and returns one result per operation, in the same order.
+// The client authenticates once up front and every operation reuses that token. A failing
+// operation doesn't stop the batch: its error is in its Result. The returned error is only
+// set when the batch couldn't start, e.g. when authentication fails.
+func (c *Client) Do(ops ...Operation) ([]Result, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	// Authenticate once, the token is cached for the operations below
+	if !c.noAuth {
+		req, err := c.newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if _, err := req.getBearerToken(); err != nil {
+			return nil, fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	results := make([]Result, len(ops))
+	for i, op := range ops {
+		value, err := c.runOperation(op)
+		if err != nil {
+			value = nil
+		}
+		results[i] = Result{Op: op, Value: value, Err: err}
+	}
+
+	return results, nil
+}
+
+// runOperation executes a single operation of a batch
+func (c *Client) runOperation(op Operation) (interface{}, error) {
+	switch op.Verb {
+	case VerbPowerStatus:
+		return c.PowerStatus()
+	case VerbUsbStatus:
+		return c.UsbGetStatus()
+	case VerbInfo:
+		return c.Info()
+	case VerbAbout:
+		return c.About()
+	case VerbUartRead:
+		return c.GetUartOutput(op.Node)
+	case VerbPowerOn:
+		return nil, c.PowerOn(op.Node)
+	case VerbPowerOff:
+		return nil, c.PowerOff(op.Node)
+	case VerbPowerReset:
+		return nil, c.PowerReset(op.Node)
+	case VerbUsbHost:
+		return nil, c.UsbSetHost(op.Node, op.Bmc)
+	case VerbUsbDevice:
+		return nil, c.UsbSetDevice(op.Node, op.Bmc)
+	case VerbUsbFlash:
+		return nil, c.UsbSetFlash(op.Node, op.Bmc)
+	case VerbUartSend:
+		return nil, c.SendUartCommand(op.Node, op.Command)
+	case VerbNormalMode:
+		return nil, c.SetNodeNormalMode(op.Node)
+	case VerbMsdMode:
+		return nil, c.SetNodeMsdMode(op.Node)
+	default:
+		return nil, fmt.Errorf("unsupported operation: %q", op.Verb)
+	}
+}
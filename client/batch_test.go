@@ -0,0 +1,130 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDoMixedOperations(t *testing.T) {
+	var mu sync.Mutex
+	authentications := 0
+	var sets []string
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			mu.Lock()
+			authentications++
+			mu.Unlock()
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			if r.Header.Get("Authorization") != "Bearer mock-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			query := r.URL.Query()
+			if query.Get("opt") == "set" {
+				mu.Lock()
+				sets = append(sets, query.Get("type"))
+				mu.Unlock()
+				w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+				return
+			}
+			switch query.Get("type") {
+			case "power":
+				w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":0}]}]}`))
+			case "other":
+				w.Write([]byte(`{"response":[{"result":[{"api":"1.1"}]}]}`))
+			default:
+				http.Error(w, "unknown type", http.StatusBadRequest)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	results, err := client.Do(
+		Operation{Verb: VerbPowerStatus},
+		Operation{Verb: VerbPowerOn, Node: 2},
+		Operation{Verb: VerbPowerOff, Node: 5},
+		Operation{Verb: VerbInfo},
+		Operation{Verb: "dance"},
+		Operation{Verb: VerbPowerReset, Node: 3},
+	)
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("Expected 6 results, got %d", len(results))
+	}
+
+	// Results are in the order of the operations
+	for i, verb := range []Verb{VerbPowerStatus, VerbPowerOn, VerbPowerOff, VerbInfo, "dance", VerbPowerReset} {
+		if results[i].Op.Verb != verb {
+			t.Errorf("Result %d: expected verb %s, got %s", i, verb, results[i].Op.Verb)
+		}
+	}
+
+	status, ok := results[0].Value.(map[int]bool)
+	if results[0].Err != nil || !ok || !reflect.DeepEqual(status, map[int]bool{1: true, 2: false, 3: false, 4: false}) {
+		t.Errorf("Unexpected power status result: %+v", results[0])
+	}
+	if results[1].Err != nil || results[1].Value != nil {
+		t.Errorf("Unexpected power on result: %+v", results[1])
+	}
+	if results[2].Err == nil || !strings.Contains(results[2].Err.Error(), "invalid node") {
+		t.Errorf("Expected an invalid node error, got: %+v", results[2])
+	}
+	if info, ok := results[3].Value.(map[string]string); results[3].Err != nil || !ok || info["api"] != "1.1" {
+		t.Errorf("Unexpected info result: %+v", results[3])
+	}
+	if results[4].Err == nil {
+		t.Error("Expected an error for an unsupported verb")
+	}
+	if results[5].Err != nil {
+		t.Errorf("Unexpected power reset result: %+v", results[5])
+	}
+
+	// Failing operations don't stop the batch, and the token is shared
+	if !reflect.DeepEqual(sets, []string{"power", "reset"}) {
+		t.Errorf("Expected the power on and reset to be sent, got %v", sets)
+	}
+	if authentications != 1 {
+		t.Errorf("Expected a single authentication, got %d", authentications)
+	}
+}
+
+func TestDoAuthenticationFailure(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		t.Errorf("Unexpected request to %s", r.URL)
+	}))
+
+	results, err := client.Do(Operation{Verb: VerbPowerStatus})
+	if err == nil {
+		t.Fatal("Expected an authentication error")
+	}
+	if results != nil {
+		t.Errorf("Expected no results, got %v", results)
+	}
+}
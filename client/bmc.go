@@ -0,0 +1,107 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetBMCHostname sets the hostname of the BMC. It returns ErrUnsupported if the firmware
+// can't change its hostname.
+func (c *Client) SetBMCHostname(name string) error {
+	if err := validateHostname(name); err != nil {
+		return err
+	}
+	return c.setBMC("hostname", "hostname", name)
+}
+
+// SetBMCTime sets the clock of the BMC to t. It returns ErrUnsupported if the firmware
+// can't set its clock.
+func (c *Client) SetBMCTime(t time.Time) error {
+	if t.IsZero() {
+		return fmt.Errorf("time is required")
+	}
+	return c.setBMC("time", "time", strconv.FormatInt(t.Unix(), 10))
+}
+
+// SetNTPServer sets the NTP server the BMC synchronizes its clock with. It returns
+// ErrUnsupported if the firmware has no NTP client or can't configure it.
+func (c *Client) SetNTPServer(server string) error {
+	if server == "" || strings.ContainsAny(server, " \t/") {
+		return fmt.Errorf("invalid NTP server: %q", server)
+	}
+	return c.setBMC("ntp", "server", server)
+}
+
+// validateHostname checks that name is a valid hostname: dot separated labels of letters,
+// digits and hyphens, that don't start or end with a hyphen
+func validateHostname(name string) error {
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("invalid hostname: %q", name)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid hostname: %q", name)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("invalid hostname: %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// setBMC sends a set request of the given type, with value as the key parameter
+func (c *Client) setBMC(requestType, key, value string) error {
+	req, err := c.newRequest()
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "set")
+	req.AddQueryParam("type", requestType)
+	req.AddQueryParam(key, value)
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return ErrUnsupported
+		}
+		if err := checkHTMLResponse(resp, body); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to set BMC %s: %w", requestType, &BMCError{StatusCode: resp.StatusCode, Message: string(body)})
+	}
+
+	if err := checkResponseError(resp); err != nil {
+		return fmt.Errorf("failed to set BMC %s: %w", requestType, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSetBMCSettings(t *testing.T) {
+	var sets []string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			query := r.URL.Query()
+			if query.Get("opt") != "set" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			sets = append(sets, r.URL.RawQuery)
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	if err := client.SetBMCHostname("turing-1"); err != nil {
+		t.Fatalf("SetBMCHostname failed: %v", err)
+	}
+	if err := client.SetBMCTime(time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("SetBMCTime failed: %v", err)
+	}
+	if err := client.SetNTPServer("pool.ntp.org"); err != nil {
+		t.Fatalf("SetNTPServer failed: %v", err)
+	}
+
+	expected := []string{
+		"hostname=turing-1&opt=set&type=hostname",
+		"opt=set&time=1700000000&type=time",
+		"opt=set&server=pool.ntp.org&type=ntp",
+	}
+	if len(sets) != len(expected) {
+		t.Fatalf("Expected %d set requests, got %v", len(expected), sets)
+	}
+	for i := range expected {
+		if sets[i] != expected[i] {
+			t.Errorf("Request %d: expected %q, got %q", i, expected[i], sets[i])
+		}
+	}
+}
+
+func TestSetBMCSettingsUnsupported(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		default:
+			http.Error(w, "Invalid `type` parameter", http.StatusBadRequest)
+		}
+	}))
+
+	calls := map[string]func() error{
+		"SetBMCHostname": func() error { return client.SetBMCHostname("turing") },
+		"SetBMCTime":     func() error { return client.SetBMCTime(time.Now()) },
+		"SetNTPServer":   func() error { return client.SetNTPServer("pool.ntp.org") },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: expected ErrUnsupported, got %v", name, err)
+		}
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	valid := []string{"turing", "turing-pi.local", "node1", "A"}
+	for _, name := range valid {
+		if err := validateHostname(name); err != nil {
+			t.Errorf("validateHostname(%q) failed: %v", name, err)
+		}
+	}
+
+	invalid := []string{"", "-turing", "turing-", "tur ing", "turing..local", "turing_pi"}
+	for _, name := range invalid {
+		if err := validateHostname(name); err == nil {
+			t.Errorf("validateHostname(%q) should have failed", name)
+		}
+	}
+}
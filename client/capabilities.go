@@ -0,0 +1,202 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Capabilities describes which operations the connected BMC firmware supports
+type Capabilities struct {
+	// FirmwareVersion is the daemon version reported by the BMC
+	FirmwareVersion string
+	// Api is the API version reported by the BMC
+	Api string
+
+	// UsbFlashMode reports whether nodes can be put in USB flash mode
+	UsbFlashMode bool
+	// UsbBmcRoute reports whether the USB bus can be routed to the BMC chip
+	UsbBmcRoute bool
+	// MsdMode reports whether nodes can expose their eMMC as a mass storage device
+	MsdMode bool
+	// FlashUpload reports whether images can be uploaded to flash a node
+	FlashUpload bool
+	// FirmwareUpgrade reports whether the BMC firmware can be upgraded remotely
+	FirmwareUpgrade bool
+	// Cooling reports whether fan speeds can be read and set
+	Cooling bool
+	// Sensors reports whether sensor readings can be read, the fan speeds of the cooling
+	// devices being the ones the BMC API exposes
+	Sensors bool
+	// BootConfig reports whether the boot configuration of a node can be changed
+	BootConfig bool
+	// UartConfig reports whether the node UART baud rate can be changed
+	UartConfig bool
+}
+
+// probeNode is a node index no BMC has, so a set request probed with it is refused by
+// firmware that knows the request, before changing anything
+const probeNode = "99"
+
+// Capabilities returns the operations supported by the connected BMC. Each one is probed
+// with a request of the endpoint it uses that changes nothing: firmware lacking the endpoint
+// answers it as an unknown request. The result is cached for the lifetime of the client.
+func (c *Client) Capabilities() (Capabilities, error) {
+	c.mu.Lock()
+	cached := c.capabilities
+	c.mu.Unlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	about, err := c.About()
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("failed to probe capabilities: %w", err)
+	}
+	caps := Capabilities{FirmwareVersion: about["version"], Api: about["api"]}
+
+	// USB flash mode and the BMC route are modes of the USB routing
+	if caps.UsbFlashMode, err = c.probeRequest("get", "usb", ""); err != nil {
+		return Capabilities{}, err
+	}
+	caps.UsbBmcRoute = caps.UsbFlashMode
+
+	// Uploads are followed by the flash progress
+	if caps.FlashUpload, err = c.probeRequest("get", "flash", ""); err != nil {
+		return Capabilities{}, err
+	}
+	if caps.MsdMode, err = c.probeRequest("set", "node_to_msd", probeNode); err != nil {
+		return Capabilities{}, err
+	}
+	if caps.BootConfig, err = c.probeRequest("set", "clear_usb_boot", probeNode); err != nil {
+		return Capabilities{}, err
+	}
+	if caps.FirmwareUpgrade, err = c.probeFirmwareUpgrade(); err != nil {
+		return Capabilities{}, err
+	}
+
+	devices, err := c.GetCoolingStatus()
+	switch {
+	case err == nil:
+		caps.Cooling = true
+		caps.Sensors = len(devices) > 0
+	case !errors.Is(err, ErrUnsupported):
+		return Capabilities{}, fmt.Errorf("failed to probe capabilities: %w", err)
+	}
+
+	if _, err := c.GetUartConfig(1); err == nil {
+		caps.UartConfig = true
+	} else if !errors.Is(err, ErrUnsupported) {
+		return Capabilities{}, fmt.Errorf("failed to probe capabilities: %w", err)
+	}
+
+	c.mu.Lock()
+	c.capabilities = &caps
+	c.mu.Unlock()
+
+	return caps, nil
+}
+
+// probeRequest reports whether the firmware knows the request of type typ. Any answer other
+// than an unknown request counts, a refusal of the node param included.
+func (c *Client) probeRequest(opt, typ, node string) (bool, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.AddQueryParam("opt", opt)
+	req.AddQueryParam("type", typ)
+	if node != "" {
+		req.AddQueryParam("node", node)
+	}
+
+	return sendProbe(req, typ)
+}
+
+// probeFirmwareUpgrade reports whether the firmware has the upload endpoint of upgrades,
+// asking it for a GET it refuses
+func (c *Client) probeFirmwareUpgrade() (bool, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.URL.Path = c.paths().Firmware()
+
+	return sendProbe(req, "firmware upgrade")
+}
+
+// sendProbe sends a probe request and reports whether its endpoint exists
+func sendProbe(req *Request, name string) (bool, error) {
+	resp, err := req.Send()
+	if err != nil {
+		return false, fmt.Errorf("failed to probe %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	// A page, such as the web UI answering unknown paths, means the endpoint is missing
+	body, _ := io.ReadAll(resp.Body)
+	if err := checkHTMLResponse(resp, body); err != nil {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return false, fmt.Errorf("failed to probe %s: %w", name, err)
+		}
+		return false, nil
+	}
+	return resp.StatusCode == http.StatusOK || !isUnsupportedResponse(resp.StatusCode, string(body)), nil
+}
+
+// parseFirmwareVersion parses versions such as "2.0.5", "v2.1" or "2.0.5-dev"
+func parseFirmwareVersion(version string) ([3]int, bool) {
+	var parsed [3]int
+
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+ "); i >= 0 {
+		version = version[:i]
+	}
+	if version == "" {
+		return parsed, false
+	}
+
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+
+	return parsed, true
+}
+
+// compareVersions returns -1, 0 or 1 when a is older, equal or newer than b
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"net/http"
+	"testing"
+)
+
+// newCapabilitiesHandler returns a mock BMC handler knowing the request types in known,
+// refusing the node no BMC has in set requests, and counting the requests it got
+func newCapabilitiesHandler(known map[string]string, firmwareUpgrade bool, requests *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/firmware":
+			*requests++
+			if !firmwareUpgrade {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		case "/api/bmc":
+			*requests++
+			query := r.URL.Query()
+			result, ok := known[query.Get("type")]
+			if !ok {
+				http.Error(w, "Invalid `type` parameter", http.StatusBadRequest)
+				return
+			}
+			if query.Get("node") == probeNode {
+				http.Error(w, "Parameter `node` is out of range", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":` + result + `}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestCapabilitiesProbed(t *testing.T) {
+	tests := []struct {
+		name            string
+		known           map[string]string
+		firmwareUpgrade bool
+		expected        Capabilities
+	}{
+		{
+			"old firmware",
+			map[string]string{
+				"about": `{"api":"1.0","version":"1.1.0"}`,
+				"usb":   `[{"node":"Node1","mode":"Host","route":"AlpineUsb"}]`,
+				"flash": `{}`,
+			},
+			false,
+			Capabilities{FirmwareVersion: "1.1.0", Api: "1.0", UsbFlashMode: true, UsbBmcRoute: true, FlashUpload: true},
+		},
+		{
+			"current firmware",
+			map[string]string{
+				"about":          `{"api":"1.1","version":"2.1.0"}`,
+				"usb":            `[{"node":"Node1","mode":"Host","route":"AlpineUsb"}]`,
+				"flash":          `{}`,
+				"node_to_msd":    `"ok"`,
+				"clear_usb_boot": `"ok"`,
+				"cooling":        `[{"device":"fan0","speed":1200,"max_speed":3000}]`,
+				"uart_config":    `{"baud":115200}`,
+			},
+			true,
+			Capabilities{FirmwareVersion: "2.1.0", Api: "1.1", UsbFlashMode: true, UsbBmcRoute: true, MsdMode: true,
+				FlashUpload: true, FirmwareUpgrade: true, Cooling: true, Sensors: true, BootConfig: true, UartConfig: true},
+		},
+	}
+
+	for _, tt := range tests {
+		var requests int
+		client, _ := newMockClient(t, newCapabilitiesHandler(tt.known, tt.firmwareUpgrade, &requests))
+
+		caps, err := client.Capabilities()
+		if err != nil {
+			t.Fatalf("%s: Capabilities failed: %v", tt.name, err)
+		}
+		if caps != tt.expected {
+			t.Errorf("%s: Capabilities() = %+v, expected %+v", tt.name, caps, tt.expected)
+		}
+	}
+}
+
+func TestCapabilitiesCached(t *testing.T) {
+	var requests int
+	client, _ := newMockClient(t, newCapabilitiesHandler(map[string]string{
+		"about": `{"api":"1.1","version":"2.0.5"}`,
+	}, false, &requests))
+
+	if _, err := client.Capabilities(); err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	probed := requests
+	if _, err := client.Capabilities(); err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	if requests != probed {
+		t.Errorf("Expected capabilities to be probed once, got %d more requests", requests-probed)
+	}
+}
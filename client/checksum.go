@@ -0,0 +1,84 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// HashFile returns the SHA256 of the file at path, in lowercase hex
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return hashReader(file)
+}
+
+// hashReader returns the SHA256 of everything r yields, in lowercase hex
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("failed to calculate SHA256: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SidecarChecksumPath returns the path of the checksum file published next to an image
+func SidecarChecksumPath(imagePath string) string {
+	return imagePath + ".sha256"
+}
+
+// ReadSidecarChecksum reads the expected SHA256 of an image from its <image>.sha256 file.
+// Both a bare hex digest and the coreutils "<hash>  <filename>" format are accepted.
+// An empty checksum and no error are returned when there is no sidecar file.
+func ReadSidecarChecksum(imagePath string) (string, error) {
+	path := SidecarChecksumPath(imagePath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read checksum file: %w", err)
+	}
+
+	return parseChecksumFile(data, path)
+}
+
+// parseChecksumFile reads the SHA256 in the contents of a checksum file from source
+func parseChecksumFile(data []byte, source string) (string, error) {
+	// sha256sum writes one "<hash>  <filename>" line per file, binary mode
+	// marks the filename with a leading '*'
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum file %s is empty", source)
+	}
+	checksum := strings.ToLower(fields[0])
+	if len(checksum) != 2*32 {
+		return "", fmt.Errorf("checksum file %s doesn't contain a SHA256 checksum", source)
+	}
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return "", fmt.Errorf("checksum file %s doesn't contain a SHA256 checksum", source)
+	}
+	return checksum, nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeImage writes an image and returns its path and SHA256
+func writeImage(t *testing.T, content string) (string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "image.img")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return path, hex.EncodeToString(sum[:])
+}
+
+func TestReadSidecarChecksum(t *testing.T) {
+	path, checksum := writeImage(t, "image content")
+
+	tests := []struct {
+		name    string
+		sidecar string
+	}{
+		{"bare hex", checksum + "\n"},
+		{"uppercase hex", strings.ToUpper(checksum)},
+		{"coreutils", checksum + "  image.img\n"},
+		{"coreutils binary mode", checksum + " *image.img\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(SidecarChecksumPath(path), []byte(tt.sidecar), 0600); err != nil {
+				t.Fatalf("Failed to write sidecar: %v", err)
+			}
+
+			got, err := ReadSidecarChecksum(path)
+			if err != nil {
+				t.Fatalf("ReadSidecarChecksum failed: %v", err)
+			}
+			if got != checksum {
+				t.Errorf("Expected checksum %s, got %s", checksum, got)
+			}
+		})
+	}
+}
+
+func TestReadSidecarChecksumMissing(t *testing.T) {
+	path, _ := writeImage(t, "image content")
+
+	got, err := ReadSidecarChecksum(path)
+	if err != nil {
+		t.Fatalf("Expected no error without a sidecar, got: %v", err)
+	}
+	if got != "" {
+		t.Errorf("Expected no checksum without a sidecar, got %s", got)
+	}
+}
+
+func TestReadSidecarChecksumInvalid(t *testing.T) {
+	path, _ := writeImage(t, "image content")
+	if err := os.WriteFile(SidecarChecksumPath(path), []byte("not-a-checksum  image.img\n"), 0600); err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+
+	if _, err := ReadSidecarChecksum(path); err == nil {
+		t.Error("Expected an error for a malformed sidecar")
+	}
+}
+
+func TestFlashNodeSidecarMismatch(t *testing.T) {
+	path, _ := writeImage(t, "image content")
+	_, other := writeImage(t, "other content")
+	if err := os.WriteFile(SidecarChecksumPath(path), []byte(other+"  image.img\n"), 0600); err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+
+	client, err := NewClient(WithHost("127.0.0.1:1"), WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The mismatch must be caught before anything is sent to the BMC
+	err = client.FlashNode(1, &FlashOptions{ImagePath: path})
+	if err == nil {
+		t.Fatal("Expected a checksum mismatch error, got nil")
+	}
+	if !strings.Contains(err.Error(), "checksum mismatch") || !strings.Contains(err.Error(), SidecarChecksumPath(path)) {
+		t.Errorf("Expected error to name the sidecar file, got: %v", err)
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	path, sum := writeImage(t, "image content")
+
+	hash, err := HashFile(path)
+	if err != nil {
+		t.Fatalf("HashFile failed: %v", err)
+	}
+	if hash != sum {
+		t.Errorf("Expected %s, got %s", sum, hash)
+	}
+
+	if _, err := HashFile(filepath.Join(t.TempDir(), "missing.img")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+// countingReadSeeker counts the bytes read from a ReadSeeker
+type countingReadSeeker struct {
+	io.ReadSeeker
+	read int64
+}
+
+func (r *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func TestInspectImageReadsOnce(t *testing.T) {
+	content := append(bytes.Repeat([]byte{0}, 3*zeroBlockSize), bytes.Repeat([]byte("x"), zeroBlockSize)...)
+	sum := sha256.Sum256(content)
+	r := &countingReadSeeker{ReadSeeker: bytes.NewReader(content)}
+
+	hash, stats, err := inspectImage(r, true, true)
+	if err != nil {
+		t.Fatalf("inspectImage failed: %v", err)
+	}
+
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected SHA256 %x, got %s", sum, hash)
+	}
+	if stats.ZeroBlocks != 3 || stats.Runs != 1 {
+		t.Errorf("Expected 3 zero blocks in 1 run, got %+v", stats)
+	}
+	if r.read != int64(len(content)) {
+		t.Errorf("Expected the image to be read once (%d bytes), read %d bytes", len(content), r.read)
+	}
+}
+
+func TestFlashNodeSendsCalculatedChecksum(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	path, sum := writeImage(t, "image content")
+
+	var sent string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			sent = query.Get("sha256")
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":1,"node3":1,"node4":1}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	// The checksum given in uppercase is verified and sent as calculated
+	err := client.FlashNode(1, &FlashOptions{ImagePath: path, SHA256: strings.ToUpper(sum), ProgressWriter: io.Discard})
+	if err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+	if sent != sum {
+		t.Errorf("Expected sha256=%s, got %q", sum, sent)
+	}
+}
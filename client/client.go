@@ -16,11 +16,13 @@
 package tpi
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -34,15 +36,44 @@ const (
 
 	// DefaultRetryWait is the default wait time between retries
 	DefaultRetryWait = 1 * time.Second
+
+	// DefaultUartLineDelay is the default delay between lines sent by SendUartStream
+	DefaultUartLineDelay = 100 * time.Millisecond
+
+	// DefaultMaxV1UploadSize is the largest file the client will upload when
+	// talking to the v1 API, which is known to fail on large uploads
+	DefaultMaxV1UploadSize int64 = 512 * 1024 * 1024
 )
 
 // Client is the main interface for interacting with a Turing Pi board
 type Client struct {
-	Host       string
-	ApiVersion ApiVersion
-	httpClient *http.Client
-	auth       *Auth
-	mu         sync.Mutex
+	Host               string
+	ApiVersion         ApiVersion
+	httpClient         *http.Client
+	auth               *Auth
+	maxV1UploadSize    int64
+	optionErr          error
+	eventHook          func(Event)
+	orderedParams      bool
+	capabilities       *Capabilities
+	uartLineDelay      time.Duration
+	credentialProvider CredentialProvider
+	basePath           string
+	pinnedCert         string
+	nodeHosts          map[int]string
+	sshDefaults        []SSHOption
+	strictNodes        bool
+	userAgent          string
+	headers            http.Header
+	retry              *RetryPolicy
+	verifyWrites       time.Duration
+	postWriteSettle    time.Duration
+	powerOnStagger     time.Duration
+	firmwareSignatures []FirmwareSignature
+	incompatibilities  []Incompatibility
+	stats              *statsCollector
+	noAuth             bool
+	mu                 sync.Mutex
 }
 
 // NewClient creates a new Turing Pi client with the provided options
@@ -51,14 +82,12 @@ func NewClient(options ...Option) (*Client, error) {
 	client := &Client{
 		ApiVersion: ApiVersionV1_1, // Default to v1-1
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true, // Skip cert verification
-				},
-			},
+			Timeout:   10 * time.Second,
+			Transport: newBMCTransport(""),
 		},
-		auth: &Auth{},
+		auth:            &Auth{},
+		maxV1UploadSize: DefaultMaxV1UploadSize,
+		uartLineDelay:   DefaultUartLineDelay,
 	}
 
 	// Apply options
@@ -66,6 +95,11 @@ func NewClient(options ...Option) (*Client, error) {
 		option(client)
 	}
 
+	// Report options that failed to apply
+	if client.optionErr != nil {
+		return nil, client.optionErr
+	}
+
 	// Validate client configuration
 	if client.Host == "" {
 		return nil, fmt.Errorf("host is required")
@@ -84,10 +118,15 @@ func WithHost(host string) Option {
 	}
 }
 
-// WithApiVersion sets the API version
+// WithApiVersion sets the API version, spellings such as "1.1" are normalized by ParseApiVersion
 func WithApiVersion(version ApiVersion) Option {
 	return func(c *Client) {
-		c.ApiVersion = version
+		parsed, err := ParseApiVersion(string(version))
+		if err != nil {
+			c.optionErr = err
+			return
+		}
+		c.ApiVersion = parsed
 	}
 }
 
@@ -99,6 +138,50 @@ func WithCredentials(username, password string) Option {
 	}
 }
 
+// WithPasswordFromFile reads the password for authentication from a file,
+// so it doesn't have to appear on the command line
+func WithPasswordFromFile(path string) Option {
+	return func(c *Client) {
+		password, err := ReadPasswordFile(path)
+		if err != nil {
+			c.optionErr = err
+			return
+		}
+		c.auth.Password = password
+	}
+}
+
+// WithPostWriteSettle makes power and USB changes wait d before returning, since the BMC
+// applies them asynchronously and a status read right after may still see the old state.
+// Unlike WithVerifyWrites, which polls the status until it matches, a settle adds the full
+// delay to every change but costs no extra requests. USB changes already confirmed by
+// WithVerifyWrites don't wait again. Disabled by default.
+func WithPostWriteSettle(d time.Duration) Option {
+	return func(c *Client) {
+		if d < 0 {
+			c.optionErr = fmt.Errorf("invalid post-write settle: %s", d)
+			return
+		}
+		c.postWriteSettle = d
+	}
+}
+
+// settle waits the delay set by WithPostWriteSettle, if any
+func (c *Client) settle() {
+	if c.postWriteSettle > 0 {
+		time.Sleep(c.postWriteSettle)
+	}
+}
+
+// WithNoAuth sends requests without a token, even a cached one, for BMCs that don't
+// require authentication, such as firmware in development mode. Credentials aren't
+// required, and the client only authenticates if the BMC answers 401.
+func WithNoAuth() Option {
+	return func(c *Client) {
+		c.noAuth = true
+	}
+}
+
 // WithTimeout sets the client timeout
 func WithTimeout(timeout time.Duration) Option {
 	return func(c *Client) {
@@ -106,11 +189,145 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithUartLineDelay sets the delay between lines sent by SendUartStream
+func WithUartLineDelay(delay time.Duration) Option {
+	return func(c *Client) {
+		c.uartLineDelay = delay
+	}
+}
+
+// WithVerifyWrites makes USB mode changes wait until the BMC reports the requested
+// state, for up to timeout, instead of returning as soon as the request is accepted.
+// The USB mux can take a moment to switch. Disabled by default.
+func WithVerifyWrites(timeout time.Duration) Option {
+	return func(c *Client) {
+		if timeout < 0 {
+			c.optionErr = fmt.Errorf("invalid verify timeout: %s", timeout)
+			return
+		}
+		c.verifyWrites = timeout
+	}
+}
+
+// WithBasePath sets the path of the BMC endpoint, "/api/bmc" by default, for BMCs
+// served behind a reverse proxy at a subpath (e.g. "/board1/api/bmc").
+// Authentication, upload and firmware URLs are derived from it.
+func WithBasePath(path string) Option {
+	return func(c *Client) {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			c.optionErr = fmt.Errorf("base path must not be empty")
+			return
+		}
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		c.basePath = path
+	}
+}
+
+// WithUserAgent identifies the application in the BMC logs. The library version is
+// appended to ua, so the User-Agent reads "<ua> TPI/<version> (<os>;<go version>)".
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		ua = strings.TrimSpace(ua)
+		if ua == "" {
+			c.optionErr = fmt.Errorf("user agent must not be empty")
+			return
+		}
+		c.userAgent = customUserAgent(ua)
+	}
+}
+
+// reservedHeaders are controlled by the library and can't be set with WithHeader
+var reservedHeaders = map[string]bool{
+	"Authorization":  true,
+	"Content-Type":   true,
+	"Content-Length": true,
+	"Host":           true,
+	"User-Agent":     true,
+}
+
+// WithHeader adds a header to every request sent to the BMC, including authentication
+// and uploads, e.g. for an auth gateway in front of it. It can be repeated, values of
+// the same key add up. Headers the library controls are refused, use WithUserAgent
+// for the User-Agent.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+		if key == "" {
+			c.optionErr = fmt.Errorf("header name must not be empty")
+			return
+		}
+		if reservedHeaders[key] {
+			c.optionErr = fmt.Errorf("header %s is set by the client and can't be overridden", key)
+			return
+		}
+		if c.headers == nil {
+			c.headers = make(http.Header)
+		}
+		c.headers.Add(key, value)
+	}
+}
+
+// addExtraHeaders adds the headers given with WithHeader to h
+func addExtraHeaders(h, extra http.Header) {
+	for key, values := range extra {
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+}
+
+// userAgentHeader returns the User-Agent sent to the BMC
+func (c *Client) userAgentHeader() string {
+	if c.userAgent != "" {
+		return c.userAgent
+	}
+	return defaultUserAgent()
+}
+
+// paths returns the endpoint paths of the client's BMC
+func (c *Client) paths() Paths {
+	return c.ApiVersion.Paths(c.basePath)
+}
+
+// WithOrderedParams sends query parameters in the order they were added
+// (opt, type, node, ...) instead of sorted by key, for firmware that is
+// sensitive to parameter order
+func WithOrderedParams() Option {
+	return func(c *Client) {
+		c.orderedParams = true
+	}
+}
+
+// WithMaxV1UploadSize sets the largest file size accepted for uploads over the v1 API.
+// A size of zero or less disables the guard.
+func WithMaxV1UploadSize(size int64) Option {
+	return func(c *Client) {
+		c.maxV1UploadSize = size
+	}
+}
+
+// checkUploadSize returns an error if the file is too large to be uploaded over the configured API version
+func (c *Client) checkUploadSize(size int64) error {
+	if c.ApiVersion != ApiVersionV1 || c.maxV1UploadSize <= 0 {
+		return nil
+	}
+
+	if size > c.maxV1UploadSize {
+		return fmt.Errorf("file size %s exceeds the v1 API upload limit of %s: large uploads will very likely fail on v1, use API version %s instead",
+			formatBytes(size), formatBytes(c.maxV1UploadSize), ApiVersionV1_1)
+	}
+
+	return nil
+}
+
 // newRequest creates a new HTTP request
 func (c *Client) newRequest() (*Request, error) {
 	// Check if we have a cached token for this host
 	hasCachedToken := false
-	if c.Host != "" {
+	if c.Host != "" && !c.noAuth {
 		_, err := GetCachedToken(c.Host)
 		if err == nil {
 			hasCachedToken = true
@@ -118,9 +335,12 @@ func (c *Client) newRequest() (*Request, error) {
 		}
 	}
 
-	// Only require explicit credentials if we don't have a cached token
-	if !hasCachedToken && (c.auth == nil || !c.auth.HasCredentials()) {
-		return nil, fmt.Errorf("no credentials provided")
+	// Only require explicit credentials if we don't have a cached token, a provider,
+	// or credentials in the environment or netrc
+	if !c.noAuth && !hasCachedToken && c.credentialProvider == nil && (c.auth == nil || !c.auth.HasCredentials()) {
+		if _, _, err := (ChainCredentials{EnvCredentials{}, NetrcCredentials{}}).Credentials(c.Host); err != nil {
+			return nil, fmt.Errorf("no credentials provided")
+		}
 	}
 
 	// Create a new request
@@ -128,6 +348,19 @@ func (c *Client) newRequest() (*Request, error) {
 	if err != nil {
 		return nil, err
 	}
+	req.PreserveOrder = c.orderedParams
+	req.CredentialProvider = c.credentialProvider
+	if c.basePath != "" {
+		req.BasePath = c.basePath
+		req.URL.Path = c.basePath
+	}
+	req.PinnedCertSHA256 = c.pinnedCert
+	req.ExtraHeaders = c.headers
+	req.NoAuth = c.noAuth
+	req.stats = c.stats
+	if c.userAgent != "" {
+		req.SetUserAgent(c.userAgent)
+	}
 
 	return req, nil
 }
@@ -136,19 +369,26 @@ func (c *Client) newRequest() (*Request, error) {
 func checkResponseError(resp *http.Response) error {
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		if err := checkHTMLResponse(resp, body); err != nil {
+			return err
+		}
+		return &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	// Try to decode response to check for error field
 	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		// If we can't decode the response, assume it's not an error
+	if err := decodeJSONResponse(resp, &result); err != nil {
+		// An HTML page is an error, anything else that can't be decoded is assumed not to be
+		var htmlErr *HTMLResponseError
+		if errors.As(err, &htmlErr) {
+			return err
+		}
 		return nil
 	}
 
 	// Check if there's an error in the response
 	if errMsg, ok := result["error"].(string); ok && errMsg != "" {
-		return fmt.Errorf("server returned error: %s", errMsg)
+		return &BMCError{StatusCode: resp.StatusCode, Message: errMsg}
 	}
 
 	return nil
@@ -178,12 +418,11 @@ func (c *Client) Info() (map[string]string, error) {
 		return nil, fmt.Errorf("failed to extract result: %w", err)
 	}
 
-	// Convert the result to a map[string]string
+	// Convert the result to a map[string]string, some firmware reports numbers
+	// such as the uptime as JSON numbers
 	info := make(map[string]string)
 	for key, value := range result {
-		if strVal, ok := value.(string); ok {
-			info[key] = strVal
-		}
+		info[key] = infoValueString(value)
 	}
 
 	return info, nil
@@ -242,61 +481,95 @@ func (c *Client) Reboot() error {
 		return fmt.Errorf("reboot failed: %w", err)
 	}
 
+	c.emit(EventReboot, 0, "rebooting")
 	return nil
 }
 
+// rebootSettleWait is how long RebootAndWait waits before checking the BMC, a variable
+// so tests can shorten it
+var rebootSettleWait = 5 * time.Second
+
+// RebootResult reports how a wait for the BMC to come back after a reboot went
+type RebootResult struct {
+	// Elapsed is the time since the reboot was requested
+	Elapsed time.Duration
+	// Attempts is the number of checks of the BMC
+	Attempts int
+}
+
 // RebootAndWait reboots the BMC and waits for it to come back online.
-// It uses exponential backoff when checking the BMC status.
+// It backs off between checks of the BMC status following the retry policy,
+// DefaultRebootRetryPolicy unless WithRetryPolicy is given.
 // The timeout is in seconds.
 func (c *Client) RebootAndWait(timeout int) error {
+	_, err := c.rebootAndWait(context.Background(), time.Duration(timeout)*time.Second, os.Stdout)
+	return err
+}
+
+// RebootAndWaitContext is RebootAndWait with a context and no progress output. It
+// returns promptly with the context's error once ctx is done, a timeout of 0 leaves
+// the deadline to ctx. The result is set even on errors, with the progress so far.
+func (c *Client) RebootAndWaitContext(ctx context.Context, timeout time.Duration) (*RebootResult, error) {
+	return c.rebootAndWait(ctx, timeout, nil)
+}
+
+// rebootAndWait reboots the BMC and checks it until it answers, printing a dot per
+// second of waiting to progress if set
+func (c *Client) rebootAndWait(ctx context.Context, timeout time.Duration, progress io.Writer) (*RebootResult, error) {
+	result := &RebootResult{}
+	startTime := time.Now()
+	defer func() { result.Elapsed = time.Since(startTime) }()
+
 	// First reboot the BMC
 	if err := c.Reboot(); err != nil {
-		return err
+		return result, err
 	}
 
-	// Wait a bit before starting to check
-	time.Sleep(5 * time.Second)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	// Start time
-	startTime := time.Now()
-	timeoutDuration := time.Duration(timeout) * time.Second
+	// Wait a bit before starting to check
+	select {
+	case <-ctx.Done():
+		return result, rebootWaitError(ctx.Err(), timeout)
+	case <-time.After(rebootSettleWait):
+	}
 
-	// Retry interval starts at 1 second, will gradually increase
-	retryInterval := time.Second
+	// Retry interval grows with every failed check
+	retry := newBackoff(c.retryPolicy(DefaultRebootRetryPolicy))
 
 	// Setup progress indicator
-	progressChar := "."
-	attempts := 0
 	lastProgressUpdate := time.Now()
 	progressInterval := 1 * time.Second
 
 	for {
-		// Check if we've exceeded the timeout
-		if time.Since(startTime) > timeoutDuration {
-			return fmt.Errorf("timeout reached: BMC did not respond within %d seconds", timeout)
-		}
-
 		// Print progress indicator at regular intervals
-		if time.Since(lastProgressUpdate) >= progressInterval {
-			fmt.Print(progressChar)
+		if progress != nil && time.Since(lastProgressUpdate) >= progressInterval {
+			fmt.Fprint(progress, ".")
 			lastProgressUpdate = time.Now()
 		}
 
 		// Try to connect to the BMC
-		_, err := c.Info()
-		if err == nil {
-			return nil // BMC is back online
+		result.Attempts++
+		if _, err := c.ping(ctx); err == nil {
+			return result, nil // BMC is back online
 		}
 
-		// Exponential backoff with a maximum of 5 seconds
-		retryInterval = time.Duration(float64(retryInterval) * 1.5)
-		if retryInterval > 5*time.Second {
-			retryInterval = 5 * time.Second
+		if err := retry.Sleep(ctx); err != nil {
+			return result, rebootWaitError(err, timeout)
 		}
+	}
+}
 
-		attempts++
-		time.Sleep(retryInterval)
+// rebootWaitError describes the context error that ended a wait for the BMC
+func rebootWaitError(err error, timeout time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) && timeout > 0 {
+		return &TimeoutError{Timeout: timeout, Err: fmt.Errorf("BMC did not come back after reboot: %w", err)}
 	}
+	return fmt.Errorf("waiting for the BMC to come back: %w", err)
 }
 
 // About returns detailed information about the BMC daemon
@@ -317,29 +590,53 @@ func (c *Client) About() (map[string]string, error) {
 	}
 	defer resp.Body.Close()
 
-	// Handle the specific response format for the about endpoint
-	var responseData struct {
-		Response []struct {
-			Result map[string]string `json:"result"`
-		} `json:"response"`
-	}
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
 	// Parse the JSON
-	if err := json.Unmarshal(body, &responseData); err != nil {
+	var result map[string]interface{}
+	found, err := decodeResult(resp, &result)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
 	// Check if we have valid data
-	if len(responseData.Response) == 0 || responseData.Response[0].Result == nil {
+	if !found || result == nil {
 		return nil, fmt.Errorf("invalid response format")
 	}
 
-	// Return the result map
-	return responseData.Response[0].Result, nil
+	// Return the result map, with non-string values in their string form
+	about := make(map[string]string)
+	for key, value := range result {
+		about[key] = infoValueString(value)
+	}
+	return about, nil
+}
+
+// Ping checks that the BMC is reachable and accepts the client's credentials
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.ping(ctx)
+	return err
+}
+
+// ping sends a lightweight request to the BMC, returning the response status
+// alongside the error so callers can tell a BMC that answers from one that doesn't
+func (c *Client) ping(ctx context.Context) (int, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Context = ctx
+
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "other")
+
+	resp, err := req.Send()
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("BMC answered with status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
 }
@@ -15,6 +15,10 @@
 package tpi
 
 import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -121,3 +125,199 @@ func TestDefaultApiVersion(t *testing.T) {
 		t.Errorf("Expected default API version to be %s, got %s", ApiVersionV1_1, client.ApiVersion)
 	}
 }
+
+// queryRecorder returns a mock BMC handler that records the raw query of the last /api/bmc call
+func queryRecorder(rawQuery *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			*rawQuery = r.URL.RawQuery
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestWithOrderedParams(t *testing.T) {
+	var rawQuery string
+	client, _ := newMockClient(t, queryRecorder(&rawQuery), WithOrderedParams())
+
+	if err := client.PowerReset(2); err != nil {
+		t.Fatalf("PowerReset failed: %v", err)
+	}
+
+	expected := "opt=set&type=reset&node=1"
+	if rawQuery != expected {
+		t.Errorf("Expected query %q, got %q", expected, rawQuery)
+	}
+}
+
+func TestDefaultParamsSorted(t *testing.T) {
+	var rawQuery string
+	client, _ := newMockClient(t, queryRecorder(&rawQuery))
+
+	if err := client.PowerReset(2); err != nil {
+		t.Fatalf("PowerReset failed: %v", err)
+	}
+
+	expected := "node=1&opt=set&type=reset"
+	if rawQuery != expected {
+		t.Errorf("Expected query %q, got %q", expected, rawQuery)
+	}
+}
+
+// userAgentRecorder returns a mock BMC recording the User-Agent of every request by path
+func userAgentRecorder(t *testing.T, options ...Option) (*Client, func() map[string][]string) {
+	var mu sync.Mutex
+	agents := make(map[string][]string)
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.URL.Path] = append(agents[r.URL.Path], r.Header.Get("User-Agent"))
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			w.Write([]byte(`{"response":[{"result":[{"api":"1.1"}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), options...)
+
+	return client, func() map[string][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return agents
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	client, agents := userAgentRecorder(t, WithUserAgent("provisioner/1.2"))
+
+	// Authentication, API requests and the custom transport all identify the application
+	if err := client.Login(); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := client.Info(); err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	resp, err := (&http.Client{Transport: client.Transport()}).Get("https://" + client.Host + "/api/bmc?opt=get&type=other")
+	if err != nil {
+		t.Fatalf("Custom request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	got := agents()
+	if len(got["/api/bmc/authenticate"]) == 0 || len(got["/api/bmc"]) != 2 {
+		t.Fatalf("Expected authentication and two API requests, got %v", got)
+	}
+	for path, values := range got {
+		for _, ua := range values {
+			if !strings.HasPrefix(ua, "provisioner/1.2 TPI/") {
+				t.Errorf("Expected %s to get the custom User-Agent with the TPI version, got %q", path, ua)
+			}
+		}
+	}
+}
+
+func TestDefaultUserAgent(t *testing.T) {
+	client, agents := userAgentRecorder(t)
+
+	if _, err := client.Info(); err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+
+	for path, values := range agents() {
+		for _, ua := range values {
+			if ua != defaultUserAgent() || !strings.HasPrefix(ua, "TPI (") {
+				t.Errorf("Expected %s to get the default User-Agent, got %q", path, ua)
+			}
+		}
+	}
+
+	if _, err := NewClient(WithHost("127.0.0.1"), WithUserAgent(" ")); err == nil {
+		t.Error("Expected an error for an empty User-Agent")
+	}
+}
+
+func TestWithHeader(t *testing.T) {
+	var mu sync.Mutex
+	headers := make(map[string][]http.Header)
+
+	client, server := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers[r.URL.Path] = append(headers[r.URL.Path], r.Header.Clone())
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc", "/api/bmc/upload/7":
+			w.Write([]byte(`{"response":[{"result":[{"api":"1.1"}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), WithHeader("CF-Access-Token", "gateway-token"), WithHeader("X-Trace", "a"), WithHeader("X-Trace", "b"))
+
+	// Authentication, API requests, uploads and the custom transport all carry the headers
+	if err := client.Login(); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := client.Info(); err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+
+	upload, err := client.newRequest()
+	if err != nil {
+		t.Fatalf("Failed to create upload request: %v", err)
+	}
+	upload.URL, _ = url.Parse(server.URL + "/api/bmc/upload/7")
+	upload.Method = http.MethodPost
+	upload.Body = strings.NewReader("image")
+	upload.ContentType = "application/octet-stream"
+	resp, err := upload.Send()
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = (&http.Client{Transport: client.Transport()}).Get(server.URL + "/api/bmc?opt=get&type=other")
+	if err != nil {
+		t.Fatalf("Custom request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range []string{"/api/bmc/authenticate", "/api/bmc", "/api/bmc/upload/7"} {
+		if len(headers[path]) == 0 {
+			t.Fatalf("Expected a request to %s, got %v", path, headers)
+		}
+		for _, h := range headers[path] {
+			if got := h.Get("CF-Access-Token"); got != "gateway-token" {
+				t.Errorf("Expected %s to get CF-Access-Token, got %q", path, got)
+			}
+			if got := h.Values("X-Trace"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+				t.Errorf("Expected %s to get both X-Trace values, got %v", path, got)
+			}
+		}
+	}
+
+	// The library keeps control of its own headers
+	if got := headers["/api/bmc/authenticate"][0].Get("Content-Type"); got != "application/json" {
+		t.Errorf("Expected authentication to be sent as JSON, got %q", got)
+	}
+	if got := headers["/api/bmc/upload/7"][0].Get("Authorization"); got != "Bearer mock-token" {
+		t.Errorf("Expected the upload to carry the bearer token, got %q", got)
+	}
+	for _, reserved := range []string{"Authorization", "content-type", " "} {
+		if _, err := NewClient(WithHost("127.0.0.1"), WithHeader(reserved, "x")); err == nil {
+			t.Errorf("Expected WithHeader(%q) to be refused", reserved)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// CoolingDevice is a fan or other cooling device of the board
+type CoolingDevice struct {
+	Device   string `json:"device"`
+	Speed    int    `json:"speed"`
+	MaxSpeed int    `json:"max_speed"`
+}
+
+// CoolingPreset is a named speed level applied to every cooling device
+type CoolingPreset string
+
+const (
+	CoolingQuiet    CoolingPreset = "quiet"    // A quarter of the maximum speed
+	CoolingBalanced CoolingPreset = "balanced" // Half of the maximum speed
+	CoolingMax      CoolingPreset = "max"      // The maximum speed
+)
+
+// GetCoolingStatus returns the cooling devices of the board and their speeds.
+// It returns ErrUnsupported if the firmware doesn't expose cooling devices.
+func (c *Client) GetCoolingStatus() ([]CoolingDevice, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "cooling")
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return nil, ErrUnsupported
+		}
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	devices := []CoolingDevice{}
+	if _, err := decodeResult(resp, &devices); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return devices, nil
+}
+
+// SetCoolingSpeed sets the speed of a cooling device, between 0 and its MaxSpeed.
+// It returns ErrUnsupported if the firmware can't set cooling devices.
+func (c *Client) SetCoolingSpeed(device string, speed int) error {
+	if device == "" {
+		return fmt.Errorf("cooling device is required")
+	}
+	if speed < 0 {
+		return fmt.Errorf("invalid cooling speed: %d", speed)
+	}
+
+	return c.setCooling(map[string]string{
+		"device": device,
+		"speed":  strconv.Itoa(speed),
+	})
+}
+
+// SetCoolingPreset sets every cooling device to the speed of preset, relative to its MaxSpeed.
+func (c *Client) SetCoolingPreset(preset CoolingPreset) error {
+	if _, err := coolingPresetSpeed(preset, 1); err != nil {
+		return err
+	}
+
+	devices, err := c.GetCoolingStatus()
+	if err != nil {
+		return fmt.Errorf("failed to get cooling devices: %w", err)
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("no cooling devices reported by the BMC")
+	}
+
+	var errs []error
+	for _, device := range devices {
+		speed, err := coolingPresetSpeed(preset, device.MaxSpeed)
+		if err == nil {
+			err = c.SetCoolingSpeed(device.Device, speed)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", device.Device, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// coolingPresetSpeed returns the speed of preset for a device running up to maxSpeed
+func coolingPresetSpeed(preset CoolingPreset, maxSpeed int) (int, error) {
+	if maxSpeed <= 0 {
+		return 0, fmt.Errorf("unknown maximum speed")
+	}
+
+	switch preset {
+	case CoolingQuiet:
+		return (maxSpeed + 3) / 4, nil
+	case CoolingBalanced:
+		return (maxSpeed + 1) / 2, nil
+	case CoolingMax:
+		return maxSpeed, nil
+	default:
+		return 0, fmt.Errorf("invalid cooling preset: %q (must be quiet, balanced or max)", preset)
+	}
+}
+
+// setCooling sends a cooling set request with params
+func (c *Client) setCooling(params map[string]string) error {
+	req, err := c.newRequest()
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "set")
+	req.AddQueryParam("type", "cooling")
+	for key, value := range params {
+		req.AddQueryParam(key, value)
+	}
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return ErrUnsupported
+		}
+		if err := checkHTMLResponse(resp, body); err != nil {
+			return err
+		}
+		return fmt.Errorf("cooling configuration failed: %w", &BMCError{StatusCode: resp.StatusCode, Message: string(body)})
+	}
+
+	if err := checkResponseError(resp); err != nil {
+		return fmt.Errorf("cooling configuration failed: %w", err)
+	}
+	return nil
+}
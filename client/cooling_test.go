@@ -0,0 +1,113 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// newCoolingRecorder returns a client for a mock BMC with two fans, and a func returning the
+// device=speed pairs set so far. Speeds above the maximum of a fan are refused.
+func newCoolingRecorder(t *testing.T) (*Client, func() []string) {
+	var mu sync.Mutex
+	var sets []string
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			query := r.URL.Query()
+			if query.Get("opt") == "get" {
+				w.Write([]byte(`{"response":[{"result":[
+					{"device":"fan0","speed":2,"max_speed":10},
+					{"device":"fan1","speed":100,"max_speed":255}
+				]}]}`))
+				return
+			}
+			speed, _ := strconv.Atoi(query.Get("speed"))
+			if speed > map[string]int{"fan0": 10, "fan1": 255}[query.Get("device")] {
+				http.Error(w, "Speed out of range", http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			sets = append(sets, query.Get("device")+"="+query.Get("speed"))
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		sorted := append([]string(nil), sets...)
+		sort.Strings(sorted)
+		return sorted
+	}
+}
+
+func TestSetCoolingPreset(t *testing.T) {
+	tests := []struct {
+		preset   CoolingPreset
+		expected []string
+	}{
+		{CoolingQuiet, []string{"fan0=3", "fan1=64"}},
+		{CoolingBalanced, []string{"fan0=5", "fan1=128"}},
+		{CoolingMax, []string{"fan0=10", "fan1=255"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.preset), func(t *testing.T) {
+			client, sets := newCoolingRecorder(t)
+			if err := client.SetCoolingPreset(tt.preset); err != nil {
+				t.Fatalf("SetCoolingPreset failed: %v", err)
+			}
+			if got := sets(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSetCoolingSpeedRefused(t *testing.T) {
+	// A refused speed is the BMC's error, not missing support
+	client, sets := newCoolingRecorder(t)
+	err := client.SetCoolingSpeed("fan0", 11)
+	var bmcErr *BMCError
+	if !errors.As(err, &bmcErr) || errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected the BMC's error, got: %v", err)
+	}
+	if len(sets()) != 0 {
+		t.Errorf("Expected no speed to be set, got %v", sets())
+	}
+}
+
+func TestSetCoolingPresetInvalid(t *testing.T) {
+	client, sets := newCoolingRecorder(t)
+	if err := client.SetCoolingPreset("turbo"); err == nil {
+		t.Error("Expected an error for an unknown preset")
+	}
+	if len(sets()) != 0 {
+		t.Errorf("Expected no speed to be set, got %v", sets())
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoCredentials is returned by a CredentialProvider that has no credentials for a host
+var ErrNoCredentials = errors.New("no credentials available")
+
+// CredentialProvider supplies the BMC credentials for a host.
+// Implement it to fetch credentials from a secrets manager.
+type CredentialProvider interface {
+	Credentials(host string) (user, pass string, err error)
+}
+
+// CandidateProvider is implemented by providers that offer several credentials
+// to try in order, such as DefaultCredentials
+type CandidateProvider interface {
+	CredentialProvider
+	CandidateCredentials(host string) ([]Credential, error)
+}
+
+// Credential is a username and password pair
+type Credential struct {
+	Username string
+	Password string
+}
+
+// WithCredentialProvider sets the provider used when no explicit credentials are given
+func WithCredentialProvider(provider CredentialProvider) Option {
+	return func(c *Client) {
+		c.credentialProvider = provider
+	}
+}
+
+// StaticCredentials provides the same credentials for every host
+type StaticCredentials struct {
+	Username string
+	Password string
+}
+
+// Credentials implements CredentialProvider
+func (s StaticCredentials) Credentials(host string) (string, string, error) {
+	if s.Username == "" {
+		return "", "", ErrNoCredentials
+	}
+	return s.Username, s.Password, nil
+}
+
+// EnvCredentials reads credentials from environment variables,
+// TPI_USERNAME and TPI_PASSWORD unless other names are given
+type EnvCredentials struct {
+	UsernameVar string
+	PasswordVar string
+}
+
+// Credentials implements CredentialProvider
+func (e EnvCredentials) Credentials(host string) (string, string, error) {
+	userVar, passVar := e.UsernameVar, e.PasswordVar
+	if userVar == "" {
+		userVar = "TPI_USERNAME"
+	}
+	if passVar == "" {
+		passVar = "TPI_PASSWORD"
+	}
+
+	user := os.Getenv(userVar)
+	if user == "" {
+		return "", "", ErrNoCredentials
+	}
+	return user, os.Getenv(passVar), nil
+}
+
+// NetrcCredentials reads credentials from a netrc file: Path if set, otherwise the
+// file named by the NETRC environment variable, otherwise ~/.netrc.
+// The machine name is matched against the host with and without its port.
+type NetrcCredentials struct {
+	Path string
+}
+
+// Credentials implements CredentialProvider
+func (n NetrcCredentials) Credentials(host string) (string, string, error) {
+	path := n.Path
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", ErrNoCredentials
+		}
+		return "", "", fmt.Errorf("failed to open netrc file: %w", err)
+	}
+	defer file.Close()
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	// Split the file into whitespace separated tokens
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, strings.Fields(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("failed to read netrc file: %w", err)
+	}
+
+	// Only the first matching machine entry and the default entry are used
+	var match, fallback, current *Credential
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			current = nil
+			if i+1 < len(tokens) {
+				i++
+				if match == nil && (tokens[i] == host || tokens[i] == hostname) {
+					match = &Credential{}
+					current = match
+				}
+			}
+		case "default":
+			current = nil
+			if fallback == nil {
+				fallback = &Credential{}
+				current = fallback
+			}
+		case "login", "password":
+			if i+1 >= len(tokens) {
+				continue
+			}
+			i++
+			if current == nil {
+				continue
+			}
+			if tokens[i-1] == "login" {
+				current.Username = tokens[i]
+			} else {
+				current.Password = tokens[i]
+			}
+		}
+	}
+
+	if match != nil && match.Username != "" {
+		return match.Username, match.Password, nil
+	}
+	if fallback != nil && fallback.Username != "" {
+		return fallback.Username, fallback.Password, nil
+	}
+	return "", "", ErrNoCredentials
+}
+
+// ChainCredentials tries each provider in order, skipping the ones without credentials
+type ChainCredentials []CredentialProvider
+
+// Credentials implements CredentialProvider, returning the first available credentials
+func (c ChainCredentials) Credentials(host string) (string, string, error) {
+	for _, provider := range c {
+		user, pass, err := provider.Credentials(host)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return user, pass, err
+	}
+	return "", "", ErrNoCredentials
+}
+
+// CandidateCredentials implements CandidateProvider, returning the credentials of every provider in order
+func (c ChainCredentials) CandidateCredentials(host string) ([]Credential, error) {
+	var all []Credential
+	for _, provider := range c {
+		candidates, err := candidateCredentials(provider, host)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, candidates...)
+	}
+
+	if len(all) == 0 {
+		return nil, ErrNoCredentials
+	}
+	return all, nil
+}
+
+// DefaultCredentialProvider is used when no credentials or provider are configured:
+// the TPI_USERNAME/TPI_PASSWORD environment variables, then netrc, then the common defaults
+func DefaultCredentialProvider() CredentialProvider {
+	return ChainCredentials{EnvCredentials{}, NetrcCredentials{}, DefaultCredentials{}}
+}
+
+// DefaultCredentials tries the factory defaults of the Turing Pi and other common defaults
+type DefaultCredentials struct{}
+
+// defaultCredentialPairs are the credentials tried by DefaultCredentials, in order
+var defaultCredentialPairs = []Credential{
+	{"root", ""},             // Empty password
+	{"root", "turing"},       // Default Turing Pi password
+	{"root", "root"},         // Common default
+	{"admin", "admin"},       // Common default
+	{"turingpi", "turingpi"}, // Product-specific
+}
+
+// Credentials implements CredentialProvider, returning the Turing Pi factory default
+func (DefaultCredentials) Credentials(host string) (string, string, error) {
+	return "root", "turing", nil
+}
+
+// CandidateCredentials implements CandidateProvider
+func (DefaultCredentials) CandidateCredentials(host string) ([]Credential, error) {
+	return append([]Credential(nil), defaultCredentialPairs...), nil
+}
+
+// candidateCredentials returns the credentials to try for host with provider
+func candidateCredentials(provider CredentialProvider, host string) ([]Credential, error) {
+	if candidates, ok := provider.(CandidateProvider); ok {
+		return candidates.CandidateCredentials(host)
+	}
+
+	user, pass, err := provider.Credentials(host)
+	if err != nil {
+		return nil, err
+	}
+	return []Credential{{Username: user, Password: pass}}, nil
+}
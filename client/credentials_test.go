@@ -0,0 +1,182 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// hostCredentials is a provider returning per-host credentials
+type hostCredentials map[string]Credential
+
+func (h hostCredentials) Credentials(host string) (string, string, error) {
+	creds, ok := h[host]
+	if !ok {
+		return "", "", ErrNoCredentials
+	}
+	return creds.Username, creds.Password, nil
+}
+
+// newAuthServer starts a mock BMC that only accepts the given credentials
+func newAuthServer(t *testing.T, want Credential) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["username"] != want.Username || body["password"] != want.Password {
+				http.Error(w, "invalid credentials", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"id":"token-` + want.Username + `"}`))
+		case "/api/bmc":
+			if r.Header.Get("Authorization") != "Bearer token-"+want.Username {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	host := server.Listener.Addr().String()
+	t.Cleanup(func() { DeleteCachedToken(host) })
+
+	return server
+}
+
+func TestCredentialProviderPerHost(t *testing.T) {
+	first := newAuthServer(t, Credential{"alice", "secret-1"})
+	second := newAuthServer(t, Credential{"bob", "secret-2"})
+
+	provider := hostCredentials{
+		first.Listener.Addr().String():  {"alice", "secret-1"},
+		second.Listener.Addr().String(): {"bob", "secret-2"},
+	}
+
+	for _, server := range []*httptest.Server{first, second} {
+		client, err := NewClient(
+			WithHost(server.Listener.Addr().String()),
+			WithCredentialProvider(provider),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+
+		if err := client.PowerOn(1); err != nil {
+			t.Errorf("PowerOn on %s failed: %v", server.Listener.Addr(), err)
+		}
+	}
+}
+
+func TestCredentialProviderUnknownHost(t *testing.T) {
+	server := newAuthServer(t, Credential{"alice", "secret-1"})
+
+	client, err := NewClient(
+		WithHost(server.Listener.Addr().String()),
+		WithCredentialProvider(hostCredentials{}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if err := client.PowerOn(1); err == nil {
+		t.Errorf("Expected PowerOn to fail without credentials for the host")
+	}
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	content := `# Turing Pi boards
+machine 192.168.1.91 login root password turing
+machine tp2.local:8443
+  login admin
+  password hunter2
+default login guest password guest
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write netrc: %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want Credential
+	}{
+		{"192.168.1.91", Credential{"root", "turing"}},
+		{"192.168.1.91:443", Credential{"root", "turing"}},
+		{"tp2.local:8443", Credential{"admin", "hunter2"}},
+		{"10.0.0.1", Credential{"guest", "guest"}},
+	}
+
+	provider := NetrcCredentials{Path: path}
+	for _, tt := range tests {
+		user, pass, err := provider.Credentials(tt.host)
+		if err != nil {
+			t.Errorf("Credentials(%s) failed: %v", tt.host, err)
+			continue
+		}
+		if user != tt.want.Username || pass != tt.want.Password {
+			t.Errorf("Credentials(%s) = %s/%s, expected %s/%s", tt.host, user, pass, tt.want.Username, tt.want.Password)
+		}
+	}
+}
+
+func TestNetrcEnvOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom-netrc")
+	content := "machine tp-a login alice password one\nmachine tp-b login bob password two\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write netrc: %v", err)
+	}
+	t.Setenv("NETRC", path)
+	t.Setenv("TPI_USERNAME", "")
+
+	user, pass, err := NetrcCredentials{}.Credentials("tp-b")
+	if err != nil || user != "bob" || pass != "two" {
+		t.Errorf("Expected bob/two from $NETRC, got %s/%s (%v)", user, pass, err)
+	}
+
+	if _, _, err := (NetrcCredentials{}).Credentials("tp-c"); err != ErrNoCredentials {
+		t.Errorf("Expected ErrNoCredentials for an unknown machine, got %v", err)
+	}
+
+	// The default provider tries netrc before the common defaults
+	candidates, err := candidateCredentials(DefaultCredentialProvider(), "tp-a")
+	if err != nil {
+		t.Fatalf("Failed to get candidates: %v", err)
+	}
+	if len(candidates) != len(defaultCredentialPairs)+1 || candidates[0] != (Credential{"alice", "one"}) {
+		t.Errorf("Expected netrc credentials first, got %v", candidates)
+	}
+
+	// Explicit environment credentials come before netrc
+	t.Setenv("TPI_USERNAME", "carol")
+	t.Setenv("TPI_PASSWORD", "three")
+	candidates, err = candidateCredentials(DefaultCredentialProvider(), "tp-a")
+	if err != nil {
+		t.Fatalf("Failed to get candidates: %v", err)
+	}
+	if candidates[0] != (Credential{"carol", "three"}) || candidates[1] != (Credential{"alice", "one"}) {
+		t.Errorf("Expected environment then netrc credentials, got %v", candidates)
+	}
+}
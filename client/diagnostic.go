@@ -0,0 +1,221 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// redacted replaces secrets in a diagnostic bundle
+const redacted = "[REDACTED]"
+
+// secretKey matches the keys of values never written to a diagnostic bundle
+var secretKey = regexp.MustCompile(`(?i)pass(word)?|token|secret|credential|private|api[_-]?key`)
+
+// DiagnosticOptions contains options for collecting a diagnostic bundle
+type DiagnosticOptions struct {
+	// Uart also reads the UART output buffered for every node. Reading consumes the buffer.
+	Uart bool
+}
+
+// BundleSection is one part of a diagnostic bundle, written to the bundle as Name.json
+type BundleSection struct {
+	Name string
+	// Data is the JSON-encodable content of the section, nil if it couldn't be collected
+	Data interface{}
+	// Err describes why the section couldn't be collected
+	Err string
+}
+
+// Bundle is a capture of the state of the board for troubleshooting, with credentials
+// and tokens redacted. Sections that fail are recorded with their error, see DiagnosticBundle.
+type Bundle struct {
+	Host           string
+	LibraryVersion string
+	CreatedAt      time.Time
+	Sections       []BundleSection
+}
+
+// Section returns the section with the given name, nil if the bundle has none
+func (b *Bundle) Section(name string) *BundleSection {
+	for i := range b.Sections {
+		if b.Sections[i].Name == name {
+			return &b.Sections[i]
+		}
+	}
+	return nil
+}
+
+// DiagnosticBundle collects the info, about, power, USB, cooling and capabilities of the
+// board into a Bundle. Sections that fail carry an error instead of failing the call.
+func (c *Client) DiagnosticBundle(ctx context.Context) (*Bundle, error) {
+	return c.DiagnosticBundleWithOptions(ctx, nil)
+}
+
+// DiagnosticBundleWithOptions collects a diagnostic bundle with the given options
+func (c *Client) DiagnosticBundleWithOptions(ctx context.Context, options *DiagnosticOptions) (*Bundle, error) {
+	if options == nil {
+		options = &DiagnosticOptions{}
+	}
+
+	bundle := &Bundle{
+		Host:           c.Host,
+		LibraryVersion: libraryVersion(),
+		CreatedAt:      time.Now(),
+	}
+	add := func(name string, data interface{}, err error) {
+		// The token is only known once the first request authenticated
+		secrets := []string{c.auth.Password, c.auth.Token}
+		section := BundleSection{Name: name, Data: redactSecrets(data, secrets)}
+		if err != nil {
+			section.Data = nil
+			section.Err = redactString(err.Error(), secrets)
+		}
+		bundle.Sections = append(bundle.Sections, section)
+	}
+
+	snapshot := c.Snapshot(ctx)
+	add("info", snapshot.Info.Values, snapshot.Info.Err)
+	add("about", snapshot.About.Values, snapshot.About.Err)
+	add("power", snapshot.Power.Status, snapshot.Power.Err)
+	add("usb", snapshot.Usb.Status, snapshot.Usb.Err)
+
+	if err := ctx.Err(); err != nil {
+		return bundle, err
+	}
+	cooling, err := c.GetCoolingStatus()
+	add("cooling", cooling, err)
+
+	caps, err := c.Capabilities()
+	add("capabilities", caps, err)
+
+	if options.Uart {
+		for node := 1; node <= nodeCount; node++ {
+			if err := ctx.Err(); err != nil {
+				return bundle, err
+			}
+			output, err := c.GetUartOutput(node)
+			add(fmt.Sprintf("uart-node%d", node), output, err)
+		}
+	}
+
+	return bundle, nil
+}
+
+// WriteZip writes the bundle as a zip archive, with a manifest.json describing the
+// bundle and one JSON file per section
+func (b *Bundle) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	type manifestSection struct {
+		Name  string `json:"name"`
+		Error string `json:"error,omitempty"`
+	}
+	manifest := struct {
+		Host           string            `json:"host"`
+		LibraryVersion string            `json:"library_version"`
+		CreatedAt      time.Time         `json:"created_at"`
+		Sections       []manifestSection `json:"sections"`
+	}{Host: b.Host, LibraryVersion: b.LibraryVersion, CreatedAt: b.CreatedAt}
+	for _, section := range b.Sections {
+		manifest.Sections = append(manifest.Sections, manifestSection{section.Name, section.Err})
+	}
+
+	if err := writeZipJSON(archive, "manifest.json", manifest); err != nil {
+		return err
+	}
+	for _, section := range b.Sections {
+		if section.Err != "" {
+			continue
+		}
+		if err := writeZipJSON(archive, section.Name+".json", section.Data); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// writeZipJSON adds v to archive as an indented JSON file
+func writeZipJSON(archive *zip.Writer, name string, v interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to bundle: %w", name, err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	return nil
+}
+
+// redactSecrets returns data as generic JSON values, with the values of secret-looking
+// keys and every occurrence of secrets replaced
+func redactSecrets(data interface{}, secrets []string) interface{} {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return nil
+	}
+	return redactValue(value, secrets)
+}
+
+// redactValue redacts a decoded JSON value, see redactSecrets
+func redactValue(value interface{}, secrets []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if secretKey.MatchString(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(item, secrets)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item, secrets)
+		}
+		return v
+	case string:
+		return redactString(v, secrets)
+	default:
+		return v
+	}
+}
+
+// redactString replaces every occurrence of secrets in s
+func redactString(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	return s
+}
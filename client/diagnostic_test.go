@@ -0,0 +1,99 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticBundle(t *testing.T) {
+	const password = "s3cret-pass"
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		switch r.URL.Query().Get("type") {
+		case "power":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":1}]}]}`))
+		case "usb":
+			w.Write([]byte(`{"result":[{"node":"Node1","mode":"Host","route":"AlpineUsb"}]}`))
+		case "other":
+			w.Write([]byte(`{"response":[{"result":[{"version":"2.0.5","api_token":"bmc-internal"}]}]}`))
+		case "about":
+			w.Write([]byte(`{"response":[{"result":{"api":"1.1","version":"2.1.0"}}]}`))
+		case "cooling":
+			http.Error(w, "Invalid `type` parameter", http.StatusBadRequest)
+		case "uart":
+			w.Write([]byte(`{"response":["login: root\nPassword: ` + password + `\n"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), WithCredentials("root", password))
+
+	bundle, err := client.DiagnosticBundleWithOptions(context.Background(), &DiagnosticOptions{Uart: true})
+	if err != nil {
+		t.Fatalf("DiagnosticBundle failed: %v", err)
+	}
+	if section := bundle.Section("cooling"); section == nil || section.Err == "" {
+		t.Errorf("Expected the cooling section to record its error, got %+v", section)
+	}
+
+	var buf bytes.Buffer
+	if err := bundle.WriteZip(&buf); err != nil {
+		t.Fatalf("WriteZip failed: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Invalid zip: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[file.Name] = string(content)
+	}
+
+	for _, name := range []string{"manifest.json", "info.json", "about.json", "power.json", "usb.json", "capabilities.json", "uart-node1.json", "uart-node4.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in the bundle, got %v", name, archive.File)
+		}
+	}
+	if !strings.Contains(files["manifest.json"], `"name": "cooling"`) {
+		t.Errorf("Expected the failed cooling section in the manifest, got:\n%s", files["manifest.json"])
+	}
+	if !strings.Contains(files["about.json"], "2.1.0") {
+		t.Errorf("Expected the about section, got:\n%s", files["about.json"])
+	}
+
+	for name, content := range files {
+		for _, secret := range []string{password, "mock-token", "bmc-internal"} {
+			if strings.Contains(content, secret) {
+				t.Errorf("Expected %s to be redacted from %s, got:\n%s", secret, name, content)
+			}
+		}
+	}
+}
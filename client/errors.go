@@ -0,0 +1,165 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned when the BMC firmware doesn't support the requested operation
+var ErrUnsupported = errors.New("operation not supported by the BMC firmware")
+
+// ErrUnauthorized is returned when the BMC rejects the credentials or the token
+var ErrUnauthorized = errors.New("authentication failed: invalid credentials")
+
+// clockSkewThreshold is how far the clock of the BMC may be from the local clock before an
+// authentication failure is blamed on it
+const clockSkewThreshold = 5 * time.Minute
+
+// ClockSkewError is an authentication failure while the clock of the BMC, from the Date header
+// of its answer, disagrees with the local clock, which can break token validation.
+// It matches the wrapped error, ErrUnauthorized, with errors.Is.
+type ClockSkewError struct {
+	// Skew is the time of the BMC minus the local time
+	Skew time.Duration
+	Err  error
+}
+
+func (e *ClockSkewError) Error() string {
+	return fmt.Sprintf("%v: your system clock appears to be off by %ds, which can break authentication",
+		e.Err, int64(e.Skew.Abs().Seconds()))
+}
+
+func (e *ClockSkewError) Unwrap() error {
+	return e.Err
+}
+
+// authFailure returns the error for a rejected authentication answered with resp:
+// ErrUnauthorized, in a ClockSkewError if the Date of resp is too far from now
+func authFailure(resp *http.Response, now time.Time) error {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return ErrUnauthorized
+	}
+
+	skew := date.Sub(now)
+	if skew.Abs() <= clockSkewThreshold {
+		return ErrUnauthorized
+	}
+	return &ClockSkewError{Skew: skew, Err: ErrUnauthorized}
+}
+
+// BMCError is returned when the BMC answers a request with an error.
+// A 401 answer also matches ErrUnauthorized with errors.Is.
+type BMCError struct {
+	// StatusCode is the HTTP status of the answer, 200 when the error was reported in the body
+	StatusCode int
+	Message    string
+}
+
+func (e *BMCError) Error() string {
+	if e.StatusCode == http.StatusOK {
+		return fmt.Sprintf("server returned error: %s", e.Message)
+	}
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *BMCError) Unwrap() error {
+	if e.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// TimeoutError is returned when the BMC doesn't answer a request in time
+type TimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("BMC didn't answer within %s: %v", e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// isTimeout reports whether err is a request or context timing out
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isUnsupportedResponse reports whether a failed response means the firmware
+// doesn't know the requested operation rather than that the operation failed
+func isUnsupportedResponse(statusCode int, body string) bool {
+	switch statusCode {
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return true
+	case http.StatusBadRequest:
+		// Older firmware rejects unknown request types with a 400
+		lower := strings.ToLower(body)
+		return strings.Contains(lower, "invalid") && strings.Contains(lower, "type")
+	}
+	return false
+}
+
+// HTMLResponseError is returned when the BMC answered with an HTML page where JSON was
+// expected, usually the error page of a reverse proxy or captive portal in front of it
+type HTMLResponseError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTMLResponseError) Error() string {
+	return fmt.Sprintf("expected JSON from BMC but got HTML (HTTP %s), is the host/proxy correct?", e.Status)
+}
+
+// checkHTMLResponse returns an HTMLResponseError if the response, whose body was read
+// into body, is an HTML page: either by its content type or by a body starting with <
+func checkHTMLResponse(resp *http.Response, body []byte) error {
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/html") ||
+		bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		status := resp.Status
+		if status == "" {
+			status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		}
+		return &HTMLResponseError{StatusCode: resp.StatusCode, Status: status}
+	}
+	return nil
+}
+
+// decodeJSONResponse decodes the JSON body of resp into v, failing with an
+// HTMLResponseError rather than a JSON syntax error when the body is HTML
+func decodeJSONResponse(resp *http.Response, v interface{}) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if err := checkHTMLResponse(resp, body); err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(body)).Decode(v)
+}
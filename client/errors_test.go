@@ -0,0 +1,171 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyErrorPage is what a reverse proxy typically serves when the BMC behind it is down
+const proxyErrorPage = `<html>
+<head><title>502 Bad Gateway</title></head>
+<body><center><h1>502 Bad Gateway</h1></center><hr><center>nginx</center></body>
+</html>`
+
+func TestHTMLResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		// authenticated is false when the proxy also serves the authentication endpoint
+		authenticated bool
+		call          func(c *Client) error
+	}{
+		{"power status", true, func(c *Client) error { _, err := c.PowerStatus(); return err }},
+		{"power on", true, func(c *Client) error { return c.PowerOn(1) }},
+		{"usb status", true, func(c *Client) error { _, err := c.UsbGetStatus(); return err }},
+		{"about", true, func(c *Client) error { _, err := c.About(); return err }},
+		{"authentication", false, func(c *Client) error { _, err := c.PowerStatus(); return err }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.authenticated && r.URL.Path == "/api/bmc/authenticate" {
+					w.Write([]byte(`{"id":"mock-token"}`))
+					return
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte(proxyErrorPage))
+			}))
+
+			err := tt.call(client)
+			var htmlErr *HTMLResponseError
+			if !errors.As(err, &htmlErr) {
+				t.Fatalf("Expected an HTMLResponseError, got: %v", err)
+			}
+			if htmlErr.StatusCode != http.StatusBadGateway {
+				t.Errorf("Expected status 502, got %d", htmlErr.StatusCode)
+			}
+			for _, want := range []string{"expected JSON from BMC but got HTML", "502 Bad Gateway", "host/proxy"} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected the error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLResponseWithoutContentType(t *testing.T) {
+	// A captive portal answering 200 with a page and no content type
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "")
+		w.Write([]byte("\n  <!DOCTYPE html><html><body>Sign in to the network</body></html>"))
+	}))
+
+	var htmlErr *HTMLResponseError
+	if _, err := client.PowerStatus(); !errors.As(err, &htmlErr) {
+		t.Errorf("Expected an HTMLResponseError, got: %v", err)
+	}
+	if err := client.PowerOn(1); !errors.As(err, &htmlErr) {
+		t.Errorf("Expected an HTMLResponseError, got: %v", err)
+	}
+}
+
+func TestClockSkewHint(t *testing.T) {
+	tests := []struct {
+		name     string
+		offset   time.Duration
+		wantHint bool
+	}{
+		{"skewed", -2 * time.Hour, true},
+		{"in sync", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Date", time.Now().Add(test.offset).UTC().Format(http.TimeFormat))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			}))
+
+			_, err := client.ForceAuthentication()
+			if !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("Expected ErrUnauthorized, got %v", err)
+			}
+
+			var skewErr *ClockSkewError
+			if hint := errors.As(err, &skewErr); hint != test.wantHint {
+				t.Fatalf("Expected clock skew hint %v, got %v", test.wantHint, err)
+			}
+			if test.wantHint && !strings.Contains(err.Error(), "your system clock appears to be off by 7200s") {
+				t.Errorf("Expected the hint in the error, got %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestResultEnvelopes(t *testing.T) {
+	results := map[string]string{
+		"about":          `{"version":"2.0.5","hostname":"turing"}`,
+		"cooling":        `[{"device":"fan0","speed":3,"max_speed":5}]`,
+		"power":          `[{"node1":1,"node2":0,"node3":0,"node4":1}]`,
+		"power_on_delay": `{"delay_ms":1500}`,
+	}
+	envelopes := map[string]string{
+		"wrapped": `{"response":[{"result":%s}]}`,
+		"flat":    `{"result":%s}`,
+	}
+
+	for name, envelope := range envelopes {
+		t.Run(name, func(t *testing.T) {
+			client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/bmc/authenticate" {
+					w.Write([]byte(`{"id":"mock-token"}`))
+					return
+				}
+				fmt.Fprintf(w, envelope, results[r.URL.Query().Get("type")])
+			}))
+
+			about, err := client.About()
+			if err != nil || about["version"] != "2.0.5" || about["hostname"] != "turing" {
+				t.Errorf("About() = %v, %v", about, err)
+			}
+
+			devices, err := client.GetCoolingStatus()
+			if err != nil || len(devices) != 1 || devices[0] != (CoolingDevice{Device: "fan0", Speed: 3, MaxSpeed: 5}) {
+				t.Errorf("GetCoolingStatus() = %v, %v", devices, err)
+			}
+
+			status, err := client.PowerStatus()
+			if err != nil || !status[1] || status[2] || status[3] || !status[4] {
+				t.Errorf("PowerStatus() = %v, %v", status, err)
+			}
+
+			delay, err := client.GetPowerOnDelay()
+			if err != nil || delay != 1500*time.Millisecond {
+				t.Errorf("GetPowerOnDelay() = %v, %v", delay, err)
+			}
+		})
+	}
+}
@@ -15,12 +15,20 @@
 package tpi
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// DefaultEthResetTimeout bounds EthResetAndWait when the context has no deadline
+const DefaultEthResetTimeout = 2 * time.Minute
+
 // EthReset resets the on-board Ethernet switch
 // Note: This is expected to cause a timeout as the network connection will be lost
 func (c *Client) EthReset() error {
@@ -50,6 +58,7 @@ func (c *Client) EthReset() error {
 			strings.Contains(err.Error(), "connection refused") ||
 			strings.Contains(err.Error(), "EOF") {
 			// This is expected, so we'll return success
+			c.emit(EventEthReset, 0, "reset")
 			return nil
 		}
 		return fmt.Errorf("failed to send request: %w", err)
@@ -62,10 +71,71 @@ func (c *Client) EthReset() error {
 	if resp.StatusCode != http.StatusOK {
 		if resp.StatusCode == http.StatusBadRequest {
 			// Some BMC firmware versions may return an error, but the command might still work
+			c.emit(EventEthReset, 0, "reset")
 			return nil
 		}
 		return fmt.Errorf("Ethernet switch reset failed: status code %d", resp.StatusCode)
 	}
 
+	c.emit(EventEthReset, 0, "reset")
 	return nil
 }
+
+// EthResetAndWait resets the on-board Ethernet switch and waits until the BMC is reachable
+// again. Connection failures and server errors while the switch comes back are expected and
+// retried with exponential backoff; any other failure is returned right away. Without a
+// deadline on ctx, the wait is bounded by DefaultEthResetTimeout.
+func (c *Client) EthResetAndWait(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultEthResetTimeout)
+		defer cancel()
+	}
+
+	if err := c.EthReset(); err != nil {
+		return err
+	}
+
+	retry := newBackoff(c.retryPolicy(DefaultEthResetRetryPolicy))
+	for {
+		status, err := c.ping(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("BMC not reachable after Ethernet switch reset: %w", errors.Join(ctx.Err(), err))
+		}
+		if !isTransientNetworkError(status, err) {
+			return fmt.Errorf("BMC failed after Ethernet switch reset: %w", err)
+		}
+		Debug("BMC not reachable yet: %v, checking again", err)
+
+		if retry.Sleep(ctx) != nil {
+			return fmt.Errorf("BMC not reachable after Ethernet switch reset: %w", errors.Join(ctx.Err(), err))
+		}
+	}
+}
+
+// isTransientNetworkError reports whether a failed request is what a network blip looks like:
+// a refused or reset connection, an unreachable host or network while the switch resets, a
+// timeout, a connection closed mid-response, or a server error while the BMC comes back.
+// TLS, certificate and URL errors are permanent.
+func isTransientNetworkError(status int, err error) bool {
+	if status >= http.StatusInternalServerError {
+		return true
+	}
+	if status != 0 || err == nil {
+		return false
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ECONNRESET,
+		syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.ENETDOWN} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
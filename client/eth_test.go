@@ -0,0 +1,183 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func shortenEthResetWait(t *testing.T) {
+	t.Helper()
+	shortenRetryPolicy(t, &DefaultEthResetRetryPolicy)
+}
+
+// newEthResetServer returns a mock BMC whose status requests are answered by ping
+// once the switch was reset, along with the number of status requests received
+func newEthResetServer(t *testing.T, ping func(w http.ResponseWriter, attempt int)) (*Client, func() int) {
+	var mu sync.Mutex
+	reset := false
+	attempts := 0
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Query().Get("type") == "network":
+			mu.Lock()
+			reset = true
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		case r.URL.Query().Get("type") == "other":
+			mu.Lock()
+			if !reset {
+				mu.Unlock()
+				t.Error("Status requested before the reset")
+				return
+			}
+			attempts++
+			attempt := attempts
+			mu.Unlock()
+			ping(w, attempt)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return client, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts
+	}
+}
+
+func TestEthResetAndWait(t *testing.T) {
+	shortenEthResetWait(t)
+
+	// The BMC drops connections and then answers with errors while the switch comes back
+	client, attempts := newEthResetServer(t, func(w http.ResponseWriter, attempt int) {
+		switch {
+		case attempt <= 2:
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Failed to hijack connection: %v", err)
+				return
+			}
+			conn.Close()
+		case attempt == 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"response":[{"result":[{"api":"1.1"}]}]}`))
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.EthResetAndWait(ctx); err != nil {
+		t.Fatalf("EthResetAndWait failed: %v", err)
+	}
+	if got := attempts(); got < 4 {
+		t.Errorf("Expected the BMC to be polled until it answered, got %d attempts", got)
+	}
+}
+
+func TestEthResetAndWaitPermanentFailure(t *testing.T) {
+	shortenEthResetWait(t)
+
+	client, attempts := newEthResetServer(t, func(w http.ResponseWriter, attempt int) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := client.EthResetAndWait(ctx)
+	if err == nil {
+		t.Fatal("Expected an error from a BMC refusing requests")
+	}
+	if !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected the error to carry the status, got: %v", err)
+	}
+	if got := attempts(); got != 1 {
+		t.Errorf("Expected a permanent failure not to be retried, got %d attempts", got)
+	}
+}
+
+func TestEthResetAndWaitTimeout(t *testing.T) {
+	shortenEthResetWait(t)
+
+	client, _ := newEthResetServer(t, func(w http.ResponseWriter, attempt int) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := client.EthResetAndWait(ctx)
+	if err == nil {
+		t.Fatal("Expected an error when the BMC never comes back")
+	}
+	if ctx.Err() == nil {
+		t.Errorf("Expected to wait until the deadline, got: %v", err)
+	}
+}
+
+func TestIsTransientNetworkError(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://bmc/api/bmc", Err: err}
+	}
+	opErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		want   bool
+	}{
+		{"connection refused", 0, urlErr(opErr(syscall.ECONNREFUSED)), true},
+		{"connection reset", 0, urlErr(opErr(syscall.ECONNRESET)), true},
+		{"host unreachable", 0, urlErr(opErr(syscall.EHOSTUNREACH)), true},
+		{"network unreachable", 0, urlErr(opErr(syscall.ENETUNREACH)), true},
+		{"network down", 0, urlErr(opErr(syscall.ENETDOWN)), true},
+		{"timeout", 0, urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}), true},
+		{"eof", 0, urlErr(io.EOF), true},
+		{"unexpected eof", 0, fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), true},
+		{"server error", http.StatusBadGateway, fmt.Errorf("bad gateway"), true},
+		{"certificate", 0, urlErr(x509.UnknownAuthorityError{}), false},
+		{"bad url", 0, urlErr(fmt.Errorf("unsupported protocol scheme")), false},
+		{"dns", 0, urlErr(&net.DNSError{Err: "no such host", Name: "bmc", IsNotFound: true}), false},
+		{"client error", http.StatusForbidden, fmt.Errorf("forbidden"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isTransientNetworkError(tt.status, tt.err); got != tt.want {
+			t.Errorf("%s: isTransientNetworkError(%d, %v) = %v, want %v", tt.name, tt.status, tt.err, got, tt.want)
+		}
+	}
+}
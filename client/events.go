@@ -0,0 +1,66 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import "time"
+
+// EventOp identifies the operation that produced an Event
+type EventOp string
+
+const (
+	EventPowerOn         EventOp = "power_on"
+	EventPowerOff        EventOp = "power_off"
+	EventPowerReset      EventOp = "power_reset"
+	EventUsbMode         EventOp = "usb_mode"
+	EventNodeMode        EventOp = "node_mode"
+	EventFlash           EventOp = "flash"
+	EventFirmwareUpgrade EventOp = "firmware_upgrade"
+	EventEthReset        EventOp = "eth_reset"
+	EventReboot          EventOp = "reboot"
+)
+
+// Event describes a successful state change made through the client
+type Event struct {
+	// Op is the operation that was performed
+	Op EventOp
+	// Node is the affected node [1-4], or 0 for board-wide operations
+	Node int
+	// Outcome describes the resulting state, e.g. "on", "host" or the flashed image
+	Outcome string
+	// Time is when the operation completed
+	Time time.Time
+}
+
+// WithEventHook registers a function called after every successful state change.
+// The hook runs synchronously on the calling goroutine, so it shouldn't block;
+// hand the event off to a goroutine or channel for any slow work.
+func WithEventHook(hook func(Event)) Option {
+	return func(c *Client) {
+		c.eventHook = hook
+	}
+}
+
+// emit calls the event hook, if any
+func (c *Client) emit(op EventOp, node int, outcome string) {
+	if c.eventHook == nil {
+		return
+	}
+	c.eventHook(Event{
+		Op:      op,
+		Node:    node,
+		Outcome: outcome,
+		Time:    time.Now(),
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"net/http"
+	"testing"
+)
+
+// powerHandler is a mock BMC that accepts power changes
+var powerHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/bmc/authenticate":
+		w.Write([]byte(`{"id":"mock-token"}`))
+	case "/api/bmc":
+		w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+	default:
+		http.NotFound(w, r)
+	}
+})
+
+func TestEventHookFiresAfterPowerOn(t *testing.T) {
+	var events []Event
+	client, _ := newMockClient(t, powerHandler, WithEventHook(func(e Event) {
+		events = append(events, e)
+	}))
+
+	if err := client.PowerOn(3); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event.Op != EventPowerOn || event.Node != 3 || event.Outcome != "on" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Time.IsZero() {
+		t.Errorf("Expected the event time to be set")
+	}
+}
+
+func TestEventHookNotFiredOnFailure(t *testing.T) {
+	var events []Event
+	client, _ := newMockClient(t, unsupportedHandler, WithEventHook(func(e Event) {
+		events = append(events, e)
+	}))
+
+	if err := client.PowerOff(1); err == nil {
+		t.Fatalf("Expected PowerOff to fail")
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no events after a failure, got %+v", events)
+	}
+}
+
+func TestEventHookOptional(t *testing.T) {
+	client, _ := newMockClient(t, powerHandler)
+
+	if err := client.PowerOn(1); err != nil {
+		t.Fatalf("PowerOn without hook failed: %v", err)
+	}
+}
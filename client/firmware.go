@@ -16,18 +16,110 @@ package tpi
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"net/http"
 	"os"
-	"path/filepath"
 )
 
+// FirmwareOptions contains options for upgrading the BMC firmware
+type FirmwareOptions struct {
+	// Path to the firmware file
+	FilePath string
+	// Optional SHA256 checksum for verification
+	SHA256 string
+	// Optional slot to write the firmware to on A/B firmware, either a slot
+	// name or "active"/"standby". Empty writes to the BMC's default target.
+	Slot string
+	// Force uploads the file even if it doesn't look like BMC firmware
+	Force bool
+	// Upload the firmware in chunks of this many bytes, retrying a failed chunk alone,
+	// if the firmware supports chunked uploads. Zero uploads the whole file at once.
+	ChunkSize int64
+}
+
+// ErrInvalidFirmware is returned when a file doesn't look like BMC firmware, see ValidateFirmwareFile
+var ErrInvalidFirmware = errors.New("file doesn't look like BMC firmware")
+
+// Size range of BMC firmware images accepted by ValidateFirmwareFile
+const (
+	minFirmwareSize = 1 << 20   // 1 MiB
+	maxFirmwareSize = 256 << 20 // 256 MiB
+)
+
+// FirmwareSignature is a magic byte sequence found at Offset in a BMC firmware image
+type FirmwareSignature struct {
+	// Name describes the format, e.g. "SWUpdate"
+	Name   string
+	Offset int64
+	Magic  []byte
+}
+
+// DefaultFirmwareSignatures are the formats BMC firmware is shipped in
+var DefaultFirmwareSignatures = []FirmwareSignature{
+	{Name: "SWUpdate", Offset: 0, Magic: []byte("070701")},
+	{Name: "SWUpdate (CRC)", Offset: 0, Magic: []byte("070702")},
+	{Name: "UBI", Offset: 0, Magic: []byte("UBI#")},
+	{Name: "SquashFS", Offset: 0, Magic: []byte("hsqs")},
+}
+
+// WithFirmwareSignatures replaces the formats a firmware file must match before an
+// upgrade, DefaultFirmwareSignatures by default, for future firmware formats
+func WithFirmwareSignatures(signatures ...FirmwareSignature) Option {
+	return func(c *Client) {
+		if len(signatures) == 0 {
+			c.optionErr = fmt.Errorf("at least one firmware signature is required")
+			return
+		}
+		c.firmwareSignatures = signatures
+	}
+}
+
+// FirmwareSlots describes the A/B firmware slots of the BMC
+type FirmwareSlots struct {
+	// Active is the slot the BMC booted from
+	Active string
+	// Standby is the slot that will be written by an upgrade targeting "standby"
+	Standby string
+	// Slots lists every slot name
+	Slots []string
+}
+
 // UpgradeFirmware upgrades the BMC firmware with the given file
 // If sha256 is provided, it will verify the file checksum before uploading
 func (c *Client) UpgradeFirmware(filePath string, providedSha256 string) error {
+	return c.UpgradeFirmwareWithOptions(&FirmwareOptions{
+		FilePath: filePath,
+		SHA256:   providedSha256,
+	})
+}
+
+// UpgradeFirmwareWithOptions upgrades the BMC firmware with the given options.
+// Targeting a slot returns ErrUnsupported if the firmware has no slot concept.
+func (c *Client) UpgradeFirmwareWithOptions(options *FirmwareOptions) error {
+	if options == nil || options.FilePath == "" {
+		return fmt.Errorf("firmware file is required")
+	}
+	if options.ChunkSize < 0 {
+		return fmt.Errorf("invalid chunk size: %d", options.ChunkSize)
+	}
+	filePath := options.FilePath
+	providedSha256 := options.SHA256
+
+	// Resolve the target slot before spending time on the file
+	slot := ""
+	if options.Slot != "" {
+		slots, err := c.FirmwareSlots()
+		if err != nil {
+			return err
+		}
+		slot, err = slots.resolve(options.Slot)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Verify file exists
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -35,25 +127,25 @@ func (c *Client) UpgradeFirmware(filePath string, providedSha256 string) error {
 	}
 	defer file.Close()
 
+	// Refuse files that would brick the board, such as node OS images
+	if !options.Force {
+		if err := validateFirmware(file, c.firmwareSignatures); err != nil {
+			return err
+		}
+	}
+
 	// If SHA256 is provided, verify the file
 	if providedSha256 != "" {
-		// Calculate SHA256
-		h := sha256.New()
-		if _, err := io.Copy(h, file); err != nil {
-			return fmt.Errorf("failed to calculate SHA256: %w", err)
+		calculatedSha256, err := hashReader(file)
+		if err != nil {
+			return err
 		}
-		calculatedSha256 := hex.EncodeToString(h.Sum(nil))
 
 		// Verify checksum
 		if calculatedSha256 != providedSha256 {
 			return fmt.Errorf("SHA256 checksum mismatch: provided %s, calculated %s",
 				providedSha256, calculatedSha256)
 		}
-
-		// Reset file position for upload
-		if _, err := file.Seek(0, 0); err != nil {
-			return fmt.Errorf("failed to reset file: %w", err)
-		}
 	}
 
 	// Create a new request
@@ -63,44 +155,161 @@ func (c *Client) UpgradeFirmware(filePath string, providedSha256 string) error {
 	}
 
 	// Modify the URL to point to the firmware endpoint
-	req.URL.Path = "/api/firmware"
+	req.URL.Path = c.paths().Firmware()
+	if slot != "" {
+		req.AddQueryParam("slot", slot)
+	}
+
+	// Stream the firmware as a multipart form, or in chunks if asked to
+	req.Method = "POST"
+	err = ErrUnsupported
+	if options.ChunkSize > 0 {
+		err = c.sendFileChunked(req, filePath, options.ChunkSize, newFlashOutput(ProgressHuman, io.Discard))
+	}
+	if errors.Is(err, ErrUnsupported) {
+		err = c.sendFileUpload(req, filePath, "firmware")
+	}
+	if err != nil {
+		return fmt.Errorf("firmware upgrade failed: %w", err)
+	}
+
+	c.emit(EventFirmwareUpgrade, 0, filePath)
+	return nil
+}
 
-	// Create a buffer for the multipart form data
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+// ValidateFirmwareFile checks that the file at path looks like BMC firmware: its size is
+// plausible and it starts with one of signatures, DefaultFirmwareSignatures if nil.
+// It returns an error wrapping ErrInvalidFirmware otherwise.
+func ValidateFirmwareFile(path string, signatures []FirmwareSignature) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open firmware file: %w", err)
+	}
+	defer file.Close()
+
+	return validateFirmware(file, signatures)
+}
+
+// validateFirmware checks the size and signature of a firmware file, without moving its offset
+func validateFirmware(file *os.File, signatures []FirmwareSignature) error {
+	if signatures == nil {
+		signatures = DefaultFirmwareSignatures
+	}
 
-	// Create the form file
-	formFile := filepath.Base(filePath)
-	part, err := writer.CreateFormFile("firmware", formFile)
+	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to create form file: %w", err)
+		return fmt.Errorf("failed to stat firmware file: %w", err)
+	}
+	if size := info.Size(); size < minFirmwareSize || size > maxFirmwareSize {
+		return fmt.Errorf("%w: its size %s is outside the expected %s to %s",
+			ErrInvalidFirmware, formatBytes(size), formatBytes(minFirmwareSize), formatBytes(maxFirmwareSize))
+	}
+
+	for _, signature := range signatures {
+		magic := make([]byte, len(signature.Magic))
+		if _, err := file.ReadAt(magic, signature.Offset); err != nil {
+			continue
+		}
+		if bytes.Equal(magic, signature.Magic) {
+			return nil
+		}
+	}
+
+	// Name what the file is instead, when it's recognizable
+	header := make([]byte, 512)
+	n, _ := file.ReadAt(header, 0)
+	if kind := describeFile(header[:n]); kind != "" {
+		return fmt.Errorf("%w: it looks like %s", ErrInvalidFirmware, kind)
 	}
+	return fmt.Errorf("%w: unknown file format", ErrInvalidFirmware)
+}
 
-	// Copy the file to the form
-	if _, err := io.Copy(part, file); err != nil {
-		return fmt.Errorf("failed to copy file to form: %w", err)
+// describeFile names common non-firmware formats from their first bytes, or returns ""
+func describeFile(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return "an xz-compressed file"
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return "a gzip-compressed file"
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		return "a zip archive"
+	case len(header) == 512 && header[510] == 0x55 && header[511] == 0xaa:
+		return "a disk image, such as a node OS image"
 	}
+	return ""
+}
 
-	// Close the writer to finalize the form data
-	if err := writer.Close(); err != nil {
-		return fmt.Errorf("failed to close multipart writer: %w", err)
+// FirmwareSlots returns the A/B firmware slots of the BMC.
+// It returns ErrUnsupported if the firmware has no slot concept.
+func (c *Client) FirmwareSlots() (*FirmwareSlots, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Set the multipart form data on the request
-	req.Method = "POST"
-	req.SetMultipartForm(body, writer.FormDataContentType())
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "firmware_slots")
 
 	// Send the request
 	resp, err := req.Send()
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Check for errors in the response
-	if err := checkResponseError(resp); err != nil {
-		return fmt.Errorf("firmware upgrade failed: %w", err)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return nil, ErrUnsupported
+		}
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
-	return nil
+	// Extract the result
+	result, err := extractResultObject(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract result: %w", err)
+	}
+
+	slots := &FirmwareSlots{}
+	slots.Active, _ = result["active"].(string)
+	slots.Standby, _ = result["standby"].(string)
+	if list, ok := result["slots"].([]interface{}); ok {
+		for _, item := range list {
+			if name, ok := item.(string); ok {
+				slots.Slots = append(slots.Slots, name)
+			}
+		}
+	}
+
+	if slots.Active == "" {
+		return nil, fmt.Errorf("invalid response: missing active slot")
+	}
+
+	return slots, nil
+}
+
+// resolve maps "active", "standby" or a slot name to a slot name
+func (s *FirmwareSlots) resolve(slot string) (string, error) {
+	switch slot {
+	case "active":
+		return s.Active, nil
+	case "standby":
+		if s.Standby == "" {
+			return "", fmt.Errorf("the BMC has no standby firmware slot")
+		}
+		return s.Standby, nil
+	}
+
+	for _, name := range s.Slots {
+		if name == slot {
+			return slot, nil
+		}
+	}
+	if slot == s.Active || slot == s.Standby {
+		return slot, nil
+	}
+
+	return "", fmt.Errorf("unknown firmware slot: %s", slot)
 }
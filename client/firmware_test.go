@@ -0,0 +1,199 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFirmware writes the smallest file that looks like firmware and returns its path
+func writeFirmware(t *testing.T) string {
+	t.Helper()
+	return writeFile(t, "tp2-firmware.swu", []byte("070701"), minFirmwareSize)
+}
+
+// writeFile writes header to a new file, sparsely extended to size, and returns its path
+func writeFile(t *testing.T, name string, header []byte, size int64) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, header, 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatalf("Failed to size %s: %v", name, err)
+	}
+	return path
+}
+
+func TestFirmwareSlotsSupported(t *testing.T) {
+	var uploadSlot string
+	uploads := 0
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			w.Write([]byte(`{"result":{"active":"a","standby":"b","slots":["a","b"]}}`))
+		case "/api/firmware":
+			io.Copy(io.Discard, r.Body)
+			uploads++
+			uploadSlot = r.URL.Query().Get("slot")
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	slots, err := client.FirmwareSlots()
+	if err != nil {
+		t.Fatalf("FirmwareSlots failed: %v", err)
+	}
+	if slots.Active != "a" || slots.Standby != "b" || len(slots.Slots) != 2 {
+		t.Errorf("Unexpected slots: %+v", slots)
+	}
+
+	err = client.UpgradeFirmwareWithOptions(&FirmwareOptions{FilePath: writeFirmware(t), Slot: "standby"})
+	if err != nil {
+		t.Fatalf("UpgradeFirmwareWithOptions failed: %v", err)
+	}
+	if uploads != 1 || uploadSlot != "b" {
+		t.Errorf("Expected one upload to slot b, got %d uploads to slot %q", uploads, uploadSlot)
+	}
+
+	err = client.UpgradeFirmwareWithOptions(&FirmwareOptions{FilePath: writeFirmware(t), Slot: "c"})
+	if err == nil {
+		t.Errorf("Expected an error for an unknown slot")
+	}
+}
+
+func TestFirmwareSlotsUnsupported(t *testing.T) {
+	uploads := 0
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			http.Error(w, "Invalid `type` parameter firmware_slots", http.StatusBadRequest)
+		case "/api/firmware":
+			io.Copy(io.Discard, r.Body)
+			uploads++
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	if _, err := client.FirmwareSlots(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported from FirmwareSlots, got %v", err)
+	}
+
+	err := client.UpgradeFirmwareWithOptions(&FirmwareOptions{FilePath: writeFirmware(t), Slot: "standby"})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported when targeting a slot, got %v", err)
+	}
+	if uploads != 0 {
+		t.Errorf("Expected no upload when the slot is unsupported, got %d", uploads)
+	}
+
+	// Upgrades without a slot still work
+	if err := client.UpgradeFirmware(writeFirmware(t), ""); err != nil {
+		t.Fatalf("UpgradeFirmware failed: %v", err)
+	}
+	if uploads != 1 {
+		t.Errorf("Expected one upload, got %d", uploads)
+	}
+}
+
+func TestValidateFirmwareFile(t *testing.T) {
+	mbr := make([]byte, 512)
+	mbr[510], mbr[511] = 0x55, 0xaa
+
+	tests := []struct {
+		name   string
+		header []byte
+		size   int64
+		valid  bool
+	}{
+		{"swupdate", []byte("070701"), 32 << 20, true},
+		{"ubi", []byte("UBI#\x01"), 32 << 20, true},
+		{"disk image", mbr, 32 << 20, false},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, 32 << 20, false},
+		{"unknown", []byte("hello"), 32 << 20, false},
+		{"too small", []byte("070701"), 1024, false},
+		{"too large", []byte("070701"), 1 << 30, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateFirmwareFile(writeFile(t, "firmware.bin", tt.header, tt.size), nil)
+			if tt.valid && err != nil {
+				t.Errorf("Expected a valid firmware file, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidFirmware) {
+				t.Errorf("Expected ErrInvalidFirmware, got %v", err)
+			}
+		})
+	}
+
+	// Custom signatures replace the default ones
+	custom := []FirmwareSignature{{Name: "TPU", Offset: 16, Magic: []byte("TPU2")}}
+	path := writeFile(t, "firmware.tpu", append(make([]byte, 16), "TPU2"...), 32<<20)
+	if err := ValidateFirmwareFile(path, custom); err != nil {
+		t.Errorf("Expected the custom signature to match, got %v", err)
+	}
+	if err := ValidateFirmwareFile(writeFirmware(t), custom); !errors.Is(err, ErrInvalidFirmware) {
+		t.Errorf("Expected default formats to be refused with custom signatures, got %v", err)
+	}
+}
+
+func TestUpgradeFirmwareRefusesInvalidFile(t *testing.T) {
+	uploads := 0
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/firmware":
+			io.Copy(io.Discard, r.Body)
+			uploads++
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	mbr := make([]byte, 512)
+	mbr[510], mbr[511] = 0x55, 0xaa
+	image := writeFile(t, "ubuntu.img", mbr, 8<<20)
+
+	if err := client.UpgradeFirmware(image, ""); !errors.Is(err, ErrInvalidFirmware) {
+		t.Fatalf("Expected ErrInvalidFirmware for a disk image, got %v", err)
+	}
+	if uploads != 0 {
+		t.Fatalf("Expected no upload of an invalid file, got %d", uploads)
+	}
+
+	// Force uploads it anyway
+	if err := client.UpgradeFirmwareWithOptions(&FirmwareOptions{FilePath: image, Force: true}); err != nil {
+		t.Fatalf("Forced upgrade failed: %v", err)
+	}
+	if uploads != 1 {
+		t.Errorf("Expected one forced upload, got %d", uploads)
+	}
+}
@@ -15,14 +15,13 @@
 package tpi
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
@@ -33,18 +32,127 @@ import (
 	"time"
 )
 
+// ErrInvalidHandle is returned when the BMC's answer to a flash request has no usable
+// transfer handle
+var ErrInvalidHandle = errors.New("invalid response: missing or malformed transfer handle")
+
+// uploadAttempts is the number of times an upload is attempted before giving up
+const uploadAttempts = 3
+
+// flashInitAttempts is the number of times the request starting a flash is sent
+const flashInitAttempts = 3
+
+// uploadRetryWait is the wait between upload attempts
+var uploadRetryWait = 5 * time.Second
+
+// Polling of the flash progress, variables so tests can shorten them
+var (
+	flashProgressDelay    = 3 * time.Second
+	flashProgressInterval = 1 * time.Second
+)
+
+// FlashPhase is the phase a flash operation is in
+type FlashPhase int
+
+const (
+	PhaseInit         FlashPhase = iota // Waiting for the BMC to start writing the image
+	PhaseTransferring                   // Writing the image to the node
+	PhaseVerifying                      // Image written, the BMC verifies the checksum
+	PhaseDone                           // Flashing completed successfully
+	PhaseError                          // Flashing failed
+)
+
+// String returns the name of the phase
+func (p FlashPhase) String() string {
+	switch p {
+	case PhaseInit:
+		return "init"
+	case PhaseTransferring:
+		return "transferring"
+	case PhaseVerifying:
+		return "verifying"
+	case PhaseDone:
+		return "done"
+	case PhaseError:
+		return "error"
+	default:
+		return fmt.Sprintf("FlashPhase(%d)", int(p))
+	}
+}
+
+// FlashProgressFunc receives the progress of a flash operation. Every phase is entered once,
+// in order, PhaseTransferring is reported again each time more bytes were written.
+type FlashProgressFunc func(phase FlashPhase, bytes, total int64)
+
+// ProgressFormat selects how FlashNode prints its progress
+type ProgressFormat int
+
+const (
+	// ProgressHuman prints a progress bar redrawn in place with carriage returns
+	ProgressHuman ProgressFormat = iota
+	// ProgressJSON prints one JSON object per line for every update, e.g.
+	// {"phase":"transferring","bytes":1048576,"total":4194304,"speed":524288,"eta":6}
+	// with speed in bytes per second and eta in seconds. Messages such as retries
+	// are objects with a "message" field.
+	ProgressJSON
+)
+
 // FlashOptions contains options for flashing a node
 type FlashOptions struct {
 	// Path to the image file
 	ImagePath string
-	// Optional SHA256 checksum for verification
+	// Optional SHA256 checksum for verification. When empty, the checksum is
+	// read from <image>.sha256 if such a file exists next to the image.
 	SHA256 string
 	// Skip CRC check
 	SkipCRC bool
+	// Optional callback receiving the phase and progress of the flash
+	Progress FlashProgressFunc
+	// How progress is printed, ProgressHuman by default
+	ProgressFormat ProgressFormat
+	// Where progress is printed, os.Stdout by default
+	ProgressWriter io.Writer
+	// Report how much of the image is blocks that contain only zeros. Nothing is
+	// skipped: the BMC upload protocol can't express holes, so the full image is
+	// still uploaded.
+	SkipZeroBlocks bool
+	// Put the node in USB flash mode, routed to the BMC, before flashing if it isn't
+	// already, and restore the previous USB mode afterwards, as WithUsbRoutedTo does.
+	// A USB mode that can't be read can't be restored, the flash fails then.
+	EnsureFlashMode bool
+	// Upload the image in chunks of this many bytes, retrying a failed chunk alone,
+	// if the firmware supports chunked uploads. Zero uploads the whole image at once.
+	ChunkSize int64
+	// Resume the interrupted chunked upload of this transfer handle, printed when the
+	// upload started, instead of starting a new flash. Requires ChunkSize.
+	ResumeHandle int
+	// Power the node on if it is off. Without it, flashing a powered off node fails
+	// with a NodePoweredOffError instead of hanging, and so does flashing a node whose
+	// power status can't be read; with it, the flash goes ahead after a warning.
+	AutoPower bool
+
+	// skipPowerCheck flashes the node whatever its power state, for callers that
+	// handle it themselves
+	skipPowerCheck bool
+}
+
+// NodePoweredOffError is returned when flashing a node that is powered off without AutoPower
+type NodePoweredOffError struct {
+	Node int
+}
+
+func (e *NodePoweredOffError) Error() string {
+	return fmt.Sprintf("node %d is powered off; flashing requires it to be on", e.Node)
 }
 
 // FlashNode flashes the specified node with an OS image
 func (c *Client) FlashNode(node int, options *FlashOptions) error {
+	return c.flashNode(node, options, "")
+}
+
+// flashNode implements FlashNode. A verifiedSha256 is the checksum of an image already
+// hashed and verified by the caller, which is then neither read nor verified again.
+func (c *Client) flashNode(node int, options *FlashOptions, verifiedSha256 string) error {
 	if node < 1 || node > 4 {
 		return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
 	}
@@ -53,6 +161,18 @@ func (c *Client) FlashNode(node int, options *FlashOptions) error {
 		return fmt.Errorf("image path is required")
 	}
 
+	if options.ProgressFormat != ProgressHuman && options.ProgressFormat != ProgressJSON {
+		return fmt.Errorf("invalid progress format: %d", options.ProgressFormat)
+	}
+
+	if options.ChunkSize < 0 {
+		return fmt.Errorf("invalid chunk size: %d", options.ChunkSize)
+	}
+	if options.ResumeHandle != 0 && options.ChunkSize == 0 {
+		return fmt.Errorf("resuming an upload requires a chunk size")
+	}
+	out := newFlashOutput(options.ProgressFormat, options.ProgressWriter)
+
 	// Verify file exists
 	file, err := os.Open(options.ImagePath)
 	if err != nil {
@@ -68,31 +188,167 @@ func (c *Client) FlashNode(node int, options *FlashOptions) error {
 	fileSize := fileInfo.Size()
 	fileName := filepath.Base(options.ImagePath)
 
-	// If SHA256 is provided, verify the file
-	if options.SHA256 != "" {
-		// Calculate SHA256
-		h := sha256.New()
-		if _, err := io.Copy(h, file); err != nil {
-			return fmt.Errorf("failed to calculate SHA256: %w", err)
+	// Refuse uploads that the configured API version can't handle
+	if err := c.checkUploadSize(fileSize); err != nil {
+		return err
+	}
+
+	calculatedSha256 := verifiedSha256
+	if calculatedSha256 == "" {
+		calculatedSha256, err = c.verifyImage(file, options, out)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The image is checked before the BMC is asked about the node
+	if !options.skipPowerCheck {
+		if err := c.checkNodePower(node, options.AutoPower, out); err != nil {
+			return err
+		}
+	}
+
+	// Make sure the node is in flash mode, and put the USB bus back the way it was when done
+	transfer := func() error {
+		return c.transferImage(node, options, fileName, fileSize, calculatedSha256, out)
+	}
+	if options.EnsureFlashMode {
+		out.printf("Routing the USB bus of node %d to the BMC in flash mode...\n", node)
+		return c.WithUsbRoutedTo(node, UsbFlash, true, transfer)
+	}
+	return transfer()
+}
+
+// transferImage uploads the image of options to the BMC, which flashes it to node, and
+// waits until the flash is done
+func (c *Client) transferImage(node int, options *FlashOptions, fileName string, fileSize int64, calculatedSha256 string, out *flashOutput) error {
+	// Step 1: Get the handle of the transfer, unless resuming one
+	handle := options.ResumeHandle
+	if handle == 0 {
+		var err error
+		handle, err = c.startFlashTransfer(node, fileName, fileSize, calculatedSha256, options.SkipCRC, out)
+		if err != nil {
+			return err
+		}
+	}
+
+	out.printf("Started transfer of %.2f GiB...\n", float64(fileSize)/(1024*1024*1024))
+	if options.ChunkSize > 0 {
+		out.printf("Transfer handle is %d, pass it as the resume handle to continue an interrupted upload\n", handle)
+	}
+
+	// Step 2: Upload the file using the handle
+	// Create upload URL
+	uploadURLStr := c.ApiVersion.BaseURL(c.Host) + c.paths().Upload(handle)
+
+	// Parse the upload URL
+	uploadURL, err := url.Parse(uploadURLStr)
+	if err != nil {
+		return fmt.Errorf("failed to parse upload URL: %w", err)
+	}
+
+	// Create upload request
+	uploadReq, err := c.newRequest()
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+
+	// Set the URL and method for the upload
+	uploadReq.URL = uploadURL
+	uploadReq.Method = "POST"
+
+	// Allow up to 60 minutes for the upload
+	uploadReq.Timeout = 60 * time.Minute
+
+	// Send the upload request with retry logic
+	if options.ResumeHandle != 0 {
+		// The whole-file fallback would start the upload over, which the BMC refuses for a started handle
+		if err := c.sendFileChunked(uploadReq, options.ImagePath, options.ChunkSize, out); err != nil {
+			return fmt.Errorf("failed to resume upload of handle %d: %w", handle, err)
 		}
-		calculatedSha256 := hex.EncodeToString(h.Sum(nil))
+	} else if err := c.uploadFile(uploadReq, options.ImagePath, "file", options.ChunkSize, out); err != nil {
+		return err
+	}
 
-		// Verify checksum
-		if calculatedSha256 != options.SHA256 {
-			return fmt.Errorf("SHA256 checksum mismatch: provided %s, calculated %s",
-				options.SHA256, calculatedSha256)
+	// Step 3: Monitor the flashing progress
+	// Create a context with timeout for the entire operation
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Minute)
+	defer cancel()
+
+	if err := c.watchFlashingProgress(ctx, handle, fileSize, options.Progress, out); err != nil {
+		return err
+	}
+
+	c.emit(EventFlash, node, options.ImagePath)
+	return nil
+}
+
+// checkNodePower makes sure the node is powered on before flashing it, powering it on
+// if autoPower is set. A power status that can't be read fails the flash, or only prints
+// a warning if autoPower is set.
+func (c *Client) checkNodePower(node int, autoPower bool, out *flashOutput) error {
+	status, err := c.PowerStatus()
+	if err != nil {
+		if !autoPower {
+			return fmt.Errorf("failed to read the power status of node %d before flashing: %w", node, err)
 		}
+		out.printf("Warning: couldn't read the power status of node %d: %v\n", node, err)
+		return nil
+	}
+	if on, ok := status[node]; !ok || on {
+		return nil
+	}
 
-		// Reset file position for upload
-		if _, err := file.Seek(0, 0); err != nil {
-			return fmt.Errorf("failed to reset file: %w", err)
+	if !autoPower {
+		return &NodePoweredOffError{Node: node}
+	}
+	out.printf("Node %d is powered off, powering it on...\n", node)
+	if err := c.PowerOn(node); err != nil {
+		return fmt.Errorf("failed to power on node %d: %w", node, err)
+	}
+	return nil
+}
+
+// verifyImage hashes the image of options if a checksum is expected, given or next to the
+// image, and verifies it. It returns the calculated checksum, "" if none was expected.
+func (c *Client) verifyImage(file io.ReadSeeker, options *FlashOptions, out *flashOutput) (string, error) {
+	// Fall back to the checksum published next to the image
+	expectedSha256 := options.SHA256
+	checksumSource := "provided"
+	if expectedSha256 == "" {
+		var err error
+		expectedSha256, err = ReadSidecarChecksum(options.ImagePath)
+		if err != nil {
+			return "", err
 		}
+		checksumSource = "expected by " + SidecarChecksumPath(options.ImagePath)
 	}
 
-	// Step 1: Create a request to get the handle
+	// Hash the image and scan its zero blocks in a single read, the upload reads it once more
+	calculatedSha256, zeroStats, err := inspectImage(file, expectedSha256 != "", options.SkipZeroBlocks)
+	if err != nil {
+		return "", err
+	}
+
+	// If SHA256 is provided, verify the file
+	if expectedSha256 != "" && !strings.EqualFold(calculatedSha256, expectedSha256) {
+		return "", fmt.Errorf("SHA256 checksum mismatch: %s %s, calculated %s",
+			checksumSource, expectedSha256, calculatedSha256)
+	}
+
+	if options.SkipZeroBlocks {
+		out.printf("Image contains %s of zero blocks in %d runs, but the BMC upload protocol doesn't support skipping them: uploading the full image\n",
+			formatBytes(zeroStats.ZeroBytes), zeroStats.Runs)
+	}
+	return calculatedSha256, nil
+}
+
+// startFlashTransfer asks the BMC to start flashing node with an image and returns the
+// handle the image is uploaded to
+func (c *Client) startFlashTransfer(node int, fileName string, fileSize int64, expectedSha256 string, skipCRC bool, out *flashOutput) (int, error) {
 	req, err := c.newRequest()
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Add query parameters
@@ -100,164 +356,270 @@ func (c *Client) FlashNode(node int, options *FlashOptions) error {
 	req.AddQueryParam("type", "flash")
 	req.AddQueryParam("file", fileName)
 	req.AddQueryParam("length", strconv.FormatInt(fileSize, 10))
-	req.AddQueryParam("node", strconv.Itoa(node-1)) // BMC uses 0-based indexing
+	req.AddQueryParam("node", toBMCNodeIndex(node))
 
 	// Add SHA256 if provided
-	if options.SHA256 != "" {
-		req.AddQueryParam("sha256", options.SHA256)
+	if expectedSha256 != "" {
+		req.AddQueryParam("sha256", expectedSha256)
 	}
 
 	// Add skip CRC if specified
-	if options.SkipCRC {
+	if skipCRC {
 		req.AddQueryParam("skip_crc", "1")
 	}
 
 	// Send the request to get the handle with retry logic
-	var handle float64
-	for attempts := 0; attempts < 3; attempts++ {
-		resp, err := req.Send()
-		if err != nil {
-			if attempts < 2 {
-				fmt.Printf("Error initializing flash operation: %v. Retrying in 3 seconds...\n", err)
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			return fmt.Errorf("failed to send request after retries: %w", err)
+	retry := newBackoff(c.retryPolicy(DefaultFlashInitRetryPolicy))
+	for {
+		handle, err := requestFlashHandle(req)
+		if err == nil {
+			return handle, nil
 		}
-		defer resp.Body.Close()
-
-		// Check response status
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			if attempts < 2 {
-				fmt.Printf("Error initializing flash operation: %s. Retrying in 3 seconds...\n", resp.Status)
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			return fmt.Errorf("failed to initiate flash operation: %s: %s", resp.Status, string(body))
+		if retry.Retries() >= flashInitAttempts-1 {
+			return 0, err
 		}
 
-		// Parse the response to get the handle
-		var respData map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-			if attempts < 2 {
-				fmt.Printf("Error parsing response: %v. Retrying in 3 seconds...\n", err)
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			return fmt.Errorf("failed to parse response: %w", err)
+		wait := retry.Next()
+		out.printf("Error initializing flash operation: %v. Retrying in %s...\n", err, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// requestFlashHandle sends the request starting a flash and returns the handle the BMC answers
+func requestFlashHandle(req *Request) (int, error) {
+	resp, err := req.Send()
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("failed to initiate flash operation: %s: %s", resp.Status, string(body))
+	}
+
+	// Parse the response to get the handle
+	var respData map[string]interface{}
+	if err := decodeJSONResponse(resp, &respData); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Extract the handle directly from the top level
+	handle, err := parseFlashHandle(respData)
+	if err != nil {
+		return 0, err
+	}
+	return handle, nil
+}
+
+// inspectImage reads r once from the start, returning its SHA256 if hash is set and its
+// zero blocks if scanZeroBlocks is set
+func inspectImage(r io.ReadSeeker, hash, scanZeroBlocks bool) (string, ZeroBlockStats, error) {
+	if !hash && !scanZeroBlocks {
+		return "", ZeroBlockStats{}, nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", ZeroBlockStats{}, fmt.Errorf("failed to rewind image file: %w", err)
+	}
+
+	h := sha256.New()
+	var reader io.Reader = r
+	if hash {
+		reader = io.TeeReader(r, h)
+	}
+
+	var stats ZeroBlockStats
+	if scanZeroBlocks {
+		var err error
+		if stats, err = ScanZeroBlocks(reader, 0); err != nil {
+			return "", stats, err
 		}
+	} else if _, err := io.Copy(h, r); err != nil {
+		return "", stats, fmt.Errorf("failed to calculate SHA256: %w", err)
+	}
 
-		// Extract the handle directly from the top level
-		var ok bool
-		handle, ok = respData["handle"].(float64)
-		if !ok {
-			if attempts < 2 {
-				fmt.Printf("Error extracting handle from response. Retrying in 3 seconds...\n")
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			return fmt.Errorf("invalid response: missing handle")
+	if !hash {
+		return "", stats, nil
+	}
+	return hex.EncodeToString(h.Sum(nil)), stats, nil
+}
+
+// sendFileUploadWithRetry uploads the file at path through req, retrying on failure.
+// A streamed body is consumed by the first attempt, so every attempt re-opens the
+// file and rebuilds the multipart form from the start.
+func (c *Client) sendFileUploadWithRetry(req *Request, path, fieldName string, out *flashOutput) error {
+	for attempts := 0; attempts < uploadAttempts; attempts++ {
+		err := c.sendFileUpload(req, path, fieldName)
+		if err == nil {
+			return nil
 		}
 
-		// If we get here, we have a valid handle
-		break
+		if attempts < uploadAttempts-1 {
+			out.printf("Error uploading file: %v. Retrying in %s...\n", err, uploadRetryWait)
+			time.Sleep(uploadRetryWait)
+			continue
+		}
+		return fmt.Errorf("failed to upload file after retries: %w", err)
 	}
 
-	fmt.Printf("Started transfer of %.2f GiB...\n", float64(fileSize)/(1024*1024*1024))
+	return nil
+}
 
-	// Step 2: Upload the file using the handle
-	// Create a buffer for the multipart form data
-	var formBuffer bytes.Buffer
-	writer := multipart.NewWriter(&formBuffer)
+// sendFileUpload opens the file at path and streams it as a multipart form through req
+func (c *Client) sendFileUpload(req *Request, path, fieldName string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
 
-	// Create the form file part
-	part, err := writer.CreateFormFile("file", fileName)
+	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to create form file: %w", err)
+		return fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	// Reset file pointer to the beginning
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("failed to reset file: %w", err)
+	body, contentType, contentLength, err := newMultipartStream(file, fileInfo.Size(), fieldName, filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("failed to create form stream: %w", err)
 	}
+	defer body.Close()
 
-	// Copy the file to the form
-	if _, err := io.Copy(part, file); err != nil {
-		return fmt.Errorf("failed to copy file to form: %w", err)
+	req.SetBody(body, contentType, contentLength)
+
+	resp, err := req.Send()
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
+
+	return checkResponseError(resp)
+}
+
+// flashOutput prints the progress of a flash in a ProgressFormat
+type flashOutput struct {
+	format ProgressFormat
+	w      io.Writer
+	// State of the last update, attached to JSON messages
+	phase FlashPhase
+	bytes int64
+	total int64
+}
 
-	// Close the writer to finalize the form data
-	if err := writer.Close(); err != nil {
-		return fmt.Errorf("failed to close multipart writer: %w", err)
+// flashProgressLine is a line printed in ProgressJSON format
+type flashProgressLine struct {
+	Phase   string  `json:"phase"`
+	Bytes   int64   `json:"bytes"`
+	Total   int64   `json:"total"`
+	Speed   float64 `json:"speed"`
+	ETA     float64 `json:"eta"`
+	Message string  `json:"message,omitempty"`
+}
+
+// newFlashOutput creates a flashOutput writing to w, or to os.Stdout if w is nil
+func newFlashOutput(format ProgressFormat, w io.Writer) *flashOutput {
+	if w == nil {
+		w = os.Stdout
 	}
+	return &flashOutput{format: format, w: w}
+}
 
-	// Create upload URL
-	uploadURLStr := fmt.Sprintf("%s://%s/api/bmc/upload/%d",
-		c.ApiVersion.GetScheme(),
-		c.Host,
-		int(handle))
+// printf prints a message, as a JSON line with the last progress in ProgressJSON format
+func (o *flashOutput) printf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if o.format != ProgressJSON {
+		fmt.Fprint(o.w, message)
+		return
+	}
+	o.writeLine(flashProgressLine{
+		Phase:   o.phase.String(),
+		Bytes:   o.bytes,
+		Total:   o.total,
+		Message: strings.TrimSpace(message),
+	})
+}
 
-	// Parse the upload URL
-	uploadURL, err := url.Parse(uploadURLStr)
-	if err != nil {
-		return fmt.Errorf("failed to parse upload URL: %w", err)
+// bar redraws the progress bar, it prints nothing in ProgressJSON format
+func (o *flashOutput) bar(format string, args ...interface{}) {
+	if o.format == ProgressHuman {
+		fmt.Fprintf(o.w, format, args...)
 	}
+}
 
-	// Create upload request
-	uploadReq, err := c.newRequest()
+// update prints a progress update, it prints nothing in ProgressHuman format where bar is used
+func (o *flashOutput) update(phase FlashPhase, bytes, total int64, speed float64, eta time.Duration) {
+	o.phase, o.bytes, o.total = phase, bytes, total
+	if o.format != ProgressJSON {
+		return
+	}
+	o.writeLine(flashProgressLine{
+		Phase: phase.String(),
+		Bytes: bytes,
+		Total: total,
+		Speed: speed,
+		ETA:   eta.Seconds(),
+	})
+}
+
+// writeLine writes line as JSON followed by a newline
+func (o *flashOutput) writeLine(line flashProgressLine) {
+	data, err := json.Marshal(line)
 	if err != nil {
-		return fmt.Errorf("failed to create upload request: %w", err)
+		return
 	}
+	o.w.Write(append(data, '\n'))
+}
 
-	// Set the URL and method for the upload
-	uploadReq.URL = uploadURL
-	uploadReq.Method = "POST"
-	uploadReq.SetMultipartForm(&formBuffer, writer.FormDataContentType())
+// flashPhaseReporter forwards progress to a FlashProgressFunc and a flashOutput, entering every phase once
+type flashPhaseReporter struct {
+	progress FlashProgressFunc
+	out      *flashOutput
+	phase    FlashPhase
+	started  bool
+	bytes    int64
+	// Speed and ETA of the transfer, attached to the next report
+	speed float64
+	eta   time.Duration
+}
 
-	// Allow up to 60 minutes for the upload
-	uploadReq.Timeout = 60 * time.Minute
+// report forwards a phase transition, or more bytes being transferred
+func (r *flashPhaseReporter) report(phase FlashPhase, bytes, total int64) {
+	// Phases only move forward
+	if r.started && (phase < r.phase || phase == r.phase && (phase != PhaseTransferring || bytes == r.bytes)) {
+		return
+	}
+	r.started, r.phase, r.bytes = true, phase, bytes
+	if phase != PhaseTransferring {
+		r.eta = 0
+	}
+	r.out.update(phase, bytes, total, r.speed, r.eta)
+	if r.progress != nil {
+		r.progress(phase, bytes, total)
+	}
+}
 
-	// Send the upload request with retry logic
-	for attempts := 0; attempts < 3; attempts++ {
-		uploadResp, err := uploadReq.Send()
-		if err != nil {
-			if attempts < 2 {
-				fmt.Printf("Error uploading file: %v. Retrying in 5 seconds...\n", err)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			return fmt.Errorf("failed to upload file after retries: %w", err)
-		}
-		defer uploadResp.Body.Close()
-
-		// Check response status
-		if uploadResp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(uploadResp.Body)
-			if attempts < 2 {
-				fmt.Printf("Error uploading file: %s. Retrying in 5 seconds...\n", uploadResp.Status)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			return fmt.Errorf("failed to upload file: %s: %s", uploadResp.Status, string(body))
-		}
+// watchFlashingProgress watches the progress of a flashing operation, reporting its phases to progress and out
+func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize int64, progress FlashProgressFunc, out *flashOutput) error {
+	reporter := &flashPhaseReporter{progress: progress, out: out}
+	reporter.report(PhaseInit, 0, fileSize)
 
-		// If we get here, the upload was successful
-		break
+	err := c.watchFlashing(ctx, handle, fileSize, reporter)
+	if err != nil {
+		reporter.report(PhaseError, reporter.bytes, fileSize)
+		return err
 	}
 
-	// Step 3: Monitor the flashing progress
-	// Create a context with timeout for the entire operation
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Minute)
-	defer cancel()
-
-	return c.watchFlashingProgress(ctx, int(handle), fileSize)
+	reporter.report(PhaseDone, fileSize, fileSize)
+	return nil
 }
 
-// watchFlashingProgress watches the progress of a flashing operation with improved error handling
-func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize int64) error {
+// watchFlashing polls the flash progress with improved error handling until the BMC reports done
+func (c *Client) watchFlashing(ctx context.Context, handle int, fileSize int64, reporter *flashPhaseReporter) error {
+	out := reporter.out
+
 	// Initial delay to allow the flashing to begin
-	time.Sleep(3 * time.Second)
+	time.Sleep(flashProgressDelay)
 
 	// Create a new request to check progress
 	progressReq, err := c.newRequest()
@@ -274,18 +636,16 @@ func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize
 
 	// Variables for tracking progress
 	var (
-		verifying      bool
-		startTime      = time.Now()
-		consecutiveErr int
-		maxRetries     = 20 // Increase max retries
-		lastBytes      int64
-		lastUpdateTime = startTime
-		speedWindow    = []float64{}
-		lastErrorMsg   string
+		verifying    bool
+		startTime    = time.Now()
+		maxRetries   = 20 // Increase max retries
+		retry        = newBackoff(c.retryPolicy(DefaultFlashPollRetryPolicy))
+		tracker      = newProgressTracker(fileSize, startTime)
+		lastErrorMsg string
 	)
 
 	// Use a ticker for consistent polling
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(flashProgressInterval)
 	defer ticker.Stop()
 
 	// Use a mutex to protect shared data during updates
@@ -305,16 +665,17 @@ func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize
 			reqCancel() // Clean up the context immediately after the request
 
 			if err != nil {
-				consecutiveErr++
+				wait := retry.Next()
+				consecutiveErr := retry.Retries()
 
 				// Only print error message if it's different from the last one
 				// or if it's been a while since we printed an error
 				errorMsg := err.Error()
 				if errorMsg != lastErrorMsg || consecutiveErr%5 == 1 {
 					if strings.Contains(errorMsg, "context deadline exceeded") {
-						fmt.Printf("\nWaiting for BMC response... (%d/%d)", consecutiveErr, maxRetries)
+						out.printf("\nWaiting for BMC response... (%d/%d)", consecutiveErr, maxRetries)
 					} else {
-						fmt.Printf("\nError checking progress: %v. Retrying... (%d/%d)",
+						out.printf("\nError checking progress: %v. Retrying... (%d/%d)",
 							err, consecutiveErr, maxRetries)
 					}
 					lastErrorMsg = errorMsg
@@ -324,20 +685,15 @@ func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize
 					return fmt.Errorf("too many consecutive errors (%d): %w", consecutiveErr, err)
 				}
 
-				// Use exponential backoff for retries but cap at 10 seconds
-				backoff := time.Duration(consecutiveErr/2) * time.Second
-				if backoff > 10*time.Second {
-					backoff = 10 * time.Second
-				}
-
-				time.Sleep(backoff)
+				// Back off following the retry policy, with jitter
+				time.Sleep(wait)
 				continue
 			}
 
 			// Reset consecutive errors on success
-			if consecutiveErr > 0 {
-				fmt.Printf("\nResumed progress monitoring after %d errors", consecutiveErr)
-				consecutiveErr = 0
+			if retry.Retries() > 0 {
+				out.printf("\nResumed progress monitoring after %d errors", retry.Retries())
+				retry.Reset()
 				lastErrorMsg = ""
 			}
 
@@ -345,42 +701,22 @@ func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize
 			var respData map[string]interface{}
 			if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
 				resp.Body.Close()
-				fmt.Printf("\nError parsing progress response: %v. Retrying...", err)
+				out.printf("\nError parsing progress response: %v. Retrying...", err)
 				continue
 			}
 			resp.Body.Close()
 
 			// Check if the transfer is still in progress
 			if transferring, ok := respData["Transferring"].(map[string]interface{}); ok {
-				// Extract the transfer ID
-				var id float64
-				if idFloat, ok := transferring["id"].(float64); ok {
-					id = idFloat
-				} else if idStr, ok := transferring["id"].(string); ok {
-					idInt, err := strconv.ParseInt(idStr, 10, 64)
-					if err != nil {
-						continue
-					}
-					id = float64(idInt)
-				} else {
-					continue
-				}
-
 				// Verify the ID matches our handle - just log and continue if not
-				if int(id) != handle {
+				id, ok := jsonInt(transferring["id"])
+				if !ok || int(id) != handle {
 					continue
 				}
 
 				// Extract bytes written
-				var bytesWritten int64
-				if bytesFloat, ok := transferring["bytes_written"].(float64); ok {
-					bytesWritten = int64(bytesFloat)
-				} else if bytesStr, ok := transferring["bytes_written"].(string); ok {
-					bytesWritten, err = strconv.ParseInt(bytesStr, 10, 64)
-					if err != nil {
-						continue
-					}
-				} else {
+				bytesWritten, ok := jsonInt(transferring["bytes_written"])
+				if !ok {
 					continue
 				}
 
@@ -389,49 +725,20 @@ func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize
 
 				// Calculate progress
 				if bytesWritten >= fileSize {
+					reporter.report(PhaseVerifying, fileSize, fileSize)
 					if !verifying {
-						fmt.Println("\nVerifying checksum...")
+						out.printf("\nVerifying checksum...\n")
 						verifying = true
 					}
 				} else {
 					progress := float64(bytesWritten) / float64(fileSize) * 100
 
-					// Calculate speed
-					now := time.Now()
-					elapsed := now.Sub(lastUpdateTime)
-
-					var speed float64
-					if elapsed.Seconds() > 0 && lastBytes > 0 {
-						// Calculate current speed
-						currentSpeed := float64(bytesWritten-lastBytes) / elapsed.Seconds()
-
-						// Add to speed window (up to 5 samples)
-						speedWindow = append(speedWindow, currentSpeed)
-						if len(speedWindow) > 5 {
-							speedWindow = speedWindow[1:]
-						}
-
-						// Calculate average speed from window
-						var totalSpeed float64
-						for _, s := range speedWindow {
-							totalSpeed += s
-						}
-						speed = totalSpeed / float64(len(speedWindow))
-					}
-
-					// Update tracking variables
-					lastBytes = bytesWritten
-					lastUpdateTime = now
-
-					// Calculate ETA
+					// Calculate speed and ETA
+					speed, eta := tracker.update(bytesWritten, time.Now())
 					totalElapsed := time.Since(startTime)
-					var eta time.Duration
 
-					if speed > 0 {
-						remainingBytes := float64(fileSize - bytesWritten)
-						etaSeconds := remainingBytes / speed
-						eta = time.Duration(etaSeconds) * time.Second
-					}
+					reporter.speed, reporter.eta = speed, eta
+					reporter.report(PhaseTransferring, bytesWritten, fileSize)
 
 					etaStr := "calculating..."
 					if eta > 0 {
@@ -444,7 +751,7 @@ func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize
 					}
 
 					// Use a carriage return to overwrite the current line
-					fmt.Printf("\rProgress: %.1f%% (%s / %s) • Speed: %s • Elapsed: %s • ETA: %s    ",
+					out.bar("\rProgress: %.1f%% (%s / %s) • Speed: %s • Elapsed: %s • ETA: %s    ",
 						progress,
 						formatBytes(bytesWritten),
 						formatBytes(fileSize),
@@ -459,7 +766,7 @@ func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize
 
 			// Check if done
 			if _, ok := respData["Done"]; ok {
-				fmt.Println("\nFlashing completed successfully")
+				out.printf("\nFlashing completed successfully\n")
 				return nil
 			}
 
@@ -469,13 +776,33 @@ func (c *Client) watchFlashingProgress(ctx context.Context, handle int, fileSize
 			}
 
 			// If we don't recognize the response, log and continue
-			fmt.Printf("\rWaiting for flashing to complete...")
+			out.bar("\rWaiting for flashing to complete...")
 		}
 	}
 }
 
+// LocalFlashOptions contains the options of FlashNodeLocalWithOptions
+type LocalFlashOptions struct {
+	// UploadFrom is a file of the client copied to the image path on the BMC over SFTP
+	// before flashing, once the BMC volume of the path is known to have room for it
+	UploadFrom string
+	// SkipSpaceCheck uploads UploadFrom without checking the free space of the BMC
+	SkipSpaceCheck bool
+	// How messages are printed, ProgressHuman by default
+	ProgressFormat ProgressFormat
+	// Where messages are printed, os.Stdout by default
+	ProgressWriter io.Writer
+}
+
 // FlashNodeLocal flashes a node with an image file that is accessible from the BMC
 func (c *Client) FlashNodeLocal(node int, imagePath string) error {
+	return c.FlashNodeLocalWithOptions(node, imagePath, nil)
+}
+
+// FlashNodeLocalWithOptions flashes a node with an image file that is accessible from the
+// BMC, first uploading it there with options.UploadFrom. An upload the BMC has no room for
+// is refused with an InsufficientSpaceError.
+func (c *Client) FlashNodeLocalWithOptions(node int, imagePath string, options *LocalFlashOptions) error {
 	if node < 1 || node > 4 {
 		return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
 	}
@@ -484,6 +811,17 @@ func (c *Client) FlashNodeLocal(node int, imagePath string) error {
 		return fmt.Errorf("image path is required")
 	}
 
+	if options == nil {
+		options = &LocalFlashOptions{}
+	}
+	out := newFlashOutput(options.ProgressFormat, options.ProgressWriter)
+
+	if options.UploadFrom != "" {
+		if err := c.uploadLocalImage(options, imagePath, out); err != nil {
+			return err
+		}
+	}
+
 	// Create a new request
 	req, err := c.newRequest()
 	if err != nil {
@@ -493,38 +831,105 @@ func (c *Client) FlashNodeLocal(node int, imagePath string) error {
 	// Add query parameters
 	req.AddQueryParam("opt", "set")
 	req.AddQueryParam("type", "update")
-	req.AddQueryParam("node", strconv.Itoa(node-1)) // BMC uses 0-based indexing
+	req.AddQueryParam("node", toBMCNodeIndex(node))
 	req.AddQueryParam("path", imagePath)
 
 	// Send the request with retry logic
-	for attempts := 0; attempts < 3; attempts++ {
-		resp, err := req.Send()
-		if err != nil {
-			if attempts < 2 {
-				fmt.Printf("Error sending request: %v. Retrying in 3 seconds...\n", err)
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			return fmt.Errorf("failed to send request after retries: %w", err)
+	retry := newBackoff(c.retryPolicy(DefaultFlashInitRetryPolicy))
+	for {
+		err := sendLocalFlash(req)
+		if err == nil {
+			break
+		}
+		if retry.Retries() >= flashInitAttempts-1 {
+			return err
 		}
-		defer resp.Body.Close()
 
-		// Check for errors in the response
-		if err := checkResponseError(resp); err != nil {
-			if attempts < 2 {
-				fmt.Printf("Error in response: %v. Retrying in 3 seconds...\n", err)
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			return fmt.Errorf("flash operation failed: %w", err)
+		wait := retry.Next()
+		out.printf("Error starting flash operation: %v. Retrying in %s...\n", err, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+
+	out.printf("Flash operation completed successfully\n")
+	c.emit(EventFlash, node, imagePath)
+	return nil
+}
+
+// uploadLocalImage uploads options.UploadFrom to imagePath on the BMC, checking first that
+// it fits. A check that can't be made is only warned about.
+func (c *Client) uploadLocalImage(options *LocalFlashOptions, imagePath string, out *flashOutput) error {
+	info, err := os.Stat(options.UploadFrom)
+	if err != nil {
+		return fmt.Errorf("failed to get image file info: %w", err)
+	}
+
+	if !options.SkipSpaceCheck {
+		err := c.checkBMCSpace(imagePath, info.Size())
+		var spaceErr *InsufficientSpaceError
+		if errors.As(err, &spaceErr) {
+			return err
+		}
+		if err != nil {
+			out.printf("Warning: couldn't check the free space of the BMC: %v\n", err)
 		}
+	}
 
-		// If we get here, the operation was successful
-		fmt.Println("Flash operation completed successfully")
-		return nil
+	out.printf("Uploading %s to the BMC at %s...\n", options.UploadFrom, imagePath)
+	if err := c.UploadFile(options.UploadFrom, imagePath); err != nil {
+		return fmt.Errorf("failed to upload image to the BMC: %w", err)
+	}
+	return nil
+}
+
+// sendLocalFlash sends the request flashing a node from an image on the BMC
+func sendLocalFlash(req *Request) error {
+	resp, err := req.Send()
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	return fmt.Errorf("failed to complete flash operation after maximum retries")
+	// Check for errors in the response
+	if err := checkResponseError(resp); err != nil {
+		return fmt.Errorf("flash operation failed: %w", err)
+	}
+	return nil
+}
+
+// parseFlashHandle extracts the transfer handle from the BMC's answer to a flash
+// request. Depending on the firmware the handle is a JSON number or a string.
+func parseFlashHandle(respData map[string]interface{}) (int, error) {
+	value, ok := respData["handle"]
+	if !ok {
+		return 0, ErrInvalidHandle
+	}
+	handle, ok := jsonInt(value)
+	if !ok || handle < 0 {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidHandle, value)
+	}
+	return int(handle), nil
+}
+
+// jsonInt returns a decoded JSON integer the BMC sent either as a number or as a string
+func jsonInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, false
+		}
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return n, err == nil
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
 }
 
 // formatBytes formats bytes into human-readable format
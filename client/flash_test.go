@@ -0,0 +1,472 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlashPhaseSequence(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	// Successive answers of the BMC to the flash status request
+	statuses := []string{
+		`{"Transferring":{"id":7,"bytes_written":40}}`,
+		`{"Transferring":{"id":7,"bytes_written":40}}`,
+		`{"Transferring":{"id":7,"bytes_written":80}}`,
+		`{"Transferring":{"id":7,"bytes_written":100}}`,
+		`{"Transferring":{"id":7,"bytes_written":100}}`,
+		`{"Done":{}}`,
+	}
+	var mu sync.Mutex
+	polls := 0
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			mu.Lock()
+			status := statuses[min(polls, len(statuses)-1)]
+			polls++
+			mu.Unlock()
+			w.Write([]byte(status))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	type update struct {
+		phase        FlashPhase
+		bytes, total int64
+	}
+	var updates []update
+	progress := func(phase FlashPhase, bytes, total int64) {
+		updates = append(updates, update{phase, bytes, total})
+	}
+
+	if err := client.watchFlashingProgress(context.Background(), 7, 100, progress, newFlashOutput(ProgressHuman, io.Discard)); err != nil {
+		t.Fatalf("watchFlashingProgress failed: %v", err)
+	}
+
+	expected := []update{
+		{PhaseInit, 0, 100},
+		{PhaseTransferring, 40, 100},
+		{PhaseTransferring, 80, 100},
+		{PhaseVerifying, 100, 100},
+		{PhaseDone, 100, 100},
+	}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("Expected updates %v, got %v", expected, updates)
+	}
+}
+
+func TestFlashPhaseError(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			w.Write([]byte(`{"Error":{"message":"checksum mismatch"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	var phases []FlashPhase
+	err := client.watchFlashingProgress(context.Background(), 7, 100, func(phase FlashPhase, bytes, total int64) {
+		phases = append(phases, phase)
+	}, newFlashOutput(ProgressHuman, io.Discard))
+	if err == nil {
+		t.Fatal("Expected an error from the BMC")
+	}
+
+	if expected := []FlashPhase{PhaseInit, PhaseError}; !reflect.DeepEqual(phases, expected) {
+		t.Errorf("Expected phases %v, got %v", expected, phases)
+	}
+}
+
+func TestFlashEnsureFlashMode(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	var mu sync.Mutex
+	var steps []string
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+			steps = append(steps, "upload")
+		case query.Get("type") == "usb" && query.Get("opt") == "get":
+			w.Write([]byte(`{"result":[{"node":"Node1","mode":"Host","route":"AlpineUsb"}]}`))
+		case query.Get("type") == "usb" && query.Get("opt") == "set":
+			steps = append(steps, "usb node="+query.Get("node")+" mode="+query.Get("mode"))
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			steps = append(steps, "flash")
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash" && query.Get("opt") == "get":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":1,"node3":1,"node4":1}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	path, _ := writeImage(t, "image content")
+	if err := client.FlashNode(3, &FlashOptions{ImagePath: path, EnsureFlashMode: true}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+
+	// Flash mode routed to the BMC for node 3, then host mode on USB-A for node 1 again
+	expected := []string{"usb node=2 mode=6", "flash", "upload", "usb node=0 mode=0"}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Expected steps %v, got %v", expected, steps)
+	}
+}
+
+func TestFlashEnsureFlashModeAlreadySet(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	usbSets := 0
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+		case query.Get("type") == "usb" && query.Get("opt") == "get":
+			w.Write([]byte(`{"result":[{"node":"Node2","mode":"Flash","route":"BMC"}]}`))
+		case query.Get("type") == "usb":
+			usbSets++
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":1,"node3":1,"node4":1}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	path, _ := writeImage(t, "image content")
+	if err := client.FlashNode(2, &FlashOptions{ImagePath: path, EnsureFlashMode: true}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+	if usbSets != 0 {
+		t.Errorf("Expected the USB mode to be left alone, got %d changes", usbSets)
+	}
+}
+
+// newPowerCheckBMC starts a mock BMC where node 3 is powered off until powered on,
+// and records the power changes and flashes
+func newPowerCheckBMC(t *testing.T) (*Client, func() []string) {
+	t.Helper()
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	var mu sync.Mutex
+	var steps []string
+	nodeOn := false
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			node3 := 0
+			if nodeOn {
+				node3 = 1
+			}
+			fmt.Fprintf(w, `{"response":[{"result":[{"node1":1,"node2":1,"node3":%d,"node4":1}]}]}`, node3)
+		case query.Get("type") == "power":
+			steps = append(steps, "power node3="+query.Get("node3"))
+			nodeOn = query.Get("node3") == "1"
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			steps = append(steps, "flash")
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), steps...)
+	}
+}
+
+func TestFlashPoweredOffNode(t *testing.T) {
+	client, steps := newPowerCheckBMC(t)
+	path, _ := writeImage(t, "image content")
+
+	err := client.FlashNode(3, &FlashOptions{ImagePath: path, ProgressWriter: io.Discard})
+	var offErr *NodePoweredOffError
+	if !errors.As(err, &offErr) || offErr.Node != 3 {
+		t.Fatalf("Expected a NodePoweredOffError for node 3, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "node 3 is powered off") {
+		t.Errorf("Expected the error to say the node is off, got: %v", err)
+	}
+	if got := steps(); len(got) != 0 {
+		t.Errorf("Expected nothing to be flashed or powered, got %v", got)
+	}
+}
+
+func TestFlashAutoPower(t *testing.T) {
+	client, steps := newPowerCheckBMC(t)
+	path, _ := writeImage(t, "image content")
+
+	var out bytes.Buffer
+	if err := client.FlashNode(3, &FlashOptions{ImagePath: path, AutoPower: true, ProgressWriter: &out}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+	if expected := []string{"power node3=1", "flash"}; !reflect.DeepEqual(steps(), expected) {
+		t.Errorf("Expected steps %v, got %v", expected, steps())
+	}
+	if !strings.Contains(out.String(), "Node 3 is powered off, powering it on") {
+		t.Errorf("Expected the power on to be reported, got %q", out.String())
+	}
+
+	// A node that is already on is flashed as is
+	if err := client.FlashNode(3, &FlashOptions{ImagePath: path, AutoPower: true, ProgressWriter: io.Discard}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+	if expected := []string{"power node3=1", "flash", "flash"}; !reflect.DeepEqual(steps(), expected) {
+		t.Errorf("Expected steps %v, got %v", expected, steps())
+	}
+}
+
+func TestFlashUnreadablePowerStatus(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	var flashes atomic.Int32
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+		case query.Get("type") == "power":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			flashes.Add(1)
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	path, _ := writeImage(t, "image content")
+
+	// Without AutoPower, a node that may be off isn't flashed
+	err := client.FlashNode(3, &FlashOptions{ImagePath: path, ProgressWriter: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), "power status of node 3") {
+		t.Fatalf("Expected the power status error, got: %v", err)
+	}
+	if flashes.Load() != 0 {
+		t.Errorf("Expected nothing to be flashed, got %d flashes", flashes.Load())
+	}
+
+	// With AutoPower, the flash goes ahead after a warning
+	var out bytes.Buffer
+	if err := client.FlashNode(3, &FlashOptions{ImagePath: path, AutoPower: true, ProgressWriter: &out}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+	if flashes.Load() != 1 {
+		t.Errorf("Expected the node to be flashed, got %d flashes", flashes.Load())
+	}
+	if !strings.Contains(out.String(), "Warning: couldn't read the power status of node 3") {
+		t.Errorf("Expected a warning, got %q", out.String())
+	}
+}
+
+func TestFlashProgressJSON(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	path, _ := writeImage(t, strings.Repeat("x", 100))
+	statuses := []string{
+		`{"Transferring":{"id":7,"bytes_written":40}}`,
+		`{"Transferring":{"id":7,"bytes_written":80}}`,
+		`{"Transferring":{"id":7,"bytes_written":100}}`,
+		`{"Done":{}}`,
+	}
+	var mu sync.Mutex
+	polls := 0
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			mu.Lock()
+			status := statuses[min(polls, len(statuses)-1)]
+			polls++
+			mu.Unlock()
+			w.Write([]byte(status))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":1,"node3":1,"node4":1}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	var out bytes.Buffer
+	err := client.FlashNode(1, &FlashOptions{ImagePath: path, ProgressFormat: ProgressJSON, ProgressWriter: &out})
+	if err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+
+	if strings.Contains(out.String(), "\r") {
+		t.Errorf("Expected no carriage returns in JSON progress, got %q", out.String())
+	}
+
+	var phases []string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var update map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &update); err != nil {
+			t.Fatalf("Line %q is not a JSON object: %v", line, err)
+		}
+		for _, field := range []string{"phase", "bytes", "total", "speed", "eta"} {
+			if _, ok := update[field]; !ok {
+				t.Errorf("Line %q is missing %q", line, field)
+			}
+		}
+		if _, ok := update["message"]; !ok {
+			phases = append(phases, update["phase"].(string))
+		}
+	}
+
+	expected := []string{"init", "transferring", "transferring", "verifying", "done"}
+	if !reflect.DeepEqual(phases, expected) {
+		t.Errorf("Expected updates for phases %v, got %v", expected, phases)
+	}
+}
+
+func TestFlashHandleAsString(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	var uploaded atomic.Bool
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+			uploaded.Store(true)
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			w.Write([]byte(`{"handle":"7"}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":1,"node3":1,"node4":1}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	path, _ := writeImage(t, "image content")
+	if err := client.FlashNode(1, &FlashOptions{ImagePath: path}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+	if !uploaded.Load() {
+		t.Error("Expected the image to be uploaded to the handle given as a string")
+	}
+}
+
+func TestParseFlashHandle(t *testing.T) {
+	tests := []struct {
+		body string
+		want int
+		ok   bool
+	}{
+		{`{"handle":7}`, 7, true},
+		{`{"handle":"7"}`, 7, true},
+		{`{"handle":" 12 "}`, 12, true},
+		{`{"handle":0}`, 0, true},
+		{`{}`, 0, false},
+		{`{"handle":null}`, 0, false},
+		{`{"handle":"seven"}`, 0, false},
+		{`{"handle":7.5}`, 0, false},
+		{`{"handle":-1}`, 0, false},
+	}
+
+	for _, tt := range tests {
+		var respData map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.body), &respData); err != nil {
+			t.Fatalf("Bad test body %s: %v", tt.body, err)
+		}
+
+		got, err := parseFlashHandle(respData)
+		if tt.ok {
+			if err != nil || got != tt.want {
+				t.Errorf("%s: expected handle %d, got %d, %v", tt.body, tt.want, got, err)
+			}
+		} else if !errors.Is(err, ErrInvalidHandle) {
+			t.Errorf("%s: expected ErrInvalidHandle, got %d, %v", tt.body, got, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import "fmt"
+
+// FirmwareOperation names a BMC operation that can be broken on some firmware versions
+type FirmwareOperation string
+
+// Operations checked against the known incompatibilities
+const (
+	FirmwareOpUsbFlashMode    FirmwareOperation = "usb-flash-mode"
+	FirmwareOpFlash           FirmwareOperation = "flash"
+	FirmwareOpMsd             FirmwareOperation = "msd"
+	FirmwareOpFirmwareUpgrade FirmwareOperation = "firmware-upgrade"
+)
+
+// Incompatibility records an operation known to be broken on a range of firmware versions
+type Incompatibility struct {
+	Operation FirmwareOperation
+	// From and To are the first and last affected versions, empty for an open range
+	From string
+	To   string
+	// Reason describes the firmware bug, shown to the user before running the operation
+	Reason string
+}
+
+// DefaultIncompatibilities are the firmware bugs known to break an operation. It is empty
+// until a bug is confirmed upstream, callers that know of one add it with WithIncompatibilities.
+var DefaultIncompatibilities = []Incompatibility{}
+
+// WithIncompatibilities replaces the firmware bugs checked by CheckCompatibility,
+// DefaultIncompatibilities by default. Pass append(DefaultIncompatibilities, ...) to extend them.
+func WithIncompatibilities(incompatibilities ...Incompatibility) Option {
+	return func(c *Client) {
+		for _, entry := range incompatibilities {
+			if err := entry.validate(); err != nil {
+				c.optionErr = err
+				return
+			}
+		}
+		c.incompatibilities = incompatibilities
+	}
+}
+
+// Affects reports whether the operation is broken on the given firmware version.
+// Versions that can't be parsed are never affected.
+func (i Incompatibility) Affects(version string, op FirmwareOperation) bool {
+	if i.Operation != op {
+		return false
+	}
+	parsed, ok := parseFirmwareVersion(version)
+	if !ok {
+		return false
+	}
+	if from, ok := parseFirmwareVersion(i.From); ok && compareVersions(parsed, from) < 0 {
+		return false
+	}
+	if to, ok := parseFirmwareVersion(i.To); ok && compareVersions(parsed, to) > 0 {
+		return false
+	}
+	return true
+}
+
+// validate checks that the bounds of the entry are versions
+func (i Incompatibility) validate() error {
+	for _, bound := range []string{i.From, i.To} {
+		if _, ok := parseFirmwareVersion(bound); bound != "" && !ok {
+			return fmt.Errorf("invalid firmware version %q for %s incompatibility", bound, i.Operation)
+		}
+	}
+	return nil
+}
+
+// FindIncompatibility returns the first entry of incompatibilities breaking op on the given firmware version
+func FindIncompatibility(incompatibilities []Incompatibility, version string, op FirmwareOperation) (Incompatibility, bool) {
+	for _, entry := range incompatibilities {
+		if entry.Affects(version, op) {
+			return entry, true
+		}
+	}
+	return Incompatibility{}, false
+}
+
+// concernsOperation reports whether an entry of incompatibilities is about op
+func concernsOperation(incompatibilities []Incompatibility, op FirmwareOperation) bool {
+	for _, entry := range incompatibilities {
+		if entry.Operation == op {
+			return true
+		}
+	}
+	return false
+}
+
+// IncompatibilityError reports an operation known to be broken on the firmware of the BMC
+type IncompatibilityError struct {
+	Incompatibility
+	FirmwareVersion string
+}
+
+func (e *IncompatibilityError) Error() string {
+	return fmt.Sprintf("%s is known to be broken on firmware %s: %s", e.Operation, e.FirmwareVersion, e.Reason)
+}
+
+// CheckCompatibility returns an IncompatibilityError if op is known to be broken on the
+// firmware of the BMC, and nil otherwise. The firmware version comes from About, which
+// isn't asked for when no known incompatibility concerns op.
+func (c *Client) CheckCompatibility(op FirmwareOperation) error {
+	incompatibilities := c.incompatibilities
+	if incompatibilities == nil {
+		incompatibilities = DefaultIncompatibilities
+	}
+	if !concernsOperation(incompatibilities, op) {
+		return nil
+	}
+
+	about, err := c.About()
+	if err != nil {
+		return err
+	}
+	version := about["version"]
+
+	if entry, ok := FindIncompatibility(incompatibilities, version, op); ok {
+		return &IncompatibilityError{Incompatibility: entry, FirmwareVersion: version}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// newVersionClient returns a client for a BMC reporting the given firmware version
+func newVersionClient(t *testing.T, version string, opts ...Option) *Client {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		w.Write([]byte(`{"response":[{"result":{"api":"1.1","version":"` + version + `"}}]}`))
+	}), opts...)
+	return client
+}
+
+// usbFlashModeBug is an incompatibility of USB flash mode up to firmware 1.1.0
+var usbFlashModeBug = Incompatibility{Operation: FirmwareOpUsbFlashMode, To: "1.1.0", Reason: "flash mode hangs"}
+
+func TestCheckCompatibility(t *testing.T) {
+	// Nothing is known to be broken by default
+	if err := newVersionClient(t, "1.0.2").CheckCompatibility(FirmwareOpUsbFlashMode); err != nil {
+		t.Errorf("Expected no default incompatibility, got: %v", err)
+	}
+
+	err := newVersionClient(t, "1.0.2", WithIncompatibilities(usbFlashModeBug)).CheckCompatibility(FirmwareOpUsbFlashMode)
+	var incompatErr *IncompatibilityError
+	if !errors.As(err, &incompatErr) {
+		t.Fatalf("Expected an IncompatibilityError, got: %v", err)
+	}
+	if incompatErr.FirmwareVersion != "1.0.2" || incompatErr.Operation != FirmwareOpUsbFlashMode {
+		t.Errorf("Unexpected incompatibility: %+v", incompatErr)
+	}
+
+	if err := newVersionClient(t, "2.0.5", WithIncompatibilities(usbFlashModeBug)).CheckCompatibility(FirmwareOpUsbFlashMode); err != nil {
+		t.Errorf("Expected no incompatibility on 2.0.5, got: %v", err)
+	}
+	if err := newVersionClient(t, "1.0.2", WithIncompatibilities(usbFlashModeBug)).CheckCompatibility(FirmwareOpFlash); err != nil {
+		t.Errorf("Expected flashing to be unaffected, got: %v", err)
+	}
+}
+
+func TestCheckCompatibilitySkipsAbout(t *testing.T) {
+	var requests atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		requests.Add(1)
+		w.Write([]byte(`{"response":[{"result":{"api":"1.1","version":"1.0.2"}}]}`))
+	})
+
+	// Without a known incompatibility of the operation, the firmware version isn't needed
+	for _, opts := range [][]Option{nil, {WithIncompatibilities(usbFlashModeBug)}} {
+		client, _ := newMockClient(t, handler, opts...)
+		if err := client.CheckCompatibility(FirmwareOpFlash); err != nil {
+			t.Errorf("Expected no incompatibility, got: %v", err)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("Expected no request to the BMC, got %d", n)
+	}
+}
+
+func TestWithIncompatibilities(t *testing.T) {
+	custom := Incompatibility{Operation: FirmwareOpFlash, From: "2.0.3", To: "2.0.5", Reason: "flashing stalls"}
+	client := newVersionClient(t, "2.0.5", WithIncompatibilities(usbFlashModeBug, custom))
+	if err := client.CheckCompatibility(FirmwareOpFlash); err == nil {
+		t.Error("Expected the added incompatibility to match")
+	}
+
+	// Replacing the table drops the previous entries
+	client = newVersionClient(t, "1.0.2", WithIncompatibilities(usbFlashModeBug), WithIncompatibilities(custom))
+	if err := client.CheckCompatibility(FirmwareOpUsbFlashMode); err != nil {
+		t.Errorf("Expected the incompatibility to be replaced, got: %v", err)
+	}
+
+	if _, err := NewClient(WithHost("bmc"), WithIncompatibilities(Incompatibility{From: "not-a-version"})); err == nil {
+		t.Error("Expected an invalid version bound to be rejected")
+	}
+}
+
+func TestIncompatibilityAffects(t *testing.T) {
+	entry := Incompatibility{Operation: FirmwareOpMsd, From: "2.0.0", To: "2.0.2"}
+	tests := map[string]bool{
+		"1.9":       false,
+		"2.0.0":     true,
+		"v2.0.2":    true,
+		"2.0.1-dev": true,
+		"2.0.3":     false,
+		"unknown":   false,
+	}
+	for version, want := range tests {
+		if got := entry.Affects(version, FirmwareOpMsd); got != want {
+			t.Errorf("Affects(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// BoardInfo is the typed form of the board info returned by Info
+type BoardInfo struct {
+	// Version of the BMC API
+	Api string
+	// Version of the BMC firmware
+	Version string
+	// Build version and time of the firmware
+	BuildVersion string
+	BuildTime    string
+	// Network address of the BMC
+	IP  string
+	MAC string
+	// Time since the BMC booted, zero when not reported or not a number
+	Uptime time.Duration
+	// Every field as reported, including the ones not parsed above
+	Fields map[string]string
+}
+
+// BoardInfo returns the board info with the known fields parsed
+func (c *Client) BoardInfo() (*BoardInfo, error) {
+	info, err := c.Info()
+	if err != nil {
+		return nil, err
+	}
+	return ParseBoardInfo(info), nil
+}
+
+// ParseBoardInfo parses the known fields of the info returned by Info. A field that
+// doesn't parse is left at its zero value, its raw value is still in Fields.
+func ParseBoardInfo(info map[string]string) *BoardInfo {
+	board := &BoardInfo{
+		Api:          info["api"],
+		Version:      info["version"],
+		BuildVersion: info["build_version"],
+		BuildTime:    info["buildtime"],
+		IP:           info["ip"],
+		MAC:          info["mac"],
+		Fields:       info,
+	}
+
+	// Uptime is reported in seconds
+	if uptime := info["uptime"]; uptime != "" {
+		if seconds, err := strconv.ParseFloat(uptime, 64); err == nil {
+			board.Uptime = time.Duration(seconds * float64(time.Second))
+		} else {
+			Debug("Ignoring invalid uptime %q: %v", uptime, err)
+		}
+	}
+
+	return board
+}
+
+// infoValueString returns the string form of a value of an info response. Strings are
+// kept as is, numbers are written without exponent, null is empty and anything else
+// is written back as JSON.
+func infoValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		out, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(out)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestInfoMixedTypes(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			w.Write([]byte(`{"response":[{"result":[{
+				"api": "1.1",
+				"version": "2.0.5",
+				"ip": "192.168.1.91",
+				"uptime": 3725,
+				"load": 0.25,
+				"secure_boot": false,
+				"storage": {"free": 12},
+				"serial": null
+			}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	info, err := client.Info()
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"api":         "1.1",
+		"version":     "2.0.5",
+		"ip":          "192.168.1.91",
+		"uptime":      "3725",
+		"load":        "0.25",
+		"secure_boot": "false",
+		"storage":     `{"free":12}`,
+		"serial":      "",
+	}
+	for key, value := range expected {
+		if info[key] != value {
+			t.Errorf("Expected %s to be %q, got %q", key, value, info[key])
+		}
+	}
+
+	board, err := client.BoardInfo()
+	if err != nil {
+		t.Fatalf("BoardInfo failed: %v", err)
+	}
+	if board.Uptime != time.Hour+2*time.Minute+5*time.Second {
+		t.Errorf("Expected uptime 1h2m5s, got %s", board.Uptime)
+	}
+	if board.Api != "1.1" || board.Version != "2.0.5" || board.IP != "192.168.1.91" {
+		t.Errorf("Unexpected board info: %+v", board)
+	}
+}
+
+func TestParseBoardInfoInvalidUptime(t *testing.T) {
+	// The other fields are still parsed, the raw uptime kept
+	board := ParseBoardInfo(map[string]string{"uptime": "soon", "version": "2.0.5"})
+	if board.Uptime != 0 || board.Version != "2.0.5" || board.Fields["uptime"] != "soon" {
+		t.Errorf("Expected a non-numeric uptime to be skipped, got %+v", board)
+	}
+
+	board = ParseBoardInfo(map[string]string{"api": "1.1"})
+	if board.Uptime != 0 {
+		t.Errorf("Expected no uptime when not reported, got %s", board.Uptime)
+	}
+}
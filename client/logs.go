@@ -0,0 +1,248 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Interval between log reads while following the BMC log, a variable so tests can shorten it
+var logPollInterval = 2 * time.Second
+
+// logLevels ranks the log levels from the least to the most severe
+var logLevels = map[string]int{
+	"TRACE": 0,
+	"DEBUG": 1,
+	"INFO":  2,
+	"WARN":  3,
+	"ERROR": 4,
+}
+
+// LogEntry is a line of the BMC log
+type LogEntry struct {
+	// Time is when the entry was logged, zero if the line has no timestamp
+	Time time.Time
+	// Level is the severity of the entry, e.g. "INFO" or "ERROR", empty if unknown
+	Level string
+	// Message is the rest of the line
+	Message string
+}
+
+// String formats the entry like the BMC prints it
+func (e LogEntry) String() string {
+	var parts []string
+	if !e.Time.IsZero() {
+		parts = append(parts, e.Time.Format(time.RFC3339))
+	}
+	if e.Level != "" {
+		parts = append(parts, e.Level)
+	}
+	return strings.Join(append(parts, e.Message), " ")
+}
+
+// LogOptions filters the entries returned by Logs
+type LogOptions struct {
+	// Since drops entries logged before it, if set. Entries without a timestamp are kept.
+	Since time.Time
+	// Level drops entries less severe than it, if set. Entries without a known level are kept.
+	Level string
+}
+
+// Logs returns the entries of the BMC log buffer, oldest first.
+// It returns ErrUnsupported if the firmware doesn't expose its log.
+func (c *Client) Logs(opts LogOptions) ([]LogEntry, error) {
+	minLevel := -1
+	if opts.Level != "" {
+		rank, ok := logLevels[normalizeLogLevel(opts.Level)]
+		if !ok {
+			return nil, fmt.Errorf("invalid log level: %q (must be trace, debug, info, warn or error)", opts.Level)
+		}
+		minLevel = rank
+	}
+
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "log")
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return nil, ErrUnsupported
+		}
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var result json.RawMessage
+	found, err := decodeResult(resp, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if !found {
+		return []LogEntry{}, nil
+	}
+
+	entries, err := parseLogResult(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log: %w", err)
+	}
+
+	filtered := entries[:0]
+	for _, entry := range entries {
+		if !opts.Since.IsZero() && !entry.Time.IsZero() && entry.Time.Before(opts.Since) {
+			continue
+		}
+		if rank, ok := logLevels[entry.Level]; ok && rank < minLevel {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered, nil
+}
+
+// FollowLogs calls handle for every entry matching opts, first those already in the log
+// buffer and then new ones as they are logged, until ctx is done. Read errors after the
+// first read are retried on the next poll.
+func (c *Client) FollowLogs(ctx context.Context, opts LogOptions, handle func(LogEntry)) error {
+	var seen []LogEntry
+	for first := true; ; first = false {
+		entries, err := c.Logs(opts)
+		if err != nil {
+			if first || errors.Is(err, ErrUnsupported) {
+				return err
+			}
+			Debug("Failed to read BMC log: %v", err)
+		} else {
+			for _, entry := range logDelta(seen, entries) {
+				handle(entry)
+			}
+			seen = entries
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// logDelta returns the entries of after that weren't in before.
+// The log is a ring buffer, so the oldest entries of before may have been dropped from
+// after: the new entries are those following the longest end of before that after starts with.
+func logDelta(before, after []LogEntry) []LogEntry {
+	for n := min(len(before), len(after)); n > 0; n-- {
+		if slices.Equal(before[len(before)-n:], after[:n]) {
+			return after[n:]
+		}
+	}
+	return after
+}
+
+// parseLogResult parses the log result, either the whole buffer as a single string or
+// a list of lines or entry objects
+func parseLogResult(raw json.RawMessage) ([]LogEntry, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return []LogEntry{}, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return parseLogLines(strings.Split(text, "\n")), nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("unexpected log format: %w", err)
+	}
+
+	entries := make([]LogEntry, 0, len(items))
+	for _, item := range items {
+		var line string
+		if err := json.Unmarshal(item, &line); err == nil {
+			entries = append(entries, parseLogLines([]string{line})...)
+			continue
+		}
+
+		var obj struct {
+			Time    string `json:"time"`
+			Level   string `json:"level"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(item, &obj); err != nil {
+			return nil, fmt.Errorf("unexpected log entry %s: %w", item, err)
+		}
+		entry := LogEntry{Level: normalizeLogLevel(obj.Level), Message: obj.Message}
+		if t, err := time.Parse(time.RFC3339Nano, obj.Time); err == nil {
+			entry.Time = t
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseLogLines parses lines like "2024-05-01T10:00:00Z INFO bmcd: started", skipping
+// blank ones. A line without a timestamp is kept whole as the message.
+func parseLogLines(lines []string) []LogEntry {
+	entries := make([]LogEntry, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		entry := LogEntry{Message: line}
+		timestamp, rest, _ := strings.Cut(line, " ")
+		if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			entry.Time = t
+			entry.Message = strings.TrimSpace(rest)
+			level, message, _ := strings.Cut(entry.Message, " ")
+			if _, ok := logLevels[normalizeLogLevel(level)]; ok {
+				entry.Level = normalizeLogLevel(level)
+				entry.Message = strings.TrimSpace(message)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// normalizeLogLevel returns level in upper case, with WARNING shortened to WARN
+func normalizeLogLevel(level string) string {
+	level = strings.ToUpper(strings.TrimSpace(level))
+	if level == "WARNING" {
+		return "WARN"
+	}
+	return level
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLogClient returns a client for a mock BMC whose log result is the value returned by payload
+func newLogClient(t *testing.T, payload func() string) *Client {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			if r.URL.Query().Get("type") != "log" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":` + payload() + `}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return client
+}
+
+func TestLogs(t *testing.T) {
+	payload := `"2024-05-01T10:00:00Z  INFO bmcd::app: started\n` +
+		`2024-05-01T10:05:00.5Z WARNING bmcd::power: node 2 slow\n` +
+		`\n` +
+		`kernel: usb 1-1: new device\n` +
+		`2024-05-01T10:10:00Z ERROR bmcd::flash: write failed\n"`
+	client := newLogClient(t, func() string { return payload })
+
+	at := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	all := []LogEntry{
+		{Time: at("2024-05-01T10:00:00Z"), Level: "INFO", Message: "bmcd::app: started"},
+		{Time: at("2024-05-01T10:05:00.5Z"), Level: "WARN", Message: "bmcd::power: node 2 slow"},
+		{Message: "kernel: usb 1-1: new device"},
+		{Time: at("2024-05-01T10:10:00Z"), Level: "ERROR", Message: "bmcd::flash: write failed"},
+	}
+
+	tests := []struct {
+		name string
+		opts LogOptions
+		want []LogEntry
+	}{
+		{"all", LogOptions{}, all},
+		{"since", LogOptions{Since: at("2024-05-01T10:05:00Z")}, all[1:]},
+		{"level", LogOptions{Level: "warning"}, all[1:]},
+		{"since and level", LogOptions{Since: at("2024-05-01T10:06:00Z"), Level: "error"}, all[2:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.Logs(tt.opts)
+			if err != nil {
+				t.Fatalf("Logs failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Logs = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := client.Logs(LogOptions{Level: "loud"}); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+}
+
+func TestLogsEntryObjects(t *testing.T) {
+	client := newLogClient(t, func() string {
+		return `[{"time":"2024-05-01T10:00:00Z","level":"info","message":"started"},"2024-05-01T10:01:00Z DEBUG polling"]`
+	})
+
+	got, err := client.Logs(LogOptions{})
+	if err != nil {
+		t.Fatalf("Logs failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Level != "INFO" || got[0].Message != "started" || got[1].Level != "DEBUG" || got[1].Message != "polling" {
+		t.Errorf("Logs = %+v", got)
+	}
+	if want := "2024-05-01T10:00:00Z INFO started"; got[0].String() != want {
+		t.Errorf("String = %q, want %q", got[0].String(), want)
+	}
+}
+
+func TestLogsUnsupported(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		http.Error(w, "Invalid request type", http.StatusBadRequest)
+	}))
+
+	if _, err := client.Logs(LogOptions{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Logs error = %v, want ErrUnsupported", err)
+	}
+	if err := client.FollowLogs(context.Background(), LogOptions{}, func(LogEntry) {}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FollowLogs error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	interval := logPollInterval
+	logPollInterval = time.Millisecond
+	t.Cleanup(func() { logPollInterval = interval })
+
+	// The ring buffer holds three lines and drops the oldest as new ones are logged
+	reads := []string{
+		`["a","b","c"]`,
+		`["a","b","c"]`,
+		`["b","c","d"]`,
+		`["d","e","f"]`,
+	}
+	var mu sync.Mutex
+	read := 0
+	client := newLogClient(t, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		payload := reads[min(read, len(reads)-1)]
+		read++
+		return payload
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []string
+	err := client.FollowLogs(ctx, LogOptions{}, func(entry LogEntry) {
+		got = append(got, entry.Message)
+		if len(got) == 6 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("FollowLogs error = %v, want context.Canceled", err)
+	}
+	if want := []string{"a", "b", "c", "d", "e", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("followed %v, want %v", got, want)
+	}
+}
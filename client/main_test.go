@@ -2,6 +2,8 @@ package tpi
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 )
@@ -40,6 +42,13 @@ func loadTestConfig(t *testing.T) TestConfig {
 	if config.Host == "" {
 		t.Skip("Test config missing host field")
 	}
+	if config.ApiVersion != "" {
+		version, err := ParseApiVersion(config.ApiVersion)
+		if err != nil {
+			t.Fatalf("Invalid api_version in test config: %v", err)
+		}
+		config.ApiVersion = string(version)
+	}
 
 	return config
 }
@@ -85,3 +94,27 @@ func createTestClient(t *testing.T) *Client {
 
 	return client
 }
+
+// newMockClient starts a mock BMC serving the given handler over TLS and returns a client for it
+func newMockClient(t *testing.T, handler http.Handler, options ...Option) (*Client, *httptest.Server) {
+	t.Helper()
+
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	host := server.Listener.Addr().String()
+	t.Cleanup(func() { DeleteCachedToken(host) })
+
+	// Mock BMCs accept any credentials
+	options = append([]Option{
+		WithHost(host),
+		WithCredentials("root", "turing"),
+	}, options...)
+
+	client, err := NewClient(options...)
+	if err != nil {
+		t.Fatalf("Failed to create mock client: %v", err)
+	}
+
+	return client, server
+}
@@ -0,0 +1,421 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageCacheDir returns the directory downloaded images are cached in, named by their
+// SHA256, a variable so tests can move it
+var imageCacheDir = func() string {
+	return filepath.Join(CacheDir(), "images")
+}
+
+// imageHTTPClient downloads images and their checksums, a variable so tests can replace it
+var imageHTTPClient = &http.Client{
+	Timeout: imageDownloadTimeout,
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// imageDownloadTimeout bounds a whole image download, and imageStallTimeout the time
+// without receiving any byte of it
+var (
+	imageDownloadTimeout = 2 * time.Hour
+	imageStallTimeout    = time.Minute
+)
+
+// imageDownload is a download of FetchImage, shared by the callers asking for the same image
+type imageDownload struct {
+	done chan struct{}
+	path string
+	err  error
+
+	// waiters counts the callers waiting for the download, the last one to give up
+	// cancels it
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// imageDownloads are the downloads of this process in progress or succeeded, by URL and
+// expected checksum
+var (
+	imageDownloadsMu sync.Mutex
+	imageDownloads   = make(map[string]*imageDownload)
+)
+
+// FetchImage downloads the image at rawURL into the image cache and returns the path of
+// the cached copy, named by its SHA256. Concurrent and later calls for the same image share
+// one download, which is cancelled once every caller waiting for it gave up. With
+// expectedSha256, an image already cached under that checksum isn't downloaded again,
+// and a download that doesn't match it is discarded.
+func FetchImage(ctx context.Context, rawURL, expectedSha256 string) (string, error) {
+	expectedSha256 = strings.ToLower(strings.TrimSpace(expectedSha256))
+	if expectedSha256 != "" {
+		path := filepath.Join(imageCacheDir(), expectedSha256)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	key := rawURL + "\x00" + expectedSha256
+	imageDownloadsMu.Lock()
+	download, ok := imageDownloads[key]
+	if ok {
+		// A finished download only counts while its copy is still cached
+		select {
+		case <-download.done:
+			if _, err := os.Stat(download.path); err != nil {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		// The download outlives the context of the first caller, others may be waiting
+		downloadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		download = &imageDownload{done: make(chan struct{}), cancel: cancel}
+		imageDownloads[key] = download
+		go func() {
+			defer cancel()
+			download.path, download.err = downloadImage(downloadCtx, rawURL, expectedSha256)
+			if download.err != nil {
+				forgetImageDownload(key, download)
+			}
+			close(download.done)
+		}()
+	}
+	download.waiters++
+	imageDownloadsMu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		imageDownloadsMu.Lock()
+		download.waiters--
+		select {
+		case <-download.done:
+		default:
+			if download.waiters == 0 {
+				download.cancel()
+				if imageDownloads[key] == download {
+					delete(imageDownloads, key)
+				}
+			}
+		}
+		imageDownloadsMu.Unlock()
+		return "", ctx.Err()
+	case <-download.done:
+		imageDownloadsMu.Lock()
+		download.waiters--
+		imageDownloadsMu.Unlock()
+		return download.path, download.err
+	}
+}
+
+// forgetImageDownload removes a failed download, so the next call tries again
+func forgetImageDownload(key string, download *imageDownload) {
+	imageDownloadsMu.Lock()
+	defer imageDownloadsMu.Unlock()
+	if imageDownloads[key] == download {
+		delete(imageDownloads, key)
+	}
+}
+
+// downloadImage downloads rawURL into the image cache, hashing it on the way. It gives
+// up when no data arrives for imageStallTimeout.
+func downloadImage(ctx context.Context, rawURL, expectedSha256 string) (string, error) {
+	dir := imageCacheDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create image cache: %w", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stall := time.AfterFunc(imageStallTimeout, func() {
+		cancel(fmt.Errorf("no data received for %s", imageStallTimeout))
+	})
+	defer stall.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid image URL: %w", err)
+	}
+	resp, err := imageHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", downloadCause(ctx, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download image: %s", resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(dir, "download-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create image file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	body := &stallReader{r: resp.Body, stall: stall, timeout: imageStallTimeout}
+	_, err = io.Copy(io.MultiWriter(tmp, hasher), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", downloadCause(ctx, err))
+	}
+
+	calculated := hex.EncodeToString(hasher.Sum(nil))
+	if expectedSha256 != "" && calculated != expectedSha256 {
+		return "", fmt.Errorf("SHA256 checksum mismatch: expected %s, downloaded %s", expectedSha256, calculated)
+	}
+
+	path := filepath.Join(dir, calculated)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to cache image: %w", err)
+	}
+	return path, nil
+}
+
+// downloadCause returns why ctx was cancelled, such as a stall, in place of err
+func downloadCause(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+		return cause
+	}
+	return err
+}
+
+// stallReader pushes back the stall timer every time data arrives
+type stallReader struct {
+	r       io.Reader
+	stall   *time.Timer
+	timeout time.Duration
+}
+
+func (s *stallReader) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	if n > 0 {
+		s.stall.Reset(s.timeout)
+	}
+	return n, err
+}
+
+// fetchSidecarChecksum reads the expected SHA256 of the image at rawURL from <url>.sha256,
+// returning an empty checksum and no error when the server has none
+func fetchSidecarChecksum(ctx context.Context, rawURL string) (string, error) {
+	sidecarURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid image URL: %w", err)
+	}
+	sidecarURL.Path += ".sha256"
+	sidecarURL.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sidecarURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid image URL: %w", err)
+	}
+	resp, err := imageHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download checksum: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download checksum: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", fmt.Errorf("failed to download checksum: %w", err)
+	}
+	return parseChecksumFile(data, sidecarURL.String())
+}
+
+// FlashNodes flashes the same image to several nodes, one after the other since the BMC
+// handles a single transfer at a time. The image is hashed and verified once, before any
+// upload begins, then uploaded to every node from the same file. Progress lines are
+// prefixed with the node, and options.Progress is called for every node. A node that
+// fails doesn't stop the others, their errors are joined.
+func (c *Client) FlashNodes(nodes []int, options *FlashOptions) error {
+	if err := checkFlashNodes(nodes, options); err != nil {
+		return err
+	}
+
+	file, err := os.Open(options.ImagePath)
+	if err != nil {
+		return fmt.Errorf("failed to open image file: %w", err)
+	}
+	out := newFlashOutput(options.ProgressFormat, options.ProgressWriter)
+	sha, err := c.verifyImage(file, options, out)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	// Without an expected checksum, hash the image anyway so no node hashes it again
+	if sha == "" {
+		if sha, err = HashFile(options.ImagePath); err != nil {
+			return err
+		}
+	}
+
+	return c.flashNodes(nodes, options, sha)
+}
+
+// checkFlashNodes validates the arguments of FlashNodes
+func checkFlashNodes(nodes []int, options *FlashOptions) error {
+	if err := checkFlashTargets(nodes, options); err != nil {
+		return err
+	}
+	if options == nil || options.ImagePath == "" {
+		return fmt.Errorf("image path is required")
+	}
+	return nil
+}
+
+// checkFlashTargets validates the nodes to flash and the options that don't depend on the
+// image, so FlashNodesFromURL can check them before downloading it
+func checkFlashTargets(nodes []int, options *FlashOptions) error {
+	if len(nodes) == 0 {
+		return fmt.Errorf("at least one node is required")
+	}
+	seen := make(map[int]bool)
+	for _, node := range nodes {
+		if node < 1 || node > 4 {
+			return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+		}
+		if seen[node] {
+			return fmt.Errorf("node %d is listed twice", node)
+		}
+		seen[node] = true
+	}
+	if options != nil && options.ResumeHandle != 0 {
+		return fmt.Errorf("a resume handle belongs to a single node, use FlashNode")
+	}
+	return nil
+}
+
+// flashNodes flashes the image, already verified to have the checksum verifiedSha256,
+// to the nodes one after the other
+func (c *Client) flashNodes(nodes []int, options *FlashOptions, verifiedSha256 string) error {
+	writer := options.ProgressWriter
+	if writer == nil {
+		writer = os.Stdout
+	}
+	var writeMu sync.Mutex
+
+	var errs []error
+	for _, node := range nodes {
+		nodeOptions := *options
+		nodeOptions.SkipZeroBlocks = false // Scanned once by the verification
+		nodeOptions.ProgressWriter = &linePrefixWriter{mu: &writeMu, w: writer, prefix: fmt.Sprintf("[node %d] ", node), lineStart: true}
+
+		if err := c.flashNode(node, &nodeOptions, verifiedSha256); err != nil {
+			errs = append(errs, fmt.Errorf("node %d: %w", node, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FlashNodesFromURL downloads the image at rawURL once into the image cache, see FetchImage,
+// and flashes it to the nodes one after the other like FlashNodes. Without
+// options.SHA256, the checksum is read from <url>.sha256 if the server has one.
+// options.ImagePath is ignored.
+func (c *Client) FlashNodesFromURL(ctx context.Context, rawURL string, nodes []int, options *FlashOptions) error {
+	if err := checkFlashTargets(nodes, options); err != nil {
+		return err
+	}
+
+	var nodeOptions FlashOptions
+	if options != nil {
+		nodeOptions = *options
+	}
+
+	if nodeOptions.SHA256 == "" {
+		sidecar, err := fetchSidecarChecksum(ctx, rawURL)
+		if err != nil {
+			return err
+		}
+		nodeOptions.SHA256 = sidecar
+	}
+
+	path, err := FetchImage(ctx, rawURL, nodeOptions.SHA256)
+	if err != nil {
+		return err
+	}
+
+	// The cached copy is named by its checksum, which was verified by the download
+	nodeOptions.ImagePath = path
+	if err := checkFlashNodes(nodes, &nodeOptions); err != nil {
+		return err
+	}
+	return c.flashNodes(nodes, &nodeOptions, filepath.Base(path))
+}
+
+// FlashNodeFromURL downloads the image at rawURL into the image cache and flashes it to
+// the node. Concurrent calls for the same image share one download, see FetchImage.
+func (c *Client) FlashNodeFromURL(ctx context.Context, rawURL string, node int, options *FlashOptions) error {
+	return c.FlashNodesFromURL(ctx, rawURL, []int{node}, options)
+}
+
+// linePrefixWriter writes prefix at the start of every line, serializing the writes of
+// several writers sharing mu and w
+type linePrefixWriter struct {
+	mu        *sync.Mutex
+	w         io.Writer
+	prefix    string
+	lineStart bool
+}
+
+func (p *linePrefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var buf []byte
+	for _, ch := range b {
+		if p.lineStart && ch != '\n' && ch != '\r' {
+			buf = append(buf, p.prefix...)
+			p.lineStart = false
+		}
+		buf = append(buf, ch)
+		if ch == '\n' || ch == '\r' {
+			p.lineStart = true
+		}
+	}
+	if _, err := p.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
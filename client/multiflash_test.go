@@ -0,0 +1,312 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlashNodesFromURLDownloadsOnce(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+	cacheDir, restoreCacheDir := t.TempDir(), imageCacheDir
+	imageCacheDir = func() string { return cacheDir }
+	t.Cleanup(func() { imageCacheDir = restoreCacheDir })
+
+	image := bytes.Repeat([]byte("turing-pi image "), 4096)
+	sum := sha256.Sum256(image)
+	sha := hex.EncodeToString(sum[:])
+
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		w.Write(image)
+	}))
+	t.Cleanup(server.Close)
+
+	var mu sync.Mutex
+	uploads := make(map[string]int)
+	var active atomic.Int32
+	var overlapped atomic.Bool
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case strings.HasPrefix(r.URL.Path, "/api/bmc/upload/"):
+			if active.Add(1) > 1 {
+				overlapped.Store(true)
+			}
+			time.Sleep(5 * time.Millisecond)
+			active.Add(-1)
+			mu.Lock()
+			uploads[r.URL.Path]++
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		case query.Get("opt") == "set" && query.Get("type") == "flash":
+			if query.Get("sha256") != sha {
+				http.Error(w, "unexpected checksum", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"handle":%s}`, query.Get("node"))
+		default:
+			w.Write([]byte(`{"Done":{}}`))
+		}
+	}))
+
+	var progress bytes.Buffer
+	err := client.FlashNodesFromURL(context.Background(), server.URL+"/image.img", []int{1, 2, 3, 4}, &FlashOptions{
+		SHA256:         sha,
+		ProgressWriter: &progress,
+	})
+	if err != nil {
+		t.Fatalf("FlashNodesFromURL failed: %v", err)
+	}
+
+	if downloads.Load() != 1 {
+		t.Errorf("Expected a single download, got %d", downloads.Load())
+	}
+	if len(uploads) != 4 {
+		t.Errorf("Expected an upload to each of the 4 nodes, got %v", uploads)
+	}
+	if overlapped.Load() {
+		t.Error("Expected the nodes to be uploaded to one at a time")
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, sha)); err != nil {
+		t.Errorf("Expected the image to be cached under its checksum: %v", err)
+	}
+	for node := 1; node <= 4; node++ {
+		if !strings.Contains(progress.String(), fmt.Sprintf("[node %d] ", node)) {
+			t.Errorf("Expected progress lines prefixed with node %d, got:\n%s", node, progress.String())
+		}
+	}
+
+	// The cached copy is used without downloading again
+	if err := client.FlashNodeFromURL(context.Background(), server.URL+"/image.img", 2, &FlashOptions{
+		SHA256:         sha,
+		ProgressWriter: &progress,
+	}); err != nil {
+		t.Fatalf("FlashNodeFromURL failed: %v", err)
+	}
+	if downloads.Load() != 1 {
+		t.Errorf("Expected the cached image to be reused, got %d downloads", downloads.Load())
+	}
+}
+
+func TestFlashNodesFromURLChecksNodesFirst(t *testing.T) {
+	useImageCache(t)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("turing-pi image"))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(WithHost("127.0.0.1:1"), WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	cases := map[string]struct {
+		nodes   []int
+		options *FlashOptions
+	}{
+		"invalid node":  {[]int{0}, nil},
+		"no nodes":      {nil, nil},
+		"listed twice":  {[]int{1, 1}, nil},
+		"resume handle": {[]int{1}, &FlashOptions{ResumeHandle: 7}},
+	}
+	for name, tc := range cases {
+		if err := client.FlashNodesFromURL(context.Background(), server.URL+"/image.img", tc.nodes, tc.options); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if requests.Load() != 0 {
+		t.Errorf("Expected nothing to be downloaded, got %d requests", requests.Load())
+	}
+}
+
+func TestFetchImageChecksumMismatch(t *testing.T) {
+	cacheDir, restoreCacheDir := t.TempDir(), imageCacheDir
+	imageCacheDir = func() string { return cacheDir }
+	t.Cleanup(func() { imageCacheDir = restoreCacheDir })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("corrupted"))
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := FetchImage(context.Background(), server.URL, strings.Repeat("0", 64))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("Expected a checksum mismatch, got: %v", err)
+	}
+	if entries, _ := os.ReadDir(cacheDir); len(entries) != 0 {
+		t.Errorf("Expected nothing cached after a mismatch, got %d entries", len(entries))
+	}
+}
+
+// useImageCache moves the image cache to a temporary directory for the duration of the test
+func useImageCache(t *testing.T) string {
+	t.Helper()
+	cacheDir, restoreCacheDir := t.TempDir(), imageCacheDir
+	imageCacheDir = func() string { return cacheDir }
+	t.Cleanup(func() { imageCacheDir = restoreCacheDir })
+	return cacheDir
+}
+
+func TestFlashNodeFromURLSidecarChecksum(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+	useImageCache(t)
+
+	image := []byte("turing-pi image")
+	sum := sha256.Sum256(image)
+	sha := hex.EncodeToString(sum[:])
+
+	sidecar := sha + "  image.img\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.img":
+			w.Write(image)
+		case "/image.img.sha256":
+			w.Write([]byte(sidecar))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	var flashedSha string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case query.Get("opt") == "set" && query.Get("type") == "flash":
+			flashedSha = query.Get("sha256")
+			w.Write([]byte(`{"handle":1}`))
+		default:
+			w.Write([]byte(`{"Done":{}}`))
+		}
+	}))
+
+	if err := client.FlashNodeFromURL(context.Background(), server.URL+"/image.img", 1, &FlashOptions{ProgressWriter: io.Discard}); err != nil {
+		t.Fatalf("FlashNodeFromURL failed: %v", err)
+	}
+	if flashedSha != sha {
+		t.Errorf("Expected the flash to carry the checksum %s, got %q", sha, flashedSha)
+	}
+
+	// A sidecar that doesn't match the image stops the flash
+	sidecar = strings.Repeat("0", 64)
+	err := client.FlashNodeFromURL(context.Background(), server.URL+"/image.img", 1, &FlashOptions{ProgressWriter: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got: %v", err)
+	}
+}
+
+func TestFetchImageRetriesFailedDownload(t *testing.T) {
+	useImageCache(t)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("image"))
+	}))
+	t.Cleanup(server.Close)
+
+	if _, err := FetchImage(context.Background(), server.URL, ""); err == nil {
+		t.Fatal("Expected the first download to fail")
+	}
+	if _, err := FetchImage(context.Background(), server.URL, ""); err != nil {
+		t.Fatalf("Expected the failed download to be tried again, got: %v", err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected 2 downloads, got %d", requests.Load())
+	}
+}
+
+func TestFetchImageCancelledByLastWaiter(t *testing.T) {
+	useImageCache(t)
+
+	// The server sends a few bytes then stalls until the client goes away
+	aborted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := FetchImage(ctx, server.URL, ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the download to be cancelled once nobody waits for it")
+	}
+
+	imageDownloadsMu.Lock()
+	_, kept := imageDownloads[server.URL+"\x00"]
+	imageDownloadsMu.Unlock()
+	if kept {
+		t.Error("Expected the cancelled download to be forgotten")
+	}
+}
+
+func TestFetchImageStall(t *testing.T) {
+	useImageCache(t)
+	stallTimeout := imageStallTimeout
+	imageStallTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { imageStallTimeout = stallTimeout })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := FetchImage(context.Background(), server.URL, "")
+	if err == nil || !strings.Contains(err.Error(), "no data received") {
+		t.Errorf("Expected the stalled download to fail, got: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// NodeNetwork is the network interface of a node as the BMC sees it
+type NodeNetwork struct {
+	MAC string `json:"mac"`
+	IP  string `json:"ip,omitempty"` // Empty until the node got an address, e.g. over DHCP
+}
+
+// NodeNetworkInfo returns the MAC and IP address of each node, keyed by node number [1-4].
+// Nodes the BMC knows nothing about are left out. It returns ErrUnsupported if the
+// firmware doesn't expose the node network table.
+func (c *Client) NodeNetworkInfo() (map[int]NodeNetwork, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "node_network")
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return nil, ErrUnsupported
+		}
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	// The node is a number or a numeric string depending on the firmware
+	var entries []struct {
+		Node interface{} `json:"node"`
+		MAC  string      `json:"mac"`
+		IP   string      `json:"ip"`
+	}
+	if _, err := decodeResult(resp, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	networks := make(map[int]NodeNetwork)
+	for _, entry := range entries {
+		node, ok := jsonInt(entry.Node)
+		if !ok || node < 1 || node > 4 {
+			return nil, fmt.Errorf("invalid node in node network table: %v", entry.Node)
+		}
+		networks[int(node)] = NodeNetwork{MAC: entry.MAC, IP: entry.IP}
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestNodeNetworkInfo(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			if r.URL.Query().Get("type") != "node_network" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":[
+				{"node":1,"mac":"02:00:00:00:00:01","ip":"192.168.1.101"},
+				{"node":"2","mac":"02:00:00:00:00:02","ip":""},
+				{"node":3,"mac":"02:00:00:00:00:03","ip":"192.168.1.103"}
+			]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	networks, err := client.NodeNetworkInfo()
+	if err != nil {
+		t.Fatalf("NodeNetworkInfo failed: %v", err)
+	}
+
+	expected := map[int]NodeNetwork{
+		1: {MAC: "02:00:00:00:00:01", IP: "192.168.1.101"},
+		2: {MAC: "02:00:00:00:00:02"},
+		3: {MAC: "02:00:00:00:00:03", IP: "192.168.1.103"},
+	}
+	if !reflect.DeepEqual(networks, expected) {
+		t.Errorf("Expected %v, got %v", expected, networks)
+	}
+}
+
+func TestNodeNetworkInfoUnsupported(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		default:
+			http.Error(w, "Invalid `type` parameter node_network", http.StatusBadRequest)
+		}
+	}))
+
+	if _, err := client.NodeNetworkInfo(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got: %v", err)
+	}
+}
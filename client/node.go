@@ -0,0 +1,33 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import "strconv"
+
+// Nodes are numbered 1-4 throughout the public API, like on the board. The BMC numbers them
+// differently depending on the request:
+//   - power on/off takes one param per node, named after the 1-based number: node1=1
+//   - every other request takes a 0-based index in a node param: node=0 for node 1
+// Requests build these params with the helpers below instead of recomputing them.
+
+// toBMCNodeIndex converts a 1-based node number to the 0-based index the BMC expects in the node param
+func toBMCNodeIndex(node int) string {
+	return strconv.Itoa(node - 1)
+}
+
+// powerNodeParam returns the name of the param setting the power of a 1-based node number
+func powerNodeParam(node int) string {
+	return "node" + strconv.Itoa(node)
+}
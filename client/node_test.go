@@ -0,0 +1,86 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNodeIndexing(t *testing.T) {
+	tests := []struct {
+		name  string
+		call  func(c *Client, node int) error
+		param func(node int) (string, string)
+	}{
+		{
+			name:  "power reset",
+			call:  (*Client).PowerReset,
+			param: func(node int) (string, string) { return "node", fmt.Sprint(node - 1) },
+		},
+		{
+			name:  "power on",
+			call:  (*Client).PowerOn,
+			param: func(node int) (string, string) { return fmt.Sprintf("node%d", node), "1" },
+		},
+		{
+			name:  "power off",
+			call:  (*Client).PowerOff,
+			param: func(node int) (string, string) { return fmt.Sprintf("node%d", node), "0" },
+		},
+		{
+			name:  "raw power on",
+			call:  func(c *Client, node int) error { return c.SetPowerRaw(node-1, true) },
+			param: func(node int) (string, string) { return fmt.Sprintf("node%d", node), "1" },
+		},
+		{
+			name:  "usb host",
+			call:  func(c *Client, node int) error { return c.UsbSetHost(node, false) },
+			param: func(node int) (string, string) { return "node", fmt.Sprint(node - 1) },
+		},
+	}
+
+	for _, tt := range tests {
+		for node := 1; node <= 4; node++ {
+			t.Run(fmt.Sprintf("%s node %d", tt.name, node), func(t *testing.T) {
+				client, queries := newPowerRecorder(t)
+				if err := tt.call(client, node); err != nil {
+					t.Fatalf("Call failed: %v", err)
+				}
+
+				sent := queries()
+				if len(sent) != 1 {
+					t.Fatalf("Expected 1 request, got %d", len(sent))
+				}
+				key, value := tt.param(node)
+				if got := sent[0].Get(key); got != value {
+					t.Errorf("Expected %s=%s, got %s=%q (query %v)", key, value, key, got, sent[0])
+				}
+			})
+		}
+	}
+}
+
+func TestSetPowerRawRejectsNodeNumbers(t *testing.T) {
+	client, queries := newPowerRecorder(t)
+	for _, index := range []int{-1, 4} {
+		if err := client.SetPowerRaw(index, true); err == nil {
+			t.Errorf("Expected index %d to be rejected", index)
+		}
+	}
+	if sent := queries(); len(sent) != 0 {
+		t.Errorf("Expected no request, got %v", sent)
+	}
+}
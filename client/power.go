@@ -15,9 +15,13 @@
 package tpi
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // PowerStatus returns the power status of all nodes
@@ -99,7 +103,7 @@ func (c *Client) PowerReset(node int) error {
 	// Add query parameters
 	req.AddQueryParam("opt", "set")
 	req.AddQueryParam("type", "reset")
-	req.AddQueryParam("node", strconv.Itoa(node-1)) // API expects 0-based index
+	req.AddQueryParam("node", toBMCNodeIndex(node))
 
 	// Send the request
 	resp, err := req.Send()
@@ -113,9 +117,52 @@ func (c *Client) PowerReset(node int) error {
 		return fmt.Errorf("reset failed: %w", err)
 	}
 
+	c.settle()
+	c.emit(EventPowerReset, node, "reset")
 	return nil
 }
 
+// ErrNodeRequired is returned by Power in strict node selection mode when no node is given
+var ErrNodeRequired = errors.New("an explicit node is required")
+
+// WithStrictNodeSelection makes Power reject a missing node instead of applying the
+// command to every node. Use PowerOnAll and PowerOffAll to target every node explicitly.
+func WithStrictNodeSelection() Option {
+	return func(c *Client) {
+		c.strictNodes = true
+	}
+}
+
+// Power applies a power command to a node. For compatibility, node 0 powers every node
+// on or off, unless the client was created with WithStrictNodeSelection. Resetting always
+// requires a node.
+func (c *Client) Power(cmd PowerCmd, node int) error {
+	if node == 0 {
+		if c.strictNodes {
+			return fmt.Errorf("power %s: %w", cmd, ErrNodeRequired)
+		}
+		switch cmd {
+		case PowerOn:
+			return c.PowerOnAll()
+		case PowerOff:
+			return c.PowerOffAll()
+		case PowerReset:
+			return fmt.Errorf("power reset: %w", ErrNodeRequired)
+		}
+	}
+
+	switch cmd {
+	case PowerOn:
+		return c.PowerOn(node)
+	case PowerOff:
+		return c.PowerOff(node)
+	case PowerReset:
+		return c.PowerReset(node)
+	default:
+		return fmt.Errorf("invalid power command: %s", cmd)
+	}
+}
+
 // setPowerState sets the power state of the specified node
 func (c *Client) setPowerState(node int, powerOn bool) error {
 	if node < 1 || node > 4 {
@@ -128,9 +175,6 @@ func (c *Client) setPowerState(node int, powerOn bool) error {
 		powerState = "1"
 	}
 
-	// Build the node parameter name (node1, node2, etc.)
-	nodeParam := fmt.Sprintf("node%d", node)
-
 	req, err := c.newRequest()
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
@@ -139,7 +183,7 @@ func (c *Client) setPowerState(node int, powerOn bool) error {
 	// Add query parameters
 	req.AddQueryParam("opt", "set")
 	req.AddQueryParam("type", "power")
-	req.AddQueryParam(nodeParam, powerState)
+	req.AddQueryParam(powerNodeParam(node), powerState)
 
 	// Send the request
 	resp, err := req.Send()
@@ -153,11 +197,44 @@ func (c *Client) setPowerState(node int, powerOn bool) error {
 		return fmt.Errorf("power state change failed: %w", err)
 	}
 
+	c.settle()
+	if powerOn {
+		c.emit(EventPowerOn, node, "on")
+	} else {
+		c.emit(EventPowerOff, node, "off")
+	}
 	return nil
 }
 
-// PowerOnAll turns on all nodes
+// SetPowerRaw powers on or off the node at a 0-based BMC index, 0 for node 1, for callers
+// that already hold the BMC's numbering, such as a node param read from a raw response.
+// Everything else in the API takes the 1-based node number.
+func (c *Client) SetPowerRaw(index int, powerOn bool) error {
+	if index < 0 || index > 3 {
+		return fmt.Errorf("invalid BMC node index: %d (must be between 0 and 3)", index)
+	}
+	return c.setPowerState(index+1, powerOn)
+}
+
+// WithPowerOnStagger makes PowerOnAll power the nodes on one at a time, interval apart,
+// instead of all at once, to spread the inrush current over marginal power supplies.
+// Disabled by default.
+func WithPowerOnStagger(interval time.Duration) Option {
+	return func(c *Client) {
+		if interval < 0 {
+			c.optionErr = fmt.Errorf("invalid power-on stagger: %s", interval)
+			return
+		}
+		c.powerOnStagger = interval
+	}
+}
+
+// PowerOnAll turns on all nodes, one at a time if WithPowerOnStagger is set
 func (c *Client) PowerOnAll() error {
+	if c.powerOnStagger > 0 {
+		return c.powerOnStaggered()
+	}
+
 	req, err := c.newRequest()
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
@@ -183,6 +260,23 @@ func (c *Client) PowerOnAll() error {
 		return fmt.Errorf("power on all failed: %w", err)
 	}
 
+	c.settle()
+	for node := 1; node <= 4; node++ {
+		c.emit(EventPowerOn, node, "on")
+	}
+	return nil
+}
+
+// powerOnStaggered powers every node on with its own request, waiting the stagger interval between them
+func (c *Client) powerOnStaggered() error {
+	for node := 1; node <= 4; node++ {
+		if node > 1 {
+			time.Sleep(c.powerOnStagger)
+		}
+		if err := c.SetPower(map[int]bool{node: true}); err != nil {
+			return fmt.Errorf("power on all failed at node %d: %w", node, err)
+		}
+	}
 	return nil
 }
 
@@ -213,5 +307,174 @@ func (c *Client) PowerOffAll() error {
 		return fmt.Errorf("power off all failed: %w", err)
 	}
 
+	c.settle()
+	for node := 1; node <= 4; node++ {
+		c.emit(EventPowerOff, node, "off")
+	}
+	return nil
+}
+
+// SetPower powers nodes on or off in a single request, e.g. {1: true, 3: false}
+func (c *Client) SetPower(states map[int]bool) error {
+	if len(states) == 0 {
+		return nil
+	}
+	for node := range states {
+		if node < 1 || node > 4 {
+			return fmt.Errorf("invalid node number: %d (must be between 1 and 4)", node)
+		}
+	}
+
+	req, err := c.newRequest()
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters, in node order
+	req.AddQueryParam("opt", "set")
+	req.AddQueryParam("type", "power")
+	for node := 1; node <= 4; node++ {
+		if on, ok := states[node]; ok {
+			powerState := "0"
+			if on {
+				powerState = "1"
+			}
+			req.AddQueryParam(powerNodeParam(node), powerState)
+		}
+	}
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check for errors in the response
+	if err := checkResponseError(resp); err != nil {
+		return fmt.Errorf("power state change failed: %w", err)
+	}
+
+	c.settle()
+	for node := 1; node <= 4; node++ {
+		if on, ok := states[node]; ok {
+			if on {
+				c.emit(EventPowerOn, node, "on")
+			} else {
+				c.emit(EventPowerOff, node, "off")
+			}
+		}
+	}
+	return nil
+}
+
+// CapturePowerState returns the power state of every node, to restore it later with
+// RestorePowerState
+func (c *Client) CapturePowerState() (map[int]bool, error) {
+	return c.PowerStatus()
+}
+
+// RestorePowerState applies a captured power state with a single SetPower request,
+// changing only the nodes whose current state differs. Nodes missing from state are
+// left alone.
+func (c *Client) RestorePowerState(state map[int]bool) error {
+	current, err := c.PowerStatus()
+	if err != nil {
+		return fmt.Errorf("failed to get current power state: %w", err)
+	}
+
+	changes := make(map[int]bool)
+	for node, on := range state {
+		if node < 1 || node > 4 {
+			return fmt.Errorf("invalid node number: %d (must be between 1 and 4)", node)
+		}
+		if currentOn, ok := current[node]; !ok || currentOn != on {
+			changes[node] = on
+		}
+	}
+
+	if err := c.SetPower(changes); err != nil {
+		return fmt.Errorf("failed to restore power state: %w", err)
+	}
+	return nil
+}
+
+// GetPowerOnDelay returns the delay the firmware waits between nodes when powering several on.
+// It returns ErrUnsupported if the firmware doesn't stagger power-on.
+func (c *Client) GetPowerOnDelay() (time.Duration, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "power_on_delay")
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return 0, ErrUnsupported
+		}
+		return 0, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var result struct {
+		DelayMs *int64 `json:"delay_ms"`
+	}
+	if _, err := decodeResult(resp, &result); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.DelayMs == nil {
+		return 0, fmt.Errorf("no power-on delay reported by the BMC")
+	}
+	return time.Duration(*result.DelayMs) * time.Millisecond, nil
+}
+
+// SetPowerOnDelay sets the delay the firmware waits between nodes when powering several on,
+// with millisecond precision. It returns ErrUnsupported if the firmware doesn't stagger power-on.
+func (c *Client) SetPowerOnDelay(delay time.Duration) error {
+	if delay < 0 {
+		return fmt.Errorf("invalid power-on delay: %s", delay)
+	}
+
+	req, err := c.newRequest()
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "set")
+	req.AddQueryParam("type", "power_on_delay")
+	req.AddQueryParam("delay_ms", strconv.FormatInt(delay.Milliseconds(), 10))
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return ErrUnsupported
+		}
+		if err := checkHTMLResponse(resp, body); err != nil {
+			return err
+		}
+		return fmt.Errorf("power-on delay configuration failed: %w", &BMCError{StatusCode: resp.StatusCode, Message: string(body)})
+	}
+
+	if err := checkResponseError(resp); err != nil {
+		return fmt.Errorf("power-on delay configuration failed: %w", err)
+	}
 	return nil
 }
@@ -1,6 +1,13 @@
 package tpi
 
-import "testing"
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestPowerCycleAll(t *testing.T) {
 	client := createTestClient(t)
@@ -43,3 +50,247 @@ func TestPowerCycleAll(t *testing.T) {
 		}
 	}
 }
+
+// newPowerRecorder returns a mock BMC recording the queries of power requests
+func newPowerRecorder(t *testing.T, options ...Option) (*Client, func() []url.Values) {
+	var mu sync.Mutex
+	var queries []url.Values
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			mu.Lock()
+			queries = append(queries, r.URL.Query())
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), options...)
+
+	return client, func() []url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return queries
+	}
+}
+
+func TestPowerLenientNodeSelection(t *testing.T) {
+	client, queries := newPowerRecorder(t)
+
+	// Without a node, power off falls back to every node
+	if err := client.Power(PowerOff, 0); err != nil {
+		t.Fatalf("Power off failed: %v", err)
+	}
+	got := queries()
+	if len(got) != 1 {
+		t.Fatalf("Expected one request, got %d", len(got))
+	}
+	for _, param := range []string{"node1", "node2", "node3", "node4"} {
+		if got[0].Get(param) != "0" {
+			t.Errorf("Expected %s=0, got query %v", param, got[0])
+		}
+	}
+
+	// Reset never falls back to every node
+	if err := client.Power(PowerReset, 0); !errors.Is(err, ErrNodeRequired) {
+		t.Errorf("Expected ErrNodeRequired for reset without a node, got: %v", err)
+	}
+}
+
+func TestPowerStrictNodeSelection(t *testing.T) {
+	client, queries := newPowerRecorder(t, WithStrictNodeSelection())
+
+	for _, cmd := range []PowerCmd{PowerOn, PowerOff, PowerReset} {
+		if err := client.Power(cmd, 0); !errors.Is(err, ErrNodeRequired) {
+			t.Errorf("Expected ErrNodeRequired for %s without a node, got: %v", cmd, err)
+		}
+	}
+	if got := queries(); len(got) != 0 {
+		t.Fatalf("Expected nothing sent to the BMC, got %v", got)
+	}
+
+	// An explicit node still works
+	if err := client.Power(PowerOff, 2); err != nil {
+		t.Fatalf("Power off node 2 failed: %v", err)
+	}
+	got := queries()
+	if len(got) != 1 || got[0].Get("node2") != "0" || got[0].Get("node1") != "" {
+		t.Errorf("Expected only node2 powered off, got %v", got)
+	}
+}
+
+func TestRestorePowerState(t *testing.T) {
+	var mu sync.Mutex
+	var sets []url.Values
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			if r.URL.Query().Get("opt") == "get" {
+				w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":1,"node4":0}]}]}`))
+				return
+			}
+			mu.Lock()
+			sets = append(sets, r.URL.Query())
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	setRequests := func() []url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]url.Values(nil), sets...)
+	}
+
+	captured, err := client.CapturePowerState()
+	if err != nil {
+		t.Fatalf("CapturePowerState failed: %v", err)
+	}
+	if len(captured) != 4 || !captured[1] || captured[2] {
+		t.Fatalf("Unexpected captured state: %v", captured)
+	}
+
+	// Nodes 1 and 4 already match, only 2 and 3 change, in one request
+	if err := client.RestorePowerState(map[int]bool{1: true, 2: true, 3: false, 4: false}); err != nil {
+		t.Fatalf("RestorePowerState failed: %v", err)
+	}
+	got := setRequests()
+	if len(got) != 1 {
+		t.Fatalf("Expected one set request, got %v", got)
+	}
+	query := got[0]
+	if query.Get("node2") != "1" || query.Get("node3") != "0" {
+		t.Errorf("Expected node2=1 and node3=0, got query %v", query)
+	}
+	if query.Has("node1") || query.Has("node4") {
+		t.Errorf("Expected nodes already in the right state to be left alone, got query %v", query)
+	}
+
+	// Restoring the current state sends nothing
+	if err := client.RestorePowerState(captured); err != nil {
+		t.Fatalf("RestorePowerState failed: %v", err)
+	}
+	if got := setRequests(); len(got) != 1 {
+		t.Errorf("Expected no request when nothing differs, got %v", got)
+	}
+
+	if err := client.RestorePowerState(map[int]bool{5: true}); err == nil {
+		t.Error("Expected an error for node 5")
+	}
+}
+
+func TestPostWriteSettle(t *testing.T) {
+	const settle = 50 * time.Millisecond
+	client, _ := newPowerRecorder(t, WithPostWriteSettle(settle))
+
+	start := time.Now()
+	if err := client.PowerOffAll(); err != nil {
+		t.Fatalf("PowerOffAll failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < settle {
+		t.Errorf("PowerOffAll returned after %s, want at least %s", elapsed, settle)
+	}
+
+	if _, err := NewClient(WithHost("bmc"), WithPostWriteSettle(-time.Second)); err == nil {
+		t.Error("expected an error for a negative settle")
+	}
+}
+
+func TestPowerOnStagger(t *testing.T) {
+	const interval = 20 * time.Millisecond
+
+	var mu sync.Mutex
+	var queries []url.Values
+	var times []time.Time
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			mu.Lock()
+			queries = append(queries, r.URL.Query())
+			times = append(times, time.Now())
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), WithPowerOnStagger(interval))
+
+	if err := client.PowerOnAll(); err != nil {
+		t.Fatalf("PowerOnAll failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) != 4 {
+		t.Fatalf("Expected one request per node, got %v", queries)
+	}
+	for i, query := range queries {
+		node := i + 1
+		if query.Get(powerNodeParam(node)) != "1" || len(query) != 3 {
+			t.Errorf("Expected request %d to power on only node %d, got %v", i, node, query)
+		}
+		if i > 0 && times[i].Sub(times[i-1]) < interval {
+			t.Errorf("Node %d powered on %s after node %d, want at least %s", node, times[i].Sub(times[i-1]), node-1, interval)
+		}
+	}
+}
+
+func TestPowerOnDelay(t *testing.T) {
+	var mu sync.Mutex
+	delay := "500"
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			mu.Lock()
+			defer mu.Unlock()
+			query := r.URL.Query()
+			if query.Get("opt") == "set" {
+				delay = query.Get("delay_ms")
+				w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":{"delay_ms":` + delay + `}}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	got, err := client.GetPowerOnDelay()
+	if err != nil || got != 500*time.Millisecond {
+		t.Fatalf("GetPowerOnDelay = %s, %v, want 500ms", got, err)
+	}
+	if err := client.SetPowerOnDelay(2 * time.Second); err != nil {
+		t.Fatalf("SetPowerOnDelay failed: %v", err)
+	}
+	if got, err := client.GetPowerOnDelay(); err != nil || got != 2*time.Second {
+		t.Errorf("GetPowerOnDelay after set = %s, %v, want 2s", got, err)
+	}
+}
+
+func TestPowerOnDelayUnsupported(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		http.Error(w, "Invalid request type", http.StatusBadRequest)
+	}))
+
+	if _, err := client.GetPowerOnDelay(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("GetPowerOnDelay error = %v, want ErrUnsupported", err)
+	}
+	if err := client.SetPowerOnDelay(time.Second); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("SetPowerOnDelay error = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PowerReason is why a node powered on, off or reset, as recorded by the BMC
+type PowerReason string
+
+// Reasons recorded by the firmware. Other values are passed through as reported.
+const (
+	PowerReasonUser      PowerReason = "user"
+	PowerReasonPowerLoss PowerReason = "power_loss"
+	PowerReasonWatchdog  PowerReason = "watchdog"
+)
+
+// PowerEvent is an entry of the power history of a node
+type PowerEvent struct {
+	// Time is when the event happened, zero if the BMC didn't record it
+	Time time.Time
+	// Event is what happened, e.g. "on", "off" or "reset"
+	Event string
+	// Reason is why it happened, empty if the BMC doesn't know
+	Reason PowerReason
+}
+
+// NodePowerHistory returns the power events recorded for the node, oldest first when the
+// BMC timestamps them all, to diagnose unexpected reboots. It returns ErrUnsupported if the firmware doesn't record them.
+func (c *Client) NodePowerHistory(node int) ([]PowerEvent, error) {
+	if node < 1 || node > 4 {
+		return nil, fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+	}
+
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "power_history")
+	req.AddQueryParam("node", toBMCNodeIndex(node))
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return nil, ErrUnsupported
+		}
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var entries []struct {
+		Time   interface{} `json:"time"`
+		Event  string      `json:"event"`
+		Reason string      `json:"reason"`
+	}
+	if _, err := decodeResult(resp, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	events := make([]PowerEvent, 0, len(entries))
+	timed := true
+	for _, entry := range entries {
+		event := PowerEvent{
+			Time:   parsePowerEventTime(entry.Time),
+			Event:  strings.ToLower(entry.Event),
+			Reason: PowerReason(strings.ToLower(entry.Reason)),
+		}
+		timed = timed && !event.Time.IsZero()
+		events = append(events, event)
+	}
+
+	// Without a time on every event, the order of the BMC is the only one known
+	if timed {
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Time.Before(events[j].Time)
+		})
+	}
+	return events, nil
+}
+
+// parsePowerEventTime parses the time of a power event, given as RFC 3339 or as Unix seconds
+func parsePowerEventTime(value interface{}) time.Time {
+	if s, ok := value.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+	}
+	if seconds, ok := jsonInt(value); ok && seconds > 0 {
+		return time.Unix(seconds, 0).UTC()
+	}
+	return time.Time{}
+}
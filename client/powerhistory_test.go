@@ -0,0 +1,76 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNodePowerHistory(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			query := r.URL.Query()
+			if query.Get("type") != "power_history" || query.Get("node") != "1" {
+				http.Error(w, "Invalid `type` parameter", http.StatusBadRequest)
+				return
+			}
+			// Newest first, with both time formats
+			w.Write([]byte(`{"response":[{"result":[
+				{"time":"2024-05-01T10:05:00Z","event":"ON","reason":"watchdog"},
+				{"time":1714557600,"event":"off","reason":"power_loss"}
+			]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	events, err := client.NodePowerHistory(2)
+	if err != nil {
+		t.Fatalf("NodePowerHistory failed: %v", err)
+	}
+
+	expected := []PowerEvent{
+		{Time: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), Event: "off", Reason: PowerReasonPowerLoss},
+		{Time: time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC), Event: "on", Reason: PowerReasonWatchdog},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %+v", len(expected), events)
+	}
+	for i, want := range expected {
+		if got := events[i]; !got.Time.Equal(want.Time) || got.Event != want.Event || got.Reason != want.Reason {
+			t.Errorf("Event %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+}
+
+func TestNodePowerHistoryUnsupported(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		http.Error(w, "Invalid `type` parameter", http.StatusBadRequest)
+	}))
+
+	if _, err := client.NodePowerHistory(1); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import "time"
+
+// progressWindow is the number of speed samples averaged by progressTracker
+const progressWindow = 5
+
+// progressTracker computes the speed of a transfer averaged over the last few samples,
+// and the time left at that speed. Flashing and SFTP transfers share it.
+type progressTracker struct {
+	total     int64
+	lastBytes int64
+	lastTime  time.Time
+	samples   []float64
+}
+
+// newProgressTracker creates a tracker for a transfer of total bytes starting at start
+func newProgressTracker(total int64, start time.Time) *progressTracker {
+	return &progressTracker{total: total, lastTime: start}
+}
+
+// update records that bytes were transferred so far at now, and returns the average speed
+// in bytes per second and the estimated time left. Both are zero until a speed is known:
+// the first sample is skipped since it includes the time the transfer took to start.
+func (t *progressTracker) update(bytes int64, now time.Time) (float64, time.Duration) {
+	elapsed := now.Sub(t.lastTime).Seconds()
+	if elapsed > 0 && t.lastBytes > 0 {
+		t.samples = append(t.samples, float64(bytes-t.lastBytes)/elapsed)
+		if len(t.samples) > progressWindow {
+			t.samples = t.samples[1:]
+		}
+	}
+	if elapsed > 0 {
+		t.lastBytes = bytes
+		t.lastTime = now
+	}
+
+	speed := t.speed()
+	if speed <= 0 {
+		return 0, 0
+	}
+	eta := time.Duration(float64(t.total-bytes) / speed * float64(time.Second))
+	return speed, max(eta, 0)
+}
+
+// speed returns the average of the speed samples
+func (t *progressTracker) speed() float64 {
+	if len(t.samples) == 0 {
+		return 0
+	}
+	var total float64
+	for _, sample := range t.samples {
+		total += sample
+	}
+	return total / float64(len(t.samples))
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressTrackerConverges(t *testing.T) {
+	const mib = 1 << 20
+	start := time.Now()
+	tracker := newProgressTracker(100*mib, start)
+
+	// The first sample only sets the baseline
+	if speed, eta := tracker.update(mib, start.Add(time.Second)); speed != 0 || eta != 0 {
+		t.Errorf("Expected no speed from the first sample, got %v and %v", speed, eta)
+	}
+
+	// 1 MiB/s for a while
+	bytes, now := int64(mib), start.Add(time.Second)
+	for i := 0; i < 10; i++ {
+		bytes += mib
+		now = now.Add(time.Second)
+		tracker.update(bytes, now)
+	}
+
+	// Then 3 MiB/s: the average moves towards it and settles once the window is full
+	var speed float64
+	var eta time.Duration
+	previous := float64(mib)
+	for i := 0; i < progressWindow; i++ {
+		bytes += 3 * mib
+		now = now.Add(time.Second)
+		speed, eta = tracker.update(bytes, now)
+		if speed <= previous {
+			t.Errorf("Sample %d: expected the speed to increase from %.0f, got %.0f", i+1, previous, speed)
+		}
+		previous = speed
+	}
+
+	if speed != 3*mib {
+		t.Errorf("Expected a speed of %d, got %.0f", 3*mib, speed)
+	}
+	expectedETA := time.Duration(float64(100*mib-bytes) / (3 * mib) * float64(time.Second))
+	if eta != expectedETA {
+		t.Errorf("Expected an ETA of %v, got %v", expectedETA, eta)
+	}
+}
+
+func TestCopyWithProgress(t *testing.T) {
+	interval := transferProgressInterval
+	transferProgressInterval = 0
+	t.Cleanup(func() { transferProgressInterval = interval })
+
+	content := strings.Repeat("x", 100*1024)
+	var updates []TransferProgress
+	var dst bytes.Buffer
+	err := copyWithProgress(&dst, strings.NewReader(content), int64(len(content)), func(p TransferProgress) {
+		updates = append(updates, p)
+	})
+	if err != nil {
+		t.Fatalf("copyWithProgress failed: %v", err)
+	}
+
+	if dst.String() != content {
+		t.Error("Expected the content to be copied")
+	}
+	if len(updates) < 2 {
+		t.Fatalf("Expected several updates, got %d", len(updates))
+	}
+	last := updates[len(updates)-1]
+	if last.Bytes != int64(len(content)) || last.Total != int64(len(content)) || last.ETA != 0 {
+		t.Errorf("Expected a final update for the whole file, got %+v", last)
+	}
+	for i := 1; i < len(updates); i++ {
+		if updates[i].Bytes < updates[i-1].Bytes {
+			t.Errorf("Expected increasing byte counts, got %+v", updates)
+			break
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// ProvisionStage is a stage of ProvisionNode
+type ProvisionStage int
+
+const (
+	StageFlash   ProvisionStage = iota // Flashing the image
+	StagePowerOn                       // Powering the node on, or resetting it if it was on
+	StageWait                          // Waiting for the node to become reachable
+	StageDone                          // The node is up with the new image
+)
+
+// String returns the name of the stage
+func (s ProvisionStage) String() string {
+	switch s {
+	case StageFlash:
+		return "flash"
+	case StagePowerOn:
+		return "power-on"
+	case StageWait:
+		return "wait"
+	case StageDone:
+		return "done"
+	default:
+		return fmt.Sprintf("ProvisionStage(%d)", int(s))
+	}
+}
+
+// ProvisionStatus is an update of ProvisionNode. During StageFlash, FlashPhase, Bytes
+// and Total carry the flash progress.
+type ProvisionStatus struct {
+	Stage      ProvisionStage
+	Message    string
+	FlashPhase FlashPhase
+	Bytes      int64
+	Total      int64
+}
+
+// ProvisionStatusFunc receives the updates of ProvisionNode
+type ProvisionStatusFunc func(status ProvisionStatus)
+
+// ProvisionOptions contains options for provisioning a node
+type ProvisionOptions struct {
+	// Image and flash settings. With Status set, the flash progress is reported
+	// there and isn't printed, unless Flash.ProgressWriter is set. The node is
+	// flashed whatever its power state and AutoPower is ignored, it is booted after.
+	Flash FlashOptions
+	// Reports when the node is up, TCPCheck(22) on the address set with
+	// WithNodeHosts by default
+	Check NodeCheck
+	// Optional callback receiving every stage and the flash progress
+	Status ProvisionStatusFunc
+}
+
+// ProvisionNode flashes an image on a node, powers it on, and waits until it's reachable.
+// Waiting for the node stops when ctx is done; flashing can't be interrupted, so ctx is
+// checked before and after it.
+func (c *Client) ProvisionNode(ctx context.Context, node int, opts ProvisionOptions) error {
+	if node < 1 || node > 4 {
+		return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+	}
+
+	// The default check needs the node's address, fail before flashing rather than after
+	check := opts.Check
+	if check == nil {
+		if _, err := c.NodeHost(node); err != nil {
+			return fmt.Errorf("provision node %d: %w", node, err)
+		}
+		check = TCPCheck(22)
+	}
+
+	report := func(status ProvisionStatus) {
+		if opts.Status != nil {
+			opts.Status(status)
+		}
+	}
+
+	// Flash the image
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("provision node %d: %w", node, err)
+	}
+	report(ProvisionStatus{Stage: StageFlash, Message: fmt.Sprintf("Flashing node %d with %s", node, opts.Flash.ImagePath)})
+
+	// The node is booted once flashed, and left as it was if flashing fails
+	flashOpts := opts.Flash
+	flashOpts.skipPowerCheck = true
+	if opts.Status != nil && flashOpts.ProgressWriter == nil {
+		flashOpts.ProgressWriter = io.Discard
+	}
+	flashProgress := flashOpts.Progress
+	flashOpts.Progress = func(phase FlashPhase, bytes, total int64) {
+		if flashProgress != nil {
+			flashProgress(phase, bytes, total)
+		}
+		report(ProvisionStatus{Stage: StageFlash, FlashPhase: phase, Bytes: bytes, Total: total})
+	}
+	if err := c.FlashNode(node, &flashOpts); err != nil {
+		return fmt.Errorf("provision node %d: flash failed: %w", node, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("provision node %d: %w", node, err)
+	}
+
+	// Boot the new image, a node that is already on has to be reset
+	status, err := c.PowerStatus()
+	if err != nil {
+		return fmt.Errorf("provision node %d: %w", node, err)
+	}
+	if status[node] {
+		report(ProvisionStatus{Stage: StagePowerOn, Message: fmt.Sprintf("Resetting node %d", node)})
+		err = c.PowerReset(node)
+	} else {
+		report(ProvisionStatus{Stage: StagePowerOn, Message: fmt.Sprintf("Powering on node %d", node)})
+		err = c.PowerOn(node)
+	}
+	if err != nil {
+		return fmt.Errorf("provision node %d: power on failed: %w", node, err)
+	}
+
+	// Wait for the node to come up
+	report(ProvisionStatus{Stage: StageWait, Message: fmt.Sprintf("Waiting for node %d to become reachable", node)})
+	if err := c.WaitForNode(ctx, node, check); err != nil {
+		return fmt.Errorf("provision node %d: %w", node, err)
+	}
+
+	report(ProvisionStatus{Stage: StageDone, Message: fmt.Sprintf("Node %d is up", node)})
+	return nil
+}
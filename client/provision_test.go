@@ -0,0 +1,188 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newProvisionBMC starts a mock BMC that flashes successfully, or reports flashErr, and
+// records the power requests
+func newProvisionBMC(t *testing.T, nodeOn bool, flashErr string) (*Client, func() []string) {
+	t.Helper()
+
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+	shortenRetryPolicy(t, &DefaultNodeCheckRetryPolicy)
+
+	var mu sync.Mutex
+	var power []string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash" && flashErr != "":
+			w.Write([]byte(`{"Error":{"message":"` + flashErr + `"}}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			if nodeOn {
+				w.Write([]byte(`{"response":[{"result":[{"node1":0,"node2":1,"node3":0,"node4":0}]}]}`))
+			} else {
+				w.Write([]byte(`{"response":[{"result":[{"node1":0,"node2":0,"node3":0,"node4":0}]}]}`))
+			}
+		case query.Get("type") == "power":
+			mu.Lock()
+			power = append(power, "on node2="+query.Get("node2"))
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		case query.Get("type") == "reset":
+			mu.Lock()
+			power = append(power, "reset node="+query.Get("node"))
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), power...)
+	}
+}
+
+func TestProvisionNode(t *testing.T) {
+	client, power := newProvisionBMC(t, false, "")
+	path, _ := writeImage(t, "image content")
+
+	// The node comes up on the third check
+	checks := 0
+	check := func(ctx context.Context, c *Client, node int) error {
+		checks++
+		if checks < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	var stages []ProvisionStage
+	var flashPhases []FlashPhase
+	err := client.ProvisionNode(context.Background(), 2, ProvisionOptions{
+		Flash: FlashOptions{ImagePath: path},
+		Check: check,
+		Status: func(status ProvisionStatus) {
+			if status.Stage == StageFlash && status.Message == "" {
+				flashPhases = append(flashPhases, status.FlashPhase)
+				return
+			}
+			stages = append(stages, status.Stage)
+		},
+	})
+	if err != nil {
+		t.Fatalf("ProvisionNode failed: %v", err)
+	}
+
+	if expected := []ProvisionStage{StageFlash, StagePowerOn, StageWait, StageDone}; !reflect.DeepEqual(stages, expected) {
+		t.Errorf("Expected stages %v, got %v", expected, stages)
+	}
+	if len(flashPhases) == 0 || flashPhases[len(flashPhases)-1] != PhaseDone {
+		t.Errorf("Expected the flash progress to end with done, got %v", flashPhases)
+	}
+	if expected := []string{"on node2=1"}; !reflect.DeepEqual(power(), expected) {
+		t.Errorf("Expected the node to be powered on, got %v", power())
+	}
+	if checks != 3 {
+		t.Errorf("Expected 3 checks, got %d", checks)
+	}
+}
+
+func TestProvisionNodeResetsRunningNode(t *testing.T) {
+	client, power := newProvisionBMC(t, true, "")
+	path, _ := writeImage(t, "image content")
+
+	err := client.ProvisionNode(context.Background(), 2, ProvisionOptions{
+		Flash: FlashOptions{ImagePath: path},
+		Check: func(ctx context.Context, c *Client, node int) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("ProvisionNode failed: %v", err)
+	}
+	if expected := []string{"reset node=1"}; !reflect.DeepEqual(power(), expected) {
+		t.Errorf("Expected the running node to be reset, got %v", power())
+	}
+}
+
+func TestProvisionNodeStopsOnFailure(t *testing.T) {
+	path, _ := writeImage(t, "image content")
+
+	// A failed flash doesn't power the node on
+	client, power := newProvisionBMC(t, false, "checksum mismatch")
+	err := client.ProvisionNode(context.Background(), 2, ProvisionOptions{
+		Flash: FlashOptions{ImagePath: path},
+		Check: func(ctx context.Context, c *Client, node int) error { return nil },
+	})
+	if err == nil {
+		t.Fatal("Expected the flash error")
+	}
+	if got := power(); len(got) != 0 {
+		t.Errorf("Expected no power change after a failed flash, got %v", got)
+	}
+
+	// A node that never comes up fails when ctx is done
+	client, _ = newProvisionBMC(t, false, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.ProvisionNode(ctx, 2, ProvisionOptions{
+		Flash: FlashOptions{ImagePath: path},
+		Check: func(ctx context.Context, c *Client, node int) error { return errors.New("connection refused") },
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+}
+
+func TestProvisionNodeWithoutNodeHost(t *testing.T) {
+	client, power := newProvisionBMC(t, false, "")
+	path, _ := writeImage(t, "image content")
+
+	// The default check has no address to reach, nothing is flashed
+	var stages []ProvisionStage
+	err := client.ProvisionNode(context.Background(), 2, ProvisionOptions{
+		Flash:  FlashOptions{ImagePath: path},
+		Status: func(status ProvisionStatus) { stages = append(stages, status.Stage) },
+	})
+	if err == nil {
+		t.Fatal("Expected an error for a node without an address")
+	}
+	if len(stages) != 0 {
+		t.Errorf("Expected the node not to be flashed, got stages %v", stages)
+	}
+	if got := power(); len(got) != 0 {
+		t.Errorf("Expected no power change, got %v", got)
+	}
+}
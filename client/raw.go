@@ -0,0 +1,84 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// Raw sends a request the client doesn't wrap to the BMC and returns its JSON response as is,
+// e.g. Raw("get", "cooling", nil) on firmware that knows the cooling type. Params are added to
+// the query alongside opt and type.
+func (c *Client) Raw(opt, typ string, params map[string]string) (json.RawMessage, error) {
+	if opt == "" || typ == "" {
+		return nil, fmt.Errorf("opt and type are required")
+	}
+	if _, ok := params["opt"]; ok {
+		return nil, fmt.Errorf("opt must not be given in params")
+	}
+	if _, ok := params["type"]; ok {
+		return nil, fmt.Errorf("type must not be given in params")
+	}
+
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters, in a stable order for ordered params
+	req.AddQueryParam("opt", opt)
+	req.AddQueryParam("type", typ)
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		req.AddQueryParam(key, params[key])
+	}
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if err := checkHTMLResponse(resp, body); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return nil, fmt.Errorf("%s %s: %w", opt, typ, ErrUnsupported)
+		}
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("invalid response: not JSON: %s", string(body))
+	}
+
+	return json.RawMessage(body), nil
+}
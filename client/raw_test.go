@@ -0,0 +1,58 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestRaw(t *testing.T) {
+	var rawQuery string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			rawQuery = r.URL.RawQuery
+			if r.URL.Query().Get("type") == "unknown" {
+				http.Error(w, "Invalid `type` parameter", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	raw, err := client.Raw("set", "cooling", map[string]string{"device": "fan0", "speed": "3"})
+	if err != nil {
+		t.Fatalf("Raw failed: %v", err)
+	}
+	if string(raw) != `{"response":[{"result":"ok"}]}` {
+		t.Errorf("Expected the BMC response as is, got %s", raw)
+	}
+	if expected := "device=fan0&opt=set&speed=3&type=cooling"; rawQuery != expected {
+		t.Errorf("Expected query %q, got %q", expected, rawQuery)
+	}
+
+	if _, err := client.Raw("get", "unknown", nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for an unknown type, got: %v", err)
+	}
+	if _, err := client.Raw("get", "power", map[string]string{"type": "usb"}); err == nil {
+		t.Error("Expected an error when params override type")
+	}
+}
@@ -17,7 +17,6 @@ package tpi
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -27,6 +26,7 @@ import (
 	"net/url"
 	"os"
 	"runtime"
+	"runtime/debug"
 	"time"
 )
 
@@ -38,6 +38,12 @@ func Debug(format string, args ...interface{}) {
 	}
 }
 
+// QueryParam is a single query parameter, used to keep parameters in insertion order
+type QueryParam struct {
+	Key   string
+	Value string
+}
+
 // Request represents an HTTP request for the Turing Pi API
 type Request struct {
 	URL         *url.URL
@@ -50,28 +56,76 @@ type Request struct {
 	Method        string
 	Headers       map[string]string
 	QueryParams   url.Values
+	OrderedParams []QueryParam // Query parameters in insertion order
+	PreserveOrder bool         // Emit OrderedParams instead of the sorted QueryParams
 	MultipartForm *bytes.Buffer
+	Body          io.Reader // Streamed body, used instead of MultipartForm when set
+	ContentLength int64     // Length of Body, if known
 	ContentType   string
 	UserAgent     string
 	Timeout       time.Duration   // Custom timeout for this request
 	Context       context.Context // Context for the request
+
+	CredentialProvider CredentialProvider // Supplies credentials when none are set, DefaultCredentialProvider if nil
+	BasePath           string             // Path of the BMC endpoint, the version's BasePath if empty
+	PinnedCertSHA256   string             // Fingerprint the BMC's certificate must match, in lowercase hex, if set
+	ExtraHeaders       http.Header        // Sent with the request and its authentication, see WithHeader
+	NoAuth             bool               // Send without a token until the BMC answers 401, see WithNoAuth
+
+	stats *statsCollector // Records the latency of the request, see WithStats
+}
+
+// modulePath is the module path of the client library, used to find its version in the build info
+const modulePath = "github.com/davidroman0O/tpi/client"
+
+// defaultUserAgent returns the User-Agent sent to the BMC when none is configured
+func defaultUserAgent() string {
+	return fmt.Sprintf("TPI (%s;%s)", runtime.GOOS, runtime.Version())
+}
+
+// customUserAgent returns ua followed by the library version, so the version stays
+// visible in the BMC logs when embedders identify their application
+func customUserAgent(ua string) string {
+	return fmt.Sprintf("%s TPI/%s (%s;%s)", ua, libraryVersion(), runtime.GOOS, runtime.Version())
+}
+
+// libraryVersion returns the version of the client library from the build info,
+// "dev" when it isn't known, e.g. when building the library itself
+func libraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if info.Main.Path == modulePath && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil && dep.Replace.Version != "" {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "dev"
+}
+
+// SetUserAgent sets the User-Agent sent with the request and its authentication
+func (r *Request) SetUserAgent(ua string) {
+	r.UserAgent = ua
+	r.Headers["User-Agent"] = ua
 }
 
 // NewRequest creates a new request with the given host and API version
 func NewRequest(host string, version ApiVersion, username, password string) (*Request, error) {
-	scheme := version.GetScheme()
-
 	// Construct the URL
-	urlStr := fmt.Sprintf("%s://%s/api/bmc", scheme, host)
+	urlStr := version.BaseURL(host) + version.BasePath()
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
-	// Get system info for user agent
-	osInfo := runtime.GOOS
-	osVersion := runtime.Version()
-	userAgent := fmt.Sprintf("TPI (%s;%s)", osInfo, osVersion)
+	userAgent := defaultUserAgent()
 
 	req := &Request{
 		URL:         parsedURL,
@@ -105,6 +159,17 @@ func (r *Request) Clone() *Request {
 		UserAgent:   r.UserAgent,
 		Timeout:     r.Timeout, // Copy timeout
 		Context:     r.Context, // Copy context
+
+		CredentialProvider: r.CredentialProvider,
+		BasePath:           r.BasePath,
+		PinnedCertSHA256:   r.PinnedCertSHA256,
+		ExtraHeaders:       r.ExtraHeaders.Clone(),
+		NoAuth:             r.NoAuth,
+
+		OrderedParams: append([]QueryParam(nil), r.OrderedParams...),
+		PreserveOrder: r.PreserveOrder,
+
+		stats: r.stats,
 	}
 
 	// Clone URL
@@ -152,133 +217,131 @@ func (r *Request) SetMultipartForm(form *bytes.Buffer, contentType string) {
 	r.ContentType = contentType
 }
 
-// GetURL returns the request's URL with query parameters
+// SetBody sets a streamed request body. Streamed bodies are consumed by the first send
+// and are not copied by Clone.
+func (r *Request) SetBody(body io.Reader, contentType string, contentLength int64) {
+	r.Body = body
+	r.ContentType = contentType
+	r.ContentLength = contentLength
+}
+
+// GetURL returns the request's URL with query parameters.
+// Parameters are sorted by key unless PreserveOrder is set.
 func (r *Request) GetURL() string {
 	u := *r.URL
-	u.RawQuery = r.QueryParams.Encode()
+	if r.PreserveOrder {
+		u.RawQuery = encodeOrdered(r.OrderedParams)
+	} else {
+		u.RawQuery = r.QueryParams.Encode()
+	}
 	return u.String()
 }
 
+// encodeOrdered encodes query parameters in the given order
+func encodeOrdered(params []QueryParam) string {
+	var buf bytes.Buffer
+	for i, param := range params {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(url.QueryEscape(param.Key))
+		buf.WriteByte('=')
+		buf.WriteString(url.QueryEscape(param.Value))
+	}
+	return buf.String()
+}
+
 // AddQueryParam adds a query parameter to the request
 func (r *Request) AddQueryParam(key, value string) {
 	r.QueryParams.Add(key, value)
+	r.OrderedParams = append(r.OrderedParams, QueryParam{Key: key, Value: value})
 }
 
 // Send sends the request and returns the response
 func (r *Request) Send() (*http.Response, error) {
-	// Check if we already have a cached token for this host
-	// and authenticate immediately if so
-	authenticated := false
-	_, tokenErr := GetCachedToken(r.Host)
-	if tokenErr == nil {
-		// We already have a token, use it right away
-		authenticated = true
-		r.Debug("Found cached token for %s, using it for first request", r.Host)
-	}
-
 	r.Debug("Send request to URL: %s", r.GetURL())
 	r.Debug("Request headers: %v", r.Headers)
 	r.Debug("Request method: %s", r.Method)
 
-	// Create a client that ignores SSL certificate errors
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, // Skip certificate verification
-		},
-	}
-
 	// Use custom timeout if set, otherwise use default
 	timeout := 3 * time.Second // Default timeout
 	if r.Timeout > 0 {
 		timeout = r.Timeout
-	}
-
-	if r.Timeout > 0 {
 		r.Debug("Using custom timeout of %s", r.Timeout)
 	}
 
+	// Authentication, including the retry on 401, is handled by the transport
 	client := &http.Client{
-		Transport: tr,
-		Timeout:   timeout,
+		Transport: &AuthTransport{
+			Host:               r.Host,
+			Version:            r.Version,
+			Username:           r.Credentials.Username,
+			Password:           r.Credentials.Password,
+			CredentialProvider: r.CredentialProvider,
+			BasePath:           r.BasePath,
+			PinnedCertSHA256:   r.PinnedCertSHA256,
+			UserAgent:          r.UserAgent,
+			Headers:            r.ExtraHeaders,
+			NoAuth:             r.NoAuth,
+			Base:               newBMCTransport(r.PinnedCertSHA256),
+		},
+		Timeout: timeout,
 	}
 
-	var resp *http.Response
-
-	for {
-		// Create a new request
-		var reqBody io.Reader
-		if r.MultipartForm != nil {
-			reqBody = r.MultipartForm
-		}
-
-		var req *http.Request
-		var err error
-
-		// Use context if available
-		if r.Context != nil {
-			req, err = http.NewRequestWithContext(r.Context, r.Method, r.GetURL(), reqBody)
-			r.Debug("Creating request with context")
-		} else {
-			req, err = http.NewRequest(r.Method, r.GetURL(), reqBody)
-		}
-
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		// Set headers
-		for k, v := range r.Headers {
-			req.Header.Set(k, v)
-		}
-
-		// Set content type if specified
-		if r.ContentType != "" {
-			req.Header.Set("Content-Type", r.ContentType)
-		}
+	var reqBody io.Reader
+	if r.MultipartForm != nil {
+		reqBody = r.MultipartForm
+	} else if r.Body != nil {
+		reqBody = r.Body
+	}
 
-		// Add authentication if required
-		if authenticated {
-			token, err := r.getBearerToken()
-			if err != nil {
-				return nil, fmt.Errorf("failed to get bearer token: %w", err)
-			}
+	var req *http.Request
+	var err error
 
-			// Set Authorization header with Bearer prefix
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-			r.Debug("Setting Authorization header with Bearer prefix: Bearer %s", token)
-		}
+	// Use context if available
+	if r.Context != nil {
+		req, err = http.NewRequestWithContext(r.Context, r.Method, r.GetURL(), reqBody)
+		r.Debug("Creating request with context")
+	} else {
+		req, err = http.NewRequest(r.Method, r.GetURL(), reqBody)
+	}
 
-		// Send the request
-		resp, err = client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to send request: %w", err)
-		}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
 
-		r.Debug("Response status: %d", resp.StatusCode)
+	// Streamed bodies don't expose their length, so set it explicitly
+	if r.Body != nil && r.ContentLength > 0 {
+		req.ContentLength = r.ContentLength
+	}
 
-		// If unauthorized and not already authenticated, try again with authentication
-		if resp.StatusCode == http.StatusUnauthorized {
-			resp.Body.Close()
+	// Set headers, extra headers first so the library's own take precedence
+	addExtraHeaders(req.Header, r.ExtraHeaders)
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
 
-			if authenticated {
-				// We got a 401 despite using a token, so the token is likely invalid
-				r.Debug("Got 401 Unauthorized with a token, token may be expired. Deleting cached token.")
-				DeleteCachedToken(r.Host)
-			}
+	// Set content type if specified
+	if r.ContentType != "" {
+		req.Header.Set("Content-Type", r.ContentType)
+	}
 
-			if !authenticated {
-				r.Debug("Got 401 Unauthorized, trying again with authentication")
-				authenticated = true
-				continue
-			} else {
-				// We already tried with authentication and still got 401
-				return resp, nil
-			}
+	// Rate limited responses are retried after the wait the BMC asks for
+	resp, err := doWithRetryAfter(req, func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := client.Do(req)
+		r.stats.record(r.operation(), time.Since(start), err != nil || resp.StatusCode >= http.StatusBadRequest)
+		return resp, err
+	})
+	if err != nil {
+		if isTimeout(err) {
+			err = &TimeoutError{Timeout: timeout, Err: err}
 		}
-
-		// No further authentication needed, return the response
-		return resp, nil
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
+
+	r.Debug("Response status: %d", resp.StatusCode)
+	return resp, nil
 }
 
 // getBearerToken retrieves the bearer token for authentication
@@ -302,23 +365,25 @@ func (r *Request) getBearerToken() (string, error) {
 		return r.requestToken()
 	}
 
-	// Try with default credentials as a last resort
-	originalUsername := r.Credentials.Username
-	originalPassword := r.Credentials.Password
+	// Otherwise ask the credential provider, the default one tries the
+	// environment and netrc before the common defaults
+	provider := r.CredentialProvider
+	if provider == nil {
+		provider = DefaultCredentialProvider()
+	}
 
-	// Try common default credentials
-	credentialPairs := []struct{ username, password string }{
-		{"root", ""},             // Empty password
-		{"root", "turing"},       // Default Turing Pi password
-		{"root", "root"},         // Common default
-		{"admin", "admin"},       // Common default
-		{"turingpi", "turingpi"}, // Product-specific
+	candidates, err := candidateCredentials(provider, r.Host)
+	if err != nil {
+		return "", fmt.Errorf("failed to get credentials: %w", err)
 	}
 
-	var lastErr error
-	for _, creds := range credentialPairs {
-		r.Credentials.Username = creds.username
-		r.Credentials.Password = creds.password
+	originalUsername := r.Credentials.Username
+	originalPassword := r.Credentials.Password
+
+	lastErr := ErrNoCredentials
+	for _, creds := range candidates {
+		r.Credentials.Username = creds.Username
+		r.Credentials.Password = creds.Password
 
 		token, err := r.requestToken()
 		if err == nil {
@@ -344,8 +409,7 @@ func (r *Request) requestToken() (string, error) {
 	r.Debug("Auth attempt with user: %s to URL: %s", username, r.Host)
 
 	// Construct authentication URL
-	baseURL := fmt.Sprintf("%s://%s", r.Version.GetScheme(), r.Host)
-	authURL := fmt.Sprintf("%s/api/bmc/authenticate", baseURL)
+	authURL := r.Version.BaseURL(r.Host) + r.Version.Paths(r.BasePath).Auth()
 
 	r.Debug("Auth URL: %s", authURL)
 
@@ -369,17 +433,13 @@ func (r *Request) requestToken() (string, error) {
 	}
 
 	// Set Content-Type to application/json
+	addExtraHeaders(req.Header, r.ExtraHeaders)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", r.UserAgent)
 
 	// Create a client that ignores SSL certificate errors
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, // Skip certificate verification
-		},
-	}
 	client := &http.Client{
-		Transport: tr,
+		Transport: newBMCTransport(r.PinnedCertSHA256),
 		Timeout:   3 * time.Second,
 	}
 
@@ -393,8 +453,11 @@ func (r *Request) requestToken() (string, error) {
 		body, _ := io.ReadAll(resp.Body)
 		r.Debug("Auth failed with status: %d, body: %s", resp.StatusCode, string(body))
 
-		if resp.StatusCode == http.StatusForbidden {
-			return "", fmt.Errorf("authentication failed: invalid credentials")
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+			return "", authFailure(resp, time.Now())
+		}
+		if err := checkHTMLResponse(resp, body); err != nil {
+			return "", fmt.Errorf("authentication failed: %w", err)
 		}
 
 		return "", fmt.Errorf("authentication failed: %s", string(body))
@@ -402,7 +465,7 @@ func (r *Request) requestToken() (string, error) {
 
 	// Parse response
 	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+	if err := decodeJSONResponse(resp, &response); err != nil {
 		return "", fmt.Errorf("failed to parse auth response: %w", err)
 	}
 
@@ -504,41 +567,70 @@ func (rb *requestBuilder) build() (*http.Request, error) {
 	return req, nil
 }
 
-// extractResultObject extracts the result object from the response
-func extractResultObject(resp *http.Response) (map[string]interface{}, error) {
-	// Try to parse as the common expected structure
-	var result struct {
-		Result map[string]interface{} `json:"result"`
+// resultEnvelope holds the result of a BMC answer, which firmware versions wrap either as
+// {"response": [{"result": ...}]} or as a top-level {"result": ...}
+type resultEnvelope struct {
+	Response []struct {
+		Result json.RawMessage `json:"result"`
+	} `json:"response"`
+	Result json.RawMessage `json:"result"`
+}
+
+// result returns the raw result of the answer, whichever envelope it came in, nil if none
+func (e *resultEnvelope) result() json.RawMessage {
+	if len(e.Response) > 0 && len(e.Response[0].Result) > 0 {
+		return e.Response[0].Result
+	}
+	return e.Result
+}
+
+// decodeResult decodes the result of resp into v, accepting both envelopes of
+// resultEnvelope. It returns false, leaving v untouched, if the answer has no result.
+func decodeResult(resp *http.Response, v interface{}) (bool, error) {
+	var envelope resultEnvelope
+	if err := decodeJSONResponse(resp, &envelope); err != nil {
+		return false, err
+	}
+
+	raw := envelope.result()
+	if len(raw) == 0 || string(raw) == "null" {
+		return false, nil
 	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
+// extractResultObject extracts the result object from the response, in either envelope of
+// resultEnvelope. A result that is a list, as the power status endpoint answers, gives
+// its first object.
+func extractResultObject(resp *http.Response) (map[string]interface{}, error) {
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
+	if err := checkHTMLResponse(resp, body); err != nil {
+		return nil, err
+	}
 
 	// Since we read the body, create a new reader for additional parsing attempts
 	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
 
-	// First try the simple structure
-	if err := json.Unmarshal(body, &result); err == nil && len(result.Result) > 0 {
-		return result.Result, nil
-	}
-
-	// If that fails, try the nested structure used by the power status endpoint
-	var nestedResult struct {
-		Response []struct {
-			Result []map[string]interface{} `json:"result"`
-		} `json:"response"`
+	var envelope resultEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode nested response: %w", err)
 	}
+	raw := envelope.result()
 
-	if err := json.Unmarshal(body, &nestedResult); err != nil {
-		return nil, fmt.Errorf("failed to decode nested response: %w", err)
+	var object map[string]interface{}
+	if err := json.Unmarshal(raw, &object); err == nil && len(object) > 0 {
+		return object, nil
 	}
 
-	// Check if we have a valid nested structure
-	if len(nestedResult.Response) > 0 && len(nestedResult.Response[0].Result) > 0 {
-		// Return the first result object
-		return nestedResult.Response[0].Result[0], nil
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(raw, &objects); err == nil && len(objects) > 0 {
+		return objects[0], nil
 	}
 
 	// Fall back to an empty map if we couldn't parse anything useful
@@ -0,0 +1,245 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how retry and polling loops back off between attempts, such as
+// RebootAndWait waiting for the BMC and the flash progress monitor retrying after
+// errors. Each wait is randomized by up to Jitter of its length, so several clients
+// polling the same BMC don't synchronize.
+type RetryPolicy struct {
+	InitialWait time.Duration // Wait before the first retry
+	MaxWait     time.Duration // Cap on the wait, before jitter
+	Multiplier  float64       // Growth of the wait after each retry, at least 1
+	Jitter      float64       // Fraction of the wait added or removed at random, in [0, 1)
+}
+
+// Default retry policies of the polling loops, used unless WithRetryPolicy is given
+var (
+	DefaultRebootRetryPolicy = RetryPolicy{
+		InitialWait: 1500 * time.Millisecond,
+		MaxWait:     5 * time.Second,
+		Multiplier:  1.5,
+		Jitter:      0.2,
+	}
+	DefaultFlashPollRetryPolicy = RetryPolicy{
+		InitialWait: 500 * time.Millisecond,
+		MaxWait:     10 * time.Second,
+		Multiplier:  1.5,
+		Jitter:      0.2,
+	}
+	DefaultFlashInitRetryPolicy = RetryPolicy{
+		InitialWait: 3 * time.Second,
+		MaxWait:     10 * time.Second,
+		Multiplier:  1.5,
+		Jitter:      0.2,
+	}
+	DefaultMsdRetryPolicy = RetryPolicy{
+		InitialWait: 1 * time.Second,
+		MaxWait:     5 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+	}
+	DefaultEthResetRetryPolicy = RetryPolicy{
+		InitialWait: 1 * time.Second,
+		MaxWait:     10 * time.Second,
+		Multiplier:  2,
+	}
+	DefaultNodeCheckRetryPolicy = RetryPolicy{
+		InitialWait: 1 * time.Second,
+		MaxWait:     30 * time.Second,
+		Multiplier:  2,
+	}
+	DefaultSnapshotRetryPolicy = RetryPolicy{
+		InitialWait: 250 * time.Millisecond,
+		MaxWait:     1 * time.Second,
+		Multiplier:  2,
+	}
+)
+
+// WithRetryPolicy sets the backoff of every retry and polling loop of the client, such as
+// RebootAndWait, flashing, MSD mode, WaitForNode and Snapshot, instead of their defaults
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		if err := policy.validate(); err != nil {
+			c.optionErr = err
+			return
+		}
+		c.retry = &policy
+	}
+}
+
+// validate checks that the policy describes a usable backoff
+func (p RetryPolicy) validate() error {
+	switch {
+	case p.InitialWait <= 0:
+		return fmt.Errorf("retry policy initial wait must be positive")
+	case p.MaxWait < p.InitialWait:
+		return fmt.Errorf("retry policy max wait must be at least the initial wait")
+	case p.Multiplier < 1:
+		return fmt.Errorf("retry policy multiplier must be at least 1")
+	case p.Jitter < 0 || p.Jitter >= 1:
+		return fmt.Errorf("retry policy jitter must be in [0, 1)")
+	}
+	return nil
+}
+
+// retryPolicy returns the policy configured with WithRetryPolicy, or defaultPolicy
+func (c *Client) retryPolicy(defaultPolicy RetryPolicy) RetryPolicy {
+	if c.retry != nil {
+		return *c.retry
+	}
+	return defaultPolicy
+}
+
+// backoff returns the wait before a retry, attempt starting at 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := float64(p.InitialWait)
+	for i := 1; i < attempt && wait < float64(p.MaxWait); i++ {
+		wait *= p.Multiplier
+	}
+	if wait > float64(p.MaxWait) {
+		wait = float64(p.MaxWait)
+	}
+	if p.Jitter > 0 {
+		wait *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(wait)
+}
+
+// backoff steps through the waits of a RetryPolicy, one call to Next per retry
+type backoff struct {
+	policy  RetryPolicy
+	retries int
+}
+
+// newBackoff returns a backoff starting from the initial wait of policy
+func newBackoff(policy RetryPolicy) *backoff {
+	return &backoff{policy: policy}
+}
+
+// Next returns the wait before the next retry, grown by the multiplier up to the max wait
+func (b *backoff) Next() time.Duration {
+	b.retries++
+	return b.policy.backoff(b.retries)
+}
+
+// Retries returns the number of waits handed out by Next since the last Reset
+func (b *backoff) Retries() int {
+	return b.retries
+}
+
+// Reset starts over from the initial wait, after an attempt succeeded
+func (b *backoff) Reset() {
+	b.retries = 0
+}
+
+// Sleep waits for Next, returning ctx's error if ctx is done first
+func (b *backoff) Sleep(ctx context.Context) error {
+	timer := time.NewTimer(b.Next())
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimitMaxWait is the longest Retry-After DoWithRetryAfter waits for, a longer
+// one is returned to the caller instead of blocking it
+var rateLimitMaxWait = 30 * time.Second
+
+// DoWithRetryAfter sends req with client, retrying rate limited (429) responses up to
+// DefaultRetries times after the wait their Retry-After header asks for, or
+// DefaultRetryWait without one. Server errors (5xx) aren't retried here, they're left
+// to the callers' retry policies. The last 429 is returned as is when the retries are
+// exhausted, the wait is over 30s or the request body can't be replayed.
+func DoWithRetryAfter(client *http.Client, req *http.Request) (*http.Response, error) {
+	return doWithRetryAfter(req, client.Do)
+}
+
+// doWithRetryAfter implements DoWithRetryAfter around a single attempt do
+func doWithRetryAfter(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt > DefaultRetries {
+			return resp, err
+		}
+
+		wait, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			wait = DefaultRetryWait
+		}
+		if wait > rateLimitMaxWait {
+			return resp, nil
+		}
+
+		// The body was consumed by the attempt, so rewind it for the next one
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		Debug("Rate limited (429), retrying in %s", wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// ParseRetryAfter returns the wait a Retry-After header value asks for, given either
+// as seconds or as an HTTP date relative to now. A date in the past means no wait.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
+}
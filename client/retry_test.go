@@ -0,0 +1,340 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shortenRetryPolicy makes a default retry policy wait milliseconds for the duration of the test
+func shortenRetryPolicy(t *testing.T, policy *RetryPolicy) {
+	t.Helper()
+	original := *policy
+	*policy = RetryPolicy{InitialWait: time.Millisecond, MaxWait: 4 * time.Millisecond, Multiplier: 2}
+	t.Cleanup(func() { *policy = original })
+}
+
+func TestRetryPolicyBackoffJitter(t *testing.T) {
+	policy := RetryPolicy{InitialWait: time.Second, MaxWait: 5 * time.Second, Multiplier: 1.5, Jitter: 0.2}
+
+	// Nominal waits: 1s, 1.5s, 2.25s, 3.375s, then capped at 5s
+	nominal := []time.Duration{
+		time.Second,
+		1500 * time.Millisecond,
+		2250 * time.Millisecond,
+		3375 * time.Millisecond,
+		5 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	}
+
+	for run := 0; run < 50; run++ {
+		distinct := make(map[time.Duration]bool)
+		for i, want := range nominal {
+			got := policy.backoff(i + 1)
+			low := time.Duration(float64(want) * (1 - policy.Jitter))
+			high := time.Duration(float64(want) * (1 + policy.Jitter))
+			if got < low || got > high {
+				t.Fatalf("Attempt %d: expected a wait in [%s, %s], got %s", i+1, low, high, got)
+			}
+			if want == policy.MaxWait {
+				distinct[got] = true
+			}
+		}
+		if len(distinct) < 2 {
+			t.Fatalf("Expected jitter to vary the capped waits, got %v", distinct)
+		}
+	}
+
+	// Without jitter the waits are exact
+	policy.Jitter = 0
+	for i, want := range nominal {
+		if got := policy.backoff(i + 1); got != want {
+			t.Errorf("Attempt %d: expected %s without jitter, got %s", i+1, want, got)
+		}
+	}
+}
+
+func TestWithRetryPolicy(t *testing.T) {
+	client, err := NewClient(WithHost("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if got := client.retryPolicy(DefaultRebootRetryPolicy); got != DefaultRebootRetryPolicy {
+		t.Errorf("Expected the default policy, got %+v", got)
+	}
+
+	custom := RetryPolicy{InitialWait: 100 * time.Millisecond, MaxWait: time.Second, Multiplier: 2, Jitter: 0.1}
+	client, err = NewClient(WithHost("10.0.0.1"), WithRetryPolicy(custom))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if got := client.retryPolicy(DefaultFlashPollRetryPolicy); got != custom {
+		t.Errorf("Expected the custom policy, got %+v", got)
+	}
+
+	invalid := []RetryPolicy{
+		{InitialWait: 0, MaxWait: time.Second, Multiplier: 2},
+		{InitialWait: time.Second, MaxWait: time.Millisecond, Multiplier: 2},
+		{InitialWait: time.Second, MaxWait: time.Second, Multiplier: 0.5},
+		{InitialWait: time.Second, MaxWait: time.Second, Multiplier: 2, Jitter: 1},
+	}
+	for _, policy := range invalid {
+		if _, err := NewClient(WithHost("10.0.0.1"), WithRetryPolicy(policy)); err == nil {
+			t.Errorf("Expected %+v to be refused", policy)
+		}
+	}
+}
+
+func TestRetryAfterOnRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	start := time.Now()
+	if err := client.PowerOn(1); err != nil {
+		t.Fatalf("Expected the request to succeed after the rate limit, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("Expected the client to wait for Retry-After, retried after %s", elapsed)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected 2 requests, got %d", got)
+	}
+}
+
+func TestRetryAfterNotForServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			calls.Add(1)
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	if err := client.PowerOn(1); err == nil {
+		t.Error("Expected an error for a 503")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected a 503 not to be retried, got %d requests", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"3", 3 * time.Second, true},
+		{" 0 ", 0, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseRetryAfter(%q) = %s, %v; expected %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBackoffSequence(t *testing.T) {
+	retry := newBackoff(RetryPolicy{InitialWait: 100 * time.Millisecond, MaxWait: 500 * time.Millisecond, Multiplier: 2})
+
+	for i, want := range []time.Duration{100, 200, 400, 500, 500} {
+		if got := retry.Next(); got != want*time.Millisecond {
+			t.Errorf("Retry %d: expected %s, got %s", i+1, want*time.Millisecond, got)
+		}
+	}
+	if retry.Retries() != 5 {
+		t.Errorf("Expected 5 retries, got %d", retry.Retries())
+	}
+
+	retry.Reset()
+	if got := retry.Next(); got != 100*time.Millisecond {
+		t.Errorf("Expected the initial wait after a reset, got %s", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := retry.Sleep(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Sleep to stop when the context is done, got %v", err)
+	}
+}
+
+// flakyHandler answers the requests of the given type with a 500 for the first failures,
+// then with body, and counts them
+func flakyHandler(requestType string, failures int, body string, calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Query().Get("type") == requestType:
+			if int(calls.Add(1)) <= failures {
+				http.Error(w, "busy", http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(body))
+		default:
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		}
+	})
+}
+
+func TestStartFlashTransferRetries(t *testing.T) {
+	shortenRetryPolicy(t, &DefaultFlashInitRetryPolicy)
+
+	var calls atomic.Int32
+	client, _ := newMockClient(t, flakyHandler("flash", 2, `{"handle":5}`, &calls))
+	handle, err := client.startFlashTransfer(1, "image.img", 100, "", false, newFlashOutput(ProgressHuman, io.Discard))
+	if err != nil || handle != 5 {
+		t.Fatalf("Expected handle 5 after two failures, got %d, %v", handle, err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+
+	calls.Store(0)
+	client, _ = newMockClient(t, flakyHandler("flash", 3, `{"handle":5}`, &calls))
+	if _, err := client.startFlashTransfer(1, "image.img", 100, "", false, newFlashOutput(ProgressHuman, io.Discard)); err == nil {
+		t.Error("Expected an error once the attempts ran out")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+func TestFlashNodeLocalRetries(t *testing.T) {
+	shortenRetryPolicy(t, &DefaultFlashInitRetryPolicy)
+
+	var calls atomic.Int32
+	client, _ := newMockClient(t, flakyHandler("update", 1, `{"response":[{"result":"ok"}]}`, &calls))
+	var out bytes.Buffer
+	if err := client.FlashNodeLocalWithOptions(2, "/mnt/sdcard/image.img", &LocalFlashOptions{ProgressWriter: &out}); err != nil {
+		t.Fatalf("Expected the flash to succeed after a failure, got: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected 2 attempts, got %d", calls.Load())
+	}
+	if !strings.Contains(out.String(), "Retrying in") || !strings.Contains(out.String(), "completed successfully") {
+		t.Errorf("Expected the retry and the result in the progress output, got: %q", out.String())
+	}
+}
+
+func TestRebootAndWaitRetries(t *testing.T) {
+	settle := rebootSettleWait
+	rebootSettleWait = 0
+	t.Cleanup(func() { rebootSettleWait = settle })
+
+	var calls atomic.Int32
+	client, _ := newMockClient(t, flakyHandler("other", 3, `{"response":[{"result":[{"version":"2.0.5"}]}]}`, &calls),
+		WithRetryPolicy(RetryPolicy{InitialWait: time.Millisecond, MaxWait: 4 * time.Millisecond, Multiplier: 2}))
+	if err := client.RebootAndWait(10); err != nil {
+		t.Fatalf("Expected the BMC to come back, got: %v", err)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("Expected 4 checks, got %d", calls.Load())
+	}
+}
+
+func TestRebootAndWaitContextCancelled(t *testing.T) {
+	settle := rebootSettleWait
+	rebootSettleWait = 0
+	t.Cleanup(func() { rebootSettleWait = settle })
+
+	// The BMC never comes back and the client waits long between checks
+	var calls atomic.Int32
+	client, _ := newMockClient(t, flakyHandler("other", 1000, "", &calls),
+		WithRetryPolicy(RetryPolicy{InitialWait: time.Minute, MaxWait: time.Minute, Multiplier: 1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	result, err := client.RebootAndWaitContext(ctx, time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected a prompt return after the cancel, took %s", elapsed)
+	}
+	if result == nil || result.Attempts != 1 || result.Elapsed <= 0 {
+		t.Errorf("Expected the progress so far with 1 attempt, got %+v", result)
+	}
+}
+
+func TestSetNodeMsdModeRetries(t *testing.T) {
+	shortenRetryPolicy(t, &DefaultMsdRetryPolicy)
+	timeouts := msdTimeouts
+	msdTimeouts = []time.Duration{50 * time.Millisecond, 5 * time.Second}
+	t.Cleanup(func() { msdTimeouts = timeouts })
+
+	var calls atomic.Int32
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Query().Get("type") == "node_to_msd":
+			// The first attempt outlives its timeout, like a node slow to reboot
+			if calls.Add(1) == 1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		}
+	}))
+
+	if err := client.SetNodeMsdMode(1); err != nil {
+		t.Fatalf("Expected MSD mode to be set on the retry, got: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected 2 attempts, got %d", calls.Load())
+	}
+}
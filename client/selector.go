@@ -0,0 +1,158 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// nodeCount is the number of nodes of the board
+const nodeCount = 4
+
+// ErrInvalidNodeSelector is returned for node selector expressions that can't be parsed
+var ErrInvalidNodeSelector = errors.New("invalid node selector")
+
+// ResolveNodeSelector resolves a node selector expression to the nodes it selects:
+//   - a node, list or range such as "2", "1,3" or "1-2,4", in the order given
+//   - all, every node
+//   - on or off, the nodes currently powered on or off according to PowerStatus
+//   - except:<selector>, every node but those selected, e.g. except:2 or except:on
+//
+// Only on and off query c, which may be nil for the other forms. Dynamic selectors may
+// resolve to no nodes; errors parsing expr wrap ErrInvalidNodeSelector.
+func ResolveNodeSelector(c *Client, expr string) ([]int, error) {
+	return resolveNodeSelector(expr, func() (map[int]bool, error) {
+		if c == nil {
+			return nil, fmt.Errorf("node selector %q needs a client to read the power status", expr)
+		}
+		return c.PowerStatus()
+	})
+}
+
+// ValidateNodeSelector checks the syntax of a node selector expression without resolving
+// dynamic selectors, see ResolveNodeSelector
+func ValidateNodeSelector(expr string) error {
+	_, err := resolveNodeSelector(expr, func() (map[int]bool, error) {
+		return map[int]bool{}, nil
+	})
+	return err
+}
+
+// resolveNodeSelector resolves expr, reading the power status from status for on and off
+func resolveNodeSelector(expr string, status func() (map[int]bool, error)) ([]int, error) {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+
+	if rest, ok := strings.CutPrefix(expr, "except:"); ok {
+		if strings.HasPrefix(strings.TrimSpace(rest), "except:") {
+			return nil, fmt.Errorf("%w: %q can't be nested", ErrInvalidNodeSelector, "except:")
+		}
+		excluded, err := resolveNodeSelector(rest, status)
+		if err != nil {
+			return nil, err
+		}
+
+		skip := make(map[int]bool, len(excluded))
+		for _, node := range excluded {
+			skip[node] = true
+		}
+		nodes := []int{}
+		for node := 1; node <= nodeCount; node++ {
+			if !skip[node] {
+				nodes = append(nodes, node)
+			}
+		}
+		return nodes, nil
+	}
+
+	switch expr {
+	case "all":
+		nodes := make([]int, 0, nodeCount)
+		for node := 1; node <= nodeCount; node++ {
+			nodes = append(nodes, node)
+		}
+		return nodes, nil
+	case "on", "off":
+		power, err := status()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve node selector %q: %w", expr, err)
+		}
+		nodes := []int{}
+		for node := 1; node <= nodeCount; node++ {
+			if on, ok := power[node]; ok && on == (expr == "on") {
+				nodes = append(nodes, node)
+			}
+		}
+		return nodes, nil
+	}
+
+	return parseNodeRanges(expr)
+}
+
+// parseNodeRanges parses a list of nodes and ranges such as "1-2,4", keeping the order given
+func parseNodeRanges(expr string) ([]int, error) {
+	var nodes []int
+	seen := make(map[int]bool)
+
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		first, last := part, part
+		if from, to, ok := strings.Cut(part, "-"); ok {
+			first, last = from, to
+		}
+
+		start, err := parseSelectorNode(first)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseSelectorNode(last)
+		if err != nil {
+			return nil, err
+		}
+		if start > end {
+			return nil, fmt.Errorf("%w: invalid node range %s", ErrInvalidNodeSelector, part)
+		}
+
+		for node := start; node <= end; node++ {
+			if !seen[node] {
+				seen[node] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("%w: no nodes given", ErrInvalidNodeSelector)
+	}
+	return nodes, nil
+}
+
+// parseSelectorNode parses a single node number of a selector
+func parseSelectorNode(value string) (int, error) {
+	node, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is not a node, a range, all, on, off or except:<nodes>", ErrInvalidNodeSelector, value)
+	}
+	if node < 1 || node > nodeCount {
+		return 0, fmt.Errorf("%w: node number must be between 1 and %d, got %d", ErrInvalidNodeSelector, nodeCount, node)
+	}
+	return node, nil
+}
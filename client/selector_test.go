@@ -0,0 +1,87 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestResolveNodeSelector(t *testing.T) {
+	// Nodes 1 and 3 are on
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":1,"node4":0}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	tests := []struct {
+		expr     string
+		expected []int
+	}{
+		{"2", []int{2}},
+		{"3,1", []int{3, 1}},
+		{"1-2,4", []int{1, 2, 4}},
+		{"1-3,2", []int{1, 2, 3}},
+		{"all", []int{1, 2, 3, 4}},
+		{"ALL", []int{1, 2, 3, 4}},
+		{"on", []int{1, 3}},
+		{"off", []int{2, 4}},
+		{"except:2", []int{1, 3, 4}},
+		{"except:1-3", []int{4}},
+		{"except:on", []int{2, 4}},
+		{"except:all", []int{}},
+	}
+
+	for _, test := range tests {
+		nodes, err := ResolveNodeSelector(client, test.expr)
+		if err != nil {
+			t.Errorf("ResolveNodeSelector(%q) failed: %v", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(nodes, test.expected) {
+			t.Errorf("ResolveNodeSelector(%q) = %v, expected %v", test.expr, nodes, test.expected)
+		}
+	}
+}
+
+func TestResolveNodeSelectorInvalid(t *testing.T) {
+	for _, expr := range []string{"", "5", "0", "3-1", "node1", "except:", "except:except:1", "1,x"} {
+		if _, err := ResolveNodeSelector(nil, expr); !errors.Is(err, ErrInvalidNodeSelector) {
+			t.Errorf("ResolveNodeSelector(%q): expected ErrInvalidNodeSelector, got %v", expr, err)
+		}
+		if err := ValidateNodeSelector(expr); !errors.Is(err, ErrInvalidNodeSelector) {
+			t.Errorf("ValidateNodeSelector(%q): expected ErrInvalidNodeSelector, got %v", expr, err)
+		}
+	}
+
+	// Static selectors don't need a client, dynamic ones do
+	if nodes, err := ResolveNodeSelector(nil, "except:4"); err != nil || !reflect.DeepEqual(nodes, []int{1, 2, 3}) {
+		t.Errorf("Expected except:4 to resolve without a client, got %v, %v", nodes, err)
+	}
+	if _, err := ResolveNodeSelector(nil, "on"); err == nil {
+		t.Error("Expected on to fail without a client")
+	}
+	if err := ValidateNodeSelector("except:off"); err != nil {
+		t.Errorf("Expected except:off to be valid, got %v", err)
+	}
+}
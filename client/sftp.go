@@ -15,27 +15,66 @@
 package tpi
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
+// ErrNoSSHCredentials is returned when an SSH connection has no way to authenticate
+var ErrNoSSHCredentials = errors.New("no SSH credentials: set a password with WithSSHCredentials, a private key or WithSSHAgent")
+
 // SSHConfig holds the configuration for SSH connections
 type SSHConfig struct {
-	Host       string
-	Port       int
-	User       string
+	Host string
+	Port int
+	User string
+	// Password defaults to the BMC password when connecting to the BMC. Nodes and other
+	// hosts never get it, set their password with WithSSHCredentials or WithSSHPassword.
 	Password   string
 	PrivateKey string
-	Timeout    time.Duration
+	// PrivateKeyFile is read for the private key when PrivateKey is empty
+	PrivateKeyFile string
+	// Passphrase decrypts an encrypted private key
+	Passphrase string
+	// Agent authenticates with the keys of the SSH agent listening on SSH_AUTH_SOCK
+	Agent bool
+	// Node connects to a compute node [1-4] instead of Host, at the address set with
+	// WithNodeHosts or else the one the BMC reports in NodeNetworkInfo
+	Node    int
+	Timeout time.Duration
+	// Progress is called while files are uploaded or downloaded
+	Progress TransferProgressFunc
+}
+
+// TransferProgress is the state of an SFTP upload or download
+type TransferProgress struct {
+	Bytes int64
+	Total int64
+	// Speed is in bytes per second, averaged over the last few updates; 0 until known
+	Speed float64
+	// ETA is the estimated time left at that speed; 0 until known
+	ETA time.Duration
 }
 
+// TransferProgressFunc receives the progress of an SFTP transfer, at most every
+// transferProgressInterval and once more when the transfer completes
+type TransferProgressFunc func(TransferProgress)
+
+// Interval between progress updates of SFTP transfers, a variable so tests can shorten it
+var transferProgressInterval = 500 * time.Millisecond
+
 // SSHOption is a function that configures an SSHConfig
 type SSHOption func(*SSHConfig)
 
@@ -47,6 +86,20 @@ func WithSSHCredentials(username, password string) SSHOption {
 	}
 }
 
+// WithSSHPassword sets the SSH password, keeping the user
+func WithSSHPassword(password string) SSHOption {
+	return func(c *SSHConfig) {
+		c.Password = password
+	}
+}
+
+// WithSSHUser sets the SSH user, keeping the password
+func WithSSHUser(username string) SSHOption {
+	return func(c *SSHConfig) {
+		c.User = username
+	}
+}
+
 // WithSSHPrivateKey sets the SSH private key
 func WithSSHPrivateKey(privateKey string) SSHOption {
 	return func(c *SSHConfig) {
@@ -54,6 +107,50 @@ func WithSSHPrivateKey(privateKey string) SSHOption {
 	}
 }
 
+// WithSSHPrivateKeyFile sets the file the SSH private key is read from
+func WithSSHPrivateKeyFile(path string) SSHOption {
+	return func(c *SSHConfig) {
+		c.PrivateKeyFile = path
+	}
+}
+
+// WithSSHPrivateKeyPassphrase sets the passphrase of an encrypted SSH private key
+func WithSSHPrivateKeyPassphrase(passphrase string) SSHOption {
+	return func(c *SSHConfig) {
+		c.Passphrase = passphrase
+	}
+}
+
+// WithSSHAgent authenticates with the keys of the SSH agent listening on SSH_AUTH_SOCK
+func WithSSHAgent() SSHOption {
+	return func(c *SSHConfig) {
+		c.Agent = true
+	}
+}
+
+// WithSSHHost connects to host instead of the BMC
+func WithSSHHost(host string) SSHOption {
+	return func(c *SSHConfig) {
+		c.Host = host
+		c.Node = 0
+	}
+}
+
+// WithSSHTargetNode connects to a compute node [1-4] instead of the BMC, see SSHConfig.Node
+func WithSSHTargetNode(node int) SSHOption {
+	return func(c *SSHConfig) {
+		c.Node = node
+	}
+}
+
+// WithSSHDefaults sets SSH options applied to every SSH connection of the client, before
+// the options of the call, e.g. WithSSHTargetNode to work on a node's files by default
+func WithSSHDefaults(options ...SSHOption) Option {
+	return func(c *Client) {
+		c.sshDefaults = append([]SSHOption(nil), options...)
+	}
+}
+
 // WithSSHPort sets the SSH port
 func WithSSHPort(port int) SSHOption {
 	return func(c *SSHConfig) {
@@ -68,6 +165,13 @@ func WithSSHTimeout(timeout time.Duration) SSHOption {
 	}
 }
 
+// WithSSHProgress reports the progress of UploadFile and DownloadFile to fn
+func WithSSHProgress(fn TransferProgressFunc) SSHOption {
+	return func(c *SSHConfig) {
+		c.Progress = fn
+	}
+}
+
 // FileInfo represents information about a file on the remote system
 type FileInfo struct {
 	Name    string
@@ -77,8 +181,8 @@ type FileInfo struct {
 	IsDir   bool
 }
 
-// getSSHClient creates an SSH client connection
-func (c *Client) getSSHClient(options ...SSHOption) (*ssh.Client, error) {
+// newSSHConfig returns the SSH configuration of the client with options applied
+func (c *Client) newSSHConfig(options ...SSHOption) *SSHConfig {
 	// Default SSH configuration
 	sshConfig := &SSHConfig{
 		Host:    c.Host,
@@ -87,40 +191,174 @@ func (c *Client) getSSHClient(options ...SSHOption) (*ssh.Client, error) {
 		Timeout: 10 * time.Second,
 	}
 
-	// Apply options
+	// Apply the client's defaults, then the options of the call
+	for _, option := range c.sshDefaults {
+		option(sshConfig)
+	}
 	for _, option := range options {
 		option(sshConfig)
 	}
 
+	// The BMC password only ever goes to the BMC
+	if sshConfig.Password == "" && sshConfig.Node == 0 && sshConfig.Host == c.Host {
+		sshConfig.Password = c.auth.Password
+	}
+
+	return sshConfig
+}
+
+// sshDial connects to an SSH server until ctx is done, which closes the connection if the
+// handshake is still going on. A variable so tests can see where the client connects.
+var sshDial = func(ctx context.Context, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Join(ctx.Err(), err)
+		}
+		return nil, err
+	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// sshAddr returns the address to connect to for the configuration, resolving a target node
+func (c *Client) sshAddr(sshConfig *SSHConfig) (string, error) {
+	host := sshConfig.Host
+	if sshConfig.Node != 0 {
+		if sshConfig.Node < 1 || sshConfig.Node > 4 {
+			return "", fmt.Errorf("invalid SSH target node: %d (must be 1-4)", sshConfig.Node)
+		}
+
+		var err error
+		host, err = c.NodeHost(sshConfig.Node)
+		if err != nil {
+			networks, netErr := c.NodeNetworkInfo()
+			if netErr != nil {
+				return "", fmt.Errorf("failed to resolve the address of node %d: %w", sshConfig.Node, netErr)
+			}
+			host = networks[sshConfig.Node].IP
+			if host == "" {
+				return "", fmt.Errorf("the BMC reports no IP address for node %d, set it with WithNodeHosts", sshConfig.Node)
+			}
+		}
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(sshConfig.Port)), nil
+}
+
+// getSSHClient creates an SSH client connection
+func (c *Client) getSSHClient(options ...SSHOption) (*ssh.Client, error) {
+	return c.getSSHClientContext(context.Background(), options...)
+}
+
+// getSSHClientContext creates an SSH client connection, giving up when ctx is done
+func (c *Client) getSSHClientContext(ctx context.Context, options ...SSHOption) (*ssh.Client, error) {
+	sshConfig := c.newSSHConfig(options...)
+
+	// Add authentication methods, the agent is only needed until connected
+	auth, closeAgent, err := sshConfig.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+	if len(auth) == 0 {
+		return nil, ErrNoSSHCredentials
+	}
+
 	// Create SSH config
 	config := &ssh.ClientConfig{
 		User:            sshConfig.User,
-		Auth:            []ssh.AuthMethod{},
+		Auth:            auth,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         sshConfig.Timeout,
 	}
 
-	// Add authentication methods
-	if sshConfig.Password != "" {
-		config.Auth = append(config.Auth, ssh.Password(sshConfig.Password))
+	// Connect to SSH server
+	addr, err := c.sshAddr(sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sshDial(ctx, "tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+	}
+
+	return client, nil
+}
+
+// authMethods returns the SSH authentication methods of the configuration, and a func
+// closing the connection to the SSH agent, if any
+func (s *SSHConfig) authMethods() ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+
+	if s.Password != "" {
+		methods = append(methods, ssh.Password(s.Password))
+	}
+
+	signer, err := s.signer()
+	if err != nil {
+		return nil, closeAgent, err
+	}
+	if signer != nil {
+		methods = append(methods, ssh.PublicKeys(signer))
 	}
 
-	if sshConfig.PrivateKey != "" {
-		signer, err := ssh.ParsePrivateKey([]byte(sshConfig.PrivateKey))
+	if s.Agent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, closeAgent, fmt.Errorf("no SSH agent: SSH_AUTH_SOCK is not set")
+		}
+		conn, err := net.Dial("unix", socket)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
+			return nil, closeAgent, fmt.Errorf("failed to connect to SSH agent: %w", err)
 		}
-		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		closeAgent = func() { conn.Close() }
 	}
 
-	// Connect to SSH server
-	addr := fmt.Sprintf("%s:%d", sshConfig.Host, sshConfig.Port)
-	client, err := ssh.Dial("tcp", addr, config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+	return methods, closeAgent, nil
+}
+
+// signer parses the private key of the configuration, read from PrivateKeyFile if
+// PrivateKey is empty. It returns nil if no key is configured.
+func (s *SSHConfig) signer() (ssh.Signer, error) {
+	key := []byte(s.PrivateKey)
+	if len(key) == 0 && s.PrivateKeyFile != "" {
+		data, err := os.ReadFile(s.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		key = data
+	}
+	if len(key) == 0 {
+		return nil, nil
 	}
 
-	return client, nil
+	var signer ssh.Signer
+	var err error
+	if s.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(s.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("private key is encrypted, set its passphrase with WithSSHPrivateKeyPassphrase")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return signer, nil
 }
 
 // UploadFile uploads a local file to the remote system using SFTP
@@ -142,6 +380,12 @@ func (c *Client) UploadFile(localPath, remotePath string, options ...SSHOption)
 		return fmt.Errorf("cannot upload a directory, only files are supported")
 	}
 
+	return c.UploadReader(localFile, stat.Size(), stat.Mode(), remotePath, options...)
+}
+
+// UploadReader uploads the content of r to the remote system using SFTP, creating the
+// file with mode. size is only used to report the progress, -1 if unknown.
+func (c *Client) UploadReader(r io.Reader, size int64, mode os.FileMode, remotePath string, options ...SSHOption) error {
 	// Get SSH client
 	client, err := c.getSSHClient(options...)
 	if err != nil {
@@ -172,12 +416,12 @@ func (c *Client) UploadFile(localPath, remotePath string, options ...SSHOption)
 	defer remoteFile.Close()
 
 	// Set mode
-	if err := sftpClient.Chmod(remotePath, stat.Mode()); err != nil {
+	if err := sftpClient.Chmod(remotePath, mode); err != nil {
 		return fmt.Errorf("failed to set file permissions: %w", err)
 	}
 
 	// Copy file content
-	_, err = io.Copy(remoteFile, localFile)
+	err = copyWithProgress(remoteFile, r, size, c.newSSHConfig(options...).Progress)
 	if err != nil {
 		return fmt.Errorf("failed to copy file content: %w", err)
 	}
@@ -187,61 +431,138 @@ func (c *Client) UploadFile(localPath, remotePath string, options ...SSHOption)
 
 // DownloadFile downloads a file from the remote system using SFTP
 func (c *Client) DownloadFile(remotePath, localPath string, options ...SSHOption) error {
+	remoteFile, remoteFileInfo, err := c.OpenRemoteFile(remotePath, options...)
+	if err != nil {
+		return err
+	}
+	defer remoteFile.Close()
+
+	// Create the local directory if it doesn't exist
+	localDir := filepath.Dir(localPath)
+	if localDir != "." && localDir != "/" {
+		if err := os.MkdirAll(localDir, 0755); err != nil {
+			return fmt.Errorf("failed to create local directory: %w", err)
+		}
+	}
+
+	// Create local file
+	localFile, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, remoteFileInfo.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	defer localFile.Close()
+
+	// Copy file content
+	err = copyWithProgress(localFile, remoteFile, remoteFileInfo.Size(), c.newSSHConfig(options...).Progress)
+	if err != nil {
+		return fmt.Errorf("failed to copy file content: %w", err)
+	}
+
+	return nil
+}
+
+// OpenRemoteFile opens a file of the remote system for reading over SFTP. Closing the
+// returned file also closes its SSH connection.
+func (c *Client) OpenRemoteFile(remotePath string, options ...SSHOption) (io.ReadCloser, os.FileInfo, error) {
 	// Get SSH client
 	client, err := c.getSSHClient(options...)
 	if err != nil {
-		return fmt.Errorf("failed to establish SSH connection: %w", err)
+		return nil, nil, fmt.Errorf("failed to establish SSH connection: %w", err)
 	}
-	defer client.Close()
 
 	// Create new SFTP client
 	sftpClient, err := sftp.NewClient(client)
 	if err != nil {
-		return fmt.Errorf("failed to create SFTP client: %w", err)
+		client.Close()
+		return nil, nil, fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	closeAll := func() {
+		sftpClient.Close()
+		client.Close()
 	}
-	defer sftpClient.Close()
 
 	// Check if remote file exists and is not a directory
 	remoteFileInfo, err := sftpClient.Stat(remotePath)
 	if err != nil {
-		return fmt.Errorf("failed to stat remote file: %w", err)
+		closeAll()
+		return nil, nil, fmt.Errorf("failed to stat remote file: %w", err)
 	}
 
 	if remoteFileInfo.IsDir() {
-		return fmt.Errorf("cannot download a directory, only files are supported")
-	}
-
-	// Create the local directory if it doesn't exist
-	localDir := filepath.Dir(localPath)
-	if localDir != "." && localDir != "/" {
-		if err := os.MkdirAll(localDir, 0755); err != nil {
-			return fmt.Errorf("failed to create local directory: %w", err)
-		}
+		closeAll()
+		return nil, nil, fmt.Errorf("cannot download a directory, only files are supported")
 	}
 
 	// Open remote file
 	remoteFile, err := sftpClient.Open(remotePath)
 	if err != nil {
-		return fmt.Errorf("failed to open remote file: %w", err)
+		closeAll()
+		return nil, nil, fmt.Errorf("failed to open remote file: %w", err)
 	}
-	defer remoteFile.Close()
 
-	// Create local file
-	localFile, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, remoteFileInfo.Mode())
-	if err != nil {
-		return fmt.Errorf("failed to create local file: %w", err)
+	return &remoteFileCloser{File: remoteFile, close: closeAll}, remoteFileInfo, nil
+}
+
+// remoteFileCloser is a remote file that closes its SFTP and SSH connections with it
+type remoteFileCloser struct {
+	*sftp.File
+	close func()
+}
+
+func (f *remoteFileCloser) Close() error {
+	err := f.File.Close()
+	f.close()
+	return err
+}
+
+// copyWithProgress copies src to dst, reporting the progress to fn if set
+func copyWithProgress(dst io.Writer, src io.Reader, total int64, fn TransferProgressFunc) error {
+	if fn == nil {
+		_, err := io.Copy(dst, src)
+		return err
 	}
-	defer localFile.Close()
 
-	// Copy file content
-	_, err = io.Copy(localFile, remoteFile)
-	if err != nil {
-		return fmt.Errorf("failed to copy file content: %w", err)
+	now := time.Now()
+	reader := &progressReader{
+		reader:     src,
+		total:      total,
+		fn:         fn,
+		tracker:    newProgressTracker(total, now),
+		lastReport: now,
+	}
+	if _, err := io.Copy(dst, reader); err != nil {
+		return err
 	}
 
+	fn(TransferProgress{Bytes: reader.bytes, Total: total, Speed: reader.speed})
 	return nil
 }
 
+// progressReader counts the bytes read through it and reports them every transferProgressInterval
+type progressReader struct {
+	reader     io.Reader
+	total      int64
+	fn         TransferProgressFunc
+	tracker    *progressTracker
+	bytes      int64
+	speed      float64
+	lastReport time.Time
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.bytes += int64(n)
+
+	if now := time.Now(); now.Sub(r.lastReport) >= transferProgressInterval {
+		var eta time.Duration
+		r.speed, eta = r.tracker.update(r.bytes, now)
+		r.lastReport = now
+		r.fn(TransferProgress{Bytes: r.bytes, Total: r.total, Speed: r.speed, ETA: eta})
+	}
+
+	return n, err
+}
+
 // ListDirectory lists the contents of a remote directory using SFTP
 func (c *Client) ListDirectory(remotePath string, options ...SSHOption) ([]FileInfo, error) {
 	// Get SSH client
@@ -313,3 +634,45 @@ func (c *Client) ExecuteCommand(command string, options ...SSHOption) (string, e
 
 	return string(output), nil
 }
+
+// CommandResult is the outcome of a command executed on the remote system
+type CommandResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// RunCommand executes a command on the remote system and returns its output and exit code.
+// A command that exits nonzero is reported through ExitCode, not as an error.
+func (c *Client) RunCommand(command string, options ...SSHOption) (*CommandResult, error) {
+	// Get SSH client
+	client, err := c.getSSHClient(options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
+	}
+	defer client.Close()
+
+	// Create new session
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	result := &CommandResult{}
+	if err := session.Run(command); err != nil {
+		var exitErr *ssh.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("command execution failed: %w", err)
+		}
+		result.ExitCode = exitErr.ExitStatus()
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, nil
+}
@@ -16,13 +16,23 @@ package tpi
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 // TestSFTPFunctionality tests the SFTP file transfer functionality
@@ -273,3 +283,232 @@ func TestSFTPExecuteCommand(t *testing.T) {
 		})
 	}
 }
+
+// writeSSHKey writes a new ed25519 private key to a file, encrypted with passphrase if set,
+// and returns its path and public key
+func writeSSHKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	}
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert public key: %v", err)
+	}
+	return path, sshPub
+}
+
+func TestSSHPrivateKeyFile(t *testing.T) {
+	client, err := NewClient(WithHost("bmc"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	path, pub := writeSSHKey(t, "")
+	signer, err := client.newSSHConfig(WithSSHPrivateKeyFile(path)).signer()
+	if err != nil {
+		t.Fatalf("Failed to load key file: %v", err)
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+		t.Error("Loaded key doesn't match the key file")
+	}
+
+	if _, err := client.newSSHConfig(WithSSHPrivateKeyFile(filepath.Join(t.TempDir(), "missing"))).signer(); err == nil {
+		t.Error("Expected an error for a missing key file")
+	}
+}
+
+func TestSSHPrivateKeyPassphrase(t *testing.T) {
+	client, err := NewClient(WithHost("bmc"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	path, pub := writeSSHKey(t, "hunter2")
+	signer, err := client.newSSHConfig(WithSSHPrivateKeyFile(path), WithSSHPrivateKeyPassphrase("hunter2")).signer()
+	if err != nil {
+		t.Fatalf("Failed to load encrypted key file: %v", err)
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+		t.Error("Loaded key doesn't match the key file")
+	}
+
+	if _, err := client.newSSHConfig(WithSSHPrivateKeyFile(path)).signer(); err == nil {
+		t.Error("Expected an error for an encrypted key without passphrase")
+	}
+	if _, err := client.newSSHConfig(WithSSHPrivateKeyFile(path), WithSSHPrivateKeyPassphrase("wrong")).signer(); err == nil {
+		t.Error("Expected an error for a wrong passphrase")
+	}
+}
+
+func TestSSHAgent(t *testing.T) {
+	client, err := NewClient(WithHost("bmc"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, _, err := client.newSSHConfig(WithSSHAgent()).authMethods(); err == nil {
+		t.Error("Expected an error without SSH_AUTH_SOCK")
+	}
+
+	// Serve an agent holding one key
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("Failed to add key to agent: %v", err)
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen for agent: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	methods, closeAgent, err := client.newSSHConfig(WithSSHAgent()).authMethods()
+	if err != nil {
+		t.Fatalf("Failed to use SSH agent: %v", err)
+	}
+	defer closeAgent()
+	if len(methods) != 1 {
+		t.Errorf("Expected only the agent auth method, got %d methods", len(methods))
+	}
+}
+
+// recordSSHDial replaces the SSH dial for the test, recording the addresses connected to
+func recordSSHDial(t *testing.T) func() []string {
+	t.Helper()
+
+	var addrs []string
+	original := sshDial
+	sshDial = func(ctx context.Context, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		addrs = append(addrs, addr)
+		return nil, errors.New("dial disabled in tests")
+	}
+	t.Cleanup(func() { sshDial = original })
+
+	return func() []string { return addrs }
+}
+
+func TestSSHTargetHost(t *testing.T) {
+	addrs := recordSSHDial(t)
+
+	client, err := NewClient(WithHost("192.168.1.91"), WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// The BMC by default, or the host given
+	client.ExecuteCommand("true")
+	client.ExecuteCommand("true", WithSSHHost("10.0.0.7"), WithSSHPort(2222), WithSSHPassword("secret"))
+	client.ExecuteCommand("true", WithSSHHost("fd00::7"), WithSSHPassword("secret"))
+
+	expected := []string{"192.168.1.91:22", "10.0.0.7:2222", "[fd00::7]:22"}
+	if got := addrs(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected SSH connections to %v, got %v", expected, got)
+	}
+}
+
+func TestSSHTargetNode(t *testing.T) {
+	addrs := recordSSHDial(t)
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			w.Write([]byte(`{"response":[{"result":[
+				{"node":1,"mac":"02:00:00:00:00:01","ip":"192.168.1.101"},
+				{"node":2,"mac":"02:00:00:00:00:02","ip":""}
+			]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), WithNodeHosts(map[int]string{3: "node3.lan"}), WithSSHDefaults(WithSSHTargetNode(1), WithSSHCredentials("ubuntu", "ubuntu")))
+
+	// The default node, resolved through the BMC, a configured node, and the BMC again
+	client.ExecuteCommand("true")
+	client.ExecuteCommand("true", WithSSHTargetNode(3))
+	client.ExecuteCommand("true", WithSSHHost("bmc.lan"))
+
+	expected := []string{"192.168.1.101:22", "node3.lan:22", "bmc.lan:22"}
+	if got := addrs(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected SSH connections to %v, got %v", expected, got)
+	}
+
+	// A node without an address isn't connected to
+	if _, err := client.ExecuteCommand("true", WithSSHTargetNode(2)); err == nil || !strings.Contains(err.Error(), "no IP address for node 2") {
+		t.Errorf("Expected an error for a node without an address, got: %v", err)
+	}
+	if _, err := client.ExecuteCommand("true", WithSSHTargetNode(5)); err == nil {
+		t.Error("Expected an error for an invalid node")
+	}
+	if got := addrs(); len(got) != len(expected) {
+		t.Errorf("Expected no more connections, got %v", got)
+	}
+}
+
+func TestSSHPasswordStaysOnBMC(t *testing.T) {
+	addrs := recordSSHDial(t)
+
+	client, err := NewClient(WithHost("192.168.1.91"), WithCredentials("root", "turing"), WithNodeHosts(map[int]string{1: "node1.lan"}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		options  []SSHOption
+		password string
+	}{
+		{"bmc", nil, "turing"},
+		{"node", []SSHOption{WithSSHTargetNode(1)}, ""},
+		{"host", []SSHOption{WithSSHHost("10.0.0.7")}, ""},
+		{"node with password", []SSHOption{WithSSHTargetNode(1), WithSSHPassword("ubuntu")}, "ubuntu"},
+	}
+	for _, tt := range tests {
+		if got := client.newSSHConfig(tt.options...).Password; got != tt.password {
+			t.Errorf("%s: expected password %q, got %q", tt.name, tt.password, got)
+		}
+	}
+
+	// Without credentials of its own, a node isn't connected to
+	_, err = client.ExecuteCommand("true", WithSSHTargetNode(1))
+	if err == nil || !strings.Contains(err.Error(), "no SSH credentials") {
+		t.Errorf("Expected an error for a node without SSH credentials, got: %v", err)
+	}
+	if got := addrs(); len(got) != 0 {
+		t.Errorf("Expected no SSH connection, got %v", got)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// snapshotAttempts is the number of times each snapshot section is fetched before giving up
+const snapshotAttempts = 3
+
+// SnapshotSection holds the outcome of fetching one section of a ClusterSnapshot
+type SnapshotSection struct {
+	// Err is set when the section couldn't be fetched, the section data is then empty
+	Err error
+	// FetchedAt is when the section was fetched successfully
+	FetchedAt time.Time
+	// Attempts is the number of fetches made for the section
+	Attempts int
+}
+
+// PowerSnapshot is the power section of a ClusterSnapshot
+type PowerSnapshot struct {
+	SnapshotSection
+	Status map[int]bool
+}
+
+// UsbSnapshot is the USB section of a ClusterSnapshot
+type UsbSnapshot struct {
+	SnapshotSection
+	Status *UsbStatusInfo
+}
+
+// InfoSnapshot is a key/value section of a ClusterSnapshot
+type InfoSnapshot struct {
+	SnapshotSection
+	Values map[string]string
+}
+
+// ClusterSnapshot is the state of the board fetched in one call.
+// Sections are fetched independently, so a snapshot can be partial: check each section's Err.
+type ClusterSnapshot struct {
+	Power PowerSnapshot
+	Usb   UsbSnapshot
+	Info  InfoSnapshot
+	About InfoSnapshot
+	// FetchedAt is when the snapshot completed
+	FetchedAt time.Time
+}
+
+// Complete reports whether every section was fetched
+func (s *ClusterSnapshot) Complete() bool {
+	return s.Power.Err == nil && s.Usb.Err == nil && s.Info.Err == nil && s.About.Err == nil
+}
+
+// snapshotResult is the outcome of a section fetch, applied to the snapshot by Snapshot
+type snapshotResult struct {
+	name  string
+	apply func(*ClusterSnapshot)
+}
+
+// Snapshot fetches the power, USB, info and about sections concurrently.
+// Each section retries transient failures with a short backoff. Sections that fail
+// or don't complete before ctx is done carry an error instead of failing the call.
+func (c *Client) Snapshot(ctx context.Context) *ClusterSnapshot {
+	snapshot := &ClusterSnapshot{}
+	sections := map[string]*SnapshotSection{
+		"power": &snapshot.Power.SnapshotSection,
+		"usb":   &snapshot.Usb.SnapshotSection,
+		"info":  &snapshot.Info.SnapshotSection,
+		"about": &snapshot.About.SnapshotSection,
+	}
+
+	results := make(chan snapshotResult, len(sections))
+	policy := c.retryPolicy(DefaultSnapshotRetryPolicy)
+
+	go func() {
+		var status map[int]bool
+		section := fetchSnapshotSection(ctx, policy, func() (err error) {
+			status, err = c.PowerStatus()
+			return err
+		})
+		results <- snapshotResult{"power", func(s *ClusterSnapshot) {
+			s.Power = PowerSnapshot{SnapshotSection: section, Status: status}
+		}}
+	}()
+
+	go func() {
+		var status *UsbStatusInfo
+		section := fetchSnapshotSection(ctx, policy, func() (err error) {
+			status, err = c.UsbGetStatus()
+			return err
+		})
+		results <- snapshotResult{"usb", func(s *ClusterSnapshot) {
+			s.Usb = UsbSnapshot{SnapshotSection: section, Status: status}
+		}}
+	}()
+
+	go func() {
+		var values map[string]string
+		section := fetchSnapshotSection(ctx, policy, func() (err error) {
+			values, err = c.Info()
+			return err
+		})
+		results <- snapshotResult{"info", func(s *ClusterSnapshot) {
+			s.Info = InfoSnapshot{SnapshotSection: section, Values: values}
+		}}
+	}()
+
+	go func() {
+		var values map[string]string
+		section := fetchSnapshotSection(ctx, policy, func() (err error) {
+			values, err = c.About()
+			return err
+		})
+		results <- snapshotResult{"about", func(s *ClusterSnapshot) {
+			s.About = InfoSnapshot{SnapshotSection: section, Values: values}
+		}}
+	}()
+
+	pending := len(sections)
+	for pending > 0 {
+		select {
+		case result := <-results:
+			result.apply(snapshot)
+			delete(sections, result.name)
+			pending--
+		case <-ctx.Done():
+			// Sections still in flight are reported as timed out
+			for name, section := range sections {
+				section.Err = fmt.Errorf("failed to fetch %s: %w", name, ctx.Err())
+			}
+			pending = 0
+		}
+	}
+
+	snapshot.FetchedAt = time.Now()
+	return snapshot
+}
+
+// fetchSnapshotSection calls fetch until it succeeds, the attempts run out or ctx is done,
+// backing off between attempts following policy
+func fetchSnapshotSection(ctx context.Context, policy RetryPolicy, fetch func() error) SnapshotSection {
+	var section SnapshotSection
+	retry := newBackoff(policy)
+
+	for section.Attempts < snapshotAttempts {
+		section.Attempts++
+
+		err := fetch()
+		if err == nil {
+			section.Err = nil
+			section.FetchedAt = time.Now()
+			return section
+		}
+		section.Err = err
+
+		if section.Attempts == snapshotAttempts {
+			break
+		}
+
+		if retry.Sleep(ctx) != nil {
+			return section
+		}
+	}
+
+	return section
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotPartial(t *testing.T) {
+	shortenRetryPolicy(t, &DefaultSnapshotRetryPolicy)
+
+	var mu sync.Mutex
+	usbCalls := 0
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			switch r.URL.Query().Get("type") {
+			case "power":
+				w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":1}]}]}`))
+			case "usb":
+				mu.Lock()
+				usbCalls++
+				mu.Unlock()
+				http.Error(w, "usb controller busy", http.StatusInternalServerError)
+			case "other":
+				w.Write([]byte(`{"response":[{"result":[{"version":"2.0.5"}]}]}`))
+			case "about":
+				w.Write([]byte(`{"response":[{"result":{"api":"1.1"}}]}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	snapshot := client.Snapshot(ctx)
+
+	if snapshot.Complete() {
+		t.Errorf("Expected a partial snapshot")
+	}
+	if snapshot.FetchedAt.IsZero() {
+		t.Errorf("Expected the snapshot time to be set")
+	}
+
+	if snapshot.Usb.Err == nil || snapshot.Usb.Status != nil {
+		t.Errorf("Expected the USB section to fail, got %+v", snapshot.Usb)
+	}
+	if snapshot.Usb.Attempts != snapshotAttempts || usbCalls != snapshotAttempts {
+		t.Errorf("Expected %d USB attempts, got %d (%d calls)", snapshotAttempts, snapshot.Usb.Attempts, usbCalls)
+	}
+
+	if snapshot.Power.Err != nil || !snapshot.Power.Status[1] || snapshot.Power.Status[2] || !snapshot.Power.Status[4] {
+		t.Errorf("Unexpected power section: %+v", snapshot.Power)
+	}
+	if snapshot.Power.FetchedAt.IsZero() {
+		t.Errorf("Expected the power section time to be set")
+	}
+	if snapshot.Info.Err != nil || snapshot.Info.Values["version"] != "2.0.5" {
+		t.Errorf("Unexpected info section: %+v", snapshot.Info)
+	}
+	if snapshot.About.Err != nil || snapshot.About.Values["api"] != "1.1" {
+		t.Errorf("Unexpected about section: %+v", snapshot.About)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// CachedState returns the paths of everything tpi keeps in its cache directory:
+// BMC tokens, agent tokens and cached images. The cache directory itself isn't included.
+func CachedState() ([]string, error) {
+	cacheDir := defaultCacheDir()
+	if cacheDir == "" {
+		return []string{}, nil
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		paths = append(paths, filepath.Join(cacheDir, entry.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// DeleteAllCachedState removes everything returned by CachedState. Unlike
+// DeleteAllCachedTokens, it also clears agent tokens and cached images.
+func DeleteAllCachedState() error {
+	paths, err := CachedState()
+	if err != nil {
+		return err
+	}
+
+	// Tokens kept in memory would outlive their deleted files
+	tokenCache.clear()
+
+	var errs []error
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeleteAllCachedState(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cacheDir := CacheDir()
+
+	if err := CacheToken("192.168.1.91", "token"); err != nil {
+		t.Fatalf("CacheToken failed: %v", err)
+	}
+	if err := cacheToken("legacy-token"); err != nil {
+		t.Fatalf("cacheToken failed: %v", err)
+	}
+	files := map[string]string{
+		"agent_token_192_168_1_2_9977":    "agent-token",
+		filepath.Join("images", "os.img"): "image content",
+	}
+	for name, content := range files {
+		path := filepath.Join(cacheDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	state, err := CachedState()
+	if err != nil {
+		t.Fatalf("CachedState failed: %v", err)
+	}
+	if len(state) != 4 {
+		t.Fatalf("Expected 4 cached entries, got %v", state)
+	}
+
+	if err := DeleteAllCachedState(); err != nil {
+		t.Fatalf("DeleteAllCachedState failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to read cache directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty cache directory, got %d entries", len(entries))
+	}
+
+	// Nothing left to clear isn't an error
+	if err := DeleteAllCachedState(); err != nil {
+		t.Errorf("DeleteAllCachedState on an empty cache failed: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram of an OperationStat
+var LatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// OperationStat holds the request counts and latencies of one type of operation
+type OperationStat struct {
+	// Count is the number of requests sent
+	Count int64
+	// Errors counts the requests that failed or were answered with an HTTP error status
+	Errors int64
+	// TotalLatency is the sum of the latencies of every request
+	TotalLatency time.Duration
+	// MaxLatency is the latency of the slowest request
+	MaxLatency time.Duration
+	// Buckets[i] counts the requests that took up to LatencyBuckets[i]; the extra
+	// last bucket counts those slower than every bound
+	Buckets []int64
+}
+
+// MeanLatency returns the average latency of the requests, 0 if there were none
+func (s OperationStat) MeanLatency() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Count)
+}
+
+// OperationStats maps operation names, such as "get power", "set usb" or "upload",
+// to their stats
+type OperationStats map[string]OperationStat
+
+// WithStats makes the client record the count and latency of its requests per type of
+// operation, see Stats. Authentication requests aren't recorded.
+func WithStats() Option {
+	return func(c *Client) {
+		c.stats = &statsCollector{}
+	}
+}
+
+// Stats returns the stats recorded since the client was created, or an empty
+// OperationStats if WithStats isn't set
+func (c *Client) Stats() OperationStats {
+	return c.stats.snapshot()
+}
+
+// statsCollector records request stats. Operations are only locked when first seen,
+// recording updates atomic counters.
+type statsCollector struct {
+	ops sync.Map // operation name -> *operationCounters
+}
+
+// operationCounters are the live counters of an OperationStat
+type operationCounters struct {
+	count   atomic.Int64
+	errors  atomic.Int64
+	total   atomic.Int64
+	max     atomic.Int64
+	buckets []atomic.Int64
+}
+
+// record adds a request to the stats of op, doing nothing on a nil collector
+func (s *statsCollector) record(op string, latency time.Duration, failed bool) {
+	if s == nil {
+		return
+	}
+
+	value, ok := s.ops.Load(op)
+	if !ok {
+		value, _ = s.ops.LoadOrStore(op, &operationCounters{
+			buckets: make([]atomic.Int64, len(LatencyBuckets)+1),
+		})
+	}
+	counters := value.(*operationCounters)
+
+	counters.count.Add(1)
+	if failed {
+		counters.errors.Add(1)
+	}
+	counters.total.Add(int64(latency))
+	for {
+		current := counters.max.Load()
+		if int64(latency) <= current || counters.max.CompareAndSwap(current, int64(latency)) {
+			break
+		}
+	}
+
+	bucket := len(LatencyBuckets)
+	for i, bound := range LatencyBuckets {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+	counters.buckets[bucket].Add(1)
+}
+
+// snapshot returns a copy of the recorded stats
+func (s *statsCollector) snapshot() OperationStats {
+	stats := OperationStats{}
+	if s == nil {
+		return stats
+	}
+
+	s.ops.Range(func(key, value any) bool {
+		counters := value.(*operationCounters)
+		stat := OperationStat{
+			Count:        counters.count.Load(),
+			Errors:       counters.errors.Load(),
+			TotalLatency: time.Duration(counters.total.Load()),
+			MaxLatency:   time.Duration(counters.max.Load()),
+			Buckets:      make([]int64, len(counters.buckets)),
+		}
+		for i := range counters.buckets {
+			stat.Buckets[i] = counters.buckets[i].Load()
+		}
+		stats[key.(string)] = stat
+		return true
+	})
+	return stats
+}
+
+// operation names the request for its stats: "<opt> <type>" for API requests, e.g.
+// "get power", otherwise the last path segment that isn't a handle, e.g. "upload"
+func (r *Request) operation() string {
+	if kind := r.QueryParams.Get("type"); kind != "" {
+		if opt := r.QueryParams.Get("opt"); opt != "" {
+			return opt + " " + kind
+		}
+		return kind
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if _, err := strconv.Atoi(segments[i]); err != nil && segments[i] != "" {
+			return segments[i]
+		}
+	}
+	return "request"
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			query := r.URL.Query()
+			if query.Get("type") == "cooling" {
+				http.Error(w, "boom", http.StatusInternalServerError)
+				return
+			}
+			if query.Get("opt") == "get" {
+				time.Sleep(delay)
+				w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":0}]}]}`))
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), WithStats())
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.PowerStatus(); err != nil {
+			t.Fatalf("PowerStatus failed: %v", err)
+		}
+	}
+	if err := client.PowerOn(1); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+	client.GetCoolingStatus()
+
+	stats := client.Stats()
+
+	status := stats["get power"]
+	if status.Count != 3 || status.Errors != 0 {
+		t.Errorf("get power: count %d, errors %d, want 3 and 0", status.Count, status.Errors)
+	}
+	if status.MeanLatency() < delay || status.MaxLatency < delay || status.TotalLatency < 3*delay {
+		t.Errorf("get power latencies too low for a %s delay: %+v", delay, status)
+	}
+	var bucketed int64
+	for i, n := range status.Buckets {
+		bucketed += n
+		if n > 0 && i < len(LatencyBuckets) && LatencyBuckets[i] < delay {
+			t.Errorf("get power counted in the %s bucket despite a %s delay", LatencyBuckets[i], delay)
+		}
+	}
+	if bucketed != 3 || len(status.Buckets) != len(LatencyBuckets)+1 {
+		t.Errorf("get power buckets %v, want 3 requests in %d buckets", status.Buckets, len(LatencyBuckets)+1)
+	}
+
+	if set := stats["set power"]; set.Count != 1 || set.Errors != 0 {
+		t.Errorf("set power: %+v, want one request", set)
+	}
+	if cooling := stats["get cooling"]; cooling.Count != 1 || cooling.Errors != 1 {
+		t.Errorf("get cooling: %+v, want one failed request", cooling)
+	}
+	if _, ok := stats["authenticate"]; ok {
+		t.Error("authentication shouldn't be recorded")
+	}
+}
+
+func TestStatsChunkedUpload(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	var uploads int64
+	upload := &chunkServer{failAt: -1}
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+			uploads++
+			upload.serveUpload(w, r)
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":0}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), WithStats())
+
+	path, _ := writeImage(t, strings.Repeat("x", 50))
+	if err := client.FlashNode(1, &FlashOptions{ImagePath: path, ChunkSize: 20, ProgressWriter: io.Discard}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+
+	// The offset query and every chunk are recorded
+	if len(upload.chunks) != 3 {
+		t.Fatalf("Expected 3 chunks, got %v", upload.chunks)
+	}
+	if got := client.Stats()["upload"]; got.Count != uploads || got.Errors != 0 {
+		t.Errorf("upload: %+v, want %d requests", got, uploads)
+	}
+}
+
+func TestStatsDisabled(t *testing.T) {
+	client, err := NewClient(WithHost("bmc"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if stats := client.Stats(); stats == nil || len(stats) != 0 {
+		t.Errorf("Expected empty stats without WithStats, got %v", stats)
+	}
+}
+
+func TestStatsConcurrent(t *testing.T) {
+	collector := &statsCollector{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				collector.record("get power", time.Duration(i*j)*time.Millisecond, j%10 == 0)
+				collector.snapshot()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stat := collector.snapshot()["get power"]
+	if stat.Count != 800 || stat.Errors != 80 {
+		t.Errorf("count %d, errors %d, want 800 and 80", stat.Count, stat.Errors)
+	}
+	if stat.MaxLatency != 7*99*time.Millisecond {
+		t.Errorf("max latency %s, want %s", stat.MaxLatency, 7*99*time.Millisecond)
+	}
+}
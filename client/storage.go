@@ -0,0 +1,183 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// StorageVolume is a filesystem of the BMC
+type StorageVolume struct {
+	// Name is the name the BMC gives the volume, or the device it is mounted from
+	Name string
+	// MountPoint is where the volume is mounted, empty if the BMC doesn't say
+	MountPoint string
+	TotalBytes int64
+	FreeBytes  int64
+}
+
+// UsedBytes returns the bytes of the volume in use
+func (v StorageVolume) UsedBytes() int64 {
+	return v.TotalBytes - v.FreeBytes
+}
+
+// InsufficientSpaceError is returned when a BMC volume has no room for a file
+type InsufficientSpaceError struct {
+	Path   string
+	Needed int64
+	Free   int64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("not enough space on the BMC for %s: %s needed, %s free",
+		e.Path, formatBytes(e.Needed), formatBytes(e.Free))
+}
+
+// runBMCCommand runs a shell command on the BMC, a variable so tests can fake its output
+var runBMCCommand = (*Client).RunCommand
+
+// StorageInfo returns the usage of the BMC filesystems. Firmware that reports its storage
+// answers directly, otherwise df is run on the BMC over SSH.
+func (c *Client) StorageInfo() ([]StorageVolume, error) {
+	volumes, err := c.reportedStorage()
+	if err != nil || len(volumes) > 0 {
+		return volumes, err
+	}
+	return c.dfStorage()
+}
+
+// reportedStorage returns the storage the BMC reports in its info, none if it doesn't
+func (c *Client) reportedStorage() ([]StorageVolume, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "info")
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return nil, nil
+		}
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var info struct {
+		Storage []struct {
+			Name       string `json:"name"`
+			TotalBytes int64  `json:"total_bytes"`
+			BytesFree  int64  `json:"bytes_free"`
+		} `json:"storage"`
+	}
+	// Firmware without the info type may answer anything, which then doesn't count as a report
+	if found, err := decodeResult(resp, &info); err != nil || !found {
+		return nil, nil
+	}
+
+	volumes := make([]StorageVolume, 0, len(info.Storage))
+	for _, volume := range info.Storage {
+		volumes = append(volumes, StorageVolume{Name: volume.Name, TotalBytes: volume.TotalBytes, FreeBytes: volume.BytesFree})
+	}
+	return volumes, nil
+}
+
+// dfStorage returns the filesystems df reports on the BMC
+func (c *Client) dfStorage() ([]StorageVolume, error) {
+	result, err := runBMCCommand(c, "df -P -k")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run df on the BMC: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("df failed on the BMC with exit code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return parseDf(result.Stdout)
+}
+
+// parseDf parses the POSIX output of df -P -k:
+// Filesystem 1024-blocks Used Available Capacity Mounted on
+func parseDf(output string) ([]StorageVolume, error) {
+	var volumes []StorageVolume
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		total, totalErr := strconv.ParseInt(fields[1], 10, 64)
+		available, availableErr := strconv.ParseInt(fields[3], 10, 64)
+		if totalErr != nil || availableErr != nil {
+			return nil, fmt.Errorf("unexpected df output: %q", line)
+		}
+		volumes = append(volumes, StorageVolume{
+			Name:       fields[0],
+			MountPoint: strings.Join(fields[5:], " "),
+			TotalBytes: total * 1024,
+			FreeBytes:  available * 1024,
+		})
+	}
+	return volumes, nil
+}
+
+// volumeFor returns the volume a path of the BMC is on, the one mounted closest to it
+func volumeFor(volumes []StorageVolume, filePath string) (StorageVolume, bool) {
+	filePath = path.Clean(filePath)
+	var found StorageVolume
+	ok := false
+	for _, volume := range volumes {
+		mount := volume.MountPoint
+		if mount == "" {
+			continue
+		}
+		if filePath != mount && !strings.HasPrefix(filePath, strings.TrimSuffix(mount, "/")+"/") {
+			continue
+		}
+		if !ok || len(mount) > len(found.MountPoint) {
+			found, ok = volume, true
+		}
+	}
+	return found, ok
+}
+
+// checkBMCSpace returns an InsufficientSpaceError if the BMC volume of filePath has less
+// than size bytes free. It can only tell from the mount points df reports.
+func (c *Client) checkBMCSpace(filePath string, size int64) error {
+	volumes, err := c.dfStorage()
+	if err != nil {
+		return err
+	}
+	volume, ok := volumeFor(volumes, filePath)
+	if !ok {
+		return fmt.Errorf("no BMC volume holds %s", filePath)
+	}
+	if volume.FreeBytes < size {
+		return &InsufficientSpaceError{Path: filePath, Needed: size, Free: volume.FreeBytes}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// dfOutput is df -P -k on a BMC with 4 KiB free on its SD card
+const dfOutput = `Filesystem           1024-blocks      Used Available Capacity Mounted on
+ubi0:rootfs              116096     86016     30080  74% /
+/dev/mmcblk0p1           30000     29996         4 100% /mnt/sdcard
+`
+
+// fakeDf makes the BMC answer df with output
+func fakeDf(t *testing.T, output string) {
+	run := runBMCCommand
+	runBMCCommand = func(c *Client, command string, options ...SSHOption) (*CommandResult, error) {
+		return &CommandResult{Stdout: output}, nil
+	}
+	t.Cleanup(func() { runBMCCommand = run })
+}
+
+func TestFlashNodeLocalRefusesWithoutSpace(t *testing.T) {
+	fakeDf(t, dfOutput)
+
+	var flashes atomic.Int32
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		if r.URL.Query().Get("type") == "update" {
+			flashes.Add(1)
+		}
+		w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+	}))
+
+	image := filepath.Join(t.TempDir(), "image.img")
+	if err := os.WriteFile(image, make([]byte, 10*1024), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	err := client.FlashNodeLocalWithOptions(1, "/mnt/sdcard/images/image.img", &LocalFlashOptions{UploadFrom: image})
+	var spaceErr *InsufficientSpaceError
+	if !errors.As(err, &spaceErr) {
+		t.Fatalf("Expected the flash to be refused for lack of space, got: %v", err)
+	}
+	if spaceErr.Needed != 10*1024 || spaceErr.Free != 4*1024 {
+		t.Errorf("Expected 10 KiB needed and 4 KiB free, got %+v", spaceErr)
+	}
+	if flashes.Load() != 0 {
+		t.Errorf("Expected no flash to start, got %d", flashes.Load())
+	}
+}
+
+func TestStorageInfo(t *testing.T) {
+	fakeDf(t, dfOutput)
+
+	reported := true
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		if reported {
+			w.Write([]byte(`{"response":[{"result":{"storage":[{"name":"microSD","total_bytes":1000,"bytes_free":400}]}}]}`))
+			return
+		}
+		w.Write([]byte(`{"response":[{"result":{"ip":[]}}]}`))
+	}))
+
+	volumes, err := client.StorageInfo()
+	if err != nil {
+		t.Fatalf("StorageInfo failed: %v", err)
+	}
+	if expected := []StorageVolume{{Name: "microSD", TotalBytes: 1000, FreeBytes: 400}}; !reflect.DeepEqual(volumes, expected) {
+		t.Errorf("Expected the reported storage %+v, got %+v", expected, volumes)
+	}
+	if volumes[0].UsedBytes() != 600 {
+		t.Errorf("Expected 600 bytes used, got %d", volumes[0].UsedBytes())
+	}
+
+	// Firmware that doesn't report its storage falls back to df
+	reported = false
+	volumes, err = client.StorageInfo()
+	if err != nil {
+		t.Fatalf("StorageInfo failed: %v", err)
+	}
+	if len(volumes) != 2 || volumes[1].MountPoint != "/mnt/sdcard" || volumes[1].FreeBytes != 4*1024 {
+		t.Errorf("Unexpected volumes from df: %+v", volumes)
+	}
+	if volume, ok := volumeFor(volumes, "/mnt/sdcard/image.img"); !ok || volume.Name != "/dev/mmcblk0p1" {
+		t.Errorf("Expected the SD card to hold the image, got %+v", volume)
+	}
+	if volume, ok := volumeFor(volumes, "/mnt/sdcard2/image.img"); !ok || volume.MountPoint != "/" {
+		t.Errorf("Expected the root volume to hold a sibling of the SD card, got %+v", volume)
+	}
+}
+
+func TestFlashNodeLocalPrintsToProgressWriter(t *testing.T) {
+	recordSSHDial(t)
+	run := runBMCCommand
+	runBMCCommand = func(c *Client, command string, options ...SSHOption) (*CommandResult, error) {
+		return nil, errors.New("df failed")
+	}
+	t.Cleanup(func() { runBMCCommand = run })
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"mock-token"}`))
+	}))
+
+	image := filepath.Join(t.TempDir(), "image.img")
+	if err := os.WriteFile(image, make([]byte, 1024), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	// The space check fails, so it's only warned about before the upload
+	var out bytes.Buffer
+	err := client.FlashNodeLocalWithOptions(1, "/data/image.img", &LocalFlashOptions{UploadFrom: image, ProgressWriter: &out})
+	if err == nil {
+		t.Fatal("Expected the upload to fail without SSH")
+	}
+	for _, want := range []string{"Warning: couldn't check the free space of the BMC", "Uploading " + image} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the progress output, got: %q", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTLSPort is the port BMCs serve HTTPS on
+const DefaultTLSPort = 443
+
+// ErrCertificateMismatch is returned when the BMC's certificate doesn't match the pinned fingerprint
+var ErrCertificateMismatch = errors.New("certificate fingerprint mismatch")
+
+// WithPinnedCertSHA256 only accepts the BMC's certificate if the SHA256 fingerprint of
+// its leaf certificate matches. BMCs ship self-signed certificates that can't be verified
+// against a CA, pinning gives trust-on-first-use security instead of skipping verification.
+// The fingerprint is hex, with or without colons, as printed by
+// `openssl x509 -noout -fingerprint -sha256`.
+func WithPinnedCertSHA256(fingerprint string) Option {
+	return func(c *Client) {
+		pin, err := normalizeFingerprint(fingerprint)
+		if err != nil {
+			c.optionErr = err
+			return
+		}
+		c.pinnedCert = pin
+		c.httpClient.Transport = newBMCTransport(pin)
+	}
+}
+
+// CertificateFingerprint returns the SHA256 fingerprint of a certificate
+// as colon separated uppercase hex, e.g. "AB:CD:..."
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// normalizeFingerprint converts a fingerprint to lowercase hex without separators
+func normalizeFingerprint(fingerprint string) (string, error) {
+	pin := strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+	if decoded, err := hex.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA256 fingerprint %q: expected %d hex bytes", fingerprint, sha256.Size)
+	}
+	return pin, nil
+}
+
+// newTLSConfig returns the TLS configuration for BMC connections. Certificates aren't
+// verified against a CA, but if pin is set the leaf certificate must match it.
+func newTLSConfig(pin string) *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: true, // Skip certificate verification
+	}
+
+	if pin != "" {
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("%w: no certificate presented", ErrCertificateMismatch)
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if got := hex.EncodeToString(sum[:]); got != pin {
+				return fmt.Errorf("%w: got %s", ErrCertificateMismatch, got)
+			}
+			return nil
+		}
+	}
+
+	return config
+}
+
+// newBMCTransport returns a transport for BMC connections, pinned to the certificate if pin is set
+func newBMCTransport(pin string) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig: newTLSConfig(pin),
+	}
+}
+
+// GetServerCertificate connects to the server and returns the leaf certificate it presents,
+// without verifying it or authenticating. A port of zero uses the port in host, if any,
+// or DefaultTLSPort.
+func GetServerCertificate(host string, port int) (*x509.Certificate, error) {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host = h
+		if port == 0 {
+			port, _ = strconv.Atoi(p)
+		}
+	}
+	if port == 0 {
+		port = DefaultTLSPort
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, newTLSConfig(""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("server %s presented no certificate", addr)
+	}
+
+	return certs[0], nil
+}
+
+// IsSelfSigned reports whether the certificate is issued by itself and signed by its own key
+func IsSelfSigned(cert *x509.Certificate) bool {
+	if string(cert.RawIssuer) != string(cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSelfSignedServer starts a TLS server with a freshly generated self-signed certificate
+func newSelfSignedServer(t *testing.T, handler http.Handler, notAfter time.Time) (*httptest.Server, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "turingpi.local"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	host := server.Listener.Addr().String()
+	t.Cleanup(func() { DeleteCachedToken(host) })
+
+	return server, cert
+}
+
+func TestPinnedCertificate(t *testing.T) {
+	server, cert := newSelfSignedServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		default:
+			w.Write([]byte(`{"response":[{"result":[{"api":"1.1"}]}]}`))
+		}
+	}), time.Now().Add(time.Hour))
+	host := server.Listener.Addr().String()
+
+	// openssl prints the fingerprint with colons, plain lowercase hex is accepted too
+	fingerprint := CertificateFingerprint(cert)
+	for _, pin := range []string{fingerprint, strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))} {
+		client, err := NewClient(WithHost(host), WithCredentials("root", "turing"), WithPinnedCertSHA256(pin))
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		if _, err := client.Info(); err != nil {
+			t.Errorf("Expected matching pin %s to succeed, got: %v", pin, err)
+		}
+		DeleteCachedToken(host)
+	}
+
+	client, err := NewClient(WithHost(host), WithCredentials("root", "turing"), WithPinnedCertSHA256(strings.Repeat("ab", 32)))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if _, err := client.Info(); !errors.Is(err, ErrCertificateMismatch) {
+		t.Errorf("Expected ErrCertificateMismatch for a wrong pin, got: %v", err)
+	}
+}
+
+func TestPinnedCertificateInvalidFingerprint(t *testing.T) {
+	if _, err := NewClient(WithHost("10.0.0.1"), WithCredentials("root", "turing"), WithPinnedCertSHA256("not-a-fingerprint")); err == nil {
+		t.Error("Expected an error for an invalid fingerprint")
+	}
+}
+
+func TestGetServerCertificate(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	server, cert := newSelfSignedServer(t, http.NotFoundHandler(), expired)
+
+	got, err := GetServerCertificate(server.Listener.Addr().String(), 0)
+	if err != nil {
+		t.Fatalf("GetServerCertificate failed: %v", err)
+	}
+
+	if CertificateFingerprint(got) != CertificateFingerprint(cert) {
+		t.Errorf("Expected fingerprint %s, got %s", CertificateFingerprint(cert), CertificateFingerprint(got))
+	}
+	if got.Subject.CommonName != "turingpi.local" {
+		t.Errorf("Expected subject turingpi.local, got %s", got.Subject.CommonName)
+	}
+	if !IsSelfSigned(got) {
+		t.Error("Expected the certificate to be reported as self-signed")
+	}
+	if !time.Now().After(got.NotAfter) {
+		t.Error("Expected the expired certificate to be returned as is")
+	}
+
+	// Host and port given separately
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+
+	addr := tlsServer.Listener.Addr().(*net.TCPAddr)
+	got, err = GetServerCertificate(addr.IP.String(), addr.Port)
+	if err != nil {
+		t.Fatalf("GetServerCertificate failed: %v", err)
+	}
+	if CertificateFingerprint(got) != CertificateFingerprint(tlsServer.Certificate()) {
+		t.Error("Expected the httptest server certificate")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// How long a token read from its file is reused before the file is read again, a
+// variable so tests can change it. Another process logging in or out is noticed after it.
+var tokenCacheTTL = 30 * time.Second
+
+// tokenStore reads and writes cached tokens, keyed by the path of their token file
+type tokenStore interface {
+	Load(path string) (string, error)
+	Save(path, token string) error
+	Delete(path string) error
+}
+
+// tokenCache is the store behind CacheToken, GetCachedToken and DeleteCachedToken
+var tokenCache = newMemoryTokenStore(fileTokenStore{})
+
+// fileTokenStore keeps tokens in files, the source of truth shared by every process
+type fileTokenStore struct{}
+
+func (fileTokenStore) Load(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (fileTokenStore) Save(path, token string) error {
+	return os.WriteFile(path, []byte(token), 0600)
+}
+
+func (fileTokenStore) Delete(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// memoryTokenStore keeps the tokens loaded from or saved to another store in memory for
+// tokenCacheTTL, so repeated requests don't read the token file every time.
+// It is safe for concurrent use.
+type memoryTokenStore struct {
+	next tokenStore
+
+	mu      sync.Mutex
+	entries map[string]memoryToken
+}
+
+// memoryToken is a token kept in memory until expires
+type memoryToken struct {
+	token   string
+	expires time.Time
+}
+
+// newMemoryTokenStore creates a memoryTokenStore in front of next
+func newMemoryTokenStore(next tokenStore) *memoryTokenStore {
+	return &memoryTokenStore{
+		next:    next,
+		entries: make(map[string]memoryToken),
+	}
+}
+
+func (s *memoryTokenStore) Load(path string) (string, error) {
+	s.mu.Lock()
+	entry, ok := s.entries[path]
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.token, nil
+	}
+
+	token, err := s.next.Load(path)
+	if err != nil {
+		s.forget(path)
+		return "", err
+	}
+	s.remember(path, token)
+	return token, nil
+}
+
+func (s *memoryTokenStore) Save(path, token string) error {
+	if err := s.next.Save(path, token); err != nil {
+		s.forget(path)
+		return err
+	}
+	s.remember(path, token)
+	return nil
+}
+
+func (s *memoryTokenStore) Delete(path string) error {
+	s.forget(path)
+	return s.next.Delete(path)
+}
+
+// clear drops every token kept in memory
+func (s *memoryTokenStore) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.entries)
+}
+
+// remember keeps token in memory for tokenCacheTTL
+func (s *memoryTokenStore) remember(path, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[path] = memoryToken{token: token, expires: time.Now().Add(tokenCacheTTL)}
+}
+
+// forget drops the token of path from memory
+func (s *memoryTokenStore) forget(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, path)
+}
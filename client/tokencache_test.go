@@ -0,0 +1,218 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTokenStore counts the loads that reach the token files
+type countingTokenStore struct {
+	fileTokenStore
+	loads atomic.Int32
+}
+
+func (s *countingTokenStore) Load(path string) (string, error) {
+	s.loads.Add(1)
+	return s.fileTokenStore.Load(path)
+}
+
+// useCountingTokenStore puts a counting store behind the token cache for the test
+func useCountingTokenStore(t *testing.T) *countingTokenStore {
+	t.Setenv("HOME", t.TempDir())
+	files := &countingTokenStore{}
+	cache := tokenCache
+	tokenCache = newMemoryTokenStore(files)
+	t.Cleanup(func() { tokenCache = cache })
+	return files
+}
+
+func TestTokenCacheReadsFileOnce(t *testing.T) {
+	files := useCountingTokenStore(t)
+
+	client, server := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		default:
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":0}]}]}`))
+		}
+	}))
+
+	// Logged in by an earlier process: the token is only on disk
+	host := server.Listener.Addr().String()
+	if err := CacheToken(host, "mock-token"); err != nil {
+		t.Fatalf("CacheToken failed: %v", err)
+	}
+	tokenCache.clear()
+
+	const requests = 10
+	for i := 0; i < requests; i++ {
+		if _, err := client.PowerStatus(); err != nil {
+			t.Fatalf("PowerStatus failed: %v", err)
+		}
+	}
+
+	if loads := files.loads.Load(); loads != 1 {
+		t.Errorf("token file read %d times for %d requests, want 1", loads, requests)
+	}
+}
+
+func TestTokenCacheInvalidation(t *testing.T) {
+	files := useCountingTokenStore(t)
+	host := "cache.test.host"
+
+	if err := CacheToken(host, "first"); err != nil {
+		t.Fatalf("CacheToken failed: %v", err)
+	}
+	if token, err := GetCachedToken(host); err != nil || token != "first" {
+		t.Fatalf("GetCachedToken = %q, %v, want first", token, err)
+	}
+	if loads := files.loads.Load(); loads != 0 {
+		t.Errorf("saved token read from file %d times, want 0", loads)
+	}
+
+	if err := DeleteCachedToken(host); err != nil {
+		t.Fatalf("DeleteCachedToken failed: %v", err)
+	}
+	if _, err := GetCachedToken(host); err == nil {
+		t.Error("expected an error for a deleted token")
+	}
+
+	// Once expired, the token is read from its file again
+	ttl := tokenCacheTTL
+	tokenCacheTTL = 0
+	t.Cleanup(func() { tokenCacheTTL = ttl })
+
+	CacheToken(host, "second")
+	before := files.loads.Load()
+	if token, err := GetCachedToken(host); err != nil || token != "second" {
+		t.Fatalf("GetCachedToken = %q, %v, want second", token, err)
+	}
+	if files.loads.Load() != before+1 {
+		t.Error("expired token wasn't read from its file")
+	}
+}
+
+func TestTokenCacheConcurrent(t *testing.T) {
+	useCountingTokenStore(t)
+	host := "concurrent.test.host"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				CacheToken(host, "token")
+				GetCachedToken(host)
+				if j%10 == 0 {
+					DeleteCachedToken(host)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestValidateToken(t *testing.T) {
+	var authentications atomic.Int32
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			authentications.Add(1)
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer good-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"response":[{"result":[]}]}`))
+	}))
+
+	// Without a cached token there is nothing to validate
+	if valid, err := client.ValidateToken(); err != nil || valid {
+		t.Fatalf("Expected no valid token, got %v, %v", valid, err)
+	}
+
+	if err := CacheToken(client.Host, "good-token"); err != nil {
+		t.Fatalf("Failed to cache token: %v", err)
+	}
+	if valid, err := client.ValidateToken(); err != nil || !valid {
+		t.Errorf("Expected the token to be accepted, got %v, %v", valid, err)
+	}
+
+	if err := CacheToken(client.Host, "stale-token"); err != nil {
+		t.Fatalf("Failed to cache token: %v", err)
+	}
+	if valid, err := client.ValidateToken(); err != nil || valid {
+		t.Errorf("Expected the token to be rejected, got %v, %v", valid, err)
+	}
+
+	// The rejected token is left alone, and no new one requested
+	if token, err := GetCachedToken(client.Host); err != nil || token != "stale-token" {
+		t.Errorf("Expected the rejected token to stay cached, got %q, %v", token, err)
+	}
+	if authentications.Load() != 0 {
+		t.Errorf("Expected no authentication, got %d", authentications.Load())
+	}
+}
+
+// jwtWithPayload builds an unsigned JWT around the given claims
+func jwtWithPayload(claims string) string {
+	return "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2lnbmF0dXJl"
+}
+
+func TestAuthenticateRaw(t *testing.T) {
+	token := jwtWithPayload(`{"sub":"root","exp":1893456000}`)
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id":%q,"expires_in":3600}`, token)
+	}))
+
+	response, err := client.AuthenticateRaw()
+	if err != nil {
+		t.Fatalf("AuthenticateRaw failed: %v", err)
+	}
+	if response["id"] != token || response["expires_in"] != float64(3600) {
+		t.Errorf("Expected the whole auth response, got %v", response)
+	}
+	if cached, err := GetCachedToken(client.Host); err != nil || cached != token {
+		t.Errorf("Expected the token to be cached, got %q, %v", cached, err)
+	}
+
+	expiry, ok := DecodeTokenExpiry(token)
+	if !ok || !expiry.Equal(time.Unix(1893456000, 0)) {
+		t.Errorf("Expected expiry %v, got %v, %v", time.Unix(1893456000, 0), expiry, ok)
+	}
+}
+
+func TestDecodeTokenExpiryNotJWT(t *testing.T) {
+	for _, token := range []string{
+		"mock-token",
+		"a.b.c",
+		jwtWithPayload(`{"sub":"root"}`),
+		jwtWithPayload(`{"exp":"tomorrow"}`),
+	} {
+		if expiry, ok := DecodeTokenExpiry(token); ok {
+			t.Errorf("Expected no expiry for %q, got %v", token, expiry)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// TransferInfo describes a flash transfer in progress on the BMC
+type TransferInfo struct {
+	// ID is the transfer handle, as returned when the flash was started
+	ID int
+	// Node is the node being flashed [1-4], or 0 if the BMC doesn't say
+	Node int
+	// Process is the BMC's description of the transfer, e.g. "Node 1 upgrade service"
+	Process string
+	// BytesWritten is how much of the image was written so far
+	BytesWritten int64
+	// Size is the size of the image, 0 if unknown
+	Size int64
+	// Cancelled is set once the transfer was asked to stop
+	Cancelled bool
+}
+
+// processNodePattern finds the node in a transfer's process name
+var processNodePattern = regexp.MustCompile(`(?i)\bnode\s*([1-4])\b`)
+
+// ActiveTransfers returns the flash transfers in progress on the BMC, an empty list
+// when it is idle. A transfer left behind by an interrupted flash blocks new ones
+// until it is cancelled with CancelTransfer or the BMC is rebooted.
+func (c *Client) ActiveTransfers() ([]TransferInfo, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "flash")
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var raw json.RawMessage
+	if err := decodeJSONResponse(resp, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// The BMC reports {"Transferring": {...}} during a transfer, and "Ready", {"Done": ...}
+	// or {"Error": ...} otherwise
+	var respData struct {
+		Transferring *struct {
+			ID           json.Number `json:"id"`
+			Node         json.Number `json:"node"`
+			ProcessName  string      `json:"process_name"`
+			Size         json.Number `json:"size"`
+			BytesWritten json.Number `json:"bytes_written"`
+			Cancelled    bool        `json:"cancelled"`
+		} `json:"Transferring"`
+	}
+	if len(raw) == 0 || raw[0] != '{' {
+		return []TransferInfo{}, nil
+	}
+	if err := json.Unmarshal(raw, &respData); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	transferring := respData.Transferring
+	if transferring == nil {
+		return []TransferInfo{}, nil
+	}
+
+	id, err := strconv.Atoi(transferring.ID.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid transfer id: %q", transferring.ID)
+	}
+	info := TransferInfo{
+		ID:        id,
+		Process:   transferring.ProcessName,
+		Cancelled: transferring.Cancelled,
+	}
+	info.BytesWritten, _ = transferring.BytesWritten.Int64()
+	info.Size, _ = transferring.Size.Int64()
+	if node, err := strconv.Atoi(transferring.Node.String()); err == nil {
+		info.Node = node
+	} else if match := processNodePattern.FindStringSubmatch(info.Process); match != nil {
+		info.Node, _ = strconv.Atoi(match[1])
+	}
+
+	return []TransferInfo{info}, nil
+}
+
+// CancelTransfer aborts the flash transfer with the given handle, see ActiveTransfers.
+// It returns ErrUnsupported if the firmware can't abort transfers.
+func (c *Client) CancelTransfer(handle int) error {
+	if handle < 0 {
+		return fmt.Errorf("invalid transfer handle: %d", handle)
+	}
+
+	req, err := c.newRequest()
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "set")
+	req.AddQueryParam("type", "cancel")
+	req.AddQueryParam("handle", strconv.Itoa(handle))
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return ErrUnsupported
+		}
+		if err := checkHTMLResponse(resp, body); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to cancel transfer %d: %w", handle, &BMCError{StatusCode: resp.StatusCode, Message: string(body)})
+	}
+
+	if err := checkResponseError(resp); err != nil {
+		return fmt.Errorf("failed to cancel transfer %d: %w", handle, err)
+	}
+	return nil
+}
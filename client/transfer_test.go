@@ -0,0 +1,111 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// newTransferClient returns a client for a mock BMC answering flash status requests with status,
+// and a func returning the handles of the cancel requests it received
+func newTransferClient(t *testing.T, status string, cancelSupported bool) (*Client, func() []string) {
+	var mu sync.Mutex
+	var cancels []string
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			query := r.URL.Query()
+			switch query.Get("type") {
+			case "flash":
+				w.Write([]byte(status))
+			case "cancel":
+				if !cancelSupported {
+					http.Error(w, "Invalid `type` parameter cancel", http.StatusBadRequest)
+					return
+				}
+				mu.Lock()
+				cancels = append(cancels, query.Get("handle"))
+				mu.Unlock()
+				w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+			default:
+				http.NotFound(w, r)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return cancels
+	}
+}
+
+func TestActiveTransfers(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		want   []TransferInfo
+	}{
+		{
+			name:   "transferring",
+			status: `{"Transferring":{"id":42,"process_name":"Node 3 upgrade service","size":1048576,"cancelled":false,"bytes_written":524288}}`,
+			want:   []TransferInfo{{ID: 42, Node: 3, Process: "Node 3 upgrade service", BytesWritten: 524288, Size: 1048576}},
+		},
+		{
+			name:   "string fields",
+			status: `{"Transferring":{"id":"7","node":2,"size":"100","bytes_written":"10","cancelled":true}}`,
+			want:   []TransferInfo{{ID: 7, Node: 2, BytesWritten: 10, Size: 100, Cancelled: true}},
+		},
+		{name: "idle", status: `"Ready"`, want: []TransferInfo{}},
+		{name: "done", status: `{"Done":[1048576,"sha"]}`, want: []TransferInfo{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newTransferClient(t, tt.status, true)
+
+			got, err := client.ActiveTransfers()
+			if err != nil {
+				t.Fatalf("ActiveTransfers failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ActiveTransfers = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelTransfer(t *testing.T) {
+	client, cancels := newTransferClient(t, `"Ready"`, true)
+	if err := client.CancelTransfer(42); err != nil {
+		t.Fatalf("CancelTransfer failed: %v", err)
+	}
+	if got := cancels(); !reflect.DeepEqual(got, []string{"42"}) {
+		t.Errorf("Expected transfer 42 to be cancelled, got %v", got)
+	}
+
+	unsupported, _ := newTransferClient(t, `"Ready"`, false)
+	if err := unsupported.CancelTransfer(42); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
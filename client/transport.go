@@ -0,0 +1,177 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// AuthTransport is an http.RoundTripper that authenticates requests against a BMC.
+// It sends a cached bearer token when one exists, and otherwise retries a request
+// that was rejected with 401 once it has obtained a token.
+type AuthTransport struct {
+	Host     string
+	Version  ApiVersion
+	Username string
+	Password string
+
+	// CredentialProvider supplies credentials when Username and Password are empty,
+	// DefaultCredentialProvider if nil
+	CredentialProvider CredentialProvider
+
+	// BasePath is the path of the BMC endpoint, the version's BasePath if empty
+	BasePath string
+
+	// PinnedCertSHA256 is the fingerprint the BMC's certificate must match when
+	// authenticating, in lowercase hex, if set
+	PinnedCertSHA256 string
+
+	// UserAgent is sent with requests that don't set one, and when authenticating.
+	// The default TPI User-Agent is used for authentication if empty.
+	UserAgent string
+
+	// Headers are added to every request, and when authenticating, without
+	// replacing headers the request already has
+	Headers http.Header
+
+	// NoAuth sends requests without a token, even a cached one, and only
+	// authenticates to replay a request the BMC rejected with 401
+	NoAuth bool
+
+	// Base performs the actual requests, a transport that skips certificate
+	// verification if nil since BMCs ship with self-signed certificates
+	Base http.RoundTripper
+}
+
+// Transport returns an authenticated round-tripper for the client's BMC, for callers
+// that need to reach endpoints the client doesn't wrap
+func (c *Client) Transport() http.RoundTripper {
+	return c.newTransport(c.httpClient.Transport)
+}
+
+// newTransport builds an AuthTransport from the client's configuration
+func (c *Client) newTransport(base http.RoundTripper) *AuthTransport {
+	t := &AuthTransport{
+		Host:               c.Host,
+		Version:            c.ApiVersion,
+		CredentialProvider: c.credentialProvider,
+		BasePath:           c.basePath,
+		PinnedCertSHA256:   c.pinnedCert,
+		UserAgent:          c.userAgentHeader(),
+		Headers:            c.headers,
+		NoAuth:             c.noAuth,
+		Base:               base,
+	}
+	if c.auth != nil {
+		t.Username = c.auth.Username
+		t.Password = c.auth.Password
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = newBMCTransport(t.PinnedCertSHA256)
+	}
+
+	// Authenticate immediately if we already have a token for this host
+	authenticated := false
+	if !t.NoAuth {
+		_, tokenErr := GetCachedToken(t.Host)
+		authenticated = tokenErr == nil
+	}
+
+	// A body that can't be rewound can't be replayed after a 401, so authenticate up front
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if !replayable && !t.NoAuth {
+		authenticated = true
+	}
+
+	attempt := req
+	for {
+		out := attempt.Clone(attempt.Context())
+		if t.UserAgent != "" && out.Header.Get("User-Agent") == "" {
+			out.Header.Set("User-Agent", t.UserAgent)
+		}
+		for key, values := range t.Headers {
+			if len(out.Header.Values(key)) == 0 {
+				out.Header[key] = append([]string(nil), values...)
+			}
+		}
+		if authenticated {
+			token, err := t.bearerToken()
+			if err != nil {
+				if out.Body != nil {
+					out.Body.Close()
+				}
+				return nil, fmt.Errorf("failed to get bearer token: %w", err)
+			}
+			out.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+
+		resp, err := base.RoundTrip(out)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			return resp, nil
+		}
+
+		if authenticated {
+			// We got a 401 despite using a token, so the token is likely invalid
+			Debug("Got 401 Unauthorized with a token, token may be expired. Deleting cached token.")
+			DeleteCachedToken(t.Host)
+			return resp, nil
+		}
+
+		// Without authentication up front, a body that can't be rewound is already spent
+		if !replayable {
+			return resp, nil
+		}
+
+		// Replay the request, this time with a token
+		resp.Body.Close()
+		Debug("Got 401 Unauthorized, trying again with authentication")
+		authenticated = true
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			attempt = req.Clone(req.Context())
+			attempt.Body = body
+		}
+	}
+}
+
+// bearerToken returns a cached token for the host, or authenticates to obtain one
+func (t *AuthTransport) bearerToken() (string, error) {
+	r, err := NewRequest(t.Host, t.Version, t.Username, t.Password)
+	if err != nil {
+		return "", err
+	}
+	r.CredentialProvider = t.CredentialProvider
+	r.BasePath = t.BasePath
+	r.PinnedCertSHA256 = t.PinnedCertSHA256
+	r.ExtraHeaders = t.Headers
+	if t.UserAgent != "" {
+		r.SetUserAgent(t.UserAgent)
+	}
+	return r.getBearerToken()
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransportAuthenticatesAfter401(t *testing.T) {
+	server := newAuthServer(t, Credential{"alice", "secret"})
+	host := server.Listener.Addr().String()
+
+	client, err := NewClient(WithHost(host), WithCredentials("alice", "secret"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	httpClient := &http.Client{Transport: client.Transport()}
+
+	// The body must be replayed once the transport has a token
+	resp, err := httpClient.Post("https://"+host+"/api/bmc", "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	token, err := GetCachedToken(host)
+	if err != nil || token != "token-alice" {
+		t.Errorf("Expected cached token-alice, got %q (%v)", token, err)
+	}
+}
+
+func TestTransportDropsRejectedToken(t *testing.T) {
+	server := newAuthServer(t, Credential{"alice", "secret"})
+	host := server.Listener.Addr().String()
+
+	if err := CacheToken(host, "stale"); err != nil {
+		t.Fatalf("CacheToken failed: %v", err)
+	}
+
+	client, err := NewClient(WithHost(host), WithCredentials("alice", "secret"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := (&http.Client{Transport: client.Transport()}).Get("https://" + host + "/api/bmc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a stale token, got %d", resp.StatusCode)
+	}
+	if _, err := GetCachedToken(host); err == nil {
+		t.Error("Expected the stale token to be removed from the cache")
+	}
+}
+
+func TestNoAuthSkipsAuthentication(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	var mu sync.Mutex
+	var authCalls, authHeaders int
+	upload := &chunkServer{failAt: -1}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/api/bmc/authenticate" {
+			authCalls++
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "" {
+			authHeaders++
+		}
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/upload/7":
+			upload.serveUpload(w, r)
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":0,"node3":0,"node4":0}]}]}`))
+		default:
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	host := server.Listener.Addr().String()
+
+	// Even a cached token isn't sent
+	if err := CacheToken(host, "cached"); err != nil {
+		t.Fatalf("CacheToken failed: %v", err)
+	}
+
+	// No credentials are needed
+	client, err := NewClient(WithHost(host), WithNoAuth())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if err := client.PowerOn(1); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+	if _, err := client.Do(Operation{Verb: VerbPowerOff, Node: 2}); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+
+	// Nor for every chunk of a chunked upload
+	path, _ := writeImage(t, strings.Repeat("x", 50))
+	if err := client.FlashNode(1, &FlashOptions{ImagePath: path, ChunkSize: 20, ProgressWriter: io.Discard}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+	if len(upload.chunks) != 3 {
+		t.Errorf("Expected 3 chunks, got %v", upload.chunks)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if authCalls != 0 || authHeaders != 0 {
+		t.Errorf("got %d authentications and %d Authorization headers, want none", authCalls, authHeaders)
+	}
+}
+
+func TestNoAuthFallsBackAfter401(t *testing.T) {
+	server := newAuthServer(t, Credential{"alice", "secret"})
+	host := server.Listener.Addr().String()
+
+	client, err := NewClient(WithHost(host), WithNoAuth(), WithCredentials("alice", "secret"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if err := client.PowerOn(1); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+
+	token, err := GetCachedToken(host)
+	if err != nil || token != "token-alice" {
+		t.Errorf("Expected cached token-alice after a 401, got %q (%v)", token, err)
+	}
+}
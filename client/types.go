@@ -14,14 +14,37 @@
 
 package tpi
 
+import (
+	"fmt"
+	"strings"
+)
+
 // ApiVersion represents the BMC API version
 type ApiVersion string
 
 const (
 	ApiVersionV1   ApiVersion = "v1"
 	ApiVersionV1_1 ApiVersion = "v1-1"
+	// ApiVersionV2 is reserved for future firmware that serves a versioned API under /api/v2
+	ApiVersionV2 ApiVersion = "v2"
 )
 
+// apiVersionAliases maps the accepted spellings of each API version, lowercase, to the version
+var apiVersionAliases = map[string]ApiVersion{
+	"1": ApiVersionV1, "v1": ApiVersionV1, "1.0": ApiVersionV1, "v1.0": ApiVersionV1, "v1-0": ApiVersionV1,
+	"1.1": ApiVersionV1_1, "v1.1": ApiVersionV1_1, "1-1": ApiVersionV1_1, "v1-1": ApiVersionV1_1, "v1_1": ApiVersionV1_1,
+	"2": ApiVersionV2, "v2": ApiVersionV2, "2.0": ApiVersionV2, "v2.0": ApiVersionV2, "v2-0": ApiVersionV2,
+}
+
+// ParseApiVersion parses an API version from a config file or a flag, accepting common
+// spellings such as "1.1", "v1.1" or "v1-1", and returns the normalized version
+func ParseApiVersion(s string) (ApiVersion, error) {
+	if version, ok := apiVersionAliases[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return version, nil
+	}
+	return "", fmt.Errorf("unknown API version %q (must be %s, %s or %s)", s, ApiVersionV1, ApiVersionV1_1, ApiVersionV2)
+}
+
 // GetScheme returns the HTTP scheme for the given API version
 func (a ApiVersion) GetScheme() string {
 	switch a {
@@ -34,6 +57,71 @@ func (a ApiVersion) GetScheme() string {
 	}
 }
 
+// apiRoot returns the path prefix every endpoint of the API version lives under
+func (a ApiVersion) apiRoot() string {
+	switch a {
+	case ApiVersionV2:
+		return "/api/v2"
+	default:
+		return "/api"
+	}
+}
+
+// BaseURL returns the scheme and host part of the API URLs, e.g. "https://192.168.1.91"
+func (a ApiVersion) BaseURL(host string) string {
+	return fmt.Sprintf("%s://%s", a.GetScheme(), host)
+}
+
+// BasePath returns the path of the BMC endpoint used by most operations
+func (a ApiVersion) BasePath() string {
+	return a.apiRoot() + "/bmc"
+}
+
+// AuthPath returns the path of the authentication endpoint
+func (a ApiVersion) AuthPath() string {
+	return a.Paths("").Auth()
+}
+
+// UploadPath returns the path images are uploaded to for the given flash handle
+func (a ApiVersion) UploadPath(handle int) string {
+	return a.Paths("").Upload(handle)
+}
+
+// FirmwarePath returns the path of the firmware upgrade endpoint
+func (a ApiVersion) FirmwarePath() string {
+	return a.Paths("").Firmware()
+}
+
+// Paths returns the endpoint paths rooted at the given BMC base path,
+// or at the version's BasePath if base is empty
+func (a ApiVersion) Paths(base string) Paths {
+	if base == "" {
+		base = a.BasePath()
+	}
+	return Paths{Base: base}
+}
+
+// Paths builds endpoint paths from the path of the BMC endpoint, so a BMC served
+// behind a reverse proxy at a subpath (e.g. "/board1/api/bmc") keeps working
+type Paths struct {
+	Base string // Path of the BMC endpoint, e.g. "/api/bmc"
+}
+
+// Auth returns the path of the authentication endpoint
+func (p Paths) Auth() string {
+	return p.Base + "/authenticate"
+}
+
+// Upload returns the path images are uploaded to for the given flash handle
+func (p Paths) Upload(handle int) string {
+	return fmt.Sprintf("%s/upload/%d", p.Base, handle)
+}
+
+// Firmware returns the path of the firmware upgrade endpoint, a sibling of the BMC endpoint
+func (p Paths) Firmware() string {
+	return strings.TrimSuffix(p.Base, "/bmc") + "/firmware"
+}
+
 // PowerCmd represents power commands
 type PowerCmd string
 
@@ -65,6 +153,22 @@ type UsbStatusInfo struct {
 	Route string
 }
 
+// UartParity represents the parity setting of a UART
+type UartParity string
+
+const (
+	UartParityNone UartParity = "none"
+	UartParityEven UartParity = "even"
+	UartParityOdd  UartParity = "odd"
+)
+
+// UartConfig represents the serial configuration of a node's UART
+type UartConfig struct {
+	BaudRate int
+	DataBits int
+	Parity   UartParity
+}
+
 // ModeCmd represents advanced mode commands
 type ModeCmd string
 
@@ -0,0 +1,189 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestApiVersionURLs(t *testing.T) {
+	tests := []struct {
+		version  ApiVersion
+		base     string
+		auth     string
+		upload   string
+		firmware string
+	}{
+		{
+			ApiVersionV1,
+			"http://10.0.0.1/api/bmc",
+			"http://10.0.0.1/api/bmc/authenticate",
+			"http://10.0.0.1/api/bmc/upload/42",
+			"http://10.0.0.1/api/firmware",
+		},
+		{
+			ApiVersionV1_1,
+			"https://10.0.0.1/api/bmc",
+			"https://10.0.0.1/api/bmc/authenticate",
+			"https://10.0.0.1/api/bmc/upload/42",
+			"https://10.0.0.1/api/firmware",
+		},
+		{
+			ApiVersionV2,
+			"https://10.0.0.1/api/v2/bmc",
+			"https://10.0.0.1/api/v2/bmc/authenticate",
+			"https://10.0.0.1/api/v2/bmc/upload/42",
+			"https://10.0.0.1/api/v2/firmware",
+		},
+	}
+
+	for _, tt := range tests {
+		baseURL := tt.version.BaseURL("10.0.0.1")
+		if got := baseURL + tt.version.BasePath(); got != tt.base {
+			t.Errorf("%s: expected base URL %s, got %s", tt.version, tt.base, got)
+		}
+		if got := baseURL + tt.version.AuthPath(); got != tt.auth {
+			t.Errorf("%s: expected auth URL %s, got %s", tt.version, tt.auth, got)
+		}
+		if got := baseURL + tt.version.UploadPath(42); got != tt.upload {
+			t.Errorf("%s: expected upload URL %s, got %s", tt.version, tt.upload, got)
+		}
+		if got := baseURL + tt.version.FirmwarePath(); got != tt.firmware {
+			t.Errorf("%s: expected firmware URL %s, got %s", tt.version, tt.firmware, got)
+		}
+
+		req, err := NewRequest("10.0.0.1", tt.version, "root", "turing")
+		if err != nil {
+			t.Fatalf("%s: failed to create request: %v", tt.version, err)
+		}
+		if got := req.GetURL(); got != tt.base {
+			t.Errorf("%s: expected request URL %s, got %s", tt.version, tt.base, got)
+		}
+	}
+}
+
+func TestWithBasePath(t *testing.T) {
+	const base = "/board1/api/bmc"
+
+	var paths []string
+	client, server := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case base + "/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case base:
+			if r.Header.Get("Authorization") != "Bearer mock-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		case "/board1/api/firmware":
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), WithBasePath("board1/api/bmc/"))
+
+	req, err := client.newRequest()
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if got, want := req.GetURL(), "https://"+server.Listener.Addr().String()+base; got != want {
+		t.Errorf("Expected request URL %s, got %s", want, got)
+	}
+
+	resp, err := req.Send()
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d (paths: %v)", resp.StatusCode, paths)
+	}
+
+	// The request was rejected, authenticated under the base path, then replayed
+	want := []string{base, base + "/authenticate", base}
+	if len(paths) != len(want) {
+		t.Fatalf("Expected paths %v, got %v", want, paths)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("Expected path %d to be %s, got %s", i, want[i], paths[i])
+		}
+	}
+
+	if got := client.paths().Upload(7); got != base+"/upload/7" {
+		t.Errorf("Expected upload path %s/upload/7, got %s", base, got)
+	}
+
+	if err := client.UpgradeFirmware(writeFirmware(t), ""); err != nil {
+		t.Errorf("Expected firmware upload under the base path, got: %v", err)
+	}
+}
+
+func TestWithBasePathRejectsEmpty(t *testing.T) {
+	if _, err := NewClient(WithHost("10.0.0.1"), WithCredentials("root", "turing"), WithBasePath("/")); err == nil {
+		t.Error("Expected an error for an empty base path")
+	}
+}
+
+func TestParseApiVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    ApiVersion
+		wantErr bool
+	}{
+		{"v1", ApiVersionV1, false},
+		{"1", ApiVersionV1, false},
+		{"1.0", ApiVersionV1, false},
+		{"V1", ApiVersionV1, false},
+		{"v1-1", ApiVersionV1_1, false},
+		{"v1.1", ApiVersionV1_1, false},
+		{"1.1", ApiVersionV1_1, false},
+		{"1-1", ApiVersionV1_1, false},
+		{"v1_1", ApiVersionV1_1, false},
+		{" v1-1 ", ApiVersionV1_1, false},
+		{"v2", ApiVersionV2, false},
+		{"2", ApiVersionV2, false},
+		{"", "", true},
+		{"v1.2", "", true},
+		{"v3", "", true},
+		{"latest", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseApiVersion(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseApiVersion(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseApiVersion(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	// NewClient normalizes aliases and rejects unknown versions
+	client, err := NewClient(WithHost("10.0.0.1"), WithApiVersion("1.1"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if client.ApiVersion != ApiVersionV1_1 {
+		t.Errorf("Expected %s, got %s", ApiVersionV1_1, client.ApiVersion)
+	}
+	if _, err := NewClient(WithHost("10.0.0.1"), WithApiVersion("v1.2")); err == nil {
+		t.Error("Expected NewClient to reject an unknown API version")
+	}
+}
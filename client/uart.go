@@ -15,9 +15,16 @@
 package tpi
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // GetUartOutput gets the UART output from the specified node
@@ -34,7 +41,7 @@ func (c *Client) GetUartOutput(node int) (string, error) {
 	// Add query parameters
 	req.AddQueryParam("opt", "get")
 	req.AddQueryParam("type", "uart")
-	req.AddQueryParam("node", strconv.Itoa(node-1)) // BMC uses 0-based indexing
+	req.AddQueryParam("node", toBMCNodeIndex(node))
 
 	// Send the request
 	resp, err := req.Send()
@@ -49,7 +56,7 @@ func (c *Client) GetUartOutput(node int) (string, error) {
 		Response []interface{} `json:"response"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+	if err := decodeJSONResponse(resp, &respData); err != nil {
 		return "", fmt.Errorf("failed to parse response: %w", err)
 	}
 
@@ -80,6 +87,61 @@ func (c *Client) GetUartOutput(node int) (string, error) {
 	return string(outputJSON), nil
 }
 
+// CollectUart fetches the UART buffers of several nodes concurrently and returns them keyed by node.
+// A node with an empty buffer maps to an empty string. Nodes that fail are left out of the map and
+// reported in the returned error, alongside the buffers of the nodes that succeeded.
+func (c *Client) CollectUart(nodes []int) (map[int]string, error) {
+	for _, node := range nodes {
+		if node < 1 || node > 4 {
+			return nil, fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		outputs = make(map[int]string, len(nodes))
+		failed  = make(map[int]error)
+		seen    = make(map[int]bool, len(nodes))
+	)
+
+	for _, node := range nodes {
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+
+		wg.Add(1)
+		go func(node int) {
+			defer wg.Done()
+			output, err := c.GetUartOutput(node)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[node] = fmt.Errorf("node %d: %w", node, err)
+				return
+			}
+			outputs[node] = output
+		}(node)
+	}
+	wg.Wait()
+
+	// Report failures in the order the nodes were given
+	var errs []error
+	for _, node := range nodes {
+		if err, ok := failed[node]; ok {
+			errs = append(errs, err)
+			delete(failed, node)
+		}
+	}
+	if len(errs) > 0 {
+		return outputs, fmt.Errorf("failed to collect UART output: %w", errors.Join(errs...))
+	}
+
+	return outputs, nil
+}
+
 // SendUartCommand sends a command to the specified node over UART
 func (c *Client) SendUartCommand(node int, command string) error {
 	if node < 1 || node > 4 {
@@ -94,7 +156,7 @@ func (c *Client) SendUartCommand(node int, command string) error {
 	// Add query parameters
 	req.AddQueryParam("opt", "set")
 	req.AddQueryParam("type", "uart")
-	req.AddQueryParam("node", strconv.Itoa(node-1)) // BMC uses 0-based indexing
+	req.AddQueryParam("node", toBMCNodeIndex(node))
 	req.AddQueryParam("cmd", command)
 
 	// Send the request
@@ -111,3 +173,180 @@ func (c *Client) SendUartCommand(node int, command string) error {
 
 	return nil
 }
+
+// SendUartAndRead sends a command to the specified node over UART, waits for wait and returns
+// the output that appeared meanwhile, usually the echoed command followed by its output.
+// Output that was already buffered before the command was sent is left out.
+func (c *Client) SendUartAndRead(node int, command string, wait time.Duration) (string, error) {
+	before, err := c.GetUartOutput(node)
+	if err != nil {
+		return "", fmt.Errorf("failed to read UART before sending: %w", err)
+	}
+
+	if err := c.SendUartCommand(node, command); err != nil {
+		return "", err
+	}
+
+	time.Sleep(wait)
+
+	after, err := c.GetUartOutput(node)
+	if err != nil {
+		return "", fmt.Errorf("failed to read UART after sending: %w", err)
+	}
+
+	return uartDelta(before, after), nil
+}
+
+// uartDelta returns the output of a UART read that is new since the previous one.
+// Depending on the firmware, a read returns either the whole buffer, which keeps growing,
+// or only what was written since the last read. A read starting with the previous one
+// is a grown buffer; anything else is already new output.
+func uartDelta(before, after string) string {
+	if before != "" && strings.HasPrefix(after, before) {
+		return after[len(before):]
+	}
+	return after
+}
+
+// SendUartStream reads lines from r and sends each one as a UART command to the
+// specified node, waiting the configured line delay (see WithUartLineDelay) between
+// lines. Both LF and CRLF line endings are accepted.
+func (c *Client) SendUartStream(node int, r io.Reader) error {
+	if node < 1 || node > 4 {
+		return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		if lineNum > 0 && c.uartLineDelay > 0 {
+			time.Sleep(c.uartLineDelay)
+		}
+		lineNum++
+
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if err := c.SendUartCommand(node, line); err != nil {
+			return fmt.Errorf("failed to send line %d: %w", lineNum, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read UART input: %w", err)
+	}
+
+	return nil
+}
+
+// GetUartConfig returns the UART configuration of the specified node.
+// It returns ErrUnsupported if the firmware doesn't expose the UART configuration.
+func (c *Client) GetUartConfig(node int) (*UartConfig, error) {
+	if node < 1 || node > 4 {
+		return nil, fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+	}
+
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "get")
+	req.AddQueryParam("type", "uart_config")
+	req.AddQueryParam("node", toBMCNodeIndex(node))
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return nil, ErrUnsupported
+		}
+		return nil, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	// Extract the result
+	result, err := extractResultObject(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract result: %w", err)
+	}
+
+	config := &UartConfig{
+		Parity: UartParityNone,
+	}
+	if baud, ok := result["baud"].(float64); ok {
+		config.BaudRate = int(baud)
+	}
+	if dataBits, ok := result["data_bits"].(float64); ok {
+		config.DataBits = int(dataBits)
+	}
+	if parity, ok := result["parity"].(string); ok && parity != "" {
+		config.Parity = UartParity(parity)
+	}
+
+	return config, nil
+}
+
+// SetUartConfig changes the UART configuration of the specified node.
+// It returns ErrUnsupported if the firmware doesn't expose the UART configuration.
+func (c *Client) SetUartConfig(node int, cfg UartConfig) error {
+	if node < 1 || node > 4 {
+		return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+	}
+
+	if cfg.BaudRate <= 0 {
+		return fmt.Errorf("invalid baud rate: %d", cfg.BaudRate)
+	}
+
+	if cfg.DataBits < 5 || cfg.DataBits > 8 {
+		return fmt.Errorf("invalid data bits: %d (must be 5-8)", cfg.DataBits)
+	}
+
+	if cfg.Parity == "" {
+		cfg.Parity = UartParityNone
+	}
+	switch cfg.Parity {
+	case UartParityNone, UartParityEven, UartParityOdd:
+	default:
+		return fmt.Errorf("invalid parity: %s (must be none, even or odd)", cfg.Parity)
+	}
+
+	req, err := c.newRequest()
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add query parameters
+	req.AddQueryParam("opt", "set")
+	req.AddQueryParam("type", "uart_config")
+	req.AddQueryParam("node", toBMCNodeIndex(node))
+	req.AddQueryParam("baud", strconv.Itoa(cfg.BaudRate))
+	req.AddQueryParam("data_bits", strconv.Itoa(cfg.DataBits))
+	req.AddQueryParam("parity", string(cfg.Parity))
+
+	// Send the request
+	resp, err := req.Send()
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if isUnsupportedResponse(resp.StatusCode, string(body)) {
+			return ErrUnsupported
+		}
+		return fmt.Errorf("UART config failed: %w", &BMCError{StatusCode: resp.StatusCode, Message: string(body)})
+	}
+
+	// Check for errors in the response
+	if err := checkResponseError(resp); err != nil {
+		return fmt.Errorf("UART config failed: %w", err)
+	}
+
+	return nil
+}
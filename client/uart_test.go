@@ -0,0 +1,243 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newUartConfigHandler returns a mock BMC handler for the uart_config type,
+// the handler records the last set query in lastSet
+func newUartConfigHandler(lastSet *url.Values) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			query := r.URL.Query()
+			if query.Get("type") != "uart_config" {
+				http.Error(w, "Invalid `type` parameter", http.StatusBadRequest)
+				return
+			}
+			if query.Get("opt") == "set" {
+				*lastSet = query
+				w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+				return
+			}
+			w.Write([]byte(`{"result":{"baud":115200,"data_bits":8,"parity":"none"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// unsupportedHandler mimics firmware without UART configuration support
+var unsupportedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/bmc/authenticate":
+		w.Write([]byte(`{"id":"mock-token"}`))
+	case "/api/bmc":
+		http.Error(w, "Invalid `type` parameter uart_config", http.StatusBadRequest)
+	default:
+		http.NotFound(w, r)
+	}
+})
+
+func TestUartConfigSupported(t *testing.T) {
+	var lastSet url.Values
+	client, _ := newMockClient(t, newUartConfigHandler(&lastSet))
+
+	config, err := client.GetUartConfig(2)
+	if err != nil {
+		t.Fatalf("GetUartConfig failed: %v", err)
+	}
+	if config.BaudRate != 115200 || config.DataBits != 8 || config.Parity != UartParityNone {
+		t.Errorf("Unexpected UART config: %+v", config)
+	}
+
+	err = client.SetUartConfig(2, UartConfig{BaudRate: 9600, DataBits: 7, Parity: UartParityEven})
+	if err != nil {
+		t.Fatalf("SetUartConfig failed: %v", err)
+	}
+	if lastSet.Get("node") != "1" || lastSet.Get("baud") != "9600" || lastSet.Get("data_bits") != "7" || lastSet.Get("parity") != "even" {
+		t.Errorf("Unexpected set query: %v", lastSet)
+	}
+}
+
+func TestUartConfigUnsupported(t *testing.T) {
+	client, _ := newMockClient(t, unsupportedHandler)
+
+	if _, err := client.GetUartConfig(1); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported from GetUartConfig, got %v", err)
+	}
+
+	err := client.SetUartConfig(1, UartConfig{BaudRate: 115200, DataBits: 8})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported from SetUartConfig, got %v", err)
+	}
+}
+
+func TestSetUartConfigValidation(t *testing.T) {
+	client, err := NewClient(WithHost("127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	invalid := []UartConfig{
+		{BaudRate: 0, DataBits: 8},
+		{BaudRate: 115200, DataBits: 9},
+		{BaudRate: 115200, DataBits: 8, Parity: "mark"},
+	}
+	for _, cfg := range invalid {
+		if err := client.SetUartConfig(1, cfg); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestSendUartStream(t *testing.T) {
+	var sent []string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			query := r.URL.Query()
+			if query.Get("type") == "uart" && query.Get("node") == "2" {
+				sent = append(sent, query.Get("cmd"))
+			}
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), WithUartLineDelay(0))
+
+	input := "root\r\nturing\nuname -a\r\n\nexit"
+	if err := client.SendUartStream(3, strings.NewReader(input)); err != nil {
+		t.Fatalf("SendUartStream failed: %v", err)
+	}
+
+	expected := []string{"root", "turing", "uname -a", "", "exit"}
+	if len(sent) != len(expected) {
+		t.Fatalf("Expected %d sends, got %d: %q", len(expected), len(sent), sent)
+	}
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("Line %d: expected %q, got %q", i+1, expected[i], sent[i])
+		}
+	}
+}
+
+func TestCollectUart(t *testing.T) {
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			// Nodes are 0-based on the wire
+			switch r.URL.Query().Get("node") {
+			case "0":
+				w.Write([]byte(`{"response":["node 1 console"]}`))
+			case "1":
+				w.Write([]byte(`{"response":[]}`))
+			case "2":
+				http.Error(w, "uart busy", http.StatusInternalServerError)
+			case "3":
+				w.Write([]byte(`{"response":[{"output":"node 4 login:"}]}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	outputs, err := client.CollectUart([]int{1, 2, 3, 4, 1})
+	if err == nil || !strings.Contains(err.Error(), "node 3") {
+		t.Errorf("Expected an error naming node 3, got: %v", err)
+	}
+
+	expected := map[int]string{1: "node 1 console", 2: "", 4: "node 4 login:"}
+	if len(outputs) != len(expected) {
+		t.Fatalf("Expected outputs for nodes 1, 2 and 4, got %v", outputs)
+	}
+	for node, want := range expected {
+		got, ok := outputs[node]
+		if !ok || got != want {
+			t.Errorf("Node %d: expected %q, got %q (present: %v)", node, want, got, ok)
+		}
+	}
+
+	if _, err := client.CollectUart([]int{5}); err == nil {
+		t.Error("Expected an error for node 5")
+	}
+}
+
+func TestSendUartAndRead(t *testing.T) {
+	tests := []struct {
+		name string
+		// draining mimics firmware returning only the output written since the last read
+		draining bool
+	}{
+		{name: "growing buffer"},
+		{name: "draining buffer", draining: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			buffer := "previous boot log\nnode1 login: "
+			read := 0
+
+			client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				switch r.URL.Path {
+				case "/api/bmc/authenticate":
+					w.Write([]byte(`{"id":"mock-token"}`))
+				case "/api/bmc":
+					query := r.URL.Query()
+					if query.Get("opt") == "set" {
+						// The node echoes the command, then prints its output
+						buffer += query.Get("cmd") + "\nLinux node1 6.1.0\n# "
+						w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+						return
+					}
+					output, _ := json.Marshal(buffer[read:])
+					if tt.draining {
+						read = len(buffer)
+					}
+					w.Write([]byte(`{"response":[` + string(output) + `]}`))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+
+			output, err := client.SendUartAndRead(1, "uname -a", time.Millisecond)
+			if err != nil {
+				t.Fatalf("SendUartAndRead failed: %v", err)
+			}
+			if expected := "uname -a\nLinux node1 6.1.0\n# "; output != expected {
+				t.Errorf("Expected %q, got %q", expected, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusResumeIncomplete is the status a chunked upload endpoint answers while the
+// upload is incomplete, with the received range in a Range header
+const statusResumeIncomplete = http.StatusPermanentRedirect
+
+// newMultipartStream returns a reader that produces a multipart form containing a single file
+// part read from r, along with the form content type and its total length in bytes.
+// The form is written through an io.Pipe as the reader is consumed, so the file content is
+// never buffered in memory. The returned reader must be closed to release the writer goroutine.
+func newMultipartStream(r io.Reader, size int64, fieldName, fileName string) (io.ReadCloser, string, int64, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	// Compute the length of the form envelope using the same boundary
+	length, err := multipartLength(writer.Boundary(), fieldName, fileName)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	go func() {
+		part, err := writer.CreateFormFile(fieldName, fileName)
+		if err != nil {
+			pw.CloseWithError(fmt.Errorf("failed to create form file: %w", err))
+			return
+		}
+
+		if _, err := io.Copy(part, r); err != nil {
+			pw.CloseWithError(fmt.Errorf("failed to copy file to form: %w", err))
+			return
+		}
+
+		// Closing the writer emits the final boundary
+		pw.CloseWithError(writer.Close())
+	}()
+
+	return pr, writer.FormDataContentType(), length + size, nil
+}
+
+// multipartLength returns the size of a multipart form envelope around a single empty file part
+func multipartLength(boundary, fieldName, fileName string) (int64, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return 0, fmt.Errorf("failed to set multipart boundary: %w", err)
+	}
+
+	if _, err := writer.CreateFormFile(fieldName, fileName); err != nil {
+		return 0, fmt.Errorf("failed to create form file: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+
+	return int64(buf.Len()), nil
+}
+
+// sendFileChunked uploads the file at path in chunks of chunkSize bytes through clones
+// of req, using the resumable upload protocol some firmware exposes on its upload
+// endpoints: a PUT with "Content-Range: bytes */<total>" and no body asks how much was
+// received, and every chunk is a PUT with "Content-Range: bytes <first>-<last>/<total>".
+// Both are answered with 308 and a "Range: bytes=0-<last>" header while the upload is
+// incomplete. The upload starts at the offset the BMC reports, so an interrupted upload
+// resumes where it stopped, and a failed chunk is retried alone from the offset the BMC
+// reports afterwards. ErrUnsupported is returned, before any data was sent, when the
+// firmware doesn't support chunked uploads.
+func (c *Client) sendFileChunked(req *Request, path string, chunkSize int64, out *flashOutput) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	size := fileInfo.Size()
+
+	offset, err := c.uploadOffset(req, size)
+	if err != nil {
+		return err
+	}
+	if offset > 0 && offset < size {
+		out.printf("Resuming upload at %s of %s...\n", formatBytes(offset), formatBytes(size))
+	}
+
+	for offset < size {
+		length := chunkSize
+		if remaining := size - offset; remaining < length {
+			length = remaining
+		}
+
+		for attempts := 0; ; attempts++ {
+			next, err := c.sendChunk(req, file, offset, length, size)
+			if err == nil {
+				offset = next
+				break
+			}
+
+			if attempts >= uploadAttempts-1 {
+				return fmt.Errorf("failed to upload chunk at offset %d after retries: %w", offset, err)
+			}
+			out.printf("Error uploading chunk at offset %d: %v. Retrying in %s...\n", offset, err, uploadRetryWait)
+			time.Sleep(uploadRetryWait)
+
+			// Part of the chunk may have been received, continue from what the BMC has
+			if received, err := c.uploadOffset(req, size); err == nil && received < size {
+				offset = received
+				if remaining := size - offset; remaining < length {
+					length = remaining
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// uploadOffset asks the upload endpoint of req how many bytes of an upload of total
+// bytes it received. It returns ErrUnsupported if the endpoint doesn't support chunked
+// uploads.
+func (c *Client) uploadOffset(req *Request, total int64) (int64, error) {
+	query := req.Clone()
+	query.Method = http.MethodPut
+	query.Headers["Content-Range"] = fmt.Sprintf("bytes */%d", total)
+
+	resp, err := query.Send()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query upload offset: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return total, nil
+	case statusResumeIncomplete:
+		return parseUploadRange(resp.Header.Get("Range"))
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusMethodNotAllowed || isUnsupportedResponse(resp.StatusCode, string(body)) {
+		return 0, ErrUnsupported
+	}
+	return 0, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+}
+
+// sendChunk uploads length bytes of file starting at offset through a clone of req, and
+// returns the offset the upload continues from
+func (c *Client) sendChunk(req *Request, file *os.File, offset, length, total int64) (int64, error) {
+	chunk := req.Clone()
+	chunk.Method = http.MethodPut
+	chunk.Headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, total)
+	chunk.SetBody(io.NewSectionReader(file, offset, length), "application/octet-stream", length)
+
+	resp, err := chunk.Send()
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return total, nil
+	case statusResumeIncomplete:
+		received, err := parseUploadRange(resp.Header.Get("Range"))
+		if err != nil {
+			return 0, err
+		}
+		if received <= offset {
+			return 0, fmt.Errorf("chunk at offset %d was not received", offset)
+		}
+		return received, nil
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if err := checkHTMLResponse(resp, body); err != nil {
+		return 0, err
+	}
+	return 0, &BMCError{StatusCode: resp.StatusCode, Message: string(body)}
+}
+
+// parseUploadRange parses the "bytes=0-<last>" Range header of an incomplete upload into
+// the number of bytes received, 0 when the header is empty
+func parseUploadRange(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	rangeSpec, ok := strings.CutPrefix(value, "bytes=")
+	first, last, found := strings.Cut(rangeSpec, "-")
+	if !ok || !found || first != "0" {
+		return 0, fmt.Errorf("invalid upload range %q", value)
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < 0 {
+		return 0, fmt.Errorf("invalid upload range %q", value)
+	}
+	return end + 1, nil
+}
+
+// uploadFile uploads the file at path through req, in chunks of chunkSize bytes if it's
+// positive and the firmware supports it, and as a single multipart form otherwise
+func (c *Client) uploadFile(req *Request, path, fieldName string, chunkSize int64, out *flashOutput) error {
+	if chunkSize > 0 {
+		err := c.sendFileChunked(req, path, chunkSize, out)
+		if !errors.Is(err, ErrUnsupported) {
+			return err
+		}
+		out.printf("BMC doesn't support chunked uploads, uploading the whole file\n")
+	}
+
+	return c.sendFileUploadWithRetry(req, path, fieldName, out)
+}
@@ -0,0 +1,444 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipartStreamContent(t *testing.T) {
+	content := []byte("turing pi image content")
+
+	body, contentType, length, err := newMultipartStream(bytes.NewReader(content), int64(len(content)), "file", "image.img")
+	if err != nil {
+		t.Fatalf("Failed to create multipart stream: %v", err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("Failed to read multipart stream: %v", err)
+	}
+
+	if int64(len(data)) != length {
+		t.Errorf("Expected stream length %d, got %d", length, len(data))
+	}
+
+	// Parse the form back and check the file part
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Failed to parse content type: %v", err)
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(data), params["boundary"])
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatalf("Failed to read form part: %v", err)
+	}
+
+	if part.FormName() != "file" || part.FileName() != "image.img" {
+		t.Errorf("Unexpected form part: name=%s file=%s", part.FormName(), part.FileName())
+	}
+
+	partData, _ := io.ReadAll(part)
+	if !bytes.Equal(partData, content) {
+		t.Errorf("Expected part content %q, got %q", content, partData)
+	}
+}
+
+func TestMultipartStreamIsNotBuffered(t *testing.T) {
+	// Create a large sparse file so the test doesn't need the disk space
+	size := int64(256 * 1024 * 1024)
+	path := filepath.Join(t.TempDir(), "sparse.img")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create sparse file: %v", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		t.Fatalf("Failed to size sparse file: %v", err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	body, _, length, err := newMultipartStream(file, size, "file", "sparse.img")
+	if err != nil {
+		t.Fatalf("Failed to create multipart stream: %v", err)
+	}
+
+	written, err := io.Copy(io.Discard, body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("Failed to stream multipart body: %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if written != length {
+		t.Errorf("Expected %d bytes streamed, got %d", length, written)
+	}
+
+	// Streaming should only ever allocate copy buffers, far less than the file itself
+	allocated := after.TotalAlloc - before.TotalAlloc
+	if allocated > uint64(size/8) {
+		t.Errorf("Streaming allocated %s for a %s file, expected it not to be buffered",
+			formatBytes(int64(allocated)), formatBytes(size))
+	}
+}
+
+func TestFlashNodeV1UploadLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.img")
+	if err := os.WriteFile(path, make([]byte, 2048), 0600); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	client, err := NewClient(
+		WithHost("127.0.0.1:1"),
+		WithApiVersion(ApiVersionV1),
+		WithCredentials("root", "turing"),
+		WithMaxV1UploadSize(1024),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The size guard must trigger before anything is sent to the BMC
+	err = client.FlashNode(1, &FlashOptions{ImagePath: path})
+	if err == nil {
+		t.Fatal("Expected error when uploading over the v1 limit, got nil")
+	}
+
+	if !strings.Contains(err.Error(), string(ApiVersionV1_1)) {
+		t.Errorf("Expected error to suggest %s, got: %v", ApiVersionV1_1, err)
+	}
+}
+
+func TestUpgradeFirmwareStreamsUpload(t *testing.T) {
+	size := int64(128 * 1024 * 1024)
+	path := writeFile(t, "firmware.tpu", []byte("UBI#"), size)
+
+	var received int64
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/firmware":
+			received, _ = io.Copy(io.Discard, r.Body)
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	if err := client.UpgradeFirmware(path, ""); err != nil {
+		t.Fatalf("Failed to upgrade firmware: %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if received <= size {
+		t.Errorf("Expected the server to receive more than %d bytes, got %d", size, received)
+	}
+
+	allocated := after.TotalAlloc - before.TotalAlloc
+	if allocated > uint64(size/4) {
+		t.Errorf("Upload allocated %s for a %s file, expected it to be streamed",
+			formatBytes(int64(allocated)), formatBytes(size))
+	}
+}
+
+func TestUploadRetrySendsFullBody(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	path := filepath.Join(t.TempDir(), "image.img")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	originalWait := uploadRetryWait
+	uploadRetryWait = 0
+	t.Cleanup(func() { uploadRetryWait = originalWait })
+
+	var received [][]byte
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc/upload/7":
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			part, err := multipart.NewReader(r.Body, params["boundary"]).NextPart()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(part)
+			received = append(received, data)
+
+			// Fail the first attempt after the body was consumed
+			if len(received) == 1 {
+				http.Error(w, "transient failure", http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	req, err := client.newRequest()
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.URL.Path = "/api/bmc/upload/7"
+	req.Method = "POST"
+
+	if err := client.sendFileUploadWithRetry(req, path, "file", newFlashOutput(ProgressHuman, io.Discard)); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 upload attempts, got %d", len(received))
+	}
+	for i, data := range received {
+		if !bytes.Equal(data, content) {
+			t.Errorf("Attempt %d uploaded %d bytes, expected %d", i+1, len(data), len(content))
+		}
+	}
+}
+
+// chunkServer implements the upload side of the resumable upload protocol
+type chunkServer struct {
+	data   []byte
+	chunks []string // Content-Range of every chunk received
+	// failAt makes the chunk starting at this offset fail once, after half of it was stored
+	failAt int64
+	failed bool
+}
+
+func (s *chunkServer) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.Write([]byte(`{}`))
+		return
+	}
+
+	contentRange := r.Header.Get("Content-Range")
+	var total int64
+	if _, err := fmt.Sscanf(contentRange, "bytes */%d", &total); err != nil {
+		var first, last int64
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &first, &last, &total); err != nil || first != int64(len(s.data)) {
+			http.Error(w, "bad range "+contentRange, http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		s.chunks = append(s.chunks, contentRange)
+
+		body, _ := io.ReadAll(r.Body)
+		if first == s.failAt && !s.failed {
+			s.failed = true
+			s.data = append(s.data, body[:len(body)/2]...)
+			http.Error(w, "connection reset", http.StatusInternalServerError)
+			return
+		}
+		s.data = append(s.data, body...)
+	}
+
+	if int64(len(s.data)) == total {
+		w.Write([]byte(`{}`))
+		return
+	}
+	if len(s.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.data)-1))
+	}
+	w.WriteHeader(statusResumeIncomplete)
+}
+
+func TestFlashNodeChunkedUploadResumesFailedChunk(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	originalWait := uploadRetryWait
+	uploadRetryWait = 0
+	t.Cleanup(func() {
+		flashProgressDelay, flashProgressInterval = delay, interval
+		uploadRetryWait = originalWait
+	})
+
+	content := strings.Repeat("0123456789", 10)
+	server := &chunkServer{failAt: 40}
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+			server.serveUpload(w, r)
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":1,"node3":1,"node4":1}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	path, _ := writeImage(t, content)
+	if err := client.FlashNode(1, &FlashOptions{ImagePath: path, ChunkSize: 20, ProgressWriter: io.Discard}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+
+	if string(server.data) != content {
+		t.Errorf("Expected the BMC to receive %q, got %q", content, server.data)
+	}
+
+	// Only the half of the failed chunk that didn't arrive is sent again
+	expected := []string{
+		"bytes 0-19/100", "bytes 20-39/100", "bytes 40-59/100",
+		"bytes 50-69/100", "bytes 70-89/100", "bytes 90-99/100",
+	}
+	if !reflect.DeepEqual(server.chunks, expected) {
+		t.Errorf("Expected chunks %v, got %v", expected, server.chunks)
+	}
+}
+
+func TestFlashNodeResumeHandle(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	content := strings.Repeat("x", 50)
+	server := &chunkServer{failAt: -1, data: []byte(content[:30])}
+	var started bool
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/9":
+			server.serveUpload(w, r)
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			started = true
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":1,"node3":1,"node4":1}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	path, _ := writeImage(t, content)
+	if err := client.FlashNode(1, &FlashOptions{ImagePath: path, ChunkSize: 16, ResumeHandle: 9, ProgressWriter: io.Discard}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+
+	if started {
+		t.Error("Expected resuming not to start a new flash")
+	}
+	if expected := []string{"bytes 30-45/50", "bytes 46-49/50"}; !reflect.DeepEqual(server.chunks, expected) {
+		t.Errorf("Expected chunks %v, got %v", expected, server.chunks)
+	}
+	if string(server.data) != content {
+		t.Errorf("Expected the BMC to receive %q, got %q", content, server.data)
+	}
+}
+
+func TestFlashNodeChunkedUploadFallsBack(t *testing.T) {
+	delay, interval := flashProgressDelay, flashProgressInterval
+	flashProgressDelay, flashProgressInterval = 0, time.Millisecond
+	t.Cleanup(func() { flashProgressDelay, flashProgressInterval = delay, interval })
+
+	var methods []string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case r.URL.Path == "/api/bmc/upload/7":
+			methods = append(methods, r.Method)
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			w.Write([]byte(`{}`))
+		case query.Get("type") == "flash" && query.Get("opt") == "set":
+			w.Write([]byte(`{"handle":7}`))
+		case query.Get("type") == "flash":
+			w.Write([]byte(`{"Done":{}}`))
+		case query.Get("type") == "power" && query.Get("opt") == "get":
+			w.Write([]byte(`{"response":[{"result":[{"node1":1,"node2":1,"node3":1,"node4":1}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	path, _ := writeImage(t, "image content")
+	if err := client.FlashNode(1, &FlashOptions{ImagePath: path, ChunkSize: 4, ProgressWriter: io.Discard}); err != nil {
+		t.Fatalf("FlashNode failed: %v", err)
+	}
+
+	// The offset query is refused, then the whole file is uploaded
+	if expected := []string{http.MethodPut, http.MethodPost}; !reflect.DeepEqual(methods, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, methods)
+	}
+}
+
+func TestParseUploadRange(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		wantErr  bool
+	}{
+		{"", 0, false},
+		{"bytes=0-0", 1, false},
+		{"bytes=0-1048575", 1048576, false},
+		{"bytes=10-20", 0, true},
+		{"0-20", 0, true},
+		{"bytes=0-x", 0, true},
+	}
+
+	for _, test := range tests {
+		received, err := parseUploadRange(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseUploadRange(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			continue
+		}
+		if received != test.expected {
+			t.Errorf("parseUploadRange(%q) = %d, expected %d", test.value, received, test.expected)
+		}
+	}
+}
@@ -15,12 +15,17 @@
 package tpi
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// Interval between USB status reads while verifying a mode change, a variable so tests can shorten it
+var usbVerifyInterval = 250 * time.Millisecond
+
 // extractResultArray extracts an array result from the response
 func extractResultArray(resp *http.Response) ([]interface{}, error) {
 	// Parse the response
@@ -28,7 +33,7 @@ func extractResultArray(resp *http.Response) ([]interface{}, error) {
 		Result []interface{} `json:"result"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+	if err := decodeJSONResponse(resp, &respData); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
@@ -91,6 +96,46 @@ func (c *Client) UsbGetStatus() (*UsbStatusInfo, error) {
 	}, nil
 }
 
+// NodeNumber returns the node the USB bus is configured for, 1-4.
+// The BMC reports it as e.g. "Node1" or "node 1".
+func (s *UsbStatusInfo) NodeNumber() (int, error) {
+	digits := strings.TrimSpace(strings.TrimPrefix(strings.ToLower(s.Node), "node"))
+	node, err := strconv.Atoi(digits)
+	if err != nil || node < 1 || node > 4 {
+		return 0, fmt.Errorf("invalid node in USB status: %q", s.Node)
+	}
+	return node, nil
+}
+
+// UsbMode returns the USB mode of the node as a UsbCmd
+func (s *UsbStatusInfo) UsbMode() (UsbCmd, error) {
+	switch mode := UsbCmd(strings.ToLower(s.Mode)); mode {
+	case UsbHost, UsbDevice, UsbFlash:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid mode in USB status: %q", s.Mode)
+	}
+}
+
+// BusHolder returns the node holding the USB bus, 0 if none. Only a node in device or flash
+// mode holds it: host mode is where the BMC leaves the bus, with some node always reported.
+func (s *UsbStatusInfo) BusHolder() int {
+	mode, err := s.UsbMode()
+	if err != nil || mode == UsbHost {
+		return 0
+	}
+	node, err := s.NodeNumber()
+	if err != nil {
+		return 0
+	}
+	return node
+}
+
+// RoutedToBmc reports whether the USB bus is routed to the BMC chip rather than USB-A
+func (s *UsbStatusInfo) RoutedToBmc() bool {
+	return strings.EqualFold(s.Route, "bmc")
+}
+
 // UsbSetHost configures the specified node as USB host
 func (c *Client) UsbSetHost(node int, bmc bool) error {
 	return c.usbSetMode(node, UsbHost, bmc)
@@ -106,6 +151,118 @@ func (c *Client) UsbSetFlash(node int, bmc bool) error {
 	return c.usbSetMode(node, UsbFlash, bmc)
 }
 
+// UsbRole is the mode a node takes on the USB bus: UsbHost, UsbDevice or UsbFlash
+type UsbRole = UsbCmd
+
+// UsbBusTakenError is returned by RouteUsbTo when another node holds the USB bus, see
+// UsbStatusInfo.BusHolder. The bus can only be routed to one node at a time.
+type UsbBusTakenError struct {
+	// Holder is the node the USB bus is routed to
+	Holder int
+	// Node is the node the bus was requested for
+	Node int
+}
+
+func (e *UsbBusTakenError) Error() string {
+	return fmt.Sprintf("the USB bus is routed to node %d, routing it to node %d would displace it", e.Holder, e.Node)
+}
+
+// RouteUsbTo routes the USB bus to node in the given role. If another node holds the bus,
+// nothing changes and a UsbBusTakenError names that node; use TakeUsbBus to displace it.
+func (c *Client) RouteUsbTo(node int, role UsbRole, bmc bool) error {
+	holder, err := c.usbBusHolder(node)
+	if err != nil {
+		return err
+	}
+	if holder != 0 && holder != node {
+		return &UsbBusTakenError{Holder: holder, Node: node}
+	}
+	return c.usbSetMode(node, role, bmc)
+}
+
+// TakeUsbBus routes the USB bus to node in the given role, even if another node holds it.
+// It returns the node that lost the bus, 0 if none. The routing is only read to report that
+// node, so the bus is routed even if it can't be read, and 0 is returned then.
+func (c *Client) TakeUsbBus(node int, role UsbRole, bmc bool) (int, error) {
+	holder, err := c.usbBusHolder(node)
+	if err != nil {
+		if node < 1 || node > 4 {
+			return 0, err
+		}
+		Debug("Routing the USB bus without knowing its holder: %v", err)
+		holder = 0
+	}
+	if err := c.usbSetMode(node, role, bmc); err != nil {
+		return 0, err
+	}
+	if holder == node {
+		return 0, nil
+	}
+	return holder, nil
+}
+
+// WithUsbRoutedTo routes the USB bus to node in the given role for the duration of fn, even
+// if it is routed to another node, then restores the routing it found, whether fn fails or
+// not. A routing that can't be read can't be restored, so nothing is changed then.
+func (c *Client) WithUsbRoutedTo(node int, role UsbRole, bmc bool, fn func() error) (err error) {
+	if node < 1 || node > 4 {
+		return fmt.Errorf("invalid node number: %d (must be between 1 and 4)", node)
+	}
+
+	status, err := c.UsbGetStatus()
+	if err != nil {
+		return fmt.Errorf("failed to read USB routing: %w", err)
+	}
+	previous, err := usbRoutingOf(status)
+	if err != nil {
+		return fmt.Errorf("USB routing can't be restored, not changing it: %w", err)
+	}
+
+	requested := usbRouting{node: node, mode: role, bmc: bmc}
+	if previous != requested {
+		if err := c.usbSetMode(node, role, bmc); err != nil {
+			return err
+		}
+		defer func() {
+			if restoreErr := c.usbSetMode(previous.node, previous.mode, previous.bmc); restoreErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to restore USB routing to node %d: %w", previous.node, restoreErr))
+			}
+		}()
+	}
+
+	return fn()
+}
+
+// usbRouting is where the USB bus is routed, and how
+type usbRouting struct {
+	node int
+	mode UsbCmd
+	bmc  bool
+}
+
+// usbRoutingOf returns the routing a USB status reports
+func usbRoutingOf(status *UsbStatusInfo) (usbRouting, error) {
+	node, nodeErr := status.NodeNumber()
+	mode, modeErr := status.UsbMode()
+	if err := errors.Join(nodeErr, modeErr); err != nil {
+		return usbRouting{}, err
+	}
+	return usbRouting{node: node, mode: mode, bmc: status.RoutedToBmc()}, nil
+}
+
+// usbBusHolder returns the node holding the USB bus before routing it to node, 0 if none
+func (c *Client) usbBusHolder(node int) (int, error) {
+	if node < 1 || node > 4 {
+		return 0, fmt.Errorf("invalid node number: %d (must be between 1 and 4)", node)
+	}
+
+	status, err := c.UsbGetStatus()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read USB routing: %w", err)
+	}
+	return status.BusHolder(), nil
+}
+
 // usbSetMode configures the USB mode for the specified node
 func (c *Client) usbSetMode(node int, mode UsbCmd, bmc bool) error {
 	if node < 1 || node > 4 {
@@ -138,7 +295,7 @@ func (c *Client) usbSetMode(node int, mode UsbCmd, bmc bool) error {
 	// Add query parameters
 	req.AddQueryParam("opt", "set")
 	req.AddQueryParam("type", "usb")
-	req.AddQueryParam("node", strconv.Itoa(node-1)) // API expects 0-based index
+	req.AddQueryParam("node", toBMCNodeIndex(node))
 	req.AddQueryParam("mode", strconv.Itoa(int(modeVal)))
 
 	// Send the request
@@ -153,5 +310,52 @@ func (c *Client) usbSetMode(node int, mode UsbCmd, bmc bool) error {
 		return fmt.Errorf("USB configuration failed: %w", err)
 	}
 
+	if c.verifyWrites > 0 {
+		if err := c.waitForUsbMode(node, mode, bmc, c.verifyWrites); err != nil {
+			return err
+		}
+	} else {
+		c.settle()
+	}
+
+	outcome := string(mode)
+	if bmc {
+		outcome += " (bmc)"
+	}
+	c.emit(EventUsbMode, node, outcome)
 	return nil
 }
+
+// waitForUsbMode polls the USB status until it reports node in mode, routed to the BMC if bmc
+// is set, or returns an error once timeout has elapsed
+func (c *Client) waitForUsbMode(node int, mode UsbCmd, bmc bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := c.UsbGetStatus()
+		if err == nil && usbStatusMatches(status, node, mode, bmc) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("failed to verify USB mode of node %d: %w", node, err)
+			}
+			return fmt.Errorf("USB mode of node %d not applied after %s: the BMC reports %s on %s, routed to %s",
+				node, timeout, status.Mode, status.Node, status.Route)
+		}
+		time.Sleep(usbVerifyInterval)
+	}
+}
+
+// usbStatusMatches reports whether status is node in mode, routed to the BMC if bmc is set
+func usbStatusMatches(status *UsbStatusInfo, node int, mode UsbCmd, bmc bool) bool {
+	statusNode, err := status.NodeNumber()
+	if err != nil || statusNode != node {
+		return false
+	}
+	statusMode, err := status.UsbMode()
+	if err != nil || statusMode != mode {
+		return false
+	}
+	return status.RoutedToBmc() == bmc
+}
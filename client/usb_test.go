@@ -0,0 +1,260 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newUsbVerifyClient returns a client for a mock BMC that reports the old USB mode for
+// staleReads status reads after a mode change, then the requested mode
+func newUsbVerifyClient(t *testing.T, staleReads int, options ...Option) (*Client, func() int) {
+	interval := usbVerifyInterval
+	usbVerifyInterval = time.Millisecond
+	t.Cleanup(func() { usbVerifyInterval = interval })
+
+	var mu sync.Mutex
+	status := `{"node":"Node1","mode":"Host","route":"AlpineUsb"}`
+	pending := ""
+	reads := 0
+
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			mu.Lock()
+			defer mu.Unlock()
+			query := r.URL.Query()
+			if query.Get("opt") == "set" {
+				// Mode 1 is device, with the BMC bit for mode 5
+				pending = `{"node":"Node3","mode":"Device","route":"AlpineUsb"}`
+				if query.Get("mode") == "5" {
+					pending = `{"node":"Node3","mode":"Device","route":"BMC"}`
+				}
+				w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+				return
+			}
+			reads++
+			if pending != "" && reads > staleReads {
+				status = pending
+			}
+			w.Write([]byte(`{"result":[` + status + `]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}), options...)
+
+	return client, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return reads
+	}
+}
+
+func TestUsbVerifyWrites(t *testing.T) {
+	client, reads := newUsbVerifyClient(t, 1, WithVerifyWrites(time.Second))
+
+	if err := client.UsbSetDevice(3, false); err != nil {
+		t.Fatalf("UsbSetDevice failed: %v", err)
+	}
+	if got := reads(); got != 2 {
+		t.Errorf("read the USB status %d times, want 2", got)
+	}
+}
+
+func TestUsbVerifyWritesTimeout(t *testing.T) {
+	client, _ := newUsbVerifyClient(t, 1000, WithVerifyWrites(20*time.Millisecond))
+
+	err := client.UsbSetDevice(3, true)
+	if err == nil {
+		t.Fatal("expected an error when the USB mode never changes")
+	}
+	if !strings.Contains(err.Error(), "not applied") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestUsbVerifyWritesDisabled(t *testing.T) {
+	client, reads := newUsbVerifyClient(t, 1)
+
+	if err := client.UsbSetDevice(3, false); err != nil {
+		t.Fatalf("UsbSetDevice failed: %v", err)
+	}
+	if got := reads(); got != 0 {
+		t.Errorf("read the USB status %d times without WithVerifyWrites, want 0", got)
+	}
+}
+
+// newUsbBusClient returns a client for a mock BMC whose USB bus is routed to holder in mode,
+// and a function returning the nodes the bus was routed to since. The BMC fails to report the
+// routing if mode is empty.
+func newUsbBusClient(t *testing.T, holder int, mode string) (*Client, func() []string) {
+	var mu sync.Mutex
+	var routed []string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if query := r.URL.Query(); query.Get("opt") == "set" {
+			routed = append(routed, query.Get("node"))
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+			return
+		}
+		if mode == "" {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"result":[{"node":"Node%d","mode":"%s","route":"AlpineUsb"}]}`, holder, mode)
+	}))
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return routed
+	}
+}
+
+func TestRouteUsbToDisplacement(t *testing.T) {
+	client, routed := newUsbBusClient(t, 2, "Device")
+
+	err := client.RouteUsbTo(3, UsbDevice, false)
+	var takenErr *UsbBusTakenError
+	if !errors.As(err, &takenErr) || takenErr.Holder != 2 || takenErr.Node != 3 {
+		t.Fatalf("Expected node 2 to be reported as holding the bus, got: %v", err)
+	}
+	if len(routed()) != 0 {
+		t.Errorf("Expected the bus to stay on node 2, got requests for %v", routed())
+	}
+
+	displaced, err := client.TakeUsbBus(3, UsbDevice, false)
+	if err != nil || displaced != 2 {
+		t.Fatalf("Expected node 2 to be displaced, got %d, %v", displaced, err)
+	}
+	// The BMC indexes nodes from 0
+	if got := routed(); len(got) != 1 || got[0] != "2" {
+		t.Errorf("Expected the bus to be routed to node 3, got requests for %v", got)
+	}
+}
+
+func TestRouteUsbToHolder(t *testing.T) {
+	client, routed := newUsbBusClient(t, 3, "Device")
+
+	if err := client.RouteUsbTo(3, UsbHost, false); err != nil {
+		t.Fatalf("Expected node 3 to keep the bus, got: %v", err)
+	}
+	if len(routed()) != 1 {
+		t.Errorf("Expected one routing request, got %v", routed())
+	}
+	if displaced, err := client.TakeUsbBus(3, UsbFlash, false); err != nil || displaced != 0 {
+		t.Errorf("Expected no displacement, got %d, %v", displaced, err)
+	}
+}
+
+func TestRouteUsbToHostModeDoesntHold(t *testing.T) {
+	// The BMC always reports a node, one in host mode doesn't hold the bus
+	client, routed := newUsbBusClient(t, 1, "Host")
+
+	if err := client.RouteUsbTo(3, UsbDevice, false); err != nil {
+		t.Fatalf("Expected the bus to be free, got: %v", err)
+	}
+	if got := routed(); len(got) != 1 || got[0] != "2" {
+		t.Errorf("Expected the bus to be routed to node 3, got requests for %v", got)
+	}
+}
+
+func TestTakeUsbBusUnreadableRouting(t *testing.T) {
+	client, routed := newUsbBusClient(t, 0, "")
+
+	displaced, err := client.TakeUsbBus(3, UsbFlash, false)
+	if err != nil || displaced != 0 {
+		t.Fatalf("Expected the bus to be routed without a displaced node, got %d, %v", displaced, err)
+	}
+	if got := routed(); len(got) != 1 || got[0] != "2" {
+		t.Errorf("Expected the bus to be routed to node 3, got requests for %v", got)
+	}
+	if err := client.RouteUsbTo(3, UsbFlash, false); err == nil {
+		t.Error("Expected RouteUsbTo to refuse a routing it can't read")
+	}
+}
+
+func TestWithUsbRoutedToRestores(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/bmc/authenticate" {
+			w.Write([]byte(`{"id":"mock-token"}`))
+			return
+		}
+		if query := r.URL.Query(); query.Get("opt") == "set" {
+			mu.Lock()
+			requests = append(requests, query.Get("node")+":"+query.Get("mode"))
+			mu.Unlock()
+			w.Write([]byte(`{"response":[{"result":"ok"}]}`))
+			return
+		}
+		// The bus is on node 2 as a device, routed to USB-A
+		w.Write([]byte(`{"result":[{"node":"Node2","mode":"Device","route":"AlpineUsb"}]}`))
+	}))
+	takeRequests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := requests
+		requests = nil
+		return taken
+	}
+
+	// Node 3 in flash mode on the BMC (mode 2|4), then node 2 back as a device (mode 1)
+	expected := []string{"2:6", "1:1"}
+
+	ran := false
+	if err := client.WithUsbRoutedTo(3, UsbFlash, true, func() error {
+		ran = true
+		if got := takeRequests(); !reflect.DeepEqual(got, expected[:1]) {
+			t.Errorf("Expected the bus to be routed to node 3 while fn runs, got %v", got)
+		}
+		return nil
+	}); err != nil || !ran {
+		t.Fatalf("Expected fn to run and succeed, got ran=%v, %v", ran, err)
+	}
+	if got := takeRequests(); !reflect.DeepEqual(got, expected[1:]) {
+		t.Errorf("Expected the routing to be restored after success, got %v", got)
+	}
+
+	flashErr := errors.New("flash failed")
+	if err := client.WithUsbRoutedTo(3, UsbFlash, true, func() error { return flashErr }); !errors.Is(err, flashErr) {
+		t.Fatalf("Expected the error of fn, got: %v", err)
+	}
+	if got := takeRequests(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the routing to be restored after an error, got %v", got)
+	}
+
+	// The bus already routed as requested is left alone
+	if err := client.WithUsbRoutedTo(2, UsbDevice, false, func() error { return nil }); err != nil {
+		t.Fatalf("WithUsbRoutedTo failed: %v", err)
+	}
+	if got := takeRequests(); len(got) != 0 {
+		t.Errorf("Expected no routing change, got %v", got)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Interval between UART reads while waiting for a boot, a variable so tests can shorten it
+var uartPollInterval = 1 * time.Second
+
+// uartBootTail is how much of the UART output is kept between reads, so a prompt
+// split across two reads still matches
+const uartBootTail = 4096
+
+// DefaultBootPattern matches common Linux console prompts printed once a node has booted:
+// a getty login prompt ("node1 login: ") or the shell prompt of an autologin console.
+var DefaultBootPattern = regexp.MustCompile(`(?m)(\blogin:\s*$|^\S+@\S+:\S*[#$]\s*$)`)
+
+// NodeCheck reports whether the OS of a node is up, returning nil once it is.
+// Use TCPCheck or SSHCheck, or any func for custom checks.
+type NodeCheck func(ctx context.Context, c *Client, node int) error
+
+// permanentError is an error of a NodeCheck that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error of a NodeCheck that retrying won't fix, such as a
+// misconfiguration, so WaitForNode returns it at once
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// WithNodeHosts sets the addresses of the nodes, keyed by node number,
+// used by TCPCheck and SSHCheck to reach the nodes themselves rather than the BMC
+func WithNodeHosts(hosts map[int]string) Option {
+	return func(c *Client) {
+		c.nodeHosts = make(map[int]string, len(hosts))
+		for node, host := range hosts {
+			c.nodeHosts[node] = host
+		}
+	}
+}
+
+// NodeHost returns the address configured for a node with WithNodeHosts
+func (c *Client) NodeHost(node int) (string, error) {
+	host, ok := c.nodeHosts[node]
+	if !ok || host == "" {
+		return "", fmt.Errorf("no address configured for node %d, set it with WithNodeHosts", node)
+	}
+	return host, nil
+}
+
+// TCPCheck returns a NodeCheck that succeeds once the node accepts TCP connections on port.
+// Without an address for the node from WithNodeHosts, the check fails permanently.
+func TCPCheck(port int) NodeCheck {
+	return func(ctx context.Context, c *Client, node int) error {
+		host, err := c.NodeHost(node)
+		if err != nil {
+			return Permanent(err)
+		}
+
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// SSHCheck returns a NodeCheck that succeeds once an SSH login to the node works.
+// The options configure the login as for ExecuteCommand; without credentials or an
+// address for the node, the check fails permanently.
+func SSHCheck(options ...SSHOption) NodeCheck {
+	return func(ctx context.Context, c *Client, node int) error {
+		host, err := c.NodeHost(node)
+		if err != nil {
+			return Permanent(err)
+		}
+
+		opts := append([]SSHOption{WithSSHHost(host)}, options...)
+		client, err := c.getSSHClientContext(ctx, opts...)
+		if errors.Is(err, ErrNoSSHCredentials) {
+			return Permanent(err)
+		}
+		if err != nil {
+			return err
+		}
+		return client.Close()
+	}
+}
+
+// WaitForNode waits until check succeeds for the node, e.g. until its OS is up after
+// a power on or a flash. Failed checks are retried with exponential backoff until ctx is done,
+// except for errors wrapped with Permanent, which are returned at once.
+func (c *Client) WaitForNode(ctx context.Context, node int, check NodeCheck) error {
+	if node < 1 || node > 4 {
+		return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+	}
+
+	retry := newBackoff(c.retryPolicy(DefaultNodeCheckRetryPolicy))
+	for {
+		err := check(ctx, c, node)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return fmt.Errorf("node %d not ready: %w", node, permanent.err)
+		}
+		Debug("Node %d not ready: %v, checking again", node, err)
+
+		if retry.Sleep(ctx) != nil {
+			return fmt.Errorf("node %d not ready: %w", node, errors.Join(ctx.Err(), err))
+		}
+	}
+}
+
+// WaitForBootComplete tails the UART of a node until readyPattern appears, e.g. a login prompt
+// after the node was flashed and powered on. A nil readyPattern uses DefaultBootPattern.
+// Failed UART reads are retried; when ctx is done, its error is returned.
+func (c *Client) WaitForBootComplete(ctx context.Context, node int, readyPattern *regexp.Regexp) error {
+	if node < 1 || node > 4 {
+		return fmt.Errorf("invalid node number: %d (must be 1-4)", node)
+	}
+
+	return waitForUartPattern(ctx, func() (string, error) {
+		return c.GetUartOutput(node)
+	}, readyPattern)
+}
+
+// waitForUartPattern calls poll until the output read so far matches pattern or ctx is done
+func waitForUartPattern(ctx context.Context, poll func() (string, error), pattern *regexp.Regexp) error {
+	if pattern == nil {
+		pattern = DefaultBootPattern
+	}
+
+	var tail string
+	for {
+		output, err := poll()
+		if err != nil {
+			Debug("Failed to read UART: %v", err)
+		} else if output != "" {
+			tail += output
+			if pattern.MatchString(tail) {
+				return nil
+			}
+			if len(tail) > uartBootTail {
+				tail = tail[len(tail)-uartBootTail:]
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return errors.Join(ctx.Err(), err)
+			}
+			return ctx.Err()
+		case <-time.After(uartPollInterval):
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// shortenNodeCheckWait makes WaitForNode retry quickly for the duration of the test
+func shortenNodeCheckWait(t *testing.T) {
+	shortenRetryPolicy(t, &DefaultNodeCheckRetryPolicy)
+}
+
+func TestWaitForNodeRetriesUntilReady(t *testing.T) {
+	shortenNodeCheckWait(t)
+
+	client, err := NewClient(WithHost("127.0.0.1:1"), WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	calls := 0
+	check := func(ctx context.Context, c *Client, node int) error {
+		calls++
+		if node != 2 {
+			t.Errorf("Expected node 2, got %d", node)
+		}
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	if err := client.WaitForNode(context.Background(), 2, check); err != nil {
+		t.Fatalf("WaitForNode failed: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 checks, got %d", calls)
+	}
+}
+
+func TestWaitForNodeRespectsContext(t *testing.T) {
+	shortenNodeCheckWait(t)
+
+	client, err := NewClient(WithHost("127.0.0.1:1"), WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	notReady := errors.New("not ready")
+	err = client.WaitForNode(ctx, 1, func(ctx context.Context, c *Client, node int) error {
+		return notReady
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, notReady) {
+		t.Errorf("Expected the deadline and the last check error, got: %v", err)
+	}
+}
+
+func TestTCPCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	client, err := NewClient(
+		WithHost("127.0.0.1:1"),
+		WithCredentials("root", "turing"),
+		WithNodeHosts(map[int]string{1: "127.0.0.1"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if err := TCPCheck(port)(context.Background(), client, 1); err != nil {
+		t.Errorf("Expected node 1 to be reachable, got: %v", err)
+	}
+	if err := TCPCheck(port)(context.Background(), client, 2); err == nil {
+		t.Error("Expected an error for a node without an address")
+	}
+}
+
+func TestWaitForNodePermanentError(t *testing.T) {
+	client, err := NewClient(WithHost("127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	calls := 0
+	err = client.WaitForNode(context.Background(), 1, func(ctx context.Context, c *Client, node int) error {
+		calls++
+		return Permanent(ErrNodeRequired)
+	})
+	if !errors.Is(err, ErrNodeRequired) {
+		t.Errorf("Expected the permanent error, got: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected a permanent error not to be retried, checked %d times", calls)
+	}
+}
+
+func TestSSHCheckWithoutCredentials(t *testing.T) {
+	client, err := NewClient(
+		WithHost("127.0.0.1:1"),
+		WithCredentials("root", "turing"),
+		WithNodeHosts(map[int]string{1: "127.0.0.1"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	err = client.WaitForNode(ctx, 1, SSHCheck())
+	if !errors.Is(err, ErrNoSSHCredentials) {
+		t.Errorf("Expected ErrNoSSHCredentials, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the wait to end at once without credentials, took %s", elapsed)
+	}
+}
+
+func TestWaitForNodeWithoutNodeHosts(t *testing.T) {
+	client, err := NewClient(WithHost("127.0.0.1:1"), WithCredentials("root", "turing"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	for name, check := range map[string]NodeCheck{
+		"tcp": TCPCheck(22),
+		"ssh": SSHCheck(WithSSHCredentials("root", "turing")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			done := make(chan error, 1)
+			go func() { done <- client.WaitForNode(context.Background(), 1, check) }()
+
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("Expected an error for a node without an address")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected the wait to end at once without node hosts")
+			}
+		})
+	}
+}
+
+func TestSSHCheckRespectsContext(t *testing.T) {
+	// A server accepting connections without ever starting the SSH handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	client, err := NewClient(
+		WithHost("127.0.0.1:1"),
+		WithCredentials("root", "turing"),
+		WithNodeHosts(map[int]string{1: "127.0.0.1"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = SSHCheck(WithSSHPort(port), WithSSHPassword("ubuntu"))(ctx, client, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the check to end with its context, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the check to stop when its context is done, took %s", elapsed)
+	}
+}
+
+// shortenUartPollInterval makes WaitForBootComplete read the UART quickly for the duration of the test
+func shortenUartPollInterval(t *testing.T) {
+	interval := uartPollInterval
+	uartPollInterval = time.Millisecond
+	t.Cleanup(func() { uartPollInterval = interval })
+}
+
+// uartLines returns a poller handing out outputs one read at a time, then nothing
+func uartLines(outputs ...string) func() (string, error) {
+	return func() (string, error) {
+		if len(outputs) == 0 {
+			return "", nil
+		}
+		output := outputs[0]
+		outputs = outputs[1:]
+		return output, nil
+	}
+}
+
+func TestDefaultBootPattern(t *testing.T) {
+	tests := []struct {
+		output string
+		ready  bool
+	}{
+		{"Ubuntu 22.04.3 LTS node1 ttyS2\n\nnode1 login: ", true},
+		{"Debian GNU/Linux 12 turing ttyS0\nturing login:", true},
+		{"root@node1:~# ", true},
+		{"pi@raspberry:/home/pi$ ", true},
+		{"[  OK  ] Started systemd-logind.service - User Login Management.\n", false},
+		{"Starting kernel ...\n", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := DefaultBootPattern.MatchString(tt.output); got != tt.ready {
+			t.Errorf("DefaultBootPattern on %q: expected %v, got %v", tt.output, tt.ready, got)
+		}
+	}
+}
+
+func TestWaitForUartPatternAcrossReads(t *testing.T) {
+	shortenUartPollInterval(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The prompt is split across two reads
+	poll := uartLines(
+		"U-Boot 2017.09\nStarting kernel ...\n",
+		"",
+		"[  OK  ] Reached target Multi-User System.\n\nnode1 lo",
+		"gin: ",
+	)
+	if err := waitForUartPattern(ctx, poll, nil); err != nil {
+		t.Fatalf("Expected the login prompt to be found, got: %v", err)
+	}
+}
+
+func TestWaitForUartPatternTimeout(t *testing.T) {
+	shortenUartPollInterval(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	poll := uartLines("Starting kernel ...\n", "node1 login: ")
+	err := waitForUartPattern(ctx, poll, regexp.MustCompile(`ready>`))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context deadline error, got: %v", err)
+	}
+}
+
+func TestWaitForBootComplete(t *testing.T) {
+	shortenUartPollInterval(t)
+
+	var mu sync.Mutex
+	reads := 0
+	client, _ := newMockClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/bmc/authenticate":
+			w.Write([]byte(`{"id":"mock-token"}`))
+		case "/api/bmc":
+			// Nodes are 0-based on the wire
+			if r.URL.Query().Get("node") != "2" {
+				t.Errorf("Expected node 2 on the wire, got %s", r.URL.Query().Get("node"))
+			}
+			mu.Lock()
+			reads++
+			first := reads == 1
+			mu.Unlock()
+			if first {
+				w.Write([]byte(`{"response":["Booting Linux on physical CPU 0x0\n"]}`))
+				return
+			}
+			w.Write([]byte(`{"response":["node3 login: "]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.WaitForBootComplete(ctx, 3, nil); err != nil {
+		t.Fatalf("WaitForBootComplete failed: %v", err)
+	}
+
+	if err := client.WaitForBootComplete(ctx, 5, nil); err == nil {
+		t.Error("Expected an error for node 5")
+	}
+}
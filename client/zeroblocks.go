@@ -0,0 +1,79 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// zeroBlockSize is the granularity zero runs are detected at
+const zeroBlockSize = 64 * 1024
+
+// ZeroBlockStats describes the blocks of an image that contain only zeros
+type ZeroBlockStats struct {
+	Size       int64 // Total size of the image in bytes
+	BlockSize  int   // Size of the blocks the image was scanned in
+	ZeroBlocks int64 // Number of blocks containing only zeros
+	ZeroBytes  int64 // Bytes covered by zero blocks
+	Runs       int   // Number of contiguous runs of zero blocks
+}
+
+// ScanZeroBlocks reads r in blocks of blockSize bytes, zeroBlockSize if zero or less,
+// and counts the blocks made only of zeros
+func ScanZeroBlocks(r io.Reader, blockSize int) (ZeroBlockStats, error) {
+	if blockSize <= 0 {
+		blockSize = zeroBlockSize
+	}
+
+	stats := ZeroBlockStats{BlockSize: blockSize}
+	buf := make([]byte, blockSize)
+	inRun := false
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			stats.Size += int64(n)
+			if isZero(buf[:n]) {
+				stats.ZeroBlocks++
+				stats.ZeroBytes += int64(n)
+				if !inRun {
+					stats.Runs++
+				}
+				inRun = true
+			} else {
+				inRun = false
+			}
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("failed to read image: %w", err)
+		}
+	}
+}
+
+// isZero reports whether b contains only zero bytes
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Turing Machines
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tpi
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanZeroBlocksSparseImage(t *testing.T) {
+	const block = 4096
+
+	// 16 blocks with data in blocks 0, 5 and 6, everything else is a hole
+	path := filepath.Join(t.TempDir(), "sparse.img")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(16 * block); err != nil {
+		t.Fatalf("Failed to size image: %v", err)
+	}
+	for _, index := range []int64{0, 5, 6} {
+		if _, err := file.WriteAt([]byte{0xff}, index*block+block/2); err != nil {
+			t.Fatalf("Failed to write block %d: %v", index, err)
+		}
+	}
+
+	stats, err := ScanZeroBlocks(file, block)
+	if err != nil {
+		t.Fatalf("ScanZeroBlocks failed: %v", err)
+	}
+
+	if stats.Size != 16*block {
+		t.Errorf("Expected size %d, got %d", 16*block, stats.Size)
+	}
+	if stats.ZeroBlocks != 13 || stats.ZeroBytes != 13*block {
+		t.Errorf("Expected 13 zero blocks (%d bytes), got %d (%d bytes)", 13*block, stats.ZeroBlocks, stats.ZeroBytes)
+	}
+	// Blocks 1-4 and 7-15
+	if stats.Runs != 2 {
+		t.Errorf("Expected 2 zero runs, got %d", stats.Runs)
+	}
+}
+
+func TestScanZeroBlocksPartialTail(t *testing.T) {
+	data := append(bytes.Repeat([]byte{1}, 10), make([]byte, 5)...)
+
+	stats, err := ScanZeroBlocks(bytes.NewReader(data), 10)
+	if err != nil {
+		t.Fatalf("ScanZeroBlocks failed: %v", err)
+	}
+
+	if stats.Size != 15 || stats.ZeroBlocks != 1 || stats.ZeroBytes != 5 || stats.Runs != 1 {
+		t.Errorf("Unexpected stats for a zero tail: %+v", stats)
+	}
+}